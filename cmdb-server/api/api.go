@@ -57,11 +57,33 @@ func init() {
 		&handlerFuncObj{Url: "/ci-types/apply/:ciType", Method: "POST", HandlerFunc: ci.CiTypesApply, LogOperation: true, ApiCode: "CiTypesApply"},
 		&handlerFuncObj{Url: "/ci-types/rollback/:ciType", Method: "POST", HandlerFunc: ci.CiTypesRollback, LogOperation: true, ApiCode: "CiTypesRollback"},
 		&handlerFuncObj{Url: "/ci-types/references/:ciType", Method: "GET", HandlerFunc: ci.CiTypesReferences, ApiCode: "CiTypesReferences"},
+		&handlerFuncObj{Url: "/ci-types/unique-keys/:ciType", Method: "GET", HandlerFunc: ci.CiTypeUniqueKeyQuery, ApiCode: "CiTypeUniqueKeyQuery"},
+		&handlerFuncObj{Url: "/ci-types/unique-keys/:ciType", Method: "POST", HandlerFunc: ci.CiTypeUniqueKeyCreate, LogOperation: true, ApiCode: "CiTypeUniqueKeyCreate"},
+		&handlerFuncObj{Url: "/ci-types/unique-keys/:ciType/:id", Method: "DELETE", HandlerFunc: ci.CiTypeUniqueKeyDelete, LogOperation: true, ApiCode: "CiTypeUniqueKeyDelete"},
+		&handlerFuncObj{Url: "/ci-types/indexes/:ciType", Method: "GET", HandlerFunc: ci.CiTypeIndexQuery, ApiCode: "CiTypeIndexQuery"},
+		&handlerFuncObj{Url: "/ci-types/indexes/:ciType", Method: "POST", HandlerFunc: ci.CiTypeIndexCreate, LogOperation: true, ApiCode: "CiTypeIndexCreate"},
+		&handlerFuncObj{Url: "/ci-types/indexes/:ciType/apply/:id", Method: "POST", HandlerFunc: ci.CiTypeIndexApply, LogOperation: true, ApiCode: "CiTypeIndexApply"},
+		&handlerFuncObj{Url: "/ci-types/indexes/:ciType/:id", Method: "DELETE", HandlerFunc: ci.CiTypeIndexDelete, LogOperation: true, ApiCode: "CiTypeIndexDelete"},
+		&handlerFuncObj{Url: "/ci-types/index-suggest/:ciType", Method: "GET", HandlerFunc: ci.CiTypeIndexSuggest, ApiCode: "CiTypeIndexSuggest"},
 		&handlerFuncObj{Url: "/ci-template", Method: "GET", HandlerFunc: ci.GetCiTemplate, ApiCode: "GetCiTemplate"},
+		&handlerFuncObj{Url: "/ci-templates", Method: "POST", HandlerFunc: ci.CiTemplateCreate, LogOperation: true, ApiCode: "CiTemplateCreate"},
+		&handlerFuncObj{Url: "/ci-templates/:id", Method: "GET", HandlerFunc: ci.CiTemplateGet, ApiCode: "CiTemplateGet"},
+		&handlerFuncObj{Url: "/ci-templates/:id", Method: "PUT", HandlerFunc: ci.CiTemplateUpdate, LogOperation: true, ApiCode: "CiTemplateUpdate"},
+		&handlerFuncObj{Url: "/ci-templates/:id", Method: "DELETE", HandlerFunc: ci.CiTemplateDelete, LogOperation: true, ApiCode: "CiTemplateDelete"},
+		&handlerFuncObj{Url: "/ci-templates/:id/attributes", Method: "POST", HandlerFunc: ci.CiTemplateAttrCreate, LogOperation: true, ApiCode: "CiTemplateAttrCreate"},
+		&handlerFuncObj{Url: "/ci-templates/:id/attributes/:attrId", Method: "PUT", HandlerFunc: ci.CiTemplateAttrUpdate, LogOperation: true, ApiCode: "CiTemplateAttrUpdate"},
+		&handlerFuncObj{Url: "/ci-templates/:id/attributes/:attrId", Method: "DELETE", HandlerFunc: ci.CiTemplateAttrDelete, LogOperation: true, ApiCode: "CiTemplateAttrDelete"},
+		&handlerFuncObj{Url: "/ci-templates/:id/propagate", Method: "POST", HandlerFunc: ci.CiTemplatePropagate, LogOperation: true, ApiCode: "CiTemplatePropagate"},
 		&handlerFuncObj{Url: "/state-machine", Method: "GET", HandlerFunc: ci.GetStateMachine, ApiCode: "GetStateMachine"},
 		&handlerFuncObj{Url: "/state-transition/:ciType", Method: "GET", HandlerFunc: ci.GetStateTransition, ApiCode: "GetStateTransition"},
+		&handlerFuncObj{Url: "/state-machines", Method: "GET", HandlerFunc: ci.StateMachineList, ApiCode: "StateMachineList"},
+		&handlerFuncObj{Url: "/state-machines", Method: "POST", HandlerFunc: ci.StateMachineCreate, LogOperation: true, ApiCode: "StateMachineCreate"},
+		&handlerFuncObj{Url: "/state-machines/:id", Method: "PUT", HandlerFunc: ci.StateMachineUpdate, LogOperation: true, ApiCode: "StateMachineUpdate"},
+		&handlerFuncObj{Url: "/state-machines/:id", Method: "DELETE", HandlerFunc: ci.StateMachineDelete, LogOperation: true, ApiCode: "StateMachineDelete"},
 		&handlerFuncObj{Url: "/extend/ci-types/model/list", Method: "GET", HandlerFunc: ci.GetExtendModelList, ApiCode: "GetExtendModelList"},
 		&handlerFuncObj{Url: "/ci-types/query/id-and-name", Method: "GET", HandlerFunc: ci.QueryIdAndName, ApiCode: "QueryIdAndName"},
+		&handlerFuncObj{Url: "/model/export", Method: "GET", HandlerFunc: ci.ModelExport, ApiCode: "ModelExport"},
+		&handlerFuncObj{Url: "/model/import", Method: "POST", HandlerFunc: ci.ModelImport, LogOperation: true, ApiCode: "ModelImport"},
 	)
 	// ciAttributes
 	httpHandlerFuncList = append(httpHandlerFuncList,
@@ -72,13 +94,28 @@ func init() {
 		&handlerFuncObj{Url: "/ci-types-attr/:ciType/attributes/apply/:ciAttr", Method: "POST", HandlerFunc: ci.AttrApply, LogOperation: true, ApiCode: "AttrApply"},
 		&handlerFuncObj{Url: "/ci-types-attr/:ciType/attributes/rollback/:ciAttr", Method: "POST", HandlerFunc: ci.AttrRollback, LogOperation: true, ApiCode: "AttrRollback"},
 		&handlerFuncObj{Url: "/ci-types-attr/:ciType/attributes/swap-position", Method: "POST", HandlerFunc: ci.AttrPositionSwap, LogOperation: true, ApiCode: "AttrPositionSwap"},
+		&handlerFuncObj{Url: "/ci-types-attr/:ciType/attributes/:ciAttr/ref-filter/preview", Method: "POST", HandlerFunc: ci.AttrRefFilterPreview, ApiCode: "AttrRefFilterPreview"},
+		&handlerFuncObj{Url: "/ci-types-attr/:ciType/attributes/:ciAttr/autofill/explain", Method: "POST", HandlerFunc: ci.AttrAutofillExplain, ApiCode: "AttrAutofillExplain"},
+		&handlerFuncObj{Url: "/ci-types-attr/:ciType/attributes/migration/plan/:ciAttr", Method: "POST", HandlerFunc: ci.AttrMigrationPlan, ApiCode: "AttrMigrationPlan"},
+		&handlerFuncObj{Url: "/ci-types-attr/:ciType/attributes/migration/apply/:ciAttr", Method: "POST", HandlerFunc: ci.AttrMigrationApply, LogOperation: true, ApiCode: "AttrMigrationApply"},
+		&handlerFuncObj{Url: "/ci-types-attr/:ciType/migrations", Method: "GET", HandlerFunc: ci.AttrMigrationList, ApiCode: "AttrMigrationList"},
+		&handlerFuncObj{Url: "/ci-types-attr/:ciType/migrations/rollback/:id", Method: "POST", HandlerFunc: ci.AttrMigrationRollback, LogOperation: true, ApiCode: "AttrMigrationRollback"},
 	)
 	// ciData
 	httpHandlerFuncList = append(httpHandlerFuncList,
 		&handlerFuncObj{Url: "/ci-data/query/:ciType", Method: "POST", HandlerFunc: ci.DataQuery, ApiCode: "DataQuery"},
 		&handlerFuncObj{Url: "/ci-data/do/:operation/:ciType", Method: "POST", HandlerFunc: ci.DataOperation, LogOperation: true, ApiCode: "DataOperation"},
+		&handlerFuncObj{Url: "/ci-data/bulk", Method: "POST", HandlerFunc: ci.DataBulkOperation, LogOperation: true, ApiCode: "DataBulkOperation"},
+		&handlerFuncObj{Url: "/ci-data/bulk/:jobId", Method: "GET", HandlerFunc: ci.DataBulkJobGet, ApiCode: "DataBulkJobGet"},
+		&handlerFuncObj{Url: "/ci-data/bulk/:jobId/rows/query", Method: "POST", HandlerFunc: ci.DataBulkRowQuery, ApiCode: "DataBulkRowQuery"},
+		&handlerFuncObj{Url: "/ci-data/express/validate", Method: "POST", HandlerFunc: ci.DataExpressValidate, ApiCode: "DataExpressValidate"},
+		&handlerFuncObj{Url: "/ci-data/autofill-check", Method: "POST", HandlerFunc: ci.DataAutofillCheck, LogOperation: true, ApiCode: "DataAutofillCheck"},
 		&handlerFuncObj{Url: "/ci-data/reference-data/query/:ciAttr", Method: "POST", HandlerFunc: ci.DataReferenceQuery, ApiCode: "DataReferenceQuery"},
 		&handlerFuncObj{Url: "/ci-data/rollback/query/:guid", Method: "GET", HandlerFunc: ci.DataRollbackList, ApiCode: "DataRollbackList"},
+		&handlerFuncObj{Url: "/ci-data/diff/:guid", Method: "GET", HandlerFunc: ci.DataDiff, ApiCode: "DataDiff"},
+		&handlerFuncObj{Url: "/ci-data/audit/:guid", Method: "GET", HandlerFunc: ci.GetCiDataAudit, ApiCode: "GetCiDataAudit"},
+		&handlerFuncObj{Url: "/ci-data/impact/:guid", Method: "GET", HandlerFunc: ci.DataImpact, ApiCode: "DataImpact"},
+		&handlerFuncObj{Url: "/ci-data/graph-query", Method: "POST", HandlerFunc: ci.DataGraphQuery, ApiCode: "DataGraphQuery"},
 		&handlerFuncObj{Url: "/ci-data/query-password/:ciType/:guid/:field", Method: "GET", HandlerFunc: ci.DataPasswordQuery, ApiCode: "DataPasswordQuery"},
 		&handlerFuncObj{Url: "/ci-data/action-query/:operation/:ciType/:guid", Method: "GET", HandlerFunc: ci.GetActionQueryData, ApiCode: "GetActionQueryData"},
 		&handlerFuncObj{Url: "/ci-data/import/:ciType", Method: "POST", HandlerFunc: ci.DataImport, ApiCode: "DataImport"},
@@ -87,10 +124,32 @@ func init() {
 		&handlerFuncObj{Url: "/extend/ci-data/model/query/:ciAttr", Method: "POST", HandlerFunc: ci.GetExtendModelData, ApiCode: "GetExtendModelData"},
 		&handlerFuncObj{Url: "/ci-data/sensitive-attr/query", Method: "POST", HandlerFunc: ci.AttrSensitiveDataQuery, ApiCode: "AttrSensitiveDataQuery"},
 	)
+	// webhook
+	httpHandlerFuncList = append(httpHandlerFuncList,
+		&handlerFuncObj{Url: "/webhooks", Method: "GET", HandlerFunc: ci.WebhookList, ApiCode: "WebhookList"},
+		&handlerFuncObj{Url: "/webhooks", Method: "POST", HandlerFunc: ci.WebhookCreate, LogOperation: true, ApiCode: "WebhookCreate"},
+		&handlerFuncObj{Url: "/webhooks/:id", Method: "PUT", HandlerFunc: ci.WebhookUpdate, LogOperation: true, ApiCode: "WebhookUpdate"},
+		&handlerFuncObj{Url: "/webhooks/:id", Method: "DELETE", HandlerFunc: ci.WebhookDelete, LogOperation: true, ApiCode: "WebhookDelete"},
+		&handlerFuncObj{Url: "/webhooks/outbox/query", Method: "POST", HandlerFunc: ci.WebhookOutboxQuery, ApiCode: "WebhookOutboxQuery"},
+		&handlerFuncObj{Url: "/webhooks/outbox/retry/:id", Method: "POST", HandlerFunc: ci.WebhookOutboxRetry, LogOperation: true, ApiCode: "WebhookOutboxRetry"},
+		&handlerFuncObj{Url: "/webhooks/delivery/query", Method: "POST", HandlerFunc: ci.WebhookDeliveryQuery, ApiCode: "WebhookDeliveryQuery"},
+		&handlerFuncObj{Url: "/work-queue/query", Method: "POST", HandlerFunc: ci.WorkQueueQuery, ApiCode: "WorkQueueQuery"},
+		&handlerFuncObj{Url: "/work-queue/retry/:id", Method: "POST", HandlerFunc: ci.WorkQueueRetry, LogOperation: true, ApiCode: "WorkQueueRetry"},
+		&handlerFuncObj{Url: "/jobs", Method: "POST", HandlerFunc: ci.JobSubmit, LogOperation: true, ApiCode: "JobSubmit"},
+		&handlerFuncObj{Url: "/jobs/query", Method: "POST", HandlerFunc: ci.JobQuery, ApiCode: "JobQuery"},
+		&handlerFuncObj{Url: "/jobs/:id", Method: "GET", HandlerFunc: ci.JobGet, ApiCode: "JobGet"},
+		&handlerFuncObj{Url: "/jobs/:id/cancel", Method: "POST", HandlerFunc: ci.JobCancel, LogOperation: true, ApiCode: "JobCancel"},
+		&handlerFuncObj{Url: "/jobs/:id/result", Method: "GET", HandlerFunc: ci.JobResultDownload, ApiCode: "JobResultDownload"},
+	)
 	// log
 	httpHandlerFuncList = append(httpHandlerFuncList,
 		&handlerFuncObj{Url: "/log/query", Method: "POST", HandlerFunc: ci.QueryOperationLog, ApiCode: "QueryOperationLog"},
 		&handlerFuncObj{Url: "/log/operation", Method: "GET", HandlerFunc: ci.GetAllLogOperation, ApiCode: "GetAllLogOperation"},
+		&handlerFuncObj{Url: "/log/chain/verify", Method: "GET", HandlerFunc: ci.VerifyOperationLogChain, ApiCode: "VerifyOperationLogChain"},
+		&handlerFuncObj{Url: "/log/archive", Method: "POST", HandlerFunc: ci.ArchiveOperationLog, ApiCode: "ArchiveOperationLog"},
+		&handlerFuncObj{Url: "/log/archive", Method: "GET", HandlerFunc: ci.QueryOperationLogArchive, ApiCode: "QueryOperationLogArchive"},
+		&handlerFuncObj{Url: "/log/archive/:archiveId/verify", Method: "GET", HandlerFunc: ci.VerifyOperationLogArchive, ApiCode: "VerifyOperationLogArchive"},
+		&handlerFuncObj{Url: "/log/audit/query", Method: "POST", HandlerFunc: ci.QueryCiDataAudit, ApiCode: "QueryCiDataAudit"},
 	)
 	// permission
 	httpHandlerFuncList = append(httpHandlerFuncList,
@@ -147,6 +206,20 @@ func init() {
 		&handlerFuncObj{Url: "/view-data", Method: "POST", HandlerFunc: view.GetViewData, ApiCode: "GetViewData"},
 		&handlerFuncObj{Url: "/view-graph-data", Method: "POST", HandlerFunc: view.GetGraphViewData, ApiCode: "GetGraphViewData"},
 		&handlerFuncObj{Url: "/view-confirm", Method: "POST", HandlerFunc: view.ConfirmView, ApiCode: "ConfirmView"},
+		&handlerFuncObj{Url: "/view-confirm/diff", Method: "POST", HandlerFunc: view.ConfirmViewDiff, ApiCode: "ConfirmViewDiff"},
+		&handlerFuncObj{Url: "/view-message/:viewId", Method: "GET", HandlerFunc: view.GetViewDetail, ApiCode: "GetViewDetail"},
+		&handlerFuncObj{Url: "/views", Method: "POST", HandlerFunc: view.CreateView, LogOperation: true, ApiCode: "CreateView"},
+		&handlerFuncObj{Url: "/views", Method: "PUT", HandlerFunc: view.UpdateView, LogOperation: true, ApiCode: "UpdateView"},
+		&handlerFuncObj{Url: "/views", Method: "DELETE", HandlerFunc: view.DeleteView, LogOperation: true, ApiCode: "DeleteView"},
+		&handlerFuncObj{Url: "/views/copy", Method: "POST", HandlerFunc: view.CopyView, LogOperation: true, ApiCode: "CopyView"},
+		&handlerFuncObj{Url: "/view-graphs-query", Method: "POST", HandlerFunc: view.QueryViewGraph, ApiCode: "QueryViewGraph"},
+		&handlerFuncObj{Url: "/view-graphs", Method: "POST", HandlerFunc: view.CreateGraph, LogOperation: true, ApiCode: "CreateGraph"},
+		&handlerFuncObj{Url: "/view-graphs", Method: "PUT", HandlerFunc: view.UpdateGraph, LogOperation: true, ApiCode: "UpdateGraph"},
+		&handlerFuncObj{Url: "/view-graphs", Method: "DELETE", HandlerFunc: view.DeleteGraph, LogOperation: true, ApiCode: "DeleteGraph"},
+		&handlerFuncObj{Url: "/view-elements-query", Method: "POST", HandlerFunc: view.QueryGraphElement, ApiCode: "QueryGraphElement"},
+		&handlerFuncObj{Url: "/view-elements", Method: "POST", HandlerFunc: view.CreateGraphElement, LogOperation: true, ApiCode: "CreateGraphElement"},
+		&handlerFuncObj{Url: "/view-elements", Method: "PUT", HandlerFunc: view.UpdateGraphElement, LogOperation: true, ApiCode: "UpdateGraphElement"},
+		&handlerFuncObj{Url: "/view-elements", Method: "DELETE", HandlerFunc: view.DeleteGraphElement, LogOperation: true, ApiCode: "DeleteGraphElement"},
 	)
 
 	// report
@@ -181,6 +254,9 @@ func init() {
 
 	httpHandlerFuncList = append(httpHandlerFuncList,
 		&handlerFuncObj{Url: "/sync/query", Method: "GET", HandlerFunc: ci.GetSyncRecord, ApiCode: "getSyncRecord"},
+		&handlerFuncObj{Url: "/sync/retry/:id", Method: "POST", HandlerFunc: ci.SyncRetry, LogOperation: true, ApiCode: "SyncRetry"},
+		&handlerFuncObj{Url: "/sync/snapshot", Method: "POST", HandlerFunc: ci.SyncSnapshot, LogOperation: true, ApiCode: "SyncSnapshot"},
+		&handlerFuncObj{Url: "/sync/resync", Method: "POST", HandlerFunc: ci.SyncResync, LogOperation: true, ApiCode: "SyncResync"},
 	)
 }
 
@@ -210,6 +286,7 @@ func InitHttpServer() {
 	}
 	// const handler func
 	r.POST(urlPrefix+"/api/v1/login", permission.Login)
+	r.POST(urlPrefix+"/sync/receive/:fileName", ci.SyncReceive)
 	// register handler func with auth
 	authRouter := r.Group(urlPrefix+"/api/v1", middleware.AuthToken())
 	authRouter.GET("/refresh-token", permission.RefreshToken)
@@ -1,7 +1,29 @@
 package middleware
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/services/db"
+	"github.com/gin-gonic/gin"
+)
 
 func GetRemoteIp(c *gin.Context) string {
 	return c.ClientIP()
 }
+
+func GetJobRequestContext(c *gin.Context) *models.JobRequestContext {
+	return &models.JobRequestContext{Operator: GetRequestUser(c), Roles: GetRequestRoles(c), UserToken: c.GetHeader("Authorization")}
+}
+
+// ReturnAsyncJob 请求带上async=true时提交异步任务并返回任务,返回false表示继续同步处理
+func ReturnAsyncJob(c *gin.Context, jobType string, param interface{}) bool {
+	if c.Query("async") != "true" {
+		return false
+	}
+	job, err := db.SubmitJob(jobType, param, GetJobRequestContext(c))
+	if err != nil {
+		ReturnServerHandleError(c, err)
+	} else {
+		ReturnData(c, job)
+	}
+	return true
+}
@@ -77,7 +77,9 @@ func ReturnParamEmptyError(c *gin.Context, paramName string) {
 func ReturnServerHandleError(c *gin.Context, err error) {
 	//log.Error(nil, log.LOGGER_APP, "Request server handle error", zap.Error(err))
 	//ReturnError(c, "SERVER_HANDLE_ERROR", err.Error(), nil)
-	err = exterror.Catch(exterror.New().ServerHandleError, err)
+	if _, b := err.(exterror.CustomError); !b {
+		err = exterror.Catch(exterror.New().ServerHandleError, err)
+	}
 	errorCode, errorKey, errorMessage := exterror.GetErrorResult(c.GetHeader(exterror.AcceptLanguageHeader), err, -1)
 	ReturnError(c, errorCode, errorKey, errorMessage, nil)
 }
@@ -12,6 +12,7 @@ import (
 
 	"github.com/WeBankPartners/go-common-lib/cipher"
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/api/middleware"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/graph"
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/services/db"
 	"github.com/gin-gonic/gin"
@@ -40,9 +41,14 @@ func DataQuery(c *gin.Context) {
 		return
 	}
 	//Query database
+	param.Roles = middleware.GetRequestRoles(c)
 	pageInfo, rowData, err := db.CiDataQuery(c.Param("ciType"), &param, &legalGuidList, false, false)
 	if err != nil {
-		middleware.ReturnServerHandleError(c, err)
+		if strings.Contains(err.Error(), "permission deny") {
+			middleware.ReturnDataPermissionDenyWithError(c, err)
+		} else {
+			middleware.ReturnServerHandleError(c, err)
+		}
 	} else {
 		if len(rowData) == 0 {
 			rowData = []map[string]interface{}{}
@@ -53,8 +59,7 @@ func DataQuery(c *gin.Context) {
 
 func DataOperation(c *gin.Context) {
 	var interfaceParam []map[string]interface{}
-	var err error
-	if err = c.ShouldBindJSON(&interfaceParam); err != nil {
+	if err := c.ShouldBindJSON(&interfaceParam); err != nil {
 		middleware.ReturnParamValidateError(c, err)
 		return
 	}
@@ -63,7 +68,34 @@ func DataOperation(c *gin.Context) {
 		// middleware.ReturnParamValidateError(c, fmt.Errorf("Input data empty "))
 		return
 	}
-	var param []models.CiDataMapObj
+	param, err := transInterfaceRowsToCiData(interfaceParam)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	//
+	onlyQueryStr := c.Query("onlyQuery")
+	var onlyQuery bool
+	if onlyQueryStr != "" {
+		onlyQuery, _ = strconv.ParseBool(onlyQueryStr)
+	}
+	handleParam := models.HandleCiDataParam{InputData: param, CiTypeId: c.Param("ciType"), Operation: c.Param("operation"), Operator: middleware.GetRequestUser(c), Roles: middleware.GetRequestRoles(c), Permission: true, OnlyQuery: onlyQuery}
+	handleParam.UserToken = c.GetHeader("Authorization")
+	//resultData, err := db.HandleCiDataOperation(param, c.Param("ciType"), c.Param("operation"), middleware.GetRequestUser(c), "", middleware.GetRequestRoles(c), true, false)
+	resultData, newInputData, handleErr := db.HandleCiDataOperation(handleParam)
+	c.Set("requestBody", newInputData)
+	if handleErr != nil {
+		if strings.Contains(handleErr.Error(), "permission deny") {
+			middleware.ReturnDataPermissionDenyWithError(c, handleErr)
+		} else {
+			middleware.ReturnServerHandleError(c, handleErr)
+		}
+	} else {
+		middleware.ReturnData(c, resultData)
+	}
+}
+
+func transInterfaceRowsToCiData(interfaceParam []map[string]interface{}) (param []models.CiDataMapObj, err error) {
 	for i, inputRow := range interfaceParam {
 		stringMap := make(map[string]string)
 		for k, v := range inputRow {
@@ -88,29 +120,90 @@ func DataOperation(c *gin.Context) {
 		}
 		param = append(param, stringMap)
 	}
+	return
+}
+
+// DataBulkOperation 批量数据操作,创建后台任务后立即返回任务,通过任务id查询进度与每行结果
+func DataBulkOperation(c *gin.Context) {
+	var param models.CiDataBulkParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	for i, item := range param.Items {
+		inputData, err := transInterfaceRowsToCiData(item.Rows)
+		if err != nil {
+			middleware.ReturnParamValidateError(c, fmt.Errorf("Item:%d %s", i, err.Error()))
+			return
+		}
+		item.InputData = inputData
+		item.Rows = nil
+	}
+	job, err := db.CreateCiDataBulkJob(&param, middleware.GetJobRequestContext(c))
 	if err != nil {
 		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, job)
+	}
+}
+
+func DataBulkJobGet(c *gin.Context) {
+	job, err := db.GetCiDataBulkJob(c.Param("jobId"))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, job)
+	}
+}
+
+func DataBulkRowQuery(c *gin.Context) {
+	var param models.QueryRequestParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
 		return
 	}
-	//
-	onlyQueryStr := c.Query("onlyQuery")
-	var onlyQuery bool
-	if onlyQueryStr != "" {
-		onlyQuery, _ = strconv.ParseBool(onlyQueryStr)
+	pageInfo, rowData, err := db.QueryCiDataBulkRow(c.Param("jobId"), &param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
 	}
-	handleParam := models.HandleCiDataParam{InputData: param, CiTypeId: c.Param("ciType"), Operation: c.Param("operation"), Operator: middleware.GetRequestUser(c), Roles: middleware.GetRequestRoles(c), Permission: true, OnlyQuery: onlyQuery}
-	handleParam.UserToken = c.GetHeader("Authorization")
-	//resultData, err := db.HandleCiDataOperation(param, c.Param("ciType"), c.Param("operation"), middleware.GetRequestUser(c), "", middleware.GetRequestRoles(c), true, false)
-	resultData, newInputData, handleErr := db.HandleCiDataOperation(handleParam)
-	c.Set("requestBody", newInputData)
-	if handleErr != nil {
-		if strings.Contains(handleErr.Error(), "permission deny") {
-			middleware.ReturnDataPermissionDenyWithError(c, handleErr)
-		} else {
-			middleware.ReturnServerHandleError(c, handleErr)
-		}
+}
+
+// DataExpressValidate 检查路径表达式的语法与其中的ci类型、属性,错误带字符位置
+func DataExpressValidate(c *gin.Context) {
+	var param models.ExpressValidateParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	result, err := db.ValidateExpress(param.Express)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
 	} else {
-		middleware.ReturnData(c, resultData)
+		middleware.ReturnData(c, result)
+	}
+}
+
+// DataAutofillCheck 重新计算自动填充属性并检查一致性,fix为true时修正数据,async=true时以异步任务执行
+func DataAutofillCheck(c *gin.Context) {
+	var param models.AutofillCheckParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if param.Fix && !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	if middleware.ReturnAsyncJob(c, models.JobTypeAutofillCheck, param) {
+		return
+	}
+	result, err := db.CheckCiAutofill(&param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, result)
 	}
 }
 
@@ -124,6 +217,7 @@ func DataReferenceQuery(c *gin.Context) {
 	if param.Dialect == nil {
 		param.Dialect = &models.QueryRequestDialect{}
 	}
+	param.Roles = middleware.GetRequestRoles(c)
 	pageInfo, resultData, err := db.GetCiDataByFilters(ciAttrId, param.Dialect.AssociatedData, param, c.GetHeader(models.HeaderAuthorization))
 	if err != nil {
 		middleware.ReturnServerHandleError(c, err)
@@ -152,6 +246,45 @@ func DataRollbackList(c *gin.Context) {
 	}
 }
 
+func DataDiff(c *gin.Context) {
+	guid := c.Param("guid")
+	lastIndex := strings.LastIndex(guid, "_")
+	if lastIndex <= 0 {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("Url param guid:%s illegal ", guid))
+		return
+	}
+	// Permissions
+	permissions, tmpErr := db.GetRoleCiDataPermission(middleware.GetRequestRoles(c), guid[:lastIndex], "", models.DataActionQuery)
+	if tmpErr != nil {
+		middleware.ReturnDataPermissionError(c, tmpErr)
+		return
+	}
+	legalGuidList, tmpErr := db.GetCiDataPermissionGuidList(&permissions, models.DataActionQuery)
+	if tmpErr != nil {
+		middleware.ReturnDataPermissionError(c, tmpErr)
+		return
+	}
+	if !legalGuidList.Legal {
+		legalFlag := false
+		for _, v := range legalGuidList.GuidList {
+			if v == guid {
+				legalFlag = true
+				break
+			}
+		}
+		if !legalFlag {
+			middleware.ReturnDataPermissionDenyError(c)
+			return
+		}
+	}
+	result, err := db.DataDiff(guid, c.Query("from"), c.Query("to"), middleware.GetRequestRoles(c))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, result)
+	}
+}
+
 func DataPasswordQuery(c *gin.Context) {
 	ciTypeId := c.Param("ciType")
 	guid := c.Param("guid")
@@ -203,6 +336,9 @@ func DataImport(c *gin.Context) {
 	//middleware.ReturnParamValidateError(c, fmt.Errorf("rootCiType is %s,not %s", param.RootCiType, ciTypeId))
 	//return
 	//}
+	if middleware.ReturnAsyncJob(c, models.JobTypeImportCiData, models.ImportCiDataJobParam{Data: &param}) {
+		return
+	}
 	if err = db.ImportCiData(&param, middleware.GetRequestUser(c), false); err != nil {
 		middleware.ReturnServerHandleError(c, err)
 	} else {
@@ -425,3 +561,83 @@ func AttrSensitiveDataQuery(c *gin.Context) {
 		middleware.ReturnData(c, paramList)
 	}
 }
+
+// DataImpact 从一行数据出发向上下游遍历引用关系,ciTypes、attrs、states用逗号分隔
+func DataImpact(c *gin.Context) {
+	param := models.CiImpactParam{Guid: c.Param("guid"), Direction: c.Query("direction"), Depth: models.ImpactDefaultDepth, Limit: models.ImpactDefaultNodeLimit,
+		CiTypes: splitQueryList(c.Query("ciTypes")), Attrs: splitQueryList(c.Query("attrs")), States: splitQueryList(c.Query("states")), Roles: middleware.GetRequestRoles(c)}
+	if param.Direction == "" {
+		param.Direction = models.ImpactDirectionBoth
+	}
+	if param.Direction != models.ImpactDirectionUpstream && param.Direction != models.ImpactDirectionDownstream && param.Direction != models.ImpactDirectionBoth {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("Url param direction:%s illegal,should be upstream,downstream or both ", param.Direction))
+		return
+	}
+	var err error
+	if c.Query("depth") != "" {
+		if param.Depth, err = strconv.Atoi(c.Query("depth")); err != nil || param.Depth <= 0 || param.Depth > models.ImpactMaxDepth {
+			middleware.ReturnParamValidateError(c, fmt.Errorf("Url param depth illegal,should be 1-%d ", models.ImpactMaxDepth))
+			return
+		}
+	}
+	if c.Query("limit") != "" {
+		if param.Limit, err = strconv.Atoi(c.Query("limit")); err != nil || param.Limit <= 0 || param.Limit > models.ImpactMaxNodeLimit {
+			middleware.ReturnParamValidateError(c, fmt.Errorf("Url param limit illegal,should be 1-%d ", models.ImpactMaxNodeLimit))
+			return
+		}
+	}
+	result, err := db.QueryCiImpact(&param)
+	if err != nil {
+		if strings.Contains(err.Error(), "permission deny") {
+			middleware.ReturnDataPermissionDenyWithError(c, err)
+		} else {
+			middleware.ReturnServerHandleError(c, err)
+		}
+		return
+	}
+	result.Dot = renderImpactDot(result)
+	middleware.ReturnData(c, result)
+}
+
+func splitQueryList(input string) []string {
+	result := []string{}
+	for _, v := range strings.Split(input, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func renderImpactDot(result *models.CiImpactResult) string {
+	var nodes []*graph.RelationNode
+	var edges []*graph.RelationEdge
+	for _, node := range result.Nodes {
+		label := fmt.Sprintf("%s\n%s", node.KeyName, node.CiTypeName)
+		nodes = append(nodes, &graph.RelationNode{Id: node.Guid, Label: label, Tooltip: fmt.Sprintf("%s %s", node.Guid, node.StateName), Root: node.Guid == result.Root})
+	}
+	for _, edge := range result.Edges {
+		edges = append(edges, &graph.RelationEdge{From: edge.From, To: edge.To, Label: edge.AttrName, Dashed: edge.Cycle})
+	}
+	return graph.RenderRelationDot(nodes, edges)
+}
+
+// DataGraphQuery 按路径模式查询关联数据,如 app_system>unit~(host)host_resource
+func DataGraphQuery(c *gin.Context) {
+	var param models.CiGraphQueryParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	param.Roles = middleware.GetRequestRoles(c)
+	result, err := db.QueryCiGraph(&param)
+	if err != nil {
+		if strings.Contains(err.Error(), "permission deny") {
+			middleware.ReturnDataPermissionDenyWithError(c, err)
+		} else {
+			middleware.ReturnServerHandleError(c, err)
+		}
+	} else {
+		middleware.ReturnData(c, result)
+	}
+}
@@ -0,0 +1,151 @@
+package ci
+
+import (
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/api/middleware"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/services/db"
+	"github.com/gin-gonic/gin"
+)
+
+func CiTemplateGet(c *gin.Context) {
+	result, err := db.GetCiTemplateDetail(c.Param("id"))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, result)
+	}
+}
+
+func CiTemplateCreate(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	var param models.SysCiTemplateTable
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if err := db.CreateCiTemplate(&param); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: param, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTemplateCreate", DataCategory: models.SyncCategoryModel, DataType: param.Id})
+		middleware.ReturnData(c, param)
+	}
+}
+
+func CiTemplateUpdate(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	var param models.SysCiTemplateTable
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	param.Id = c.Param("id")
+	if err := db.UpdateCiTemplate(&param); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: param, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTemplateUpdate", DataCategory: models.SyncCategoryModel, DataType: param.Id})
+		middleware.ReturnData(c, param)
+	}
+}
+
+func CiTemplateDelete(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	templateId := c.Param("id")
+	if err := db.DeleteCiTemplate(templateId); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: templateId, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTemplateDelete", DataCategory: models.SyncCategoryModel, DataType: templateId})
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func CiTemplateAttrCreate(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	var param models.SysCiTemplateAttrTable
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	param.CiTemplate = c.Param("id")
+	if err := db.CreateCiTemplateAttr(&param); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: param, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTemplateAttrCreate", DataCategory: models.SyncCategoryModel, DataType: param.CiTemplate})
+		middleware.ReturnData(c, param)
+	}
+}
+
+func CiTemplateAttrUpdate(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	var param models.SysCiTemplateAttrTable
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	param.CiTemplate = c.Param("id")
+	if err := db.UpdateCiTemplateAttr(&param); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: param, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTemplateAttrUpdate", DataCategory: models.SyncCategoryModel, DataType: param.CiTemplate})
+		middleware.ReturnData(c, param)
+	}
+}
+
+func CiTemplateAttrDelete(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	templateId, attrId := c.Param("id"), c.Param("attrId")
+	if err := db.DeleteCiTemplateAttr(templateId, attrId); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: attrId, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTemplateAttrDelete", DataCategory: models.SyncCategoryModel, DataType: templateId})
+		middleware.ReturnSuccess(c)
+	}
+}
+
+// CiTemplatePropagate 把模板属性的变化同步到使用该模板的ci类型,dryRun=true时只返回预览
+func CiTemplatePropagate(c *gin.Context) {
+	var param models.CiTemplatePropagateParam
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&param); err != nil {
+			middleware.ReturnParamValidateError(c, err)
+			return
+		}
+	}
+	templateId := c.Param("id")
+	var plan *models.CiTemplatePropagatePlan
+	var err error
+	if c.Query("dryRun") == "true" {
+		plan, _, err = db.PlanCiTemplatePropagate(templateId, &param)
+	} else {
+		if !middleware.CheckModifyLegal(c) {
+			middleware.ReturnSlaveModifyDenyError(c)
+			return
+		}
+		plan, err = db.ApplyCiTemplatePropagate(templateId, &param)
+		if err == nil {
+			db.SyncPush(&models.SysSyncRecordTable{ContentData: param, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTemplatePropagate", DataCategory: models.SyncCategoryModel, DataType: templateId})
+		}
+	}
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, plan)
+	}
+}
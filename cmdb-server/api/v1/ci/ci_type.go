@@ -279,6 +279,76 @@ func GetStateTransition(c *gin.Context) {
 	}
 }
 
+func StateMachineList(c *gin.Context) {
+	result, err := db.QueryStateMachineList()
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, result)
+	}
+}
+
+func StateMachineCreate(c *gin.Context) {
+	var param models.StateMachineParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	saveStateMachine(c, &param, true)
+}
+
+func StateMachineUpdate(c *gin.Context) {
+	var param models.StateMachineParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	param.Id = c.Param("id")
+	saveStateMachine(c, &param, false)
+}
+
+// saveStateMachine dryRun=true时只返回校验结果与影响的数据,force=true时允许删除仍有数据的状态
+func saveStateMachine(c *gin.Context, param *models.StateMachineParam, isNew bool) {
+	var plan *models.StateMachineSavePlan
+	var err error
+	if c.Query("dryRun") == "true" {
+		plan, err = db.PlanStateMachine(param, isNew)
+	} else {
+		if !middleware.CheckModifyLegal(c) {
+			middleware.ReturnSlaveModifyDenyError(c)
+			return
+		}
+		fromSync := c.GetString("fromSync") == "yes"
+		plan, err = db.SaveStateMachine(param, isNew, c.Query("force") == "true" || fromSync)
+		if err == nil {
+			actionFunc := "StateMachineUpdate"
+			if isNew {
+				actionFunc = "StateMachineCreate"
+			}
+			db.SyncPush(&models.SysSyncRecordTable{ContentData: param, Operator: middleware.GetRequestUser(c), ActionFunc: actionFunc, DataCategory: models.SyncCategoryModel, DataType: param.Id})
+		}
+	}
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, plan)
+	}
+}
+
+func StateMachineDelete(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	machineId := c.Param("id")
+	if err := db.DeleteStateMachine(machineId); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: machineId, Operator: middleware.GetRequestUser(c), ActionFunc: "StateMachineDelete", DataCategory: models.SyncCategoryModel, DataType: machineId})
+		middleware.ReturnSuccess(c)
+	}
+}
+
 func saveImageFile(imageString, imageType string) (imageGuid, imageFileName string, err error) {
 	if len(imageString) > 204800 {
 		err = fmt.Errorf("Image file too big,max size is 200KB ")
@@ -344,3 +414,113 @@ func QueryIdAndName(c *gin.Context) {
 	}
 	middleware.ReturnData(c, result)
 }
+
+func CiTypeUniqueKeyQuery(c *gin.Context) {
+	rowData, err := db.QueryCiTypeUniqueIndex(c.Param("ciType"))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, rowData)
+	}
+}
+
+func CiTypeUniqueKeyCreate(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	var param models.CiTypeUniqueKeyParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	ciTypeId := c.Param("ciType")
+	rowData, err := db.CreateCiTypeUniqueKey(ciTypeId, &param, middleware.GetRequestUser(c))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: param, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTypeUniqueKeyCreate", DataCategory: models.SyncCategoryModel, DataType: ciTypeId})
+		middleware.ReturnData(c, rowData)
+	}
+}
+
+func CiTypeUniqueKeyDelete(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	ciTypeId, keyId := c.Param("ciType"), c.Param("id")
+	if err := db.DeleteCiTypeUniqueKey(ciTypeId, keyId); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: keyId, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTypeUniqueKeyDelete", DataCategory: models.SyncCategoryModel, DataType: ciTypeId})
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func CiTypeIndexQuery(c *gin.Context) {
+	rowData, err := db.QueryCiTypeIndex(c.Param("ciType"))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, rowData)
+	}
+}
+
+func CiTypeIndexCreate(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	var param models.CiTypeIndexParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	ciTypeId := c.Param("ciType")
+	rowData, err := db.CreateCiTypeIndex(ciTypeId, &param, middleware.GetRequestUser(c))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: param, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTypeIndexCreate", DataCategory: models.SyncCategoryModel, DataType: ciTypeId})
+		middleware.ReturnData(c, rowData)
+	}
+}
+
+func CiTypeIndexApply(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	ciTypeId, indexId := c.Param("ciType"), c.Param("id")
+	rowData, err := db.ApplyCiTypeIndex(ciTypeId, indexId)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: indexId, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTypeIndexApply", DataCategory: models.SyncCategoryModel, DataType: ciTypeId})
+		middleware.ReturnData(c, rowData)
+	}
+}
+
+func CiTypeIndexDelete(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	ciTypeId, indexId := c.Param("ciType"), c.Param("id")
+	if err := db.DeleteCiTypeIndex(ciTypeId, indexId); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: indexId, Operator: middleware.GetRequestUser(c), ActionFunc: "CiTypeIndexDelete", DataCategory: models.SyncCategoryModel, DataType: ciTypeId})
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func CiTypeIndexSuggest(c *gin.Context) {
+	rowData, err := db.QueryCiTypeIndexSuggest(c.Param("ciType"))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, rowData)
+	}
+}
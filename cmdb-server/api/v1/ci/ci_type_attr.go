@@ -110,6 +110,13 @@ func AttrUpdate(c *gin.Context) {
 		middleware.ReturnParamValidateError(c, err)
 		return
 	}
+	// refFilterCheck=true时,现有数据有不满足新引用过滤规则的引用值则拒绝更新
+	if c.Query("refFilterCheck") == "true" {
+		if err := db.CheckAttrRefFilterChange(&param); err != nil {
+			middleware.ReturnServerHandleError(c, err)
+			return
+		}
+	}
 	//Update database
 	_, err := db.CiAttrUpdate(&param)
 	if err != nil {
@@ -136,7 +143,7 @@ func AttrDelete(c *gin.Context) {
 	if err != nil {
 		middleware.ReturnServerHandleError(c, err)
 	} else {
-		db.SyncPush(&models.SysSyncRecordTable{ContentData: map[string]string{"ciAttr": ciAttrId}, Operator: middleware.GetRequestUser(c), ActionFunc: "AttrDelete", DataCategory: "model", DataType: ciAttrId})
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: map[string]string{"ciAttr": ciAttrId}, Operator: middleware.GetRequestUser(c), ActionFunc: "AttrDelete", DataCategory: models.SyncCategoryModel, DataType: ciAttrId})
 		middleware.ReturnData(c, []string{})
 	}
 }
@@ -180,7 +187,7 @@ func AttrApply(c *gin.Context) {
 		middleware.ReturnServerHandleError(c, err)
 	} else {
 		db.AutoCreateRoleCiTypeAttrPermission(ciTypeGuid)
-		db.SyncPush(&models.SysSyncRecordTable{ContentData: param, Operator: middleware.GetRequestUser(c), ActionFunc: "AttrApply", DataCategory: "model", DataType: ciAttrId})
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: param, Operator: middleware.GetRequestUser(c), ActionFunc: "AttrApply", DataCategory: models.SyncCategoryModel, DataType: ciAttrId})
 		middleware.ReturnData(c, []string{})
 	}
 }
@@ -201,7 +208,7 @@ func AttrRollback(c *gin.Context) {
 	if err != nil {
 		middleware.ReturnServerHandleError(c, err)
 	} else {
-		db.SyncPush(&models.SysSyncRecordTable{ContentData: map[string]string{"ciAttr": ciAttrId}, Operator: middleware.GetRequestUser(c), ActionFunc: "AttrRollback", DataCategory: "model", DataType: ciAttrId})
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: map[string]string{"ciAttr": ciAttrId}, Operator: middleware.GetRequestUser(c), ActionFunc: "AttrRollback", DataCategory: models.SyncCategoryModel, DataType: ciAttrId})
 		middleware.ReturnData(c, []string{})
 	}
 }
@@ -229,3 +236,96 @@ func AttrPositionSwap(c *gin.Context) {
 		middleware.ReturnData(c, []string{})
 	}
 }
+
+// AttrRefFilterPreview 预览新的引用过滤规则可选的引用数据,以及现有数据中会变得不合法的引用
+func AttrRefFilterPreview(c *gin.Context) {
+	var param models.AttrRefFilterPreviewParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	result, err := db.PreviewAttrRefFilter(c.Param("ciType"), c.Param("ciAttr"), &param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, result)
+	}
+}
+
+// AttrAutofillExplain 按指定数据行逐段解释自动填充规则的计算过程,可传入未保存的规则进行试算
+func AttrAutofillExplain(c *gin.Context) {
+	var param models.AutofillExplainParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	result, err := db.ExplainCiAttrAutofill(c.Param("ciType"), c.Param("ciAttr"), &param, middleware.GetRequestRoles(c))
+	if err != nil {
+		if strings.Contains(err.Error(), "permission deny") {
+			middleware.ReturnDataPermissionDenyWithError(c, err)
+		} else {
+			middleware.ReturnServerHandleError(c, err)
+		}
+	} else {
+		middleware.ReturnData(c, result)
+	}
+}
+
+func AttrMigrationPlan(c *gin.Context) {
+	var param models.SchemaMigrationParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	plan, err := db.PlanSchemaMigration(c.Param("ciAttr"), &param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, plan)
+	}
+}
+
+func AttrMigrationApply(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	var param models.SchemaMigrationParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	ciAttrId := c.Param("ciAttr")
+	record, err := db.ApplySchemaMigration(ciAttrId, &param, middleware.GetRequestUser(c))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: param, Operator: middleware.GetRequestUser(c), ActionFunc: "AttrMigrationApply", DataCategory: models.SyncCategoryModel, DataType: ciAttrId})
+		middleware.ReturnData(c, record)
+	}
+}
+
+func AttrMigrationList(c *gin.Context) {
+	rowData, err := db.QuerySchemaMigration(c.Param("ciType"))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, rowData)
+	}
+}
+
+func AttrMigrationRollback(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	record, err := db.RollbackSchemaMigration(c.Param("id"), middleware.GetRequestUser(c))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		// 备环境没有相同的迁移记录,按回滚后的类型同步
+		syncParam := models.SchemaMigrationParam{DataType: record.NewDataType, DataLength: record.NewDataLength}
+		db.SyncPush(&models.SysSyncRecordTable{ContentData: syncParam, Operator: middleware.GetRequestUser(c), ActionFunc: "AttrMigrationApply", DataCategory: models.SyncCategoryModel, DataType: record.CiTypeAttr})
+		middleware.ReturnData(c, record)
+	}
+}
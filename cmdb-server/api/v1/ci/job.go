@@ -0,0 +1,71 @@
+package ci
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/api/middleware"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/services/db"
+	"github.com/gin-gonic/gin"
+)
+
+func JobSubmit(c *gin.Context) {
+	var param models.JobSubmitParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if err := db.CheckUserSubmitJob(param.JobType); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	job, err := db.SubmitJob(param.JobType, param.Param, middleware.GetJobRequestContext(c))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, job)
+	}
+}
+
+func JobGet(c *gin.Context) {
+	job, err := db.GetJob(c.Param("id"), middleware.GetJobRequestContext(c))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, job)
+	}
+}
+
+func JobQuery(c *gin.Context) {
+	var param models.QueryRequestParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	pageInfo, rowData, err := db.QueryJob(&param, middleware.GetJobRequestContext(c))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
+	}
+}
+
+func JobCancel(c *gin.Context) {
+	if err := db.CancelJob(c.Param("id"), middleware.GetJobRequestContext(c)); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+// JobResultDownload 以json文件下载任务结果
+func JobResultDownload(c *gin.Context) {
+	job, err := db.GetJobResult(c.Param("id"), middleware.GetJobRequestContext(c))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s_%s.json", job.JobType, job.Id))
+	c.Data(http.StatusOK, "application/json", []byte(job.Result))
+}
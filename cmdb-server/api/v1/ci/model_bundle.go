@@ -0,0 +1,117 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/api/middleware"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/services/db"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+func ModelExport(c *gin.Context) {
+	var ciTypeList []string
+	if c.Query("ciType") != "" {
+		ciTypeList = strings.Split(c.Query("ciType"), ",")
+	}
+	bundle, err := db.ExportModelBundle(ciTypeList)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	if strings.ToLower(c.Query("format")) != "yaml" {
+		middleware.ReturnData(c, bundle)
+		return
+	}
+	yamlBytes, err := transModelBundleToYaml(bundle)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=cmdb_model_%s.yaml", time.Now().Format("20060102150405")))
+	c.Data(http.StatusOK, "application/x-yaml", yamlBytes)
+}
+
+// ModelImport 默认只返回导入计划,dryRun=false时才执行导入
+func ModelImport(c *gin.Context) {
+	if !middleware.CheckModifyLegal(c) {
+		middleware.ReturnSlaveModifyDenyError(c)
+		return
+	}
+	bodyBytes, err := c.GetRawData()
+	if err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	var bundle models.ModelBundle
+	if strings.ToLower(c.Query("format")) == "yaml" || strings.Contains(c.GetHeader("Content-Type"), "yaml") {
+		err = transYamlToModelBundle(bodyBytes, &bundle)
+	} else {
+		err = json.Unmarshal(bodyBytes, &bundle)
+	}
+	if err != nil {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("Model bundle content illegal,%s ", err.Error()))
+		return
+	}
+	var plan *models.ModelImportPlan
+	if c.Query("dryRun") == "false" || c.GetString("fromSync") == "yes" {
+		plan, err = db.ApplyModelImport(&bundle, middleware.GetRequestUser(c))
+		if err == nil {
+			db.SyncPush(&models.SysSyncRecordTable{ContentData: bundle, Operator: middleware.GetRequestUser(c), ActionFunc: "ModelImport", DataCategory: models.SyncCategoryModel, DataType: "model"})
+		}
+	} else {
+		plan, err = db.PlanModelImport(&bundle)
+	}
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, plan)
+	}
+}
+
+// transModelBundleToYaml 先转成json再转yaml,保证yaml的字段名与json一致
+func transModelBundleToYaml(bundle *models.ModelBundle) (yamlBytes []byte, err error) {
+	var bundleObj interface{}
+	jsonBytes, _ := json.Marshal(bundle)
+	if err = json.Unmarshal(jsonBytes, &bundleObj); err != nil {
+		return
+	}
+	if yamlBytes, err = yaml.Marshal(bundleObj); err != nil {
+		err = fmt.Errorf("Try to marshal model bundle to yaml fail,%s ", err.Error())
+	}
+	return
+}
+
+func transYamlToModelBundle(yamlBytes []byte, bundle *models.ModelBundle) (err error) {
+	var bundleObj interface{}
+	if err = yaml.Unmarshal(yamlBytes, &bundleObj); err != nil {
+		return
+	}
+	jsonBytes, jsonErr := json.Marshal(transYamlMapKey(bundleObj))
+	if jsonErr != nil {
+		return jsonErr
+	}
+	return json.Unmarshal(jsonBytes, bundle)
+}
+
+// transYamlMapKey yaml解析出来的map的key是interface{},json不支持,需要转成string
+func transYamlMapKey(input interface{}) interface{} {
+	switch value := input.(type) {
+	case map[interface{}]interface{}:
+		output := make(map[string]interface{})
+		for k, v := range value {
+			output[fmt.Sprintf("%v", k)] = transYamlMapKey(v)
+		}
+		return output
+	case []interface{}:
+		for i, v := range value {
+			value[i] = transYamlMapKey(v)
+		}
+	}
+	return input
+}
@@ -1,6 +1,8 @@
 package ci
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/api/middleware"
@@ -71,3 +73,160 @@ func GetAllLogOperation(c *gin.Context) {
 	operationList := db.GetAllLogOperation()
 	middleware.ReturnData(c, operationList)
 }
+
+func QueryCiDataAudit(c *gin.Context) {
+	var param models.CiDataAuditQueryParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if !checkCiDataAuditPermission(c, &param) {
+		return
+	}
+	pageInfo, rowData, err := db.QueryCiDataAudit(&param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
+	}
+}
+
+// GetCiDataAudit 查询单行数据的审计记录,可用startTime与endTime限定时间范围
+func GetCiDataAudit(c *gin.Context) {
+	param := models.CiDataAuditQueryParam{Guid: c.Param("guid"), StartTime: c.Query("startTime"), EndTime: c.Query("endTime")}
+	if c.Query("pageSize") != "" {
+		startIndex, _ := strconv.Atoi(c.Query("startIndex"))
+		pageSize, err := strconv.Atoi(c.Query("pageSize"))
+		if err != nil || pageSize <= 0 {
+			middleware.ReturnParamValidateError(c, fmt.Errorf("Url param pageSize illegal "))
+			return
+		}
+		param.Pageable = &models.PageInfo{StartIndex: startIndex, PageSize: pageSize}
+	}
+	if !checkCiDataAuditPermission(c, &param) {
+		return
+	}
+	pageInfo, rowData, err := db.QueryCiDataAudit(&param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
+	}
+}
+
+// checkCiDataAuditPermission 按数据查询权限限定审计记录范围,指定guid时校验该行权限,只指定ciType时限定为有权限的guid
+func checkCiDataAuditPermission(c *gin.Context, param *models.CiDataAuditQueryParam) bool {
+	ciType := param.CiType
+	if param.Guid != "" {
+		lastIndex := strings.LastIndex(param.Guid, "_")
+		if lastIndex <= 0 {
+			middleware.ReturnParamValidateError(c, fmt.Errorf("Param guid:%s illegal ", param.Guid))
+			return false
+		}
+		ciType = param.Guid[:lastIndex]
+	}
+	roles := middleware.GetRequestRoles(c)
+	if ciType == "" {
+		for _, role := range roles {
+			if role == models.AdminRole {
+				return true
+			}
+		}
+		middleware.ReturnParamValidateError(c, fmt.Errorf("Param ciType or guid can not empty "))
+		return false
+	}
+	permissions, err := db.GetRoleCiDataPermission(roles, ciType, "", models.DataActionQuery)
+	if err != nil {
+		middleware.ReturnDataPermissionError(c, err)
+		return false
+	}
+	legalGuidList, err := db.GetCiDataPermissionGuidList(&permissions, models.DataActionQuery)
+	if err != nil {
+		middleware.ReturnDataPermissionError(c, err)
+		return false
+	}
+	if legalGuidList.Legal {
+		return true
+	}
+	if param.Guid == "" {
+		param.GuidLimited = true
+		param.LegalGuidList = legalGuidList.GuidList
+		return true
+	}
+	for _, v := range legalGuidList.GuidList {
+		if v == param.Guid {
+			return true
+		}
+	}
+	middleware.ReturnDataPermissionDenyError(c)
+	return false
+}
+
+// VerifyOperationLogChain 校验日志哈希链,可用startSeq与endSeq限定链序号范围
+func VerifyOperationLogChain(c *gin.Context) {
+	var startSeq, endSeq int64
+	var err error
+	if c.Query("startSeq") != "" {
+		if startSeq, err = strconv.ParseInt(c.Query("startSeq"), 10, 64); err != nil {
+			middleware.ReturnParamValidateError(c, fmt.Errorf("Url param startSeq illegal "))
+			return
+		}
+	}
+	if c.Query("endSeq") != "" {
+		if endSeq, err = strconv.ParseInt(c.Query("endSeq"), 10, 64); err != nil {
+			middleware.ReturnParamValidateError(c, fmt.Errorf("Url param endSeq illegal "))
+			return
+		}
+	}
+	result, err := db.VerifyOperationLogChain(startSeq, endSeq)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, result)
+	}
+}
+
+// ArchiveOperationLog 手动归档过期日志,retentionDays为空时用配置的保留天数
+func ArchiveOperationLog(c *gin.Context) {
+	retentionDays := models.Config.OperationLog.RetentionDays
+	if c.Query("retentionDays") != "" {
+		var err error
+		if retentionDays, err = strconv.Atoi(c.Query("retentionDays")); err != nil || retentionDays <= 0 {
+			middleware.ReturnParamValidateError(c, fmt.Errorf("Url param retentionDays illegal "))
+			return
+		}
+	}
+	if retentionDays <= 0 {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("Operation log retention is disabled,please set retention_days or url param retentionDays "))
+		return
+	}
+	archive, err := db.ArchiveOperationLog(retentionDays)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, archive)
+	}
+}
+
+func QueryOperationLogArchive(c *gin.Context) {
+	rowData, err := db.QueryOperationLogArchive()
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, rowData)
+	}
+}
+
+func VerifyOperationLogArchive(c *gin.Context) {
+	archiveId, err := strconv.Atoi(c.Param("archiveId"))
+	if err != nil {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("Url param archiveId illegal "))
+		return
+	}
+	result, err := db.VerifyOperationLogArchive(archiveId)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, result)
+	}
+}
@@ -4,32 +4,285 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/api/middleware"
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
-	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/tools"
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/services/db"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+const (
+	syncActionFileMissing  = "SyncFileMissing"
+	syncActionSnapshot     = "Snapshot"
+	syncActionSnapshotPart = "SnapshotPart"
+)
+
+var syncSlaveLock = new(sync.Mutex)
+
 func GetSyncRecord(c *gin.Context) {
 	rowList, err := db.GetSyncRows()
 	if err != nil {
 		middleware.ReturnServerHandleError(c, err)
 		return
 	}
-	result := models.SyncRecordQuery{List: rowList, Config: &models.Config.Sync}
+	syncConfig := models.Config.Sync
+	syncConfig.SyncKey = ""
+	result := models.SyncRecordQuery{List: rowList, Config: &syncConfig}
 	middleware.ReturnData(c, result)
 }
 
+// SyncReceive http同步方式下备环境接收主环境推送的同步文件,使用共享密钥校验签名
+func SyncReceive(c *gin.Context) {
+	if !models.Config.Sync.SlaveEnable || strings.ToLower(models.Config.Sync.Transport) != db.SyncTransportHttp {
+		middleware.ReturnApiPermissionError(c)
+		return
+	}
+	fileName := c.Param("fileName")
+	bodyBytes, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("read request body fail,%s ", err.Error()))
+		return
+	}
+	if err = db.ValidateSyncSignature(c.GetHeader(db.SyncHeaderTimestamp), fileName, c.GetHeader(db.SyncHeaderSignature), bodyBytes); err != nil {
+		log.Error(nil, log.LOGGER_APP, "Validate sync signature fail", zap.String("file", fileName), zap.String("ip", middleware.GetRemoteIp(c)), zap.Error(err))
+		middleware.ReturnTokenValidateError(c, err)
+		return
+	}
+	if err = db.SaveSyncReceiveFile(fileName, bodyBytes); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+// SyncRetry 主环境重新推送失败的同步文件,备环境重新执行失败的同步记录
+func SyncRetry(c *gin.Context) {
+	syncRecord, err := db.GetSyncRecordById(c.Param("id"))
+	if err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if syncRecord.Status == "ok" {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("sync record:%s status is ok,no need to retry ", syncRecord.ID))
+		return
+	}
+	if models.Config.Sync.MasterEnable {
+		err = db.RetrySyncPush(syncRecord)
+	} else if models.Config.Sync.SlaveEnable {
+		syncSlaveLock.Lock()
+		err = retrySyncSlaveRecord(syncRecord)
+		syncSlaveLock.Unlock()
+	} else {
+		err = fmt.Errorf("sync is not enable ")
+	}
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func retrySyncSlaveRecord(syncRecord *models.SysSyncRecordTable) (err error) {
+	if syncRecord.ActionFunc == syncActionFileMissing {
+		// 缺失的同步文件,重新从远端获取内容
+		transport, getTransportErr := db.GetSyncTransport()
+		if getTransportErr != nil {
+			return getTransportErr
+		}
+		fileName := syncRecord.ID + ".json"
+		if err = transport.Download([]string{fileName}, models.TmpSyncDir); err != nil {
+			db.UpdateSyncRecord(syncRecord.ID, syncRecord.RetryCount, err)
+			return
+		}
+		fileData, readErr := readSyncFile(models.TmpSyncDir + fileName)
+		os.Remove(models.TmpSyncDir + fileName)
+		if readErr != nil {
+			db.UpdateSyncRecord(syncRecord.ID, syncRecord.RetryCount, readErr)
+			return readErr
+		}
+		syncRecord.ActionFunc, syncRecord.DataCategory, syncRecord.DataType, syncRecord.Content, syncRecord.Operator = fileData.ActionFunc, fileData.DataCategory, fileData.DataType, fileData.Content, fileData.Operator
+		if err = db.UpdateSyncRecordContent(syncRecord); err != nil {
+			return
+		}
+	}
+	switch syncRecord.DataCategory {
+	case models.SyncCategorySnapshot:
+		err = fmt.Errorf("sync record:%s is snapshot,please use resync ", syncRecord.ID)
+	case models.SyncCategoryCiData:
+		dataList, getDataErr := db.GetSyncDataByRecord(syncRecord.ID)
+		if getDataErr != nil {
+			return getDataErr
+		}
+		if len(dataList) == 0 {
+			// 乱序或缺失的同步文件还没有拆分出同步数据
+			if err = applySyncSlaveData(syncRecord); err == nil {
+				dataList, err = db.GetSyncDataByRecord(syncRecord.ID)
+			}
+			if err != nil {
+				db.UpdateSyncRecord(syncRecord.ID, syncRecord.RetryCount, err)
+				return
+			}
+		} else if err = db.ResetSyncDataForRetry(syncRecord.ID); err != nil {
+			return
+		}
+		err = applySyncCiData(syncRecord, dataList)
+	default:
+		err = applySyncSlaveData(syncRecord)
+		db.UpdateSyncRecord(syncRecord.ID, syncRecord.RetryCount, err)
+	}
+	return
+}
+
+// SyncSnapshot 主环境导出所有开启同步的ci类型全量数据,作为备环境重建的快照
+func SyncSnapshot(c *gin.Context) {
+	if !models.Config.Sync.MasterEnable {
+		middleware.ReturnServerHandleError(c, fmt.Errorf("sync master is not enable "))
+		return
+	}
+	ciTypeList, err := db.GetSyncSnapshotCiTypes()
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	// 每个ci类型单独导出并推送,避免把全部数据放在内存和同一个文件里,最后推送清单
+	manifest := models.SyncSnapshotManifestObj{}
+	for _, ciType := range ciTypeList {
+		snapshotObj, buildErr := db.BuildSyncSnapshotCiType(ciType)
+		if buildErr != nil {
+			middleware.ReturnServerHandleError(c, buildErr)
+			return
+		}
+		partRecord := models.SysSyncRecordTable{ContentData: snapshotObj, Operator: middleware.GetRequestUser(c), ActionFunc: syncActionSnapshotPart, DataCategory: models.SyncCategorySnapshot, DataType: ciType}
+		db.SyncPush(&partRecord)
+		if partRecord.Status == "fail" {
+			middleware.ReturnServerHandleError(c, fmt.Errorf("push ciType:%s snapshot fail,%s ", ciType, partRecord.ErrorMsg))
+			return
+		}
+		manifest.Parts = append(manifest.Parts, &models.SyncSnapshotPartObj{CiType: ciType, SyncId: partRecord.ID})
+	}
+	syncRecord := models.SysSyncRecordTable{ContentData: manifest, Operator: middleware.GetRequestUser(c), ActionFunc: syncActionSnapshot, DataCategory: models.SyncCategorySnapshot, DataType: "all"}
+	db.SyncPush(&syncRecord)
+	if syncRecord.Status == "fail" {
+		middleware.ReturnServerHandleError(c, fmt.Errorf(syncRecord.ErrorMsg))
+	} else {
+		middleware.ReturnData(c, syncRecord.ID)
+	}
+}
+
+// SyncResync 备环境用主环境的全量快照重建数据,之后从快照位置继续增量同步
+func SyncResync(c *gin.Context) {
+	var param models.SyncResyncParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if param.SourceId != "" && !db.IsSyncFileNameLegal(param.SourceId+".json") {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("sourceId:%s illegal ", param.SourceId))
+		return
+	}
+	if !models.Config.Sync.SlaveEnable {
+		middleware.ReturnServerHandleError(c, fmt.Errorf("sync slave is not enable "))
+		return
+	}
+	syncSlaveLock.Lock()
+	defer syncSlaveLock.Unlock()
+	transport, err := db.GetSyncTransport()
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	snapshotRecord, err := findSyncSnapshot(transport, param.SourceId)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	var manifest models.SyncSnapshotManifestObj
+	if err = json.Unmarshal([]byte(snapshotRecord.Content), &manifest); err != nil {
+		middleware.ReturnServerHandleError(c, fmt.Errorf("json unmarshal snapshot content fail,%s ", err.Error()))
+		return
+	}
+	snapshotRecord.SourceID = snapshotRecord.ID
+	snapshotRecord.CreateTime = time.Now()
+	snapshotRecord.SyncType = models.Config.Sync.Type
+	snapshotRecord.Source = models.Config.Sync.Source
+	snapshotRecord.Target = models.Config.Sync.Target
+	snapshotRecord.RemoteRepo = transport.RemoteRepo()
+	if err = db.ApplySyncSnapshot(snapshotRecord, &manifest, func(part *models.SyncSnapshotPartObj) (*models.SyncSnapshotCiTypeObj, error) {
+		return loadSyncSnapshotPart(transport, part)
+	}); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, snapshotRecord.ID)
+	}
+}
+
+// loadSyncSnapshotPart 下载清单中单个ci类型的快照文件
+func loadSyncSnapshotPart(transport db.SyncTransport, part *models.SyncSnapshotPartObj) (snapshotObj *models.SyncSnapshotCiTypeObj, err error) {
+	fileName := part.SyncId + ".json"
+	if !db.IsSyncFileNameLegal(fileName) {
+		err = fmt.Errorf("snapshot part file:%s illegal ", fileName)
+		return
+	}
+	if err = transport.Download([]string{fileName}, models.TmpSyncDir); err != nil {
+		return
+	}
+	fileData, readErr := readSyncFile(models.TmpSyncDir + fileName)
+	os.Remove(models.TmpSyncDir + fileName)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	snapshotObj = &models.SyncSnapshotCiTypeObj{}
+	if err = json.Unmarshal([]byte(fileData.Content), snapshotObj); err != nil {
+		err = fmt.Errorf("json unmarshal snapshot content fail,%s ", err.Error())
+		return
+	}
+	if snapshotObj.CiType != part.CiType {
+		err = fmt.Errorf("snapshot file:%s ciType:%s not match %s ", fileName, snapshotObj.CiType, part.CiType)
+	}
+	return
+}
+
+// findSyncSnapshot 找到指定的快照清单文件,没有指定时从最新的同步文件往前找
+func findSyncSnapshot(transport db.SyncTransport, sourceId string) (snapshotRecord *models.SysSyncRecordTable, err error) {
+	var fileNameList []string
+	if sourceId != "" {
+		fileNameList = []string{sourceId + ".json"}
+	} else {
+		if fileNameList, err = transport.ListFiles(); err != nil {
+			return
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(fileNameList)))
+	}
+	for _, fileName := range fileNameList {
+		if err = transport.Download([]string{fileName}, models.TmpSyncDir); err != nil {
+			return
+		}
+		fileData, readErr := readSyncFile(models.TmpSyncDir + fileName)
+		os.Remove(models.TmpSyncDir + fileName)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		if fileData.DataCategory == models.SyncCategorySnapshot && fileData.ActionFunc == syncActionSnapshot {
+			snapshotRecord = &fileData
+			return
+		}
+	}
+	err = fmt.Errorf("Can not find sync snapshot file ")
+	return
+}
+
 func StartSyncCron() {
 	if !models.Config.Sync.SlaveEnable {
 		return
@@ -40,31 +293,30 @@ func StartSyncCron() {
 	t := time.NewTicker(10 * time.Second).C
 	for {
 		<-t
+		syncSlaveLock.Lock()
 		if err := SyncPull(); err != nil {
 			log.Error(nil, log.LOGGER_APP, "SyncPull job fail", zap.Error(err))
 		}
 		if err := handleSyncCiData(); err != nil {
 			log.Error(nil, log.LOGGER_APP, "handleSyncCiData job fail", zap.Error(err))
 		}
+		syncSlaveLock.Unlock()
 	}
 }
 
 func SyncPull() (err error) {
-	nexusParam := tools.NexusReqParam{
-		UserName:   models.Config.Sync.NexusUser,
-		Password:   models.Config.Sync.NexusPwd,
-		RepoUrl:    models.Config.Sync.NexusAddress,
-		Repository: models.Config.Sync.NexusRepo,
-		TimeoutSec: 30,
-		DirPath:    models.SyncNexusDir,
-	}
-	fileNameList, listFileErr := tools.ListFilesInRepo(&nexusParam)
+	transport, getTransportErr := db.GetSyncTransport()
+	if getTransportErr != nil {
+		err = getTransportErr
+		return
+	}
+	fileNameList, listFileErr := transport.ListFiles()
 	if listFileErr != nil {
-		err = fmt.Errorf("list file from nexus fail,%s ", listFileErr.Error())
+		err = listFileErr
 		return
 	}
 	if len(fileNameList) == 0 {
-		log.Debug(nil, log.LOGGER_APP, "SyncPull done with empty nexus file list")
+		log.Debug(nil, log.LOGGER_APP, "SyncPull done with empty remote file list")
 		return
 	}
 	sort.Strings(fileNameList)
@@ -73,63 +325,111 @@ func SyncPull() (err error) {
 		err = getMaxIdErr
 		return
 	}
-	var consumeFileNameList []string
-	if curMaxSourceId == "" {
-		consumeFileNameList = fileNameList
-	} else {
-		matchMaxSourceId := false
-		for _, fileName := range fileNameList {
-			if strings.HasPrefix(fileName, curMaxSourceId) {
-				matchMaxSourceId = true
-				continue
-			}
-			if !matchMaxSourceId {
-				continue
-			}
+	sourceIdMap, getSourceIdErr := db.GetSyncSourceIdMap()
+	if getSourceIdErr != nil {
+		err = getSourceIdErr
+		return
+	}
+	gapFloorSourceId, getFloorErr := db.GetSyncGapFloorSourceId()
+	if getFloorErr != nil {
+		err = getFloorErr
+		return
+	}
+	remoteSourceIdMap := make(map[string]bool)
+	var consumeFileNameList, gapFileNameList []string
+	for _, fileName := range fileNameList {
+		sourceId := strings.TrimSuffix(fileName, ".json")
+		remoteSourceIdMap[sourceId] = true
+		if _, b := sourceIdMap[sourceId]; b {
+			continue
+		}
+		if curMaxSourceId == "" || sourceId > curMaxSourceId {
 			consumeFileNameList = append(consumeFileNameList, fileName)
+		} else if sourceId > gapFloorSourceId {
+			// 比已同步的记录还早但没有被同步过的文件,直接同步会打乱顺序,记录下来等人工重试
+			gapFileNameList = append(gapFileNameList, fileName)
 		}
 	}
-	if len(consumeFileNameList) == 0 {
+	if len(consumeFileNameList) == 0 && len(gapFileNameList) == 0 {
 		log.Debug(nil, log.LOGGER_APP, "SyncPull done with empty consume file list", zap.String("curMaxSourceId", curMaxSourceId))
 		return
 	}
-	nexusParam.FileParams = []*tools.NexusFileParam{}
-	for _, fileName := range consumeFileNameList {
-		nexusParam.FileParams = append(nexusParam.FileParams, &tools.NexusFileParam{SourceFilePath: nexusParam.RepoUrl + "/repository/" + nexusParam.Repository + models.SyncNexusDir + fileName, DestFilePath: models.TmpSyncDir + fileName})
-	}
-	err = tools.DownloadFile(&nexusParam)
-	if err != nil {
-		err = fmt.Errorf("download file from nexus fail,%s ", err.Error())
+	if err = transport.Download(append(gapFileNameList, consumeFileNameList...), models.TmpSyncDir); err != nil {
 		return
 	}
+	for _, fileName := range gapFileNameList {
+		tmpData, tmpErr := readSyncFile(models.TmpSyncDir + fileName)
+		os.Remove(models.TmpSyncDir + fileName)
+		if tmpErr != nil {
+			log.Error(nil, log.LOGGER_APP, "read sync gap file fail", zap.String("file", fileName), zap.Error(tmpErr))
+			continue
+		}
+		log.Warn(nil, log.LOGGER_APP, "SyncPull find out of order sync file", zap.String("file", fileName), zap.String("curMaxSourceId", curMaxSourceId))
+		tmpData.Status = "fail"
+		tmpData.RetryCount = models.SyncMaxRetryCount
+		tmpData.ErrorMsg = fmt.Sprintf("sync file arrived after %s,need manual retry ", curMaxSourceId)
+		addSlaveSyncRecord(&tmpData, transport)
+	}
 	for _, fileName := range consumeFileNameList {
 		tmpData, tmpErr := handleSyncSlaveData(models.TmpSyncDir + fileName)
 		if tmpErr != nil {
 			tmpData.Status = "fail"
+			tmpData.ErrorMsg = tmpErr.Error()
 			log.Error(nil, log.LOGGER_APP, "handle slave data fail", zap.String("file", fileName), zap.Error(tmpErr))
 		}
 		if tmpData.ID != "" {
-			tmpData.SourceID = tmpData.ID
-			tmpData.CreateTime = time.Now()
+			checkSyncPrevSourceId(&tmpData, sourceIdMap, remoteSourceIdMap, gapFloorSourceId, transport)
 			if tmpData.Status != "fail" {
-				if tmpData.DataCategory == "ciData" {
+				if tmpData.DataCategory == models.SyncCategoryCiData {
 					tmpData.Status = "wait"
 				} else {
 					tmpData.Status = "ok"
 				}
 			}
-			tmpData.SyncType = models.Config.Sync.Type
-			tmpData.Source = models.Config.Sync.Source
-			tmpData.Target = models.Config.Sync.Target
-			tmpData.RemoteRepo = fmt.Sprintf("%s__%s", models.Config.Sync.NexusAddress, models.Config.Sync.NexusRepo)
-			db.AddSyncRecord(&tmpData)
+			addSlaveSyncRecord(&tmpData, transport)
+			sourceIdMap[tmpData.ID] = tmpData.Status
 		}
 		os.Remove(models.TmpSyncDir + fileName)
 	}
 	return
 }
 
-func handleSyncSlaveData(inputFile string) (inputData models.SysSyncRecordTable, err error) {
+func addSlaveSyncRecord(inputData *models.SysSyncRecordTable, transport db.SyncTransport) {
+	inputData.SourceID = inputData.ID
+	inputData.CreateTime = time.Now()
+	inputData.SyncType = models.Config.Sync.Type
+	inputData.Source = models.Config.Sync.Source
+	inputData.Target = models.Config.Sync.Target
+	inputData.RemoteRepo = transport.RemoteRepo()
+	db.AddSyncRecord(inputData)
+}
+
+// checkSyncPrevSourceId 检查主环境上一个同步文件是否已经同步,缺失时记录一条失败的同步记录,阻塞后续的数据同步直到人工重试
+func checkSyncPrevSourceId(inputData *models.SysSyncRecordTable, sourceIdMap map[string]string, remoteSourceIdMap map[string]bool, gapFloorSourceId string, transport db.SyncTransport) {
+	if inputData.PrevSourceId == "" || inputData.PrevSourceId <= gapFloorSourceId {
+		return
+	}
+	if _, b := sourceIdMap[inputData.PrevSourceId]; b {
+		return
+	}
+	if remoteSourceIdMap[inputData.PrevSourceId] {
+		return
+	}
+	log.Error(nil, log.LOGGER_APP, "SyncPull find missing sync file", zap.String("file", inputData.ID), zap.String("prevSourceId", inputData.PrevSourceId))
+	missingRecord := models.SysSyncRecordTable{
+		ID:           inputData.PrevSourceId,
+		ActionFunc:   syncActionFileMissing,
+		DataCategory: models.SyncCategoryCiData,
+		Status:       "fail",
+		RetryCount:   models.SyncMaxRetryCount,
+		ErrorMsg:     fmt.Sprintf("sync file %s.json missing,need manual retry ", inputData.PrevSourceId),
+		Operator:     "SYSTEM",
+	}
+	addSlaveSyncRecord(&missingRecord, transport)
+	sourceIdMap[missingRecord.ID] = missingRecord.Status
+}
+
+func readSyncFile(inputFile string) (inputData models.SysSyncRecordTable, err error) {
 	inputData = models.SysSyncRecordTable{}
 	tmpFileBytes, readErr := os.ReadFile(inputFile)
 	if readErr != nil {
@@ -138,6 +438,21 @@ func handleSyncSlaveData(inputFile string) (inputData models.SysSyncRecordTable,
 	}
 	if err = json.Unmarshal(tmpFileBytes, &inputData); err != nil {
 		err = fmt.Errorf("json unmarshal sync file to struct fail,%s ", err.Error())
+	}
+	return
+}
+
+func handleSyncSlaveData(inputFile string) (inputData models.SysSyncRecordTable, err error) {
+	if inputData, err = readSyncFile(inputFile); err != nil {
+		return
+	}
+	err = applySyncSlaveData(&inputData)
+	return
+}
+
+func applySyncSlaveData(inputData *models.SysSyncRecordTable) (err error) {
+	if inputData.DataCategory == models.SyncCategorySnapshot {
+		// 全量快照只在备环境重建时使用,增量同步时跳过
 		return
 	}
 	req := httptest.NewRequest(http.MethodPost, "/syncSlave", bytes.NewBuffer([]byte(inputData.Content)))
@@ -146,7 +461,7 @@ func handleSyncSlaveData(inputFile string) (inputData models.SysSyncRecordTable,
 	c, _ := gin.CreateTestContext(recorder) // 创建测试上下文
 	c.Request = req                         // 将模拟的请求赋值给上下文
 	c.Set("fromSync", "yes")
-	if inputData.DataCategory == "model" {
+	if inputData.DataCategory == models.SyncCategoryModel {
 		switch inputData.ActionFunc {
 		case "AttrApply":
 			AttrApply(c)
@@ -163,6 +478,9 @@ func handleSyncSlaveData(inputFile string) (inputData models.SysSyncRecordTable,
 		case "AttrPositionSwap":
 			c.AddParam("ciType", inputData.DataType)
 			AttrPositionSwap(c)
+		case "AttrMigrationApply":
+			c.AddParam("ciAttr", inputData.DataType)
+			AttrMigrationApply(c)
 		case "CiTypesCreate":
 			CiTypesCreate(c)
 		case "CiTypesUpdate":
@@ -176,8 +494,62 @@ func handleSyncSlaveData(inputFile string) (inputData models.SysSyncRecordTable,
 		case "CiTypesRollback":
 			c.AddParam("ciType", inputData.DataType)
 			CiTypesRollback(c)
+		case "ModelImport":
+			ModelImport(c)
+		case "CiTypeUniqueKeyCreate":
+			c.AddParam("ciType", inputData.DataType)
+			CiTypeUniqueKeyCreate(c)
+		case "CiTypeUniqueKeyDelete":
+			var keyId string
+			json.Unmarshal([]byte(inputData.Content), &keyId)
+			c.AddParam("ciType", inputData.DataType)
+			c.AddParam("id", keyId)
+			CiTypeUniqueKeyDelete(c)
+		case "CiTemplateCreate":
+			CiTemplateCreate(c)
+		case "CiTemplateUpdate", "CiTemplateDelete", "CiTemplateAttrCreate", "CiTemplateAttrUpdate", "CiTemplatePropagate":
+			c.AddParam("id", inputData.DataType)
+			switch inputData.ActionFunc {
+			case "CiTemplateUpdate":
+				CiTemplateUpdate(c)
+			case "CiTemplateDelete":
+				CiTemplateDelete(c)
+			case "CiTemplateAttrCreate":
+				CiTemplateAttrCreate(c)
+			case "CiTemplateAttrUpdate":
+				CiTemplateAttrUpdate(c)
+			default:
+				CiTemplatePropagate(c)
+			}
+		case "CiTemplateAttrDelete":
+			var attrId string
+			json.Unmarshal([]byte(inputData.Content), &attrId)
+			c.AddParam("id", inputData.DataType)
+			c.AddParam("attrId", attrId)
+			CiTemplateAttrDelete(c)
+		case "StateMachineCreate":
+			StateMachineCreate(c)
+		case "StateMachineUpdate":
+			c.AddParam("id", inputData.DataType)
+			StateMachineUpdate(c)
+		case "StateMachineDelete":
+			c.AddParam("id", inputData.DataType)
+			StateMachineDelete(c)
+		case "CiTypeIndexCreate":
+			c.AddParam("ciType", inputData.DataType)
+			CiTypeIndexCreate(c)
+		case "CiTypeIndexApply", "CiTypeIndexDelete":
+			var indexId string
+			json.Unmarshal([]byte(inputData.Content), &indexId)
+			c.AddParam("ciType", inputData.DataType)
+			c.AddParam("id", indexId)
+			if inputData.ActionFunc == "CiTypeIndexApply" {
+				CiTypeIndexApply(c)
+			} else {
+				CiTypeIndexDelete(c)
+			}
 		}
-	} else if inputData.DataCategory == "ciData" {
+	} else if inputData.DataCategory == models.SyncCategoryCiData {
 		switch inputData.ActionFunc {
 		case "HandleCiDataOperation":
 			c.AddParam("syncRecordId", inputData.ID)
@@ -227,6 +599,11 @@ func handleSyncCiData() (err error) {
 		log.Debug(nil, log.LOGGER_APP, "handleSyncCiData with empty syncRecord")
 		return
 	}
+	err = applySyncCiData(syncRecord, dataList)
+	return
+}
+
+func applySyncCiData(syncRecord *models.SysSyncRecordTable, dataList []*models.SysSyncDataTable) (err error) {
 	for _, data := range dataList {
 		if data.Status == "ok" {
 			continue
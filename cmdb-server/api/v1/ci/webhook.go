@@ -0,0 +1,97 @@
+package ci
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/api/middleware"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/services/db"
+	"github.com/gin-gonic/gin"
+)
+
+func WebhookList(c *gin.Context) {
+	result, err := db.QueryWebhook(c.Query("ciType"))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, result)
+	}
+}
+
+func WebhookCreate(c *gin.Context) {
+	var param models.SysWebhookTable
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if err := db.CreateWebhook(&param, middleware.GetRequestUser(c)); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		param.Secret = ""
+		middleware.ReturnData(c, param)
+	}
+}
+
+func WebhookUpdate(c *gin.Context) {
+	var param models.SysWebhookTable
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	param.Id = c.Param("id")
+	if err := db.UpdateWebhook(&param, middleware.GetRequestUser(c)); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func WebhookDelete(c *gin.Context) {
+	if err := db.DeleteWebhook(c.Param("id")); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func WebhookOutboxQuery(c *gin.Context) {
+	var param models.QueryRequestParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	pageInfo, rowData, err := db.QueryWebhookOutbox(&param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
+	}
+}
+
+func WebhookDeliveryQuery(c *gin.Context) {
+	var param models.QueryRequestParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	pageInfo, rowData, err := db.QueryWebhookDelivery(&param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
+	}
+}
+
+func WebhookOutboxRetry(c *gin.Context) {
+	outboxId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("Url param id:%s illegal ", c.Param("id")))
+		return
+	}
+	if err = db.RetryWebhookOutbox(outboxId); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
@@ -123,6 +123,9 @@ func ciModelQuery(ciType string, bodyBytes []byte, user string, roles []string)
 		}
 		queryParam.Filters = append(queryParam.Filters, &models.QueryRequestFilterObj{Name: filter.AttrName, Operator: filter.Op, Value: filter.Condition})
 	}
+	if param.FilterGroup != nil {
+		queryParam.FilterGroup = transEntityQueryGroup(param.FilterGroup)
+	}
 	queryParam.Paging = false
 	legalGuidList := models.CiDataLegalGuidList{Legal: true}
 	if user != models.PlatformUser {
@@ -136,6 +139,7 @@ func ciModelQuery(ciType string, bodyBytes []byte, user string, roles []string)
 			return
 		}
 	}
+	queryParam.Roles = roles
 	_, result, err = db.CiDataQuery(ciType, &queryParam, &legalGuidList, true, false)
 	for _, tmpObj := range result {
 		tmpObj["id"] = tmpObj["guid"]
@@ -147,6 +151,32 @@ func ciModelQuery(ciType string, bodyBytes []byte, user string, roles []string)
 	return
 }
 
+func transEntityQueryGroup(input *models.EntityQueryGroup) (output *models.QueryRequestFilterGroup) {
+	output = &models.QueryRequestFilterGroup{Logic: input.Logic}
+	for _, filter := range input.Filters {
+		if filter == nil {
+			continue
+		}
+		if filter.AttrName == "id" {
+			filter.AttrName = "guid"
+		}
+		if filter.AttrName == "displayName" {
+			filter.AttrName = "key_name"
+		}
+		if filter.Op == "" {
+			filter.Op = "eq"
+		}
+		output.Filters = append(output.Filters, &models.QueryRequestFilterObj{Name: filter.AttrName, Operator: filter.Op, Value: filter.Condition})
+	}
+	for _, subGroup := range input.Groups {
+		if subGroup == nil {
+			continue
+		}
+		output.Groups = append(output.Groups, transEntityQueryGroup(subGroup))
+	}
+	return
+}
+
 func ciModelCreate(ciType string, bodyBytes []byte) (result, logResult []map[string]interface{}, newInputData string, err error) {
 	newInputData = string(bodyBytes)
 	var param []map[string]interface{}
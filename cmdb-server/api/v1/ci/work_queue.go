@@ -0,0 +1,39 @@
+package ci
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/api/middleware"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/services/db"
+	"github.com/gin-gonic/gin"
+)
+
+// WorkQueueQuery 查询自动填充与唯一路径队列中等待、处理中和失败的项
+func WorkQueueQuery(c *gin.Context) {
+	var param models.QueryRequestParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	pageInfo, rowData, err := db.QueryWorkQueue(&param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
+	}
+}
+
+func WorkQueueRetry(c *gin.Context) {
+	itemId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("Url param id:%s illegal ", c.Param("id")))
+		return
+	}
+	if err = db.RetryWorkQueueItem(itemId); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
@@ -102,9 +102,14 @@ func QueryReportData(c *gin.Context) {
 	}
 
 	user := middleware.GetRequestUser(c)
+	queryParam.Roles = middleware.GetRequestRoles(c)
 	pageInfo, rowData, err := db.QueryReportData(reportId, &queryParam, user)
 	if err != nil {
-		middleware.ReturnServerHandleError(c, err)
+		if strings.Contains(err.Error(), "permission deny") {
+			middleware.ReturnDataPermissionDenyWithError(c, err)
+		} else {
+			middleware.ReturnServerHandleError(c, err)
+		}
 	} else {
 		if rowData == nil {
 			middleware.ReturnPageData(c, pageInfo, []string{})
@@ -226,7 +231,10 @@ func ExportReportData(c *gin.Context) {
 		middleware.ReturnParamValidateError(c, err)
 		return
 	}
-	result, err := db.ExportReportData(&param)
+	if middleware.ReturnAsyncJob(c, models.JobTypeExportReport, param) {
+		return
+	}
+	result, err := db.ExportReportData(c.Request.Context(), &param)
 	if err != nil {
 		middleware.ReturnServerHandleError(c, err)
 		return
@@ -241,7 +249,7 @@ func CopyReportData(c *gin.Context) {
 		middleware.ReturnParamValidateError(c, err)
 		return
 	}
-	result, err := db.ExportReportData(&param)
+	result, err := db.ExportReportData(c.Request.Context(), &param)
 	if err != nil {
 		middleware.ReturnServerHandleError(c, err)
 		return
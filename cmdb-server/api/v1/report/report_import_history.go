@@ -74,6 +74,9 @@ func QueryReportImportHistory(c *gin.Context) {
 
 // 刷新报告导入历史记录
 func RefreshReportImportHistory(c *gin.Context) {
+	if middleware.ReturnAsyncJob(c, models.JobTypeRefreshImportHistory, models.RefreshImportHistoryJobParam{}) {
+		return
+	}
 	// 查询状态为"created"的报告导入历史记录
 	status := "created"
 	importHistoryRowData, err := db.QueryReportImportHistoryByStatus(status)
@@ -100,8 +103,8 @@ func RefreshReportImportHistory(c *gin.Context) {
 			middleware.ReturnSuccess(c)
 			return
 		}
-		// 调用refreshReportImportHistory函数刷新报告导入历史记录
-		err = refreshReportImportHistory(c, importGuidMapTable)
+		// 刷新报告导入历史记录的检查结果
+		err = db.RefreshReportImportHistoryByGuidMap(importGuidMapTable)
 		if err != nil {
 			log.Error(nil, log.LOGGER_APP, fmt.Sprintf("Refresh report import history failed, err:%s", err.Error()))
 			middleware.ReturnServerHandleError(c, err)
@@ -118,6 +121,9 @@ func RefreshReportImportHistoryById(c *gin.Context) {
 		middleware.ReturnParamValidateError(c, fmt.Errorf("param guid can not empty"))
 		return
 	}
+	if middleware.ReturnAsyncJob(c, models.JobTypeRefreshImportHistory, models.RefreshImportHistoryJobParam{Guid: guid}) {
+		return
+	}
 
 	// 根据报告导入GUID查询导入映射表
 	importGuidMapTable, err := db.QueryCiImportGuidMapByReportImportGuid(guid)
@@ -131,8 +137,8 @@ func RefreshReportImportHistoryById(c *gin.Context) {
 		return
 	}
 
-	// 调用refreshReportImportHistory函数刷新报告导入历史记录
-	err = refreshReportImportHistory(c, importGuidMapTable)
+	// 刷新报告导入历史记录的检查结果
+	err = db.RefreshReportImportHistoryByGuidMap(importGuidMapTable)
 	if err != nil {
 		log.Error(nil, log.LOGGER_APP, fmt.Sprintf("Refresh report import history failed, err:%s", err.Error()))
 		middleware.ReturnServerHandleError(c, err)
@@ -141,40 +147,6 @@ func RefreshReportImportHistoryById(c *gin.Context) {
 	middleware.ReturnSuccess(c)
 }
 
-// 刷新报告导入历史记录：主要功能是构造MultiCiDataObj的切片，然后获取其Attributes和InputData，并最终刷新报告导入历史记录
-func refreshReportImportHistory(c *gin.Context, importGuidMapTable []*models.SysCiImportGuidMapTable) (err error) {
-	// 构造multiCiData，获取multiCiData的CiTypeId
-	var multiCiData []*models.MultiCiDataObj
-	var ciTypeMap = make(map[string]bool)
-	for _, row := range importGuidMapTable {
-		if _, exists := ciTypeMap[row.CiType]; !exists {
-			ciTypeMap[row.CiType] = true
-			multiCiData = append(multiCiData, &models.MultiCiDataObj{CiTypeId: row.CiType})
-		}
-	}
-	// 获取multiCiData的Attributes
-	if err = db.GetMultiCiAttributes(multiCiData); err != nil {
-		log.Error(nil, log.LOGGER_APP, fmt.Sprintf("Query CI attributes failed, err:%s", err.Error()))
-		middleware.ReturnServerHandleError(c, err)
-		return
-	}
-	// 获取multiCiData的InputData
-	err = db.GetUniqueAndNotNullColumn(multiCiData, importGuidMapTable)
-	if err != nil {
-		log.Error(nil, log.LOGGER_APP, fmt.Sprintf("Get unique and not null column failed, err:%s", err.Error()))
-		middleware.ReturnServerHandleError(c, err)
-		return
-	}
-
-	err = db.RefreshReportImportHistory(multiCiData)
-	if err != nil {
-		log.Error(nil, log.LOGGER_APP, fmt.Sprintf("Refresh report import history failed, err:%s", err.Error()))
-		middleware.ReturnServerHandleError(c, err)
-		return
-	}
-	return
-}
-
 // 查询报告导入历史记录
 func QueryReportImportHistoryById(c *gin.Context) {
 	// 获取URL查询参数guid
@@ -420,6 +392,7 @@ func DataQueryWithCheckResult(c *gin.Context) {
 		return
 	}
 	// Query database
+	param.Roles = middleware.GetRequestRoles(c)
 	pageInfo, rowData, err := db.CiDataQuery(c.Param("ciType"), &param, &legalGuidList, false, true)
 
 	// add check_result
@@ -227,6 +227,9 @@ func ConfirmView(c *gin.Context) {
 		middleware.ReturnParamValidateError(c, err)
 		return
 	}
+	if middleware.ReturnAsyncJob(c, models.JobTypeViewConfirm, param) {
+		return
+	}
 	result, err := db.ViewConfirmAction(param, c.GetHeader("Authorization"), middleware.GetRequestUser(c), middleware.GetRequestRoles(c))
 	if err != nil {
 		middleware.ReturnServerHandleError(c, err)
@@ -237,6 +240,20 @@ func ConfirmView(c *gin.Context) {
 	}
 }
 
+func ConfirmViewDiff(c *gin.Context) {
+	var param models.ViewData
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	result, err := db.ViewConfirmDiff(param, middleware.GetRequestRoles(c))
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, result)
+	}
+}
+
 func GetGraphViewData(c *gin.Context) {
 
 	var param models.GraphViewData
@@ -366,3 +383,184 @@ func GetGraphViewData(c *gin.Context) {
 	// always return only one graph
 	middleware.ReturnData(c, dots[0])
 }
+
+func GetViewDetail(c *gin.Context) {
+	viewId := c.Param("viewId")
+	if viewId == "" {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("Url param viewId can not empty "))
+		return
+	}
+	result, err := db.GetViewDetail(viewId)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, result)
+	}
+}
+
+func CreateView(c *gin.Context) {
+	var param models.UpdateViewParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if err := db.CreateView(&param, middleware.GetRequestUser(c)); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, param)
+	}
+}
+
+func UpdateView(c *gin.Context) {
+	var param models.UpdateViewParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if err := db.UpdateView(&param, middleware.GetRequestUser(c)); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, param)
+	}
+}
+
+func DeleteView(c *gin.Context) {
+	var param models.DeleteViewParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if err := db.DeleteView(param.Id); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func CopyView(c *gin.Context) {
+	var param models.CopyViewParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if err := db.CopyView(&param, middleware.GetRequestUser(c)); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func QueryViewGraph(c *gin.Context) {
+	var param models.QueryRequestParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	pageInfo, rowData, err := db.QueryViewGraph(&param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
+	}
+}
+
+func CreateGraph(c *gin.Context) {
+	var param models.ModifyGraphParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	graphId, err := db.CreateGraph(&param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, map[string]string{"graphId": graphId})
+	}
+}
+
+func UpdateGraph(c *gin.Context) {
+	var param models.ModifyGraphParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if err := db.UpdateGraph(&param); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func DeleteGraph(c *gin.Context) {
+	var param models.DeleteGraphParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if err := db.DeleteGraph(param.Id); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func QueryGraphElement(c *gin.Context) {
+	var param models.QueryRequestParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	pageInfo, rowData, err := db.QueryViewGraphElement(&param)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
+	}
+}
+
+func CreateGraphElement(c *gin.Context) {
+	var param models.SysGraphElementTable
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if param.Graph == "" {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("Param graph can not empty "))
+		return
+	}
+	if err := db.CreateGraphElement(&param); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, param)
+	}
+}
+
+func UpdateGraphElement(c *gin.Context) {
+	var param models.SysGraphElementTable
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if param.Id == "" {
+		middleware.ReturnParamValidateError(c, fmt.Errorf("Param graphElementId can not empty "))
+		return
+	}
+	if err := db.UpdateGraphElement(&param); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnData(c, param)
+	}
+}
+
+func DeleteGraphElement(c *gin.Context) {
+	var param models.DeleteGraphElementParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnParamValidateError(c, err)
+		return
+	}
+	if err := db.DeleteGraphElement(param.Id); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
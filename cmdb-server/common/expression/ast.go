@@ -0,0 +1,118 @@
+package expression
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	JoinStart    = ""
+	JoinForward  = ">"
+	JoinBackward = "~"
+)
+
+// Error 表达式错误,Pos为从0开始的字符位置
+type Error struct {
+	Pos     int    `json:"pos"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos, e.Message)
+}
+
+func newError(pos int, message string) *Error {
+	return &Error{Pos: pos, Message: message}
+}
+
+// Expression 路径表达式,如 host.unit>unit~(unit)app[{key_name eq 'x'}]:[guid]
+type Expression struct {
+	Source   string     `json:"source"`
+	Pos      int        `json:"pos"`
+	Segments []*Segment `json:"segments"`
+}
+
+// Segment 表达式中的一段ci,Join为>时由上一段的Attr引用,为~时由本段的RefAttr引用上一段
+type Segment struct {
+	Pos          int       `json:"pos"`
+	Join         string    `json:"join"`
+	RefAttr      string    `json:"refAttr"`
+	RefAttrPos   int       `json:"refAttrPos"`
+	CiType       string    `json:"ciType"`
+	Filters      []*Filter `json:"filters"`
+	Attr         string    `json:"attr"`
+	AttrPos      int       `json:"attrPos"`
+	ResultColumn string    `json:"resultColumn"`
+	ResultPos    int       `json:"resultPos"`
+}
+
+type Filter struct {
+	Pos       int      `json:"pos"`
+	Attr      string   `json:"attr"`
+	AttrPos   int      `json:"attrPos"`
+	Operator  string   `json:"operator"`
+	Value     string   `json:"value"`
+	ValueList []string `json:"valueList"`
+	IsList    bool     `json:"isList"`
+	HasValue  bool     `json:"hasValue"`
+}
+
+// FirstSegment 表达式起点ci
+func (e *Expression) FirstSegment() *Segment {
+	return e.Segments[0]
+}
+
+// LastSegment 表达式终点ci
+func (e *Expression) LastSegment() *Segment {
+	return e.Segments[len(e.Segments)-1]
+}
+
+// ResultColumn 取终点ci上的 :[column] 配置
+func (e *Expression) ResultColumn() string {
+	return e.LastSegment().ResultColumn
+}
+
+// String 把表达式重新输出成文本,字符串值统一用单引号并转义
+func (e *Expression) String() string {
+	var builder strings.Builder
+	for _, segment := range e.Segments {
+		if segment.Join == JoinForward {
+			builder.WriteString(">")
+		} else if segment.Join == JoinBackward {
+			builder.WriteString(fmt.Sprintf("~(%s)", segment.RefAttr))
+		}
+		builder.WriteString(segment.CiType)
+		if len(segment.Filters) > 0 {
+			var filterList []string
+			for _, filter := range segment.Filters {
+				filterList = append(filterList, filter.String())
+			}
+			builder.WriteString(fmt.Sprintf("[%s]", strings.Join(filterList, ",")))
+		}
+		if segment.Attr != "" {
+			builder.WriteString("." + segment.Attr)
+		}
+		if segment.ResultColumn != "" {
+			builder.WriteString(fmt.Sprintf(":[%s]", segment.ResultColumn))
+		}
+	}
+	return builder.String()
+}
+
+func (f *Filter) String() string {
+	content := f.Attr + " " + f.Operator
+	if f.IsList {
+		var valueList []string
+		for _, v := range f.ValueList {
+			valueList = append(valueList, quoteValue(v))
+		}
+		content += fmt.Sprintf(" [%s]", strings.Join(valueList, ","))
+	} else if f.HasValue {
+		content += " " + quoteValue(f.Value)
+	}
+	return "{" + content + "}"
+}
+
+func quoteValue(value string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(value, "\\", "\\\\"), "'", "\\'") + "'"
+}
@@ -0,0 +1,126 @@
+package expression
+
+import (
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdent
+	tokenString
+	tokenRefForward  // >
+	tokenRefBackward // ~
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+	tokenLBrace
+	tokenRBrace
+	tokenDot
+	tokenColon
+	tokenComma
+	tokenCompare // >= <=
+)
+
+var tokenNameMap = map[tokenType]string{
+	tokenEOF:         "end of express",
+	tokenIdent:       "name",
+	tokenString:      "quoted string",
+	tokenRefForward:  "'>'",
+	tokenRefBackward: "'~'",
+	tokenLParen:      "'('",
+	tokenRParen:      "')'",
+	tokenLBracket:    "'['",
+	tokenRBracket:    "']'",
+	tokenLBrace:      "'{'",
+	tokenRBrace:      "'}'",
+	tokenDot:         "'.'",
+	tokenColon:       "':'",
+	tokenComma:       "','",
+	tokenCompare:     "compare operator",
+}
+
+type token struct {
+	Type  tokenType
+	Value string
+	Pos   int
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// tokenize 把表达式切分成token,单引号字符串中可以用\'与\\转义,反引号包裹的名称与普通名称相同
+func tokenize(express string) (tokenList []*token, err error) {
+	singleCharMap := map[byte]tokenType{'~': tokenRefBackward, '(': tokenLParen, ')': tokenRParen, '[': tokenLBracket, ']': tokenRBracket,
+		'{': tokenLBrace, '}': tokenRBrace, '.': tokenDot, ':': tokenColon, ',': tokenComma}
+	for i := 0; i < len(express); {
+		c := express[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '>' || c == '<':
+			if i+1 < len(express) && express[i+1] == '=' {
+				tokenList = append(tokenList, &token{Type: tokenCompare, Value: express[i : i+2], Pos: i})
+				i += 2
+			} else if c == '>' {
+				tokenList = append(tokenList, &token{Type: tokenRefForward, Value: ">", Pos: i})
+				i++
+			} else {
+				return nil, newError(i, "unexpected character '<'")
+			}
+		case c == '\'':
+			var value strings.Builder
+			j := i + 1
+			closed := false
+			for ; j < len(express); j++ {
+				if express[j] == '\\' && j+1 < len(express) && (express[j+1] == '\'' || express[j+1] == '\\') {
+					value.WriteByte(express[j+1])
+					j++
+					continue
+				}
+				if express[j] == '\'' {
+					closed = true
+					break
+				}
+				value.WriteByte(express[j])
+			}
+			if !closed {
+				return nil, newError(i, "quoted string is not closed")
+			}
+			tokenList = append(tokenList, &token{Type: tokenString, Value: value.String(), Pos: i})
+			i = j + 1
+		case c == '`':
+			end := strings.IndexByte(express[i+1:], '`')
+			if end < 0 {
+				return nil, newError(i, "backquoted name is not closed")
+			}
+			name := express[i+1 : i+1+end]
+			for k := 0; k < len(name); k++ {
+				if !isIdentChar(name[k]) {
+					return nil, newError(i+1+k, "illegal character in backquoted name")
+				}
+			}
+			tokenList = append(tokenList, &token{Type: tokenIdent, Value: name, Pos: i})
+			i += end + 2
+		case isIdentChar(c):
+			j := i
+			for j < len(express) && isIdentChar(express[j]) {
+				j++
+			}
+			tokenList = append(tokenList, &token{Type: tokenIdent, Value: express[i:j], Pos: i})
+			i = j
+		default:
+			if tokenType, b := singleCharMap[c]; b {
+				tokenList = append(tokenList, &token{Type: tokenType, Value: string(c), Pos: i})
+				i++
+			} else {
+				return nil, newError(i, "unexpected character '"+string(c)+"'")
+			}
+		}
+	}
+	tokenList = append(tokenList, &token{Type: tokenEOF, Pos: len(express)})
+	return
+}
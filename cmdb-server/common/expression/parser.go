@@ -0,0 +1,326 @@
+package expression
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	noValueOperators   = map[string]bool{"null": true, "notNull": true, "empty": true, "notEmpty": true}
+	listValueOperators = map[string]bool{"in": true, "notIn": true}
+	oneValueOperators  = map[string]bool{"eq": true, "ne": true, "like": true, ">=": true, "<=": true}
+)
+
+type parser struct {
+	express        string
+	tokenList      []*token
+	index          int
+	implicitRef    bool
+	extraOperators map[string]bool
+}
+
+// Parse 解析单个路径表达式,出错时返回带字符位置的*Error
+func Parse(express string) (result *Expression, err error) {
+	resultList, err := ParseList(express)
+	if err != nil {
+		return
+	}
+	if len(resultList) > 1 {
+		err = newError(resultList[1].Pos, "only one express is allowed")
+		return
+	}
+	result = resultList[0]
+	return
+}
+
+// ParsePattern 解析单个路径模式,与Parse相同但>前可以省略引用属性,如 app_system>unit~(host)host_resource
+// 省略的属性Attr为空,AttrPos为下一段的位置,由调用方按ci类型推断
+func ParsePattern(express string) (result *Expression, err error) {
+	resultList, err := parseList(express, true)
+	if err != nil {
+		return
+	}
+	if len(resultList) > 1 {
+		err = newError(resultList[1].Pos, "only one express is allowed")
+		return
+	}
+	result = resultList[0]
+	return
+}
+
+// ParseList 解析用顶层逗号隔开的多段表达式,如权限中的 a[{code eq 'x'}],a.b>b[{state in ['c','d']}]
+func ParseList(express string) (resultList []*Expression, err error) {
+	return parseList(express, false)
+}
+
+// ParseFilters 解析不带ci类型的过滤条件列表,如 [{state eq 'a b'},{code in ['x','y']}],外层的[]可以省略
+// extraOperators为调用方额外支持的单值操作符,如状态守卫中的gt、lt、regexp
+func ParseFilters(express string, extraOperators ...string) (filterList []*Filter, err error) {
+	tokenList, tokenErr := tokenize(express)
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+	p := parser{express: express, tokenList: tokenList, extraOperators: make(map[string]bool)}
+	for _, operator := range extraOperators {
+		p.extraOperators[operator] = true
+	}
+	if p.peek().Type == tokenEOF {
+		return
+	}
+	if p.peek().Type == tokenLBracket {
+		p.next()
+		if filterList, err = p.parseFilterList(); err != nil {
+			return nil, err
+		}
+	} else {
+		for {
+			filter, filterErr := p.parseFilter()
+			if filterErr != nil {
+				return nil, filterErr
+			}
+			filterList = append(filterList, filter)
+			if p.peek().Type != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if t := p.peek(); t.Type != tokenEOF {
+		return nil, p.unexpected(t, tokenNameMap[tokenEOF])
+	}
+	return
+}
+
+func parseList(express string, implicitRef bool) (resultList []*Expression, err error) {
+	tokenList, tokenErr := tokenize(express)
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+	p := parser{express: express, tokenList: tokenList, implicitRef: implicitRef}
+	for {
+		expr, parseErr := p.parseExpression()
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		resultList = append(resultList, expr)
+		if p.peek().Type == tokenEOF {
+			break
+		}
+		// 逗号分隔下一段表达式
+		p.next()
+	}
+	return
+}
+
+func (p *parser) peek() *token {
+	return p.tokenList[p.index]
+}
+
+func (p *parser) next() *token {
+	t := p.tokenList[p.index]
+	if t.Type != tokenEOF {
+		p.index++
+	}
+	return t
+}
+
+func (p *parser) expect(tokenType tokenType) (t *token, err error) {
+	t = p.next()
+	if t.Type != tokenType {
+		err = p.unexpected(t, tokenNameMap[tokenType])
+	}
+	return
+}
+
+func (p *parser) unexpected(t *token, expect string) error {
+	if t.Type == tokenEOF {
+		return newError(t.Pos, fmt.Sprintf("unexpected end of express, expect %s", expect))
+	}
+	return newError(t.Pos, fmt.Sprintf("unexpected %s '%s', expect %s", tokenNameMap[t.Type], t.Value, expect))
+}
+
+func (p *parser) parseExpression() (expr *Expression, err error) {
+	startToken := p.peek()
+	expr = &Expression{Pos: startToken.Pos}
+	for {
+		segment, segmentErr := p.parseSegment(len(expr.Segments) == 0)
+		if segmentErr != nil {
+			return nil, segmentErr
+		}
+		if segment.Join == JoinForward && expr.LastSegment().Attr == "" {
+			if !p.implicitRef {
+				return nil, newError(segment.Pos, fmt.Sprintf("'>' must follow a reference attribute like %s.attr", expr.LastSegment().CiType))
+			}
+			expr.LastSegment().AttrPos = segment.Pos
+		}
+		expr.Segments = append(expr.Segments, segment)
+		if nextType := p.peek().Type; nextType == tokenEOF || nextType == tokenComma {
+			break
+		}
+	}
+	expr.Source = strings.TrimSpace(p.express[expr.Pos:p.peek().Pos])
+	for _, segment := range expr.Segments[:len(expr.Segments)-1] {
+		if segment.ResultColumn != "" {
+			return nil, newError(segment.ResultPos, "result column can only be set on the last ci")
+		}
+	}
+	return
+}
+
+func (p *parser) parseSegment(first bool) (segment *Segment, err error) {
+	t := p.peek()
+	segment = &Segment{Pos: t.Pos, Join: JoinStart, Filters: []*Filter{}}
+	if !first {
+		switch t.Type {
+		case tokenRefForward:
+			p.next()
+			segment.Join = JoinForward
+		case tokenRefBackward:
+			p.next()
+			segment.Join = JoinBackward
+			if _, err = p.expect(tokenLParen); err != nil {
+				return
+			}
+			refToken, refErr := p.expect(tokenIdent)
+			if refErr != nil {
+				return nil, refErr
+			}
+			segment.RefAttr, segment.RefAttrPos = refToken.Value, refToken.Pos
+			if _, err = p.expect(tokenRParen); err != nil {
+				return
+			}
+		default:
+			return nil, p.unexpected(t, "'>' or '~' or ','")
+		}
+	}
+	ciToken, ciErr := p.expect(tokenIdent)
+	if ciErr != nil {
+		return nil, ciErr
+	}
+	segment.CiType = ciToken.Value
+	if p.peek().Type == tokenLBracket {
+		p.next()
+		if segment.Filters, err = p.parseFilterList(); err != nil {
+			return
+		}
+	}
+	if p.peek().Type == tokenDot {
+		p.next()
+		attrToken, attrErr := p.expect(tokenIdent)
+		if attrErr != nil {
+			return nil, attrErr
+		}
+		segment.Attr, segment.AttrPos = attrToken.Value, attrToken.Pos
+	}
+	if p.peek().Type == tokenColon {
+		p.next()
+		if _, err = p.expect(tokenLBracket); err != nil {
+			return
+		}
+		columnToken, columnErr := p.expect(tokenIdent)
+		if columnErr != nil {
+			return nil, columnErr
+		}
+		segment.ResultColumn, segment.ResultPos = columnToken.Value, columnToken.Pos
+		if _, err = p.expect(tokenRBracket); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// parseFilterList 解析 {attr op value},{attr op value}] ,起始的[已被读取
+func (p *parser) parseFilterList() (filterList []*Filter, err error) {
+	for {
+		filter, filterErr := p.parseFilter()
+		if filterErr != nil {
+			return nil, filterErr
+		}
+		filterList = append(filterList, filter)
+		t := p.next()
+		if t.Type == tokenRBracket {
+			break
+		}
+		if t.Type != tokenComma {
+			return nil, p.unexpected(t, "',' or ']'")
+		}
+	}
+	return
+}
+
+func (p *parser) parseFilter() (filter *Filter, err error) {
+	startToken, err := p.expect(tokenLBrace)
+	if err != nil {
+		return
+	}
+	filter = &Filter{Pos: startToken.Pos, ValueList: []string{}}
+	attrToken, attrErr := p.expect(tokenIdent)
+	if attrErr != nil {
+		return nil, attrErr
+	}
+	filter.Attr, filter.AttrPos = attrToken.Value, attrToken.Pos
+	opToken := p.next()
+	if opToken.Type != tokenIdent && opToken.Type != tokenCompare {
+		return nil, p.unexpected(opToken, "filter operator")
+	}
+	filter.Operator = opToken.Value
+	if !noValueOperators[filter.Operator] && !listValueOperators[filter.Operator] && !oneValueOperators[filter.Operator] && !p.extraOperators[filter.Operator] {
+		return nil, newError(opToken.Pos, fmt.Sprintf("filter operator '%s' is not supported", filter.Operator))
+	}
+	if p.peek().Type != tokenRBrace {
+		if noValueOperators[filter.Operator] {
+			return nil, newError(p.peek().Pos, fmt.Sprintf("filter operator '%s' can not have value", filter.Operator))
+		}
+		if p.peek().Type == tokenLBracket {
+			p.next()
+			if !listValueOperators[filter.Operator] {
+				return nil, newError(opToken.Pos, fmt.Sprintf("filter operator '%s' can not use value list", filter.Operator))
+			}
+			filter.IsList = true
+			for p.peek().Type != tokenRBracket {
+				value, valueErr := p.parseValue()
+				if valueErr != nil {
+					return nil, valueErr
+				}
+				filter.ValueList = append(filter.ValueList, value)
+				if p.peek().Type == tokenComma {
+					p.next()
+				} else if p.peek().Type != tokenRBracket {
+					return nil, p.unexpected(p.peek(), "',' or ']'")
+				}
+			}
+			p.next()
+		} else {
+			if filter.Value, err = p.parseValue(); err != nil {
+				return
+			}
+			filter.ValueList = []string{filter.Value}
+		}
+		filter.HasValue = true
+	} else if !noValueOperators[filter.Operator] {
+		return nil, newError(p.peek().Pos, fmt.Sprintf("filter operator '%s' need a value", filter.Operator))
+	}
+	if _, err = p.expect(tokenRBrace); err != nil {
+		return
+	}
+	return
+}
+
+// parseValue 值可以是单引号字符串,也可以是不带引号的名称或数字,如 1.5
+func (p *parser) parseValue() (value string, err error) {
+	t := p.next()
+	if t.Type == tokenString {
+		value = t.Value
+		return
+	}
+	if t.Type != tokenIdent {
+		err = p.unexpected(t, "value")
+		return
+	}
+	value = t.Value
+	for p.peek().Type == tokenDot && p.tokenList[p.index+1].Type == tokenIdent && p.tokenList[p.index+1].Pos == p.peek().Pos+1 {
+		p.next()
+		value += "." + p.next().Value
+	}
+	return
+}
@@ -0,0 +1,262 @@
+package expression
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+)
+
+func TestTokenize(t *testing.T) {
+	testCases := []struct {
+		name      string
+		express   string
+		typeList  []tokenType
+		valueList []string
+		errPos    int
+	}{
+		{name: "path", express: "host.unit>unit", typeList: []tokenType{tokenIdent, tokenDot, tokenIdent, tokenRefForward, tokenIdent, tokenEOF}, valueList: []string{"host", ".", "unit", ">", "unit", ""}},
+		{name: "backward", express: "a~(b)c", typeList: []tokenType{tokenIdent, tokenRefBackward, tokenLParen, tokenIdent, tokenRParen, tokenIdent, tokenEOF}, valueList: []string{"a", "~", "(", "b", ")", "c", ""}},
+		{name: "compare", express: "{a >= '1'}", typeList: []tokenType{tokenLBrace, tokenIdent, tokenCompare, tokenString, tokenRBrace, tokenEOF}, valueList: []string{"{", "a", ">=", "1", "}", ""}},
+		{name: "escape", express: `'it\'s a\\b'`, typeList: []tokenType{tokenString, tokenEOF}, valueList: []string{`it's a\b`, ""}},
+		{name: "space in string", express: "'a b  c'", typeList: []tokenType{tokenString, tokenEOF}, valueList: []string{"a b  c", ""}},
+		{name: "backquote", express: "`key_name`", typeList: []tokenType{tokenIdent, tokenEOF}, valueList: []string{"key_name", ""}},
+		{name: "string not closed", express: "a[{b eq 'c}]", errPos: 8},
+		{name: "backquote not closed", express: "a.`b", errPos: 2},
+		{name: "illegal backquote name", express: "`a b`", errPos: 2},
+		{name: "less than", express: "a<b", errPos: 1},
+		{name: "unexpected character", express: "a#b", errPos: 1},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			tokenList, err := tokenize(testCase.express)
+			if testCase.typeList == nil {
+				checkErrorPos(t, err, testCase.errPos)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			var typeList []tokenType
+			var valueList []string
+			for _, v := range tokenList {
+				typeList = append(typeList, v.Type)
+				valueList = append(valueList, v.Value)
+			}
+			if !reflect.DeepEqual(typeList, testCase.typeList) {
+				t.Errorf("token type: got %v, want %v", typeList, testCase.typeList)
+			}
+			if !reflect.DeepEqual(valueList, testCase.valueList) {
+				t.Errorf("token value: got %q, want %q", valueList, testCase.valueList)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		express  string
+		ciTypes  []string
+		joins    []string
+		result   string
+		filters  int
+		output   string
+		errPos   int
+		hasError bool
+	}{
+		{name: "single ci", express: "host", ciTypes: []string{"host"}, joins: []string{JoinStart}, output: "host"},
+		{name: "forward", express: "host.unit>unit", ciTypes: []string{"host", "unit"}, joins: []string{JoinStart, JoinForward}, output: "host.unit>unit"},
+		{name: "backward", express: "unit~(unit)host", ciTypes: []string{"unit", "host"}, joins: []string{JoinStart, JoinBackward}, output: "unit~(unit)host"},
+		{name: "result column", express: "host.unit>unit:[key_name]", ciTypes: []string{"host", "unit"}, joins: []string{JoinStart, JoinForward}, result: "key_name", output: "host.unit>unit:[key_name]"},
+		{name: "filters", express: "host[{state eq 'created'},{code in ['a','b c']}]", ciTypes: []string{"host"}, joins: []string{JoinStart}, filters: 2, output: "host[{state eq 'created'},{code in ['a','b c']}]"},
+		{name: "unquoted value", express: "host[{cpu >= 1.5}]", ciTypes: []string{"host"}, joins: []string{JoinStart}, filters: 1, output: "host[{cpu >= '1.5'}]"},
+		{name: "no value operator", express: "host[{ip notEmpty}]", ciTypes: []string{"host"}, joins: []string{JoinStart}, filters: 1, output: "host[{ip notEmpty}]"},
+		{name: "missing ref attr", express: "host>unit", errPos: 4, hasError: true},
+		{name: "result not last", express: "unit:[guid]~(unit)host", errPos: 6, hasError: true},
+		{name: "unsupported operator", express: "host[{a gt '1'}]", errPos: 8, hasError: true},
+		{name: "need value", express: "host[{a eq}]", errPos: 10, hasError: true},
+		{name: "no value allowed", express: "host[{a null 'x'}]", errPos: 13, hasError: true},
+		{name: "list not allowed", express: "host[{a eq ['x']}]", errPos: 8, hasError: true},
+		{name: "two express", express: "a,b", errPos: 2, hasError: true},
+		{name: "unexpected end", express: "host.", errPos: 5, hasError: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			expr, err := Parse(testCase.express)
+			if testCase.hasError {
+				checkErrorPos(t, err, testCase.errPos)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			var ciTypes, joins []string
+			filterCount := 0
+			for _, segment := range expr.Segments {
+				ciTypes = append(ciTypes, segment.CiType)
+				joins = append(joins, segment.Join)
+				filterCount += len(segment.Filters)
+			}
+			if !reflect.DeepEqual(ciTypes, testCase.ciTypes) {
+				t.Errorf("ciTypes: got %v, want %v", ciTypes, testCase.ciTypes)
+			}
+			if !reflect.DeepEqual(joins, testCase.joins) {
+				t.Errorf("joins: got %q, want %q", joins, testCase.joins)
+			}
+			if expr.ResultColumn() != testCase.result {
+				t.Errorf("result column: got %s, want %s", expr.ResultColumn(), testCase.result)
+			}
+			if filterCount != testCase.filters {
+				t.Errorf("filter count: got %d, want %d", filterCount, testCase.filters)
+			}
+			if expr.String() != testCase.output {
+				t.Errorf("string: got %s, want %s", expr.String(), testCase.output)
+			}
+		})
+	}
+}
+
+// TestParseLegacyExpress 原有表达式语法解析后重新输出应保持不变
+func TestParseList(t *testing.T) {
+	testCases := []struct {
+		name       string
+		express    string
+		sourceList []string
+		errPos     int
+		hasError   bool
+	}{
+		{name: "one", express: "a", sourceList: []string{"a"}},
+		{name: "filter comma", express: "a[{code eq 'x'},{b in ['c','d']}],a.b>b", sourceList: []string{"a[{code eq 'x'},{b in ['c','d']}]", "a.b>b"}},
+		{name: "space", express: " a.b>b , c ", sourceList: []string{"a.b>b", "c"}},
+		{name: "empty second", express: "a,", errPos: 2, hasError: true},
+		{name: "second error", express: "a,b>c", errPos: 3, hasError: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			exprList, err := ParseList(testCase.express)
+			if testCase.hasError {
+				checkErrorPos(t, err, testCase.errPos)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			var sourceList []string
+			for _, expr := range exprList {
+				sourceList = append(sourceList, expr.Source)
+			}
+			if !reflect.DeepEqual(sourceList, testCase.sourceList) {
+				t.Errorf("source: got %q, want %q", sourceList, testCase.sourceList)
+			}
+		})
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	testCases := []struct {
+		name       string
+		express    string
+		extra      []string
+		outputList []string
+		errPos     int
+		hasError   bool
+	}{
+		{name: "empty", express: ""},
+		{name: "bracket", express: "[{state eq 'a b'},{code in ['x y','z']}]", outputList: []string{"{state eq 'a b'}", "{code in ['x y','z']}"}},
+		{name: "no bracket", express: "{state eq 'a'},{code notEmpty}", outputList: []string{"{state eq 'a'}", "{code notEmpty}"}},
+		{name: "extra operator", express: "[{num gt 5},{code regexp '^a b$'}]", extra: []string{"gt", "regexp"}, outputList: []string{"{num gt '5'}", "{code regexp '^a b$'}"}},
+		{name: "extra not allowed", express: "[{num gt 5}]", errPos: 6, hasError: true},
+		{name: "trailing", express: "[{a eq 'b'}] c", errPos: 13, hasError: true},
+		{name: "need value", express: "{a eq}", errPos: 5, hasError: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filterList, err := ParseFilters(testCase.express, testCase.extra...)
+			if testCase.hasError {
+				checkErrorPos(t, err, testCase.errPos)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			var outputList []string
+			for _, filter := range filterList {
+				outputList = append(outputList, filter.String())
+			}
+			if !reflect.DeepEqual(outputList, testCase.outputList) {
+				t.Errorf("filters: got %q, want %q", outputList, testCase.outputList)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	attrData := map[string]map[string]*models.SysCiTypeAttrTable{
+		"host": {
+			"guid": {Name: "guid", InputType: "text"},
+			"unit": {Name: "unit", InputType: "ref", RefCiType: "unit"},
+			"code": {Name: "code", InputType: "text"},
+		},
+		"unit": {
+			"guid":       {Name: "guid", InputType: "text"},
+			"app_system": {Name: "app_system", InputType: models.MultiRefType, RefCiType: "app_system"},
+		},
+		"app_system": {
+			"guid": {Name: "guid", InputType: "text"},
+		},
+	}
+	provider := func(ciType string) (attrMap map[string]*models.SysCiTypeAttrTable, exist bool, err error) {
+		attrMap, exist = attrData[ciType]
+		return
+	}
+	testCases := []struct {
+		name    string
+		express string
+		posList []int
+	}{
+		{name: "valid", express: "host[{code eq 'a'}].unit>unit.app_system>app_system:[guid]"},
+		{name: "valid backward", express: "unit~(unit)host"},
+		{name: "ci not exist", express: "host.unit>none", posList: []int{10}},
+		{name: "backward ci not exist", express: "unit~(unit)none", posList: []int{11}},
+		{name: "attr not exist", express: "host.none>unit", posList: []int{5}},
+		{name: "not ref attr", express: "host.code>unit", posList: []int{5}},
+		{name: "wrong ref ci", express: "host.unit>app_system", posList: []int{5}},
+		{name: "filter attr", express: "host[{none eq 'a'}]", posList: []int{6}},
+		{name: "result column", express: "host:[none]", posList: []int{6}},
+		{name: "multiple", express: "host[{none eq 'a'}]:[none2]", posList: []int{6, 21}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			expr, err := Parse(testCase.express)
+			if err != nil {
+				t.Fatalf("unexpected parse error: %s", err.Error())
+			}
+			errList, err := Validate(expr, provider)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			var posList []int
+			for _, v := range errList {
+				posList = append(posList, v.Pos)
+			}
+			if !reflect.DeepEqual(posList, testCase.posList) {
+				t.Errorf("error pos: got %v, want %v (%v)", posList, testCase.posList, errList)
+			}
+		})
+	}
+}
+
+func checkErrorPos(t *testing.T, err error, pos int) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expect error at position %d, got nil", pos)
+	}
+	exprErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expect *Error, got %T: %s", err, err.Error())
+	}
+	if exprErr.Pos != pos {
+		t.Errorf("error position: got %d, want %d (%s)", exprErr.Pos, pos, exprErr.Message)
+	}
+}
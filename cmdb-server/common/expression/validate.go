@@ -0,0 +1,68 @@
+package expression
+
+import (
+	"fmt"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+)
+
+// AttrProvider 返回ci类型的属性,ci类型不存在时exist返回false
+type AttrProvider func(ciType string) (attrMap map[string]*models.SysCiTypeAttrTable, exist bool, err error)
+
+// Validate 检查表达式中的ci类型、引用属性、过滤属性与结果列是否存在且引用关系正确
+func Validate(expr *Expression, provider AttrProvider) (errList []*Error, err error) {
+	var lastSegment *Segment
+	var lastAttrMap map[string]*models.SysCiTypeAttrTable
+	for _, segment := range expr.Segments {
+		attrMap, exist, queryErr := provider(segment.CiType)
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		ciTypePos := segment.Pos
+		if segment.Join == JoinForward {
+			ciTypePos++
+		} else if segment.Join == JoinBackward {
+			ciTypePos = segment.RefAttrPos + len(segment.RefAttr) + 1
+		}
+		if !exist {
+			errList = append(errList, newError(ciTypePos, fmt.Sprintf("ciType '%s' is not exist", segment.CiType)))
+			lastSegment, lastAttrMap = segment, nil
+			continue
+		}
+		if segment.Join == JoinForward && lastAttrMap != nil {
+			errList = append(errList, checkRefAttr(lastAttrMap, lastSegment.CiType, lastSegment.Attr, lastSegment.AttrPos, segment.CiType)...)
+		}
+		if segment.Join == JoinBackward && lastSegment != nil {
+			errList = append(errList, checkRefAttr(attrMap, segment.CiType, segment.RefAttr, segment.RefAttrPos, lastSegment.CiType)...)
+		}
+		for _, filter := range segment.Filters {
+			if _, b := attrMap[filter.Attr]; !b {
+				errList = append(errList, newError(filter.AttrPos, fmt.Sprintf("attr '%s' is not exist in ciType '%s'", filter.Attr, segment.CiType)))
+			}
+		}
+		if segment.ResultColumn != "" {
+			if _, b := attrMap[segment.ResultColumn]; !b {
+				errList = append(errList, newError(segment.ResultPos, fmt.Sprintf("attr '%s' is not exist in ciType '%s'", segment.ResultColumn, segment.CiType)))
+			}
+		}
+		lastSegment, lastAttrMap = segment, attrMap
+	}
+	return
+}
+
+func checkRefAttr(attrMap map[string]*models.SysCiTypeAttrTable, ciType, attrName string, pos int, refCiType string) (errList []*Error) {
+	attr, b := attrMap[attrName]
+	if !b {
+		errList = append(errList, newError(pos, fmt.Sprintf("attr '%s' is not exist in ciType '%s'", attrName, ciType)))
+		return
+	}
+	if attr.InputType != "ref" && attr.InputType != models.MultiRefType {
+		errList = append(errList, newError(pos, fmt.Sprintf("attr '%s.%s' is not a reference attribute", ciType, attrName)))
+		return
+	}
+	if attr.RefCiType != refCiType {
+		errList = append(errList, newError(pos, fmt.Sprintf("attr '%s.%s' reference ciType is '%s' not '%s'", ciType, attrName, attr.RefCiType, refCiType)))
+	}
+	return
+}
@@ -50,6 +50,8 @@ type ErrorTemplate struct {
 	ApiPermissionDeny   CustomError `json:"api_permission_deny"`
 
 	SlaveModifyDeny CustomError `json:"slave_modify_deny"`
+	// ci data unique index conflict
+	UniqueConstraintConflictError CustomError `json:"unique_constraint_conflict_error"`
 }
 
 var (
@@ -0,0 +1,60 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+)
+
+type RelationNode struct {
+	Id      string
+	Label   string
+	Tooltip string
+	Root    bool
+}
+
+type RelationEdge struct {
+	From   string
+	To     string
+	Label  string
+	Dashed bool
+}
+
+// RenderRelationDot 把数据关系渲染成dot,节点按id去重,根节点加粗
+func RenderRelationDot(nodes []*RelationNode, edges []*RelationEdge) string {
+	var dot strings.Builder
+	dot.WriteString("\ndigraph G {\n")
+	dot.WriteString("rankdir=LR;compound=true;fillcolor=white;\n")
+	dot.WriteString("node[shape=box;fontsize=14;" + DefaultStyle + "];\n")
+	renderedNodes := make(map[string]bool)
+	for _, node := range nodes {
+		if renderedNodes[node.Id] {
+			continue
+		}
+		renderedNodes[node.Id] = true
+		nodeAttrs := []string{
+			fmt.Sprintf("id=\"%s\"", escapeDotString(node.Id)),
+			fmt.Sprintf("label=\"%s\"", escapeDotString(coverLableTooltip(node.Label))),
+			fmt.Sprintf("tooltip=\"%s\"", escapeDotString(coverLableTooltip(node.Tooltip))),
+		}
+		if node.Root {
+			nodeAttrs = append(nodeAttrs, "penwidth=3")
+		}
+		dot.WriteString(fmt.Sprintf("\"%s\"[%s];\n", escapeDotString(node.Id), strings.Join(nodeAttrs, ";")))
+	}
+	for _, edge := range edges {
+		if !renderedNodes[edge.From] || !renderedNodes[edge.To] {
+			continue
+		}
+		edgeAttrs := []string{fmt.Sprintf("label=\"%s\"", escapeDotString(edge.Label))}
+		if edge.Dashed {
+			edgeAttrs = append(edgeAttrs, "style=dashed")
+		}
+		dot.WriteString(fmt.Sprintf("\"%s\"->\"%s\"[%s];\n", escapeDotString(edge.From), escapeDotString(edge.To), strings.Join(edgeAttrs, ";")))
+	}
+	dot.WriteString("}\n")
+	return dot.String()
+}
+
+func escapeDotString(input string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(input)
+}
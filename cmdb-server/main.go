@@ -32,9 +32,11 @@ func main() {
 	//start cron job
 	go ci.StartConsumeOperationLog()
 	go db.StartSyncImageFile()
-	go db.StartConsumeAffectGuidMap()
-	go db.StartConsumeAffectCiType()
-	go db.StartConsumeUniquePathHandle()
+	go db.StartConsumeWorkQueue()
+	go db.StartConsumeWebhookOutbox()
+	go db.StartFlushCiQueryStat()
+	go db.StartJobWorker()
+	go db.StartOperationLogChainJob()
 	go ci.StartSyncCron()
 	//start http
 	api.InitHttpServer()
@@ -0,0 +1,96 @@
+package models
+
+const (
+	AuditLogCat = "CI Data Audit"
+)
+
+// CiDataAuditContent 数据审计内容,以json存放在sys_log.content中
+type CiDataAuditContent struct {
+	Action      string                  `json:"action"`
+	SourceState string                  `json:"sourceState"`
+	TargetState string                  `json:"targetState"`
+	Changes     []*CiDataAuditChangeObj `json:"changes"`
+}
+
+type CiDataAuditChangeObj struct {
+	Attr     string `json:"attr"`
+	OldValue string `json:"oldValue"`
+	NewValue string `json:"newValue"`
+	Masked   bool   `json:"masked"`
+}
+
+type CiDataAuditQueryParam struct {
+	Guid      string    `json:"guid"`
+	CiType    string    `json:"ciType"`
+	Operator  string    `json:"operator"`
+	StartTime string    `json:"startTime"`
+	EndTime   string    `json:"endTime"`
+	Pageable  *PageInfo `json:"pageable"`
+	// 无整类查询权限时由接口层填入有权限的数据guid
+	GuidLimited   bool     `json:"-"`
+	LegalGuidList []string `json:"-"`
+}
+
+type CiDataAuditObj struct {
+	Id          int                     `json:"id"`
+	CiType      string                  `json:"ciType"`
+	Guid        string                  `json:"guid"`
+	KeyName     string                  `json:"keyName"`
+	Operator    string                  `json:"operator"`
+	Operation   string                  `json:"operation"`
+	Action      string                  `json:"action"`
+	SourceState string                  `json:"sourceState"`
+	TargetState string                  `json:"targetState"`
+	CreatedDate string                  `json:"createdDate"`
+	Changes     []*CiDataAuditChangeObj `json:"changes"`
+}
+
+type SysLogArchiveTable struct {
+	Id            int    `json:"id" xorm:"id"`
+	FileName      string `json:"fileName" xorm:"file_name"`
+	StartSeq      int64  `json:"startSeq" xorm:"start_seq"`
+	EndSeq        int64  `json:"endSeq" xorm:"end_seq"`
+	RowCount      int    `json:"rowCount" xorm:"row_count"`
+	StartDate     string `json:"startDate" xorm:"start_date"`
+	EndDate       string `json:"endDate" xorm:"end_date"`
+	FirstPrevHash string `json:"firstPrevHash" xorm:"first_prev_hash"`
+	LastHash      string `json:"lastHash" xorm:"last_hash"`
+	FileSha256    string `json:"fileSha256" xorm:"file_sha256"`
+	Signature     string `json:"signature" xorm:"signature"`
+	CreateTime    string `json:"createTime" xorm:"create_time"`
+}
+
+// LogArchiveManifest 归档清单,与归档文件放在同一目录,Signature为去掉签名后的清单内容签名
+type LogArchiveManifest struct {
+	FileName      string `json:"fileName"`
+	StartSeq      int64  `json:"startSeq"`
+	EndSeq        int64  `json:"endSeq"`
+	RowCount      int    `json:"rowCount"`
+	StartDate     string `json:"startDate"`
+	EndDate       string `json:"endDate"`
+	FirstPrevHash string `json:"firstPrevHash"`
+	LastHash      string `json:"lastHash"`
+	FileSha256    string `json:"fileSha256"`
+	CreateTime    string `json:"createTime"`
+	Signature     string `json:"signature,omitempty"`
+}
+
+type LogChainVerifyResult struct {
+	Valid         bool                `json:"valid"`
+	ArchiveSeq    int64               `json:"archiveSeq"`
+	HeadSeq       int64               `json:"headSeq"`
+	StartSeq      int64               `json:"startSeq"`
+	EndSeq        int64               `json:"endSeq"`
+	CheckedCount  int                 `json:"checkedCount"`
+	UnsealedCount int                 `json:"unsealedCount"`
+	Truncated     bool                `json:"truncated"`
+	Issues        []*LogChainIssueObj `json:"issues"`
+}
+
+// LogChainIssueObj Type: gap 序号缺失, prevHash 与上一条哈希不连续, hash 内容被修改, head 链头不一致, archive 归档文件异常
+type LogChainIssueObj struct {
+	Type    string `json:"type"`
+	Seq     int64  `json:"seq"`
+	LogId   string `json:"logId"`
+	Message string `json:"message"`
+}
@@ -85,6 +85,9 @@ type SysStateTransitionTable struct {
 	Action            string `json:"action" xorm:"action"`
 	OperationFormType string `json:"operationFormType" xorm:"operation_form_type"`
 	OperationMultiple string `json:"operationMultiple" xorm:"operation_multiple"`
+	GuardFilter       string `json:"guardFilter" xorm:"guard_filter"`      // 转换前数据需满足的条件,如 [{env eq 'prd'},{size in ['s','m']}]
+	RequiredAttrs     string `json:"requiredAttrs" xorm:"required_attrs"`  // 转换前必须有值的属性,逗号分隔
+	RefStateGuard     string `json:"refStateGuard" xorm:"ref_state_guard"` // 引用数据的状态条件,如 [{unit in ['created_1','changed_1']}]
 }
 
 type SysStateTransitionQuery struct {
@@ -102,6 +105,9 @@ type SysStateTransitionQuery struct {
 	Action           string `json:"action" xorm:"action"`
 	StartState       string `json:"start_state" xorm:"start_state"`
 	FinalState       string `json:"final_state" xorm:"final_state"`
+	GuardFilter      string `json:"guardFilter" xorm:"guard_filter"`
+	RequiredAttrs    string `json:"requiredAttrs" xorm:"required_attrs"`
+	RefStateGuard    string `json:"refStateGuard" xorm:"ref_state_guard"`
 }
 
 type BuildAttrValueParam struct {
@@ -144,6 +150,7 @@ type MultiCiDataObj struct {
 	NowData             []map[string]string
 	InputData           []CiDataMapObj
 	RefCiTypeMap        map[string]*SysCiTypeTable
+	UniqueIndexColumn   map[string]bool
 }
 
 type AttrRefFilterLegalObj struct {
@@ -335,3 +342,109 @@ type SensitiveDataQueryResult struct {
 	Text     string `json:"text"`
 	Password string `json:"password"`
 }
+
+type CiDataDiffResult struct {
+	Guid     string               `json:"guid"`
+	CiType   string               `json:"ciType"`
+	KeyName  string               `json:"keyName"`
+	FromId   string               `json:"fromId"`
+	FromTime string               `json:"fromTime"`
+	ToId     string               `json:"toId"`
+	ToTime   string               `json:"toTime"`
+	Changes  []*CiDataAttrDiffObj `json:"changes"`
+}
+
+type CiDataAttrDiffObj struct {
+	Attr        string              `json:"attr"`
+	DisplayName string              `json:"displayName"`
+	InputType   string              `json:"inputType"`
+	OldValue    interface{}         `json:"oldValue"`
+	NewValue    interface{}         `json:"newValue"`
+	Added       []*CiDataRefDataObj `json:"added,omitempty"`
+	Removed     []*CiDataRefDataObj `json:"removed,omitempty"`
+	Masked      bool                `json:"masked"`
+}
+
+// AutofillCheckParam ciType为空时按自动填充依赖顺序检查所有ci类型,fix为true时修正不一致的数据
+type AutofillCheckParam struct {
+	CiType string `json:"ciType"`
+	Fix    bool   `json:"fix"`
+}
+
+type AutofillCheckResult struct {
+	CiTypes       []*AutofillCheckCiTypeObj `json:"ciTypes"`
+	Diffs         []*AutofillCheckDiffObj   `json:"diffs"`
+	DiffTruncated bool                      `json:"diffTruncated"`
+}
+
+type AutofillCheckCiTypeObj struct {
+	CiType        string   `json:"ciType"`
+	Attrs         []string `json:"attrs"`
+	RowCount      int      `json:"rowCount"`
+	DiffRowCount  int      `json:"diffRowCount"`
+	FixedRowCount int      `json:"fixedRowCount"`
+	ErrorMsg      string   `json:"errorMsg"`
+}
+
+type AutofillCheckDiffObj struct {
+	CiType        string `json:"ciType"`
+	Guid          string `json:"guid"`
+	KeyName       string `json:"keyName"`
+	Attr          string `json:"attr"`
+	StoredValue   string `json:"storedValue"`
+	ComputedValue string `json:"computedValue"`
+	Fixed         bool   `json:"fixed"`
+}
+
+// AutofillExplainParam guid与inputData至少传一个,inputData会覆盖guid对应的行数据;rule不为空时解释未保存的规则
+type AutofillExplainParam struct {
+	Guid      string            `json:"guid"`
+	InputData map[string]string `json:"inputData"`
+	Rule      string            `json:"rule"`
+}
+
+type AutofillExplainResult struct {
+	CiType     string                       `json:"ciType"`
+	Attr       string                       `json:"attr"`
+	InputType  string                       `json:"inputType"`
+	Rule       string                       `json:"rule"`
+	RowData    map[string]string            `json:"rowData"`
+	Segments   []*AutofillExplainSegmentObj `json:"segments"`
+	ValueList  []string                     `json:"valueList"`
+	FinalValue string                       `json:"finalValue"`
+	ErrorMsg   string                       `json:"errorMsg"`
+}
+
+// AutofillExplainSegmentObj depth大于0表示是嵌套的规则(autofillRule类型属性或过滤条件中的自动填充)
+type AutofillExplainSegmentObj struct {
+	Depth     int                      `json:"depth"`
+	Index     int                      `json:"index"`
+	Type      string                   `json:"type"`
+	Value     string                   `json:"value"`
+	Queries   []*AutofillExplainSqlObj `json:"queries"`
+	ValueList []string                 `json:"valueList"`
+	ErrorMsg  string                   `json:"errorMsg"`
+}
+
+type AutofillExplainSqlObj struct {
+	Sql      string        `json:"sql"`
+	Params   []interface{} `json:"params"`
+	RowCount int           `json:"rowCount"`
+	ErrorMsg string        `json:"errorMsg"`
+}
+
+type ExpressValidateParam struct {
+	Express string `json:"express" binding:"required"`
+}
+
+type ExpressValidateResult struct {
+	Express string             `json:"express"`
+	Valid   bool               `json:"valid"`
+	Errors  []*ExpressErrorObj `json:"errors"`
+}
+
+// ExpressErrorObj pos为表达式中从0开始的字符位置
+type ExpressErrorObj struct {
+	Pos     int    `json:"pos"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,55 @@
+package models
+
+const (
+	CiDataBulkModeChunkAtomic = "chunk-atomic"
+	CiDataBulkModeBestEffort  = "best-effort"
+)
+
+// CiDataBulkParam 批量数据操作,每个item为同一ci类型同一操作的数据行
+type CiDataBulkParam struct {
+	Mode      string            `json:"mode"` // chunk-atomic: 每个分片一个事务,分片失败后停止,已提交的分片不回滚; best-effort: 分片失败后逐行重试并继续
+	ChunkSize int               `json:"chunkSize"`
+	Items     []*CiDataBulkItem `json:"items" binding:"required"`
+}
+
+type CiDataBulkItem struct {
+	CiType    string                   `json:"ciType"`
+	Operation string                   `json:"operation" binding:"required"`
+	Rows      []map[string]interface{} `json:"rows"`
+	InputData []CiDataMapObj           `json:"inputData"`
+	Action    string                   `json:"action"`
+}
+
+// CiDataBulkJobParam 批量操作任务的参数,Rows已转换成InputData
+type CiDataBulkJobParam struct {
+	BulkJob string           `json:"bulkJob"`
+	Param   *CiDataBulkParam `json:"param"`
+}
+
+type SysCiDataBulkJobTable struct {
+	Id           string `json:"id" xorm:"id"`
+	Mode         string `json:"mode" xorm:"mode"`
+	Status       string `json:"status" xorm:"status"` // running,success,partial,fail,interrupted
+	Job          string `json:"job" xorm:"job"`       // 执行批量操作的异步任务id
+	ChunkSize    int    `json:"chunkSize" xorm:"chunk_size"`
+	TotalCount   int    `json:"totalCount" xorm:"total_count"`
+	DoneCount    int    `json:"doneCount" xorm:"done_count"`
+	SuccessCount int    `json:"successCount" xorm:"success_count"`
+	FailCount    int    `json:"failCount" xorm:"fail_count"`
+	ErrorMsg     string `json:"errorMsg" xorm:"error_msg"`
+	CreateUser   string `json:"createUser" xorm:"create_user"`
+	CreateTime   string `json:"createTime" xorm:"create_time"`
+	UpdateTime   string `json:"updateTime" xorm:"update_time"`
+}
+
+type SysCiDataBulkRowTable struct {
+	Id        int    `json:"id" xorm:"id"`
+	Job       string `json:"job" xorm:"job"`
+	RowIndex  int    `json:"rowIndex" xorm:"row_index"`
+	CiType    string `json:"ciType" xorm:"ci_type"`
+	Operation string `json:"operation" xorm:"operation"`
+	Guid      string `json:"guid" xorm:"guid"`
+	NewGuid   string `json:"newGuid" xorm:"new_guid"`
+	Status    string `json:"status" xorm:"status"` // success,fail,skip
+	ErrorMsg  string `json:"errorMsg" xorm:"error_msg"`
+}
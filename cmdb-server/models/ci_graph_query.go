@@ -0,0 +1,44 @@
+package models
+
+const (
+	GraphQueryResultTable     = "table"
+	GraphQueryResultNested    = "nested"
+	GraphQueryMaxHops         = 8
+	GraphQueryDefaultPageSize = 100
+	GraphQueryMaxPageSize     = 1000
+	GraphQueryMaxNestedRows   = 10000
+	GraphQueryTimeoutMs       = 10000
+)
+
+// CiGraphQueryParam Pattern 为路径表达式,如 app_system>unit~(host)host_resource, Hops 按下标对应表达式中的每一段ci
+// ResultType: table 每条路径一行,列名为 别名.属性; nested 按第一段ci分页,下一段数据放在上一段数据的 别名 字段中
+type CiGraphQueryParam struct {
+	Pattern    string                `json:"pattern" binding:"required"`
+	Hops       []*CiGraphQueryHopObj `json:"hops"`
+	ResultType string                `json:"resultType"`
+	Pageable   *PageInfo             `json:"pageable"`
+	Roles      []string              `json:"-"`
+}
+
+// CiGraphQueryHopObj Alias 为空时用ci类型,同一ci类型出现多次时后面的加上 _下标; Columns 为空时返回guid与key_name
+// Columns 与 Filters 不支持多选引用属性,过滤密码与敏感属性需要有该属性的查询权限
+type CiGraphQueryHopObj struct {
+	Alias   string                   `json:"alias"`
+	Filters []*QueryRequestFilterObj `json:"filters"`
+	Columns []string                 `json:"columns"`
+}
+
+type CiGraphQueryHopMeta struct {
+	Index   int      `json:"index"`
+	Alias   string   `json:"alias"`
+	CiType  string   `json:"ciType"`
+	RefAttr string   `json:"refAttr"`
+	Columns []string `json:"columns"`
+}
+
+type CiGraphQueryResult struct {
+	PageInfo  PageInfo                 `json:"pageInfo"`
+	Hops      []*CiGraphQueryHopMeta   `json:"hops"`
+	Truncated bool                     `json:"truncated"`
+	Contents  []map[string]interface{} `json:"contents"`
+}
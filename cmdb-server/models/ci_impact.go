@@ -0,0 +1,56 @@
+package models
+
+const (
+	ImpactDirectionUpstream   = "upstream"
+	ImpactDirectionDownstream = "downstream"
+	ImpactDirectionBoth       = "both"
+	ImpactDefaultDepth        = 3
+	ImpactMaxDepth            = 10
+	ImpactDefaultNodeLimit    = 500
+	ImpactMaxNodeLimit        = 5000
+)
+
+// CiImpactParam Direction: upstream 沿本行引用的数据走(依赖), downstream 沿引用本行的数据走(受影响), both 两个方向
+// CiTypes、Attrs、States 为空时不限制,用于限制遍历能进入的ci类型、能走的引用属性(属性id或名称)和数据状态(状态id或名称)
+type CiImpactParam struct {
+	Guid      string   `json:"guid"`
+	Direction string   `json:"direction"`
+	Depth     int      `json:"depth"`
+	Limit     int      `json:"limit"`
+	CiTypes   []string `json:"ciTypes"`
+	Attrs     []string `json:"attrs"`
+	States    []string `json:"states"`
+	Roles     []string `json:"-"`
+}
+
+type CiImpactNodeObj struct {
+	Guid       string `json:"guid"`
+	CiType     string `json:"ciType"`
+	CiTypeName string `json:"ciTypeName"`
+	KeyName    string `json:"keyName"`
+	State      string `json:"state"`
+	StateName  string `json:"stateName"`
+	Depth      int    `json:"depth"`
+	Direction  string `json:"direction"`
+}
+
+// CiImpactEdgeObj From 为引用方数据, To 为被引用数据, Cycle 表示指回遍历路径上的祖先数据
+// 指向已访问过但不在当前路径上的数据时不算环,两种情况遍历都不再从该数据继续
+type CiImpactEdgeObj struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Attr      string `json:"attr"`
+	AttrName  string `json:"attrName"`
+	InputType string `json:"inputType"`
+	Cycle     bool   `json:"cycle"`
+}
+
+type CiImpactResult struct {
+	Root      string             `json:"root"`
+	Direction string             `json:"direction"`
+	Depth     int                `json:"depth"`
+	Truncated bool               `json:"truncated"`
+	Nodes     []*CiImpactNodeObj `json:"nodes"`
+	Edges     []*CiImpactEdgeObj `json:"edges"`
+	Dot       string             `json:"dot"`
+}
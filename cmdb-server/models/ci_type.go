@@ -77,6 +77,40 @@ type SysCiTemplateTable struct {
 	StateMachine string `json:"stateMachine" xorm:"state_machine"`
 }
 
+// CiTemplateObj ci模板详情,CiTypes为使用该模板创建的ci类型
+type CiTemplateObj struct {
+	Id           string                    `json:"id"`
+	Description  string                    `json:"description"`
+	ImageFile    string                    `json:"imageFile"`
+	StateMachine string                    `json:"stateMachine"`
+	Attributes   []*SysCiTemplateAttrTable `json:"attributes"`
+	CiTypes      []string                  `json:"ciTypes"`
+}
+
+type CiTemplatePropagateParam struct {
+	CiTypes []string `json:"ciTypes"` // 为空时同步到所有使用该模板的ci类型
+}
+
+// CiTemplatePropagatePlan 模板属性同步计划,新增的属性为notCreated状态,需要在ci类型中确认后生效
+type CiTemplatePropagatePlan struct {
+	CiTemplate string                      `json:"ciTemplate"`
+	CiTypes    []*CiTemplatePropagateCiObj `json:"ciTypes"`
+	Applied    bool                        `json:"applied"`
+}
+
+type CiTemplatePropagateCiObj struct {
+	CiType       string                        `json:"ciType"`
+	NewAttrs     []string                      `json:"newAttrs"`
+	ChangedAttrs []*ModelAttrChangeObj         `json:"changedAttrs"`
+	SkippedAttrs []*CiTemplatePropagateSkipObj `json:"skippedAttrs"`
+}
+
+type CiTemplatePropagateSkipObj struct {
+	Attr   string `json:"attr"`
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
 type SysFilesTable struct {
 	Guid    string `json:"guid"`
 	Type    string `json:"type"`
@@ -102,6 +136,7 @@ type SysSyncRecordTable struct {
 	Operator     string      `json:"operator" xorm:"operator"`
 	ContentData  interface{} `json:"-" xorm:"-"`
 	SyncDataIds  []string    `json:"-" xorm:"-"`
+	PrevSourceId string      `json:"prevSourceId" xorm:"-"` // 主环境上一个推送成功的同步记录,备环境用来检查是否缺失同步文件
 }
 
 type SyncRecordQuery struct {
@@ -109,6 +144,29 @@ type SyncRecordQuery struct {
 	List   []*SysSyncRecordTable `json:"list"`
 }
 
+type SyncResyncParam struct {
+	SourceId string `json:"sourceId"` // 指定全量快照的同步记录id,为空时取最新的快照
+}
+
+// SyncSnapshotCiTypeObj 全量快照中单个ci类型的数据,值为null的列为nil,与空字符串区分
+type SyncSnapshotCiTypeObj struct {
+	CiType          string                          `json:"ciType"`
+	Rows            []map[string]*string            `json:"rows"`
+	History         []map[string]*string            `json:"history"`
+	MultiRef        map[string][]map[string]*string `json:"multiRef"`
+	MultiRefHistory map[string][]map[string]*string `json:"multiRefHistory"`
+}
+
+// SyncSnapshotManifestObj 全量快照清单,每个ci类型的数据作为单独的同步文件推送
+type SyncSnapshotManifestObj struct {
+	Parts []*SyncSnapshotPartObj `json:"parts"`
+}
+
+type SyncSnapshotPartObj struct {
+	CiType string `json:"ciType"`
+	SyncId string `json:"syncId"`
+}
+
 type SysSyncDataTable struct {
 	ID          int       `json:"id" xorm:"id"`
 	SyncRecord  string    `json:"syncRecord" xorm:"sync_record"`
@@ -123,3 +181,74 @@ type SysSyncDataTable struct {
 	CreateTime  time.Time `json:"createTime" xorm:"create_time"`
 	UpdateTime  time.Time `json:"updateTime" xorm:"update_time"`
 }
+
+// SysCiTypeUniqueKeyTable ci类型上声明的组合唯一键,会在ci表上建立真实的唯一索引
+type SysCiTypeUniqueKeyTable struct {
+	Id         string `json:"id" xorm:"id"`
+	CiType     string `json:"ciType" xorm:"ci_type"`
+	Name       string `json:"name" xorm:"name"`
+	Attrs      string `json:"attrs" xorm:"attrs"`   // 属性名,逗号分隔
+	Status     string `json:"status" xorm:"status"` // notCreated,created
+	CreateUser string `json:"createUser" xorm:"create_user"`
+	CreateTime string `json:"createTime" xorm:"create_time"`
+}
+
+type CiTypeUniqueKeyParam struct {
+	Name  string   `json:"name" binding:"required"`
+	Attrs []string `json:"attrs" binding:"required"`
+}
+
+type CiTypeUniqueIndexObj struct {
+	IndexName string   `json:"indexName"`
+	Source    string   `json:"source"` // attr:属性唯一约束 key:组合唯一键
+	KeyId     string   `json:"keyId"`
+	Attrs     []string `json:"attrs"`
+	Exist     bool     `json:"exist"`
+}
+
+// SysCiTypeIndexTable ci类型上声明的普通索引,生效后在ci表和历史表上建立
+type SysCiTypeIndexTable struct {
+	Id         string `json:"id" xorm:"id"`
+	CiType     string `json:"ciType" xorm:"ci_type"`
+	Name       string `json:"name" xorm:"name"`
+	Attrs      string `json:"attrs" xorm:"attrs"`   // 属性名,逗号分隔
+	Target     string `json:"target" xorm:"target"` // now:只建在ci表 history:只建在历史表 all:都建
+	Status     string `json:"status" xorm:"status"` // notCreated,created
+	CreateUser string `json:"createUser" xorm:"create_user"`
+	CreateTime string `json:"createTime" xorm:"create_time"`
+	UpdateTime string `json:"updateTime" xorm:"update_time"`
+}
+
+type CiTypeIndexParam struct {
+	Name   string   `json:"name" binding:"required"`
+	Attrs  []string `json:"attrs" binding:"required"`
+	Target string   `json:"target"`
+}
+
+type CiTypeIndexObj struct {
+	*SysCiTypeIndexTable
+	NowExist     bool `json:"nowExist"`
+	HistoryExist bool `json:"historyExist"`
+}
+
+// SysCiQueryStatTable ci数据查询过滤条件统计,用来给出索引建议
+type SysCiQueryStatTable struct {
+	Id          string `json:"id" xorm:"id"`
+	CiType      string `json:"ciType" xorm:"ci_type"`
+	QueryMode   string `json:"queryMode" xorm:"query_mode"`
+	FilterAttrs string `json:"filterAttrs" xorm:"filter_attrs"` // 属性名:操作符,逗号分隔
+	SortAttr    string `json:"sortAttr" xorm:"sort_attr"`
+	QueryCount  int    `json:"queryCount" xorm:"query_count"`
+	SlowCount   int    `json:"slowCount" xorm:"slow_count"`
+	TotalCostMs int64  `json:"totalCostMs" xorm:"total_cost_ms"`
+	MaxCostMs   int64  `json:"maxCostMs" xorm:"max_cost_ms"`
+	LastTime    string `json:"lastTime" xorm:"last_time"`
+}
+
+type CiTypeIndexSuggestObj struct {
+	Stat        *SysCiQueryStatTable `json:"stat"`
+	Attrs       []string             `json:"attrs"`
+	Target      string               `json:"target"`
+	CoveredBy   string               `json:"coveredBy"` // 已有能覆盖该查询的索引
+	Description string               `json:"description"`
+}
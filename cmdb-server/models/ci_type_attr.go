@@ -56,13 +56,13 @@ type SysCiTemplateAttrTable struct {
 	DataType                string `json:"dataType" xorm:"data_type"`
 	DataLength              int    `json:"dataLength" xorm:"data_length"`
 	TextValidate            string `json:"textValidate" xorm:"text_validate"`
-	RefCiType               string `json:"-" xorm:"ref_ci_type"`
-	RefName                 string `json:"-" xorm:"ref_name"`
-	RefType                 string `json:"-" xorm:"ref_type"`
-	RefFilter               string `json:"-" xorm:"ref_filter"`
-	RefUpdateStateValidate  string `json:"-" xorm:"ref_update_state_validate"`
-	RefConfirmStateValidate string `json:"-" xorm:"ref_confirm_state_validate"`
-	SelectList              string `json:"-" xorm:"select_list"`
+	RefCiType               string `json:"referenceId" xorm:"ref_ci_type"`
+	RefName                 string `json:"referenceName" xorm:"ref_name"`
+	RefType                 string `json:"referenceType" xorm:"ref_type"`
+	RefFilter               string `json:"referenceFilter" xorm:"ref_filter"`
+	RefUpdateStateValidate  string `json:"refUpdateStateValidate" xorm:"ref_update_state_validate"`
+	RefConfirmStateValidate string `json:"refConfirmStateValidate" xorm:"ref_confirm_state_validate"`
+	SelectList              string `json:"selectList" xorm:"select_list"`
 	UiSearchOrder           int    `json:"uiSearchOrder" xorm:"ui_search_order"`
 	UiFormOrder             int    `json:"uiFormOrder" xorm:"ui_form_order"`
 	UniqueConstraint        string `json:"uniqueConstraint" xorm:"unique_constraint"`
@@ -74,12 +74,12 @@ type SysCiTemplateAttrTable struct {
 	ResetOnEdit             string `json:"resetOnEdit" xorm:"reset_on_edit"`
 	Source                  string `json:"source" xorm:"source"`
 	Customizable            string `json:"customizable" xorm:"customizable"`
-	AutofillAble            string `json:"-" xorm:"autofillable"`
-	AutofillRule            string `json:"-" xorm:"autofill_rule"`
-	AutofillType            string `json:"-" xorm:"autofill_type"`
-	EditGroupControl        string `json:"-" xorm:"edit_group_control"`
-	EditGroupValues         string `json:"-" xorm:"edit_group_value"`
-	ExtRefEntity            string `json:"-" xorm:"ext_ref_entity"`
+	AutofillAble            string `json:"autofillable" xorm:"autofillable"`
+	AutofillRule            string `json:"autoFillRule" xorm:"autofill_rule"`
+	AutofillType            string `json:"autoFillType" xorm:"autofill_type"`
+	EditGroupControl        string `json:"editGroupControl" xorm:"edit_group_control"`
+	EditGroupValues         string `json:"editGroupValues" xorm:"edit_group_value"`
+	ExtRefEntity            string `json:"extRefEntity" xorm:"ext_ref_entity"`
 	ConfirmNullable         string `json:"confirmNullable" xorm:"confirm_nullable"`
 	Sensitive               string `json:"sensitive" xorm:"sensitive"`
 }
@@ -98,3 +98,68 @@ type CiTypeReferenceObj struct {
 	RefName        string `json:"referenceName" xorm:"ref_name"`
 	RefType        string `json:"referenceType" xorm:"ref_type"`
 }
+
+type SysSchemaMigrationTable struct {
+	Id            string `json:"id" xorm:"id"`
+	CiType        string `json:"ciType" xorm:"ci_type"`
+	CiTypeAttr    string `json:"ciTypeAttr" xorm:"ci_type_attr"`
+	ChangeType    string `json:"changeType" xorm:"change_type"` // widen,narrow,typeChange
+	OldDataType   string `json:"oldDataType" xorm:"old_data_type"`
+	OldDataLength int    `json:"oldDataLength" xorm:"old_data_length"`
+	NewDataType   string `json:"newDataType" xorm:"new_data_type"`
+	NewDataLength int    `json:"newDataLength" xorm:"new_data_length"`
+	ForwardSql    string `json:"forwardSql" xorm:"forward_sql"`
+	RollbackSql   string `json:"rollbackSql" xorm:"rollback_sql"`
+	Status        string `json:"status" xorm:"status"` // running,ok,fail,rollback
+	ErrorMsg      string `json:"errorMsg" xorm:"error_msg"`
+	CreateUser    string `json:"createUser" xorm:"create_user"`
+	CreateTime    string `json:"createTime" xorm:"create_time"`
+	UpdateTime    string `json:"updateTime" xorm:"update_time"`
+}
+
+type SchemaMigrationParam struct {
+	DataType   string `json:"dataType" binding:"required"`
+	DataLength int    `json:"dataLength"`
+}
+
+type SchemaMigrationPlan struct {
+	CiType          string   `json:"ciType"`
+	CiTypeAttr      string   `json:"ciTypeAttr"`
+	ChangeType      string   `json:"changeType"`
+	OldDataType     string   `json:"oldDataType"`
+	OldDataLength   int      `json:"oldDataLength"`
+	NewDataType     string   `json:"newDataType"`
+	NewDataLength   int      `json:"newDataLength"`
+	ForwardSqlList  []string `json:"forwardSqlList"`
+	RollbackSqlList []string `json:"rollbackSqlList"`
+	InvalidCount    int      `json:"invalidCount"`
+	InvalidGuidList []string `json:"invalidGuidList"`
+	Executable      bool     `json:"executable"`
+}
+
+// AttrRefFilterPreviewParam referenceFilter为准备保存的引用过滤规则,guid不为空时按该行数据计算可选的引用数据
+type AttrRefFilterPreviewParam struct {
+	RefFilter string `json:"referenceFilter"`
+	Guid      string `json:"guid"`
+}
+
+type AttrRefFilterPreviewResult struct {
+	CiType             string                       `json:"ciType"`
+	CiTypeAttr         string                       `json:"ciTypeAttr"`
+	RefCiType          string                       `json:"referenceId"`
+	RefFilter          string                       `json:"referenceFilter"`
+	Candidates         []*CiDataRefDataObj          `json:"candidates"`
+	RowCount           int                          `json:"rowCount"`
+	ViolationCount     int                          `json:"violationCount"`
+	Violations         []*AttrRefFilterViolationObj `json:"violations"`
+	ViolationTruncated bool                         `json:"violationTruncated"`
+}
+
+// AttrRefFilterViolationObj 现有数据行中不满足新过滤规则的引用值
+type AttrRefFilterViolationObj struct {
+	Guid          string   `json:"guid"`
+	KeyName       string   `json:"key_name"`
+	Values        []string `json:"values"`
+	InvalidValues []string `json:"invalidValues"`
+	ErrorMsg      string   `json:"errorMsg"`
+}
@@ -30,14 +30,17 @@ type LogConfig struct {
 }
 
 type DatabaseConfig struct {
-	Server   string `json:"server"`
-	Port     string `json:"port"`
-	User     string `json:"user"`
-	Password string `json:"password"`
-	DataBase string `json:"database"`
-	MaxOpen  int    `json:"maxOpen"`
-	MaxIdle  int    `json:"maxIdle"`
-	Timeout  int    `json:"timeout"`
+	Server            string `json:"server"`
+	Port              string `json:"port"`
+	User              string `json:"user"`
+	Password          string `json:"password"`
+	DataBase          string `json:"database"`
+	MaxOpen           int    `json:"maxOpen"`
+	MaxIdle           int    `json:"maxIdle"`
+	Timeout           int    `json:"timeout"`
+	UniqueIndexEnable bool   `json:"unique_index_enable"` // 属性唯一约束是否同时建立数据库唯一索引
+	QueryStatEnable   bool   `json:"query_stat_enable"`   // 是否统计ci数据查询的过滤条件
+	SlowQueryMs       int64  `json:"slow_query_ms"`       // 超过该耗时的查询记为慢查询,默认500ms
 }
 
 type WecubeConfig struct {
@@ -69,11 +72,26 @@ type SyncConfig struct {
 	NexusUser    string `json:"nexus_user"`
 	NexusPwd     string `json:"nexus_pwd"`
 	NexusRepo    string `json:"nexus_repo"`
+	Transport    string `json:"transport"`    // 同步文件传输方式: nexus(默认)/local/http
+	LocalDir     string `json:"local_dir"`    // local方式为共享目录,http方式为备环境接收目录
+	HttpAddress  string `json:"http_address"` // http方式备环境地址,如 http://127.0.0.1:19090
+	SyncKey      string `json:"sync_key"`     // http方式主备共享密钥
 	HostIp       string `json:"host_ip"`
 	MasterEnable bool   `json:"-"`
 	SlaveEnable  bool   `json:"-"`
 }
 
+type JobConfig struct {
+	WorkerNum      int `json:"worker_num"`       // 异步任务并发数,默认4
+	QueueWorkerNum int `json:"queue_worker_num"` // 自动填充与唯一路径队列并发数,默认4
+}
+
+type OperationLogConfig struct {
+	ChainKey      string `json:"chain_key"`      // 日志哈希链、链头与归档清单的签名密钥,为空时不封链也不归档
+	RetentionDays int    `json:"retention_days"` // 操作日志保留天数,超过的归档后删除,0为不清理
+	ArchiveDir    string `json:"archive_dir"`    // 归档文件目录,默认logs/archive
+}
+
 type GlobalConfig struct {
 	IsPluginMode         string                        `json:"is_plugin_mode"`
 	DefaultLanguage      string                        `json:"default_language"`
@@ -85,6 +103,8 @@ type GlobalConfig struct {
 	Auth                 AuthConfig                    `json:"auth"`
 	MenuApiMap           MenuApiMapConfig              `json:"menu_api_map"`
 	Sync                 SyncConfig                    `json:"sync"`
+	Job                  JobConfig                     `json:"job"`
+	OperationLog         OperationLogConfig            `json:"operation_log"`
 	DefaultReportObjAttr []*DefaultReportObjAttrConfig `json:"default_report_obj_attr"`
 	// default json
 }
@@ -45,6 +45,16 @@ const (
 
 	TmpSyncDir   = "/tmp/sync/"
 	SyncNexusDir = "/"
+
+	SyncMaxRetryCount    = 5
+	SyncCategoryModel    = "model"
+	SyncCategoryCiData   = "ciData"
+	SyncCategorySnapshot = "snapshot"
+
+	SchemaChangeWiden      = "widen"
+	SchemaChangeNarrow     = "narrow"
+	SchemaChangeTypeChange = "typeChange"
+	SchemaInvalidGuidLimit = 100
 )
 
 var (
@@ -74,3 +74,24 @@ type GraphElementNode struct {
 	UpdateOperation     string              `json:"updateOperation" xorm:"update_operation"`
 	Children            []*GraphElementNode `json:"children" xorm:"children"`
 }
+
+// ModifyGraphParam 新增与修改图,RootData不为空时整棵图元素树会被替换
+type ModifyGraphParam struct {
+	Id              string            `json:"graphId"`
+	Name            string            `json:"name" binding:"required"`
+	View            string            `json:"view" binding:"required"`
+	GraphType       string            `json:"graphType" binding:"required"`
+	NodeGroups      string            `json:"nodeGroups"`
+	GraphDir        string            `json:"graphDir"`
+	GraphNodeConfig string            `json:"graphNodeConfig"`
+	GraphEdgeConfig string            `json:"graphEdgeConfig"`
+	RootData        *GraphElementNode `json:"rootData"`
+}
+
+type DeleteGraphParam struct {
+	Id string `json:"graphId" binding:"required"`
+}
+
+type DeleteGraphElementParam struct {
+	Id string `json:"graphElementId" binding:"required"`
+}
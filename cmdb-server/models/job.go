@@ -0,0 +1,56 @@
+package models
+
+const (
+	JobTypeImportCiData         = "importCiData"
+	JobTypeExportReport         = "exportReport"
+	JobTypeViewConfirm          = "viewConfirm"
+	JobTypeRefreshImportHistory = "refreshImportHistory"
+	JobTypeAutofillCheck        = "autofillCheck"
+	JobTypeCiDataBulk           = "ciDataBulk"
+
+	JobStatusWait        = "wait"
+	JobStatusRunning     = "running"
+	JobStatusSuccess     = "success"
+	JobStatusFail        = "fail"
+	JobStatusCancel      = "cancel"
+	JobStatusInterrupted = "interrupted"
+)
+
+type SysJobTable struct {
+	Id         string `json:"id" xorm:"id"`
+	JobType    string `json:"jobType" xorm:"job_type"`
+	Status     string `json:"status" xorm:"status"` // wait,running,success,fail,cancel,interrupted
+	Param      string `json:"param" xorm:"param"`
+	Result     string `json:"-" xorm:"result"`
+	ErrorMsg   string `json:"errorMsg" xorm:"error_msg"`
+	Roles      string `json:"-" xorm:"roles"`
+	UserToken  string `json:"-" xorm:"user_token"` // 加密后的提交人token,任务结束后清空
+	Instance   string `json:"instance" xorm:"instance"`
+	RetryCount int    `json:"retryCount" xorm:"retry_count"`
+	CreateUser string `json:"createUser" xorm:"create_user"`
+	CreateTime string `json:"createTime" xorm:"create_time"`
+	StartTime  string `json:"startTime" xorm:"start_time"`
+	EndTime    string `json:"endTime" xorm:"end_time"`
+	UpdateTime string `json:"updateTime" xorm:"update_time"`
+}
+
+type JobSubmitParam struct {
+	JobType string      `json:"jobType" binding:"required"`
+	Param   interface{} `json:"param"`
+}
+
+// JobRequestContext 提交任务的用户信息,token加密保存在任务中供任意实例执行时使用
+type JobRequestContext struct {
+	Operator  string
+	Roles     []string
+	UserToken string
+}
+
+type ImportCiDataJobParam struct {
+	UseNewGuid bool                `json:"useNewGuid"`
+	Data       *ExportReportResult `json:"data"`
+}
+
+type RefreshImportHistoryJobParam struct {
+	Guid string `json:"guid"` // 为空时刷新所有created状态的导入记录
+}
@@ -0,0 +1,60 @@
+package models
+
+const (
+	ModelBundleVersion = "v1"
+)
+
+// ModelBundle 模型导出包,包含ci类型、属性、状态机、报表与视图的定义
+type ModelBundle struct {
+	Version             string                        `json:"version"`
+	ExportTime          string                        `json:"exportTime"`
+	CiTypes             []*SysCiTypeTable             `json:"ciTypes"`
+	CiTypeAttrs         []*SysCiTypeAttrTable         `json:"ciTypeAttrs"`
+	StateMachines       []*SysStateMachineTable       `json:"stateMachines"`
+	States              []*SysStateTable              `json:"states"`
+	StateTransitions    []*SysStateTransitionTable    `json:"stateTransitions"`
+	Reports             []*SysReportTable             `json:"reports"`
+	ReportObjects       []*SysReportObjectTable       `json:"reportObjects"`
+	ReportObjectAttrs   []*SysReportObjectAttrTable   `json:"reportObjectAttrs"`
+	ReportObjectFilters []*SysReportObjectFilterTable `json:"reportObjectFilters"`
+	Views               []*SysViewTable               `json:"views"`
+	Graphs              []*SysGraphTable              `json:"graphs"`
+	GraphElements       []*SysGraphElementTable       `json:"graphElements"`
+}
+
+// ModelImportPlan 模型导入计划,Errors不为空时不允许执行导入
+type ModelImportPlan struct {
+	Version              string                `json:"version"`
+	NewCiTypes           []string              `json:"newCiTypes"`
+	ApplyCiTypes         []string              `json:"applyCiTypes"`
+	NewAttrs             []string              `json:"newAttrs"`
+	ApplyAttrs           []string              `json:"applyAttrs"`
+	ChangedAttrs         []*ModelAttrChangeObj `json:"changedAttrs"`
+	NewStateMachines     []string              `json:"newStateMachines"`
+	ChangedStateMachines []string              `json:"changedStateMachines"`
+	NewReports           []string              `json:"newReports"`
+	ChangedReports       []string              `json:"changedReports"`
+	NewViews             []string              `json:"newViews"`
+	ChangedViews         []string              `json:"changedViews"`
+	MissingAttrRefs      []*ModelMissingRefObj `json:"missingAttrRefs"`
+	Warnings             []string              `json:"warnings"`
+	Errors               []string              `json:"errors"`
+	Applied              bool                  `json:"applied"`
+}
+
+type ModelAttrChangeObj struct {
+	Id     string                 `json:"id"`
+	Fields []*ModelFieldChangeObj `json:"fields"`
+}
+
+type ModelFieldChangeObj struct {
+	Field    string `json:"field"`
+	OldValue string `json:"oldValue"`
+	NewValue string `json:"newValue"`
+}
+
+type ModelMissingRefObj struct {
+	Object   string `json:"object"`
+	ObjectId string `json:"objectId"`
+	Attr     string `json:"attr"`
+}
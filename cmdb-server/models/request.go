@@ -4,6 +4,14 @@ type QueryRequestFilterObj struct {
 	Name     string      `json:"name"`
 	Operator string      `json:"operator"`
 	Value    interface{} `json:"value"`
+	GuidList []string    `json:"-"` // refPath等需要预先解析的条件,解析出的数据guid列表,不为nil时按guid列过滤
+}
+
+// QueryRequestFilterGroup 嵌套过滤条件,logic为and/or/not,not表示组内条件按and连接后取反
+type QueryRequestFilterGroup struct {
+	Logic   string                     `json:"logic"`
+	Filters []*QueryRequestFilterObj   `json:"filters"`
+	Groups  []*QueryRequestFilterGroup `json:"groups"`
 }
 
 type QueryRequestSorting struct {
@@ -14,16 +22,19 @@ type QueryRequestSorting struct {
 type QueryRequestDialect struct {
 	AssociatedData map[string]string `json:"associatedData"`
 	QueryMode      string            `json:"queryMode"`
+	AsOf           string            `json:"asOf"` // queryMode为asOf时生效,查询该时间点的数据快照,格式 2006-01-02 15:04:05
 }
 
 type QueryRequestParam struct {
 	Filters        []*QueryRequestFilterObj `json:"filters"`
+	FilterGroup    *QueryRequestFilterGroup `json:"filterGroup"` // 与filters按and连接
 	Dialect        *QueryRequestDialect     `json:"dialect"`
 	Paging         bool                     `json:"paging"`
 	Pageable       *PageInfo                `json:"pageable"`
 	Sorting        *QueryRequestSorting     `json:"sorting"`
 	ResultColumns  []string                 `json:"resultColumns"`
 	WithRefRowData bool                     `json:"withRefRowData"` // ref选项查询时是否返回整行数据，默认否只返回guid、keyName
+	Roles          []string                 `json:"-"`              // refPath条件按这些角色校验路径上各ci的查询权限
 }
 
 type TransFiltersParam struct {
@@ -32,4 +43,5 @@ type TransFiltersParam struct {
 	Prefix     string
 	KeyMap     map[string]string
 	PrimaryKey string
+	GuidColumn string // 按guid过滤时使用的列,如tt.guid
 }
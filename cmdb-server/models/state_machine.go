@@ -0,0 +1,29 @@
+package models
+
+// StateMachineParam 状态机完整定义,状态与状态转换按整体保存,不在列表中的状态与转换会被删除
+type StateMachineParam struct {
+	Id          string                     `json:"id"`
+	Description string                     `json:"description"`
+	StartState  string                     `json:"startState"`
+	FinalState  string                     `json:"finalState"`
+	States      []*SysStateTable           `json:"states"`
+	Transitions []*SysStateTransitionTable `json:"transitions"`
+}
+
+// StateMachineSavePlan 状态机保存计划,Errors不为空时不允许保存,Impacts不为空时需要force确认
+type StateMachineSavePlan struct {
+	Id            string                   `json:"id"`
+	Errors        []string                 `json:"errors"`
+	Warnings      []string                 `json:"warnings"`
+	NewStates     []string                 `json:"newStates"`
+	RemovedStates []string                 `json:"removedStates"`
+	CiTypes       []string                 `json:"ciTypes"`
+	Impacts       []*StateMachineImpactObj `json:"impacts"`
+	Applied       bool                     `json:"applied"`
+}
+
+type StateMachineImpactObj struct {
+	CiType   string `json:"ciType" xorm:"ci_type"`
+	State    string `json:"state" xorm:"state"`
+	RowCount int    `json:"rowCount" xorm:"row_count"`
+}
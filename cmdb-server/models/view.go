@@ -68,3 +68,14 @@ type SysRoleViewTable struct {
 	View       string `json:"view" xorm:"view"`
 	Permission string `json:"permission" xorm:"permission"`
 }
+
+type DeleteViewParam struct {
+	Id string `json:"viewId" binding:"required"`
+}
+
+// CopyViewParam 复制视图及其下的图与图元素,权限角色一并复制
+type CopyViewParam struct {
+	Id      string `json:"viewId" binding:"required"`
+	NewId   string `json:"newViewId" binding:"required"`
+	NewName string `json:"newName" binding:"required"`
+}
@@ -0,0 +1,65 @@
+package models
+
+type SysWebhookTable struct {
+	Id         string `json:"id" xorm:"id"`
+	Name       string `json:"name" xorm:"name" binding:"required"`
+	Url        string `json:"url" xorm:"url" binding:"required"`
+	Secret     string `json:"secret" xorm:"secret"`
+	CiType     string `json:"ciType" xorm:"ci_type" binding:"required"`
+	Actions    string `json:"actions" xorm:"actions"` // 逗号分隔,insert/update/confirm/delete/execute,为空表示全部
+	Filter     string `json:"filter" xorm:"filter"`   // 过滤表达式,如 host_resource[{state eq 'created'}]
+	Enable     string `json:"enable" xorm:"enable"`   // yes/no
+	MaxRetry   int    `json:"maxRetry" xorm:"max_retry"`
+	CreateUser string `json:"createUser" xorm:"create_user"`
+	CreateTime string `json:"createTime" xorm:"create_time"`
+	UpdateUser string `json:"updateUser" xorm:"update_user"`
+	UpdateTime string `json:"updateTime" xorm:"update_time"`
+}
+
+type SysWebhookOutboxTable struct {
+	Id         int    `json:"id" xorm:"id"`
+	Webhook    string `json:"webhook" xorm:"webhook"`
+	CiType     string `json:"ciType" xorm:"ci_type"`
+	DataGuid   string `json:"dataGuid" xorm:"data_guid"`
+	Action     string `json:"action" xorm:"action"`
+	Operation  string `json:"operation" xorm:"operation"`
+	Payload    string `json:"payload" xorm:"payload"`
+	Status     string `json:"status" xorm:"status"` // pending,wait,sending,ok,fail,skip
+	RetryCount int    `json:"retryCount" xorm:"retry_count"`
+	NextTime   string `json:"nextTime" xorm:"next_time"`
+	ErrorMsg   string `json:"errorMsg" xorm:"error_msg"`
+	CreateTime string `json:"createTime" xorm:"create_time"`
+	UpdateTime string `json:"updateTime" xorm:"update_time"`
+}
+
+type SysWebhookDeliveryTable struct {
+	Id           int    `json:"id" xorm:"id"`
+	Outbox       int    `json:"outbox" xorm:"outbox"`
+	Webhook      string `json:"webhook" xorm:"webhook"`
+	RequestTime  string `json:"requestTime" xorm:"request_time"`
+	ResponseCode int    `json:"responseCode" xorm:"response_code"`
+	ResponseBody string `json:"responseBody" xorm:"response_body"`
+	ErrorMsg     string `json:"errorMsg" xorm:"error_msg"`
+	CostMs       int64  `json:"costMs" xorm:"cost_ms"`
+}
+
+type WebhookEventPayload struct {
+	EventId   int                    `json:"eventId"`
+	Webhook   string                 `json:"webhook"`
+	CiType    string                 `json:"ciType"`
+	Guid      string                 `json:"guid"`
+	Action    string                 `json:"action"`
+	Operation string                 `json:"operation"`
+	Operator  string                 `json:"operator"`
+	EventTime string                 `json:"eventTime"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// WebhookRowObj 数据操作中单行数据的webhook事件
+type WebhookRowObj struct {
+	CiType         string
+	Guid           string
+	Action         string
+	NowData        map[string]string
+	SensitiveAttrs []string
+}
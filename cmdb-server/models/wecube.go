@@ -3,6 +3,13 @@ package models
 type EntityQueryParam struct {
 	Criteria          EntityQueryObj    `json:"criteria"`
 	AdditionalFilters []*EntityQueryObj `json:"additionalFilters"`
+	FilterGroup       *EntityQueryGroup `json:"filterGroup"` // 嵌套条件,与criteria、additionalFilters按and连接
+}
+
+type EntityQueryGroup struct {
+	Logic   string              `json:"logic"`
+	Filters []*EntityQueryObj   `json:"filters"`
+	Groups  []*EntityQueryGroup `json:"groups"`
 }
 
 type EntityQueryObj struct {
@@ -0,0 +1,27 @@
+package models
+
+const (
+	WorkQueueAutofillChain  = "autofillChain"  // 数据行属性变化后,查找自动填充依赖它的数据
+	WorkQueueAutofillGuid   = "autofillGuid"   // 重新计算单行数据的自动填充属性
+	WorkQueueAutofillCiType = "autofillCiType" // 重新计算整个ci类型的自动填充属性
+	WorkQueueUniquePath     = "uniquePath"     // 唯一路径状态自动流转
+)
+
+type SysWorkQueueTable struct {
+	Id         int    `json:"id" xorm:"id"`
+	Queue      string `json:"queue" xorm:"queue"`
+	DedupKey   string `json:"dedupKey" xorm:"dedup_key"`
+	Payload    string `json:"payload" xorm:"payload"`
+	Status     string `json:"status" xorm:"status"` // wait,running,fail
+	RetryCount int    `json:"retryCount" xorm:"retry_count"`
+	NextTime   string `json:"nextTime" xorm:"next_time"`
+	ErrorMsg   string `json:"errorMsg" xorm:"error_msg"`
+	CreateTime string `json:"createTime" xorm:"create_time"`
+	UpdateTime string `json:"updateTime" xorm:"update_time"`
+}
+
+type WorkQueueAutofillPayload struct {
+	CiType string `json:"ciType"`
+	Guid   string `json:"guid"`
+	Column string `json:"column"`
+}
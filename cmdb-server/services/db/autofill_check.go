@@ -0,0 +1,262 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+)
+
+const (
+	autofillCheckPageSize    = 500
+	autofillCheckMaxDiff     = 2000
+	autofillFixHistoryAction = "autofill_fix"
+)
+
+// CheckCiAutofill 重新计算ci类型所有数据的自动填充属性,返回与计算值不一致的数据,fix为true时修正并写入历史
+func CheckCiAutofill(param *models.AutofillCheckParam) (result *models.AutofillCheckResult, err error) {
+	ciTypeList, attrMap, err := getAutofillCheckCiTypeOrder(param.CiType)
+	if err != nil {
+		return
+	}
+	result = &models.AutofillCheckResult{CiTypes: []*models.AutofillCheckCiTypeObj{}, Diffs: []*models.AutofillCheckDiffObj{}}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	for _, ciType := range ciTypeList {
+		ciTypeResult := &models.AutofillCheckCiTypeObj{CiType: ciType, Attrs: []string{}}
+		if tmpErr := checkCiTypeAutofill(ciType, attrMap[ciType], param, nowTime, ciTypeResult, result); tmpErr != nil {
+			ciTypeResult.ErrorMsg = tmpErr.Error()
+			log.Error(nil, log.LOGGER_APP, "Check ci type autofill fail", zap.String("ciType", ciType), zap.Error(tmpErr))
+		}
+		result.CiTypes = append(result.CiTypes, ciTypeResult)
+	}
+	return
+}
+
+// getAutofillCheckCiTypeOrder 被依赖的ci类型排在前面,保证上游的值先修正,循环依赖的部分按名称排在最后
+func getAutofillCheckCiTypeOrder(ciType string) (ciTypeList []string, attrMap map[string][]*models.SysCiTypeAttrTable, err error) {
+	var attrTable []*models.SysCiTypeAttrTable
+	err = x.SQL("select * from sys_ci_type_attr where status='created' and ci_type in (select id from sys_ci_type where status='created') order by ci_type,ui_form_order").Find(&attrTable)
+	if err != nil {
+		err = fmt.Errorf("Try to query ci attributes fail,%s ", err.Error())
+		return
+	}
+	attrMap = make(map[string][]*models.SysCiTypeAttrTable)
+	autofillRuleMap := make(map[string][]string)
+	for _, attr := range attrTable {
+		attrMap[attr.CiType] = append(attrMap[attr.CiType], attr)
+		if attr.AutofillAble == "yes" && attr.AutofillType != "suggest" {
+			autofillRuleMap[attr.CiType] = append(autofillRuleMap[attr.CiType], attr.AutofillRule)
+		}
+	}
+	if ciType != "" {
+		if _, b := autofillRuleMap[ciType]; !b {
+			err = fmt.Errorf("CiType:%s has no created autofill attribute ", ciType)
+			return
+		}
+		ciTypeList = []string{ciType}
+	} else {
+		for k := range autofillRuleMap {
+			ciTypeList = append(ciTypeList, k)
+		}
+		sort.Strings(ciTypeList)
+		ciTypeList = sortCiTypeByAutofillDep(ciTypeList, autofillRuleMap)
+	}
+	for _, tmpCiType := range ciTypeList {
+		if attrMap[tmpCiType], err = rebuildAttrOrderByAutofill(tmpCiType, attrMap[tmpCiType]); err != nil {
+			err = fmt.Errorf("CiType:%s %s", tmpCiType, err.Error())
+			return
+		}
+	}
+	return
+}
+
+func sortCiTypeByAutofillDep(ciTypeList []string, autofillRuleMap map[string][]string) (sortList []string) {
+	depMap := make(map[string]map[string]bool)
+	for _, ciType := range ciTypeList {
+		depMap[ciType] = make(map[string]bool)
+		ruleString := strings.Join(autofillRuleMap[ciType], "\n")
+		for _, depCiType := range ciTypeList {
+			if depCiType == ciType {
+				continue
+			}
+			if regexp.MustCompile(`(^|[^\w])` + regexp.QuoteMeta(depCiType) + `#`).MatchString(ruleString) {
+				depMap[ciType][depCiType] = true
+			}
+		}
+	}
+	doneMap := make(map[string]bool)
+	for len(sortList) < len(ciTypeList) {
+		var readyList []string
+		for _, ciType := range ciTypeList {
+			if doneMap[ciType] {
+				continue
+			}
+			ready := true
+			for depCiType := range depMap[ciType] {
+				if !doneMap[depCiType] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				readyList = append(readyList, ciType)
+			}
+		}
+		if len(readyList) == 0 {
+			// 存在循环依赖,剩下的按名称顺序
+			for _, ciType := range ciTypeList {
+				if !doneMap[ciType] {
+					readyList = append(readyList, ciType)
+				}
+			}
+		}
+		for _, ciType := range readyList {
+			doneMap[ciType] = true
+			sortList = append(sortList, ciType)
+		}
+	}
+	return
+}
+
+func checkCiTypeAutofill(ciType string, attrs []*models.SysCiTypeAttrTable, param *models.AutofillCheckParam, nowTime string, ciTypeResult *models.AutofillCheckCiTypeObj, result *models.AutofillCheckResult) (err error) {
+	var multiRefColumn []string
+	var checkAttrs, multiRefAutofillAttrs []*models.SysCiTypeAttrTable
+	for _, attr := range attrs {
+		if attr.AutofillAble == "yes" && attr.AutofillType != "suggest" {
+			ciTypeResult.Attrs = append(ciTypeResult.Attrs, attr.Name)
+		}
+		if attr.InputType == models.MultiRefType {
+			multiRefColumn = append(multiRefColumn, attr.Name)
+			// 多选引用的值在关系表中,按排序后的guid集合单独比较
+			if attr.AutofillAble == "yes" {
+				if attr.AutofillType != "suggest" {
+					multiRefAutofillAttrs = append(multiRefAutofillAttrs, attr)
+				}
+				continue
+			}
+		}
+		checkAttrs = append(checkAttrs, attr)
+	}
+	uniqueIndexColumn := getUniqueIndexColumnMap(ciType, attrs)
+	for startIndex := 0; ; startIndex += autofillCheckPageSize {
+		rowList, queryErr := x.QueryString(fmt.Sprintf("select * from `%s` order by guid limit ?,?", ciType), startIndex, autofillCheckPageSize)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to query ci:%s data fail,%s ", ciType, queryErr.Error())
+			return
+		}
+		if len(rowList) == 0 {
+			break
+		}
+		var guidList []string
+		for _, row := range rowList {
+			guidList = append(guidList, row["guid"])
+		}
+		for _, column := range multiRefColumn {
+			multiRefData, tmpErr := queryMultiRefMapData(ciType, column, guidList)
+			if tmpErr != nil {
+				err = tmpErr
+				return
+			}
+			for _, row := range rowList {
+				row[column] = strings.Join(multiRefData[row["guid"]], ",")
+			}
+		}
+		for _, row := range rowList {
+			ciTypeResult.RowCount += 1
+			rowGuid := row["guid"]
+			updateColumnList, updateColumn, oldValueMap := buildAutofillRowUpdate(rowGuid, checkAttrs, row, uniqueIndexColumn)
+			var multiRefUpdateAttrs []*models.SysCiTypeAttrTable
+			for _, attr := range multiRefAutofillAttrs {
+				changed, oldValue, buildErr := buildAutofillMultiRefUpdate(attr, row)
+				if buildErr != nil {
+					log.Error(nil, log.LOGGER_APP, "Try to check multiRef autofill data fail,build value error", zap.String("guid", rowGuid), zap.String("attr", attr.Name), zap.Error(buildErr))
+					continue
+				}
+				if changed {
+					multiRefUpdateAttrs = append(multiRefUpdateAttrs, attr)
+					updateColumn = append(updateColumn, attr.Name)
+					oldValueMap[attr.Name] = oldValue
+				}
+			}
+			if len(updateColumn) == 0 {
+				continue
+			}
+			ciTypeResult.DiffRowCount += 1
+			fixed := false
+			if param.Fix {
+				fixActions := getAutofillUpdateActions(ciType, rowGuid, nowTime, autofillFixHistoryAction, updateColumnList, row, multiRefColumn)
+				for _, attr := range multiRefUpdateAttrs {
+					multiRefActions, _, buildErr := buildMultiRefActions(&models.BuildAttrValueParam{NowTime: nowTime, AttributeConfig: attr, Action: "update",
+						InputData: models.CiDataMapObj{"guid": rowGuid, attr.Name: row[attr.Name]}, NowData: models.CiDataMapObj{attr.Name: oldValueMap[attr.Name]}})
+					if buildErr != nil {
+						err = buildErr
+						return
+					}
+					fixActions = append(fixActions, multiRefActions...)
+				}
+				// 检查单个ci类型时,修正后的值还需要传递给依赖它的下游数据,待处理项与修正在同一个事务里写入
+				var workItemActions []*execAction
+				if param.CiType != "" {
+					if workItemActions, err = buildWorkItemActions(buildAutofillChainWorkItem(map[string][]*models.AutofillChainObj{ciType: {{Guid: rowGuid, UpdateColumn: updateColumn}}})); err != nil {
+						return
+					}
+					fixActions = append(fixActions, workItemActions...)
+				}
+				if tmpErr := transaction(fixActions); tmpErr != nil {
+					log.Error(nil, log.LOGGER_APP, "Try to fix autofill data fail", zap.String("guid", rowGuid), zap.Error(tmpErr))
+				} else {
+					fixed = true
+					ciTypeResult.FixedRowCount += 1
+					notifyWorkQueue(len(workItemActions))
+				}
+			}
+			for _, column := range updateColumn {
+				if len(result.Diffs) >= autofillCheckMaxDiff {
+					result.DiffTruncated = true
+					break
+				}
+				result.Diffs = append(result.Diffs, &models.AutofillCheckDiffObj{CiType: ciType, Guid: rowGuid, KeyName: row["key_name"], Attr: column, StoredValue: oldValueMap[column], ComputedValue: row[column], Fixed: fixed})
+			}
+		}
+		if len(rowList) < autofillCheckPageSize {
+			break
+		}
+	}
+	return
+}
+
+// buildAutofillMultiRefUpdate 多选引用的自动填充值与关系表中的值按排序后的guid集合比较,不同时把计算值写回nowData
+func buildAutofillMultiRefUpdate(attr *models.SysCiTypeAttrTable, nowData map[string]string) (changed bool, oldValue string, err error) {
+	autofillValueList, err := buildAutofillValue(nowData, attr.AutofillRule, attr.InputType)
+	if err != nil {
+		return
+	}
+	computedValue := strings.Join(sortAutofillGuidList(autofillValueList), ",")
+	oldValue = strings.Join(sortAutofillGuidList([]string{nowData[attr.Name]}), ",")
+	if computedValue != oldValue {
+		changed = true
+		nowData[attr.Name] = computedValue
+	}
+	return
+}
+
+func sortAutofillGuidList(valueList []string) (guidList []string) {
+	existMap := make(map[string]bool)
+	for _, value := range valueList {
+		for _, v := range strings.Split(strings.Trim(value, "[]"), ",") {
+			v = strings.Trim(strings.TrimSpace(v), "\"")
+			if v == "" || v == specialNullChar || existMap[v] {
+				continue
+			}
+			existMap[v] = true
+			guidList = append(guidList, v)
+		}
+	}
+	sort.Strings(guidList)
+	return
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+)
+
+// autofillTrace 记录自动填充规则计算过程,为nil时所有方法不做任何事
+type autofillTrace struct {
+	depth    int
+	current  *models.AutofillExplainSegmentObj
+	segments []*models.AutofillExplainSegmentObj
+}
+
+// enter 进入一层规则计算,返回的函数在退出时恢复上一层的当前段
+func (t *autofillTrace) enter() func() {
+	if t == nil {
+		return func() {}
+	}
+	parent := t.current
+	t.depth++
+	return func() {
+		t.depth--
+		t.current = parent
+	}
+}
+
+func (t *autofillTrace) startSegment(index int, ruleObj *models.AutofillObj) *models.AutofillExplainSegmentObj {
+	if t == nil {
+		return nil
+	}
+	segment := models.AutofillExplainSegmentObj{Depth: t.depth - 1, Index: index, Type: ruleObj.Type, Value: ruleObj.Value, Queries: []*models.AutofillExplainSqlObj{}, ValueList: []string{}}
+	t.segments = append(t.segments, &segment)
+	t.current = &segment
+	return &segment
+}
+
+func (t *autofillTrace) finishSegment(segment *models.AutofillExplainSegmentObj, valueList []string, err error) {
+	if t == nil || segment == nil {
+		return
+	}
+	if valueList != nil {
+		segment.ValueList = valueList
+	}
+	if err != nil {
+		segment.ErrorMsg = err.Error()
+	}
+	t.current = segment
+}
+
+func (t *autofillTrace) addQuery(sql string, params []interface{}, rowCount int, err error) {
+	if t == nil || t.current == nil {
+		return
+	}
+	query := models.AutofillExplainSqlObj{Sql: sql, Params: params, RowCount: rowCount}
+	if err != nil {
+		query.ErrorMsg = err.Error()
+	}
+	t.current.Queries = append(t.current.Queries, &query)
+}
+
+// ExplainCiAttrAutofill 按行数据逐段计算自动填充规则,返回每段的sql与取值,规则计算出错时错误放在errorMsg中返回
+// 指定guid时需要有该行的查询权限,返回的行数据中密码和敏感属性脱敏
+func ExplainCiAttrAutofill(ciType, ciAttrId string, param *models.AutofillExplainParam, roles []string) (result *models.AutofillExplainResult, err error) {
+	attr, err := GetCiAttrById(ciAttrId)
+	if err != nil {
+		return
+	}
+	if attr.CiType != ciType {
+		err = fmt.Errorf("Attribute:%s is not belong to ciType:%s ", ciAttrId, ciType)
+		return
+	}
+	rule := attr.AutofillRule
+	if param.Rule != "" {
+		if err = ValidateAutoFillRuleList(param.Rule); err != nil {
+			err = fmt.Errorf("Autofill rule illegal,%s ", err.Error())
+			return
+		}
+		rule = param.Rule
+	}
+	if rule == "" {
+		err = fmt.Errorf("Attribute:%s has no autofill rule ", ciAttrId)
+		return
+	}
+	if param.Guid == "" && len(param.InputData) == 0 {
+		err = fmt.Errorf("Param guid and inputData can not both empty ")
+		return
+	}
+	rowData := make(map[string]string)
+	if param.Guid != "" {
+		if err = checkCiDataQueryPermission(roles, ciType, param.Guid); err != nil {
+			return
+		}
+		if rowData, err = getCiRowWithMultiRefData(ciType, param.Guid); err != nil {
+			return
+		}
+	}
+	for k, v := range param.InputData {
+		rowData[k] = v
+	}
+	sensitiveAttrs, err := getCiTypeSensitiveAttrs(ciType)
+	if err != nil {
+		return
+	}
+	displayRowData := make(map[string]string)
+	for k, v := range rowData {
+		displayRowData[k] = v
+	}
+	for _, attrName := range sensitiveAttrs {
+		if _, b := displayRowData[attrName]; b {
+			displayRowData[attrName] = models.PasswordDisplay
+		}
+	}
+	result = &models.AutofillExplainResult{CiType: ciType, Attr: attr.Name, InputType: attr.InputType, Rule: rule, RowData: displayRowData, ValueList: []string{}}
+	trace := autofillTrace{}
+	valueList, buildErr := buildAutofillValueWithTrace(rowData, rule, attr.InputType, &trace)
+	result.Segments = trace.segments
+	if result.Segments == nil {
+		result.Segments = []*models.AutofillExplainSegmentObj{}
+	}
+	if buildErr != nil {
+		result.ErrorMsg = buildErr.Error()
+		return
+	}
+	if valueList != nil {
+		result.ValueList = valueList
+	}
+	result.FinalValue = getAutofillValueString(valueList, attr.InputType)
+	if attr.InputType == models.PasswordInputType || attr.Sensitive == "yes" {
+		result.ValueList = []string{models.PasswordDisplay}
+		result.FinalValue = models.PasswordDisplay
+	}
+	return
+}
+
+// checkCiDataQueryPermission 检查角色是否有指定数据行的查询权限
+func checkCiDataQueryPermission(roles []string, ciType, rowGuid string) (err error) {
+	legalAll, legalGuidList, err := ValidateCiDataPermission(roles, ciType, "", models.DataActionQuery)
+	if err != nil || legalAll {
+		return
+	}
+	for _, v := range legalGuidList {
+		if v == rowGuid {
+			return
+		}
+	}
+	err = fmt.Errorf("Ci data:%s query permission deny ", rowGuid)
+	return
+}
+
+func getCiRowWithMultiRefData(ciType, guid string) (rowData map[string]string, err error) {
+	rowList, queryErr := x.QueryString(fmt.Sprintf("select * from `%s` where guid=?", ciType), guid)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query ci data fail,%s ", queryErr.Error())
+		return
+	}
+	if len(rowList) == 0 {
+		err = fmt.Errorf("Can not find ci data with guid:%s ", guid)
+		return
+	}
+	rowData = rowList[0]
+	var multiRefAttrs []*models.SysCiTypeAttrTable
+	err = x.SQL("select name from sys_ci_type_attr where ci_type=? and input_type=? and status='created'", ciType, models.MultiRefType).Find(&multiRefAttrs)
+	if err != nil {
+		err = fmt.Errorf("Try to query multiRef attributes fail,%s ", err.Error())
+		return
+	}
+	for _, attr := range multiRefAttrs {
+		multiRefData, tmpErr := queryMultiRefMapData(ciType, attr.Name, []string{guid})
+		if tmpErr != nil {
+			err = tmpErr
+			return
+		}
+		rowData[attr.Name] = strings.Join(multiRefData[guid], ",")
+	}
+	return
+}
@@ -30,7 +30,10 @@ func BaseKeyCatCreate(input models.SysBaseKeyCatTable) error {
 
 func BaseKeyCodeQuery(param *models.QueryRequestParam) (pageInfo models.PageInfo, rowData []*models.SysBaseKeyCodeTable, err error) {
 	rowData = []*models.SysBaseKeyCodeTable{}
-	filterSql, queryColumn, queryParam := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysBaseKeyCodeTable{}})
+	filterSql, queryColumn, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysBaseKeyCodeTable{}})
+	if err != nil {
+		return
+	}
 	baseSql := fmt.Sprintf("SELECT %s FROM sys_basekey_code WHERE 1=1 %s ", queryColumn, filterSql)
 	if param.Paging {
 		pageInfo.StartIndex = param.Pageable.StartIndex
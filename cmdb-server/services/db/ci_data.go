@@ -194,6 +194,8 @@ func HandleCiDataOperation(param models.HandleCiDataParam) (outputData []models.
 	var autofillChainMap = make(map[string][]*models.AutofillChainObj)
 	var uniquePathList []*models.AutoActiveHandleParam
 	deleteUniquePath := models.AutoActiveHandleParam{User: models.SystemUser}
+	var webhookRowList []*models.WebhookRowObj
+	var guardFailList []string
 	for _, ciObj := range multiCiData {
 		for i, inputRowData := range ciObj.InputData {
 			actionParam := models.ActionFuncParam{CiType: ciObj.CiTypeId, InputData: inputRowData, Attributes: ciObj.Attributes, ReferenceAttributes: ciObj.ReferenceAttributes, Operator: param.Operator, Operation: param.Operation, NowTime: tNow, RefCiTypeMap: ciObj.RefCiTypeMap, DeleteList: deleteList, FromCore: param.FromCore, FromSync: param.FromSync}
@@ -244,6 +246,24 @@ func HandleCiDataOperation(param models.HandleCiDataParam) (outputData []models.
 					break
 				}
 			}
+			// 检查状态转换的守卫条件,收集所有行的失败原因后统一返回
+			if param.BareAction == "" && !param.FromSync {
+				tmpGuardFailList, tmpErr := checkTransitionGuard(&actionParam)
+				if tmpErr != nil {
+					err = tmpErr
+					break
+				}
+				if len(tmpGuardFailList) > 0 {
+					tmpRowKeyName := inputRowData["key_name"]
+					if tmpRowKeyName == "" {
+						tmpRowKeyName = actionParam.NowData["key_name"]
+					}
+					guardFailList = append(guardFailList, fmt.Sprintf("CiType:%s Row:%s operation:%s -> %s", ciObj.CiTypeId, tmpRowKeyName, param.Operation, strings.Join(tmpGuardFailList, "; ")))
+				}
+			}
+			if len(guardFailList) > 0 {
+				continue
+			}
 			//
 			if firstAction == "confirm" {
 				// 检查ciObj.InputData的guid是否在历史记录中，并且status为confirmed
@@ -262,6 +282,7 @@ func HandleCiDataOperation(param models.HandleCiDataParam) (outputData []models.
 					}
 				}
 			}
+			auditOldData, auditInputData := copyAuditRowData(actionParam.NowData), copyAuditRowData(inputRowData)
 			// 处理输入,把参数变成对应的SQL加进事务里
 			tmpAction, tmpErr := doActionFunc(&actionParam)
 			if tmpErr != nil {
@@ -277,6 +298,9 @@ func HandleCiDataOperation(param models.HandleCiDataParam) (outputData []models.
 				outputData = mergeCiData(outputData, ciObj)
 			}
 			actions = append(actions, tmpAction...)
+			if !param.OnlyQuery {
+				actions = append(actions, buildCiDataAuditAction(&actionParam, auditOldData, auditInputData))
+			}
 			if actionParam.Transition.Action == "insert" && param.Permission {
 				if _, b := insertPermissionMap[ciObj.CiTypeId]; b {
 					insertPermissionMap[ciObj.CiTypeId].GuidList = append(insertPermissionMap[ciObj.CiTypeId].GuidList, actionParam.InputData["guid"])
@@ -305,6 +329,7 @@ func HandleCiDataOperation(param models.HandleCiDataParam) (outputData []models.
 					actions = append(actions, tmpAction...)
 				}
 			}
+			webhookRowList = append(webhookRowList, buildWebhookRowObj(ciObj, inputRowData["guid"], actionParam.Transition.Action, actionParam.NowData))
 			if actionParam.Transition.TargetUniquePath == "yes" {
 				log.Info(nil, log.LOGGER_APP, "Unique path trigger", zap.String("data", actionParam.InputData["guid"]))
 				uniqueTransition, tmpErr := getUniquePathNextOperation(actionParam.Transition.TargetState)
@@ -337,6 +362,9 @@ func HandleCiDataOperation(param models.HandleCiDataParam) (outputData []models.
 			break
 		}
 	}
+	if err == nil && len(guardFailList) > 0 {
+		err = fmt.Errorf("Transition guard check fail:\n%s ", strings.Join(guardFailList, "\n"))
+	}
 	if err == nil {
 		if len(insertPermissionMap) > 0 {
 			err = ValidateInsertPermission(insertPermissionMap, param.Roles)
@@ -345,19 +373,36 @@ func HandleCiDataOperation(param models.HandleCiDataParam) (outputData []models.
 			}
 		}
 		if !param.OnlyQuery {
-			err = transaction(actions)
+			webhookActions, webhookErr := buildWebhookOutboxActions(webhookRowList, param.Operation, param.Operator, tNow)
+			if webhookErr != nil {
+				err = webhookErr
+				return
+			}
+			actions = append(actions, webhookActions...)
+			// 自动填充链与唯一路径的待处理项与数据在同一个事务里写入
+			if len(deleteUniquePath.Data) > 0 {
+				uniquePathList = append(uniquePathList, &deleteUniquePath)
+			}
+			workItemActions, workItemErr := buildWorkItemActions(append(buildAutofillChainWorkItem(autofillChainMap), buildUniquePathWorkItem(uniquePathList)...))
+			if workItemErr != nil {
+				err = workItemErr
+				return
+			}
+			actions = append(actions, workItemActions...)
+			var handleCiTypeList []string
+			for _, ciObj := range multiCiData {
+				handleCiTypeList = append(handleCiTypeList, ciObj.CiTypeId)
+			}
+			err = transUniqueIndexError(handleCiTypeList, transaction(actions))
+			if err == nil {
+				if len(webhookActions) > 0 {
+					notifyWebhookOutbox(0)
+				}
+				notifyWorkQueue(len(workItemActions))
+			}
 		}
 	}
 	if err == nil && !param.OnlyQuery {
-		if len(autofillChainMap) > 0 {
-			affectGuidListChan <- autofillChainMap
-		}
-		if len(deleteUniquePath.Data) > 0 {
-			uniquePathList = append(uniquePathList, &deleteUniquePath)
-		}
-		if len(uniquePathList) > 0 {
-			uniquePathHandleChan <- uniquePathList
-		}
 		if firstAction == "insert" {
 			outputData, err = fetchNewRowData(multiCiData)
 		}
@@ -470,6 +515,10 @@ func insertActionFunc(param *models.ActionFuncParam) (result []*execAction, err
 		if !buildValueParam.IsSystem {
 			param.InputData[ciAttr.Name] = tmpColumn.ValueString
 		}
+		if tmpColumn.ValueString == "" && param.MultiCiData != nil && param.MultiCiData.UniqueIndexColumn[ciAttr.Name] {
+			// 唯一索引列的空值存成NULL
+			tmpColumn.ColumnValue = "reset_null^"
+		}
 		columnList = append(columnList, tmpColumn)
 	}
 	for _, multiRefColumn := range multiRefColumnList {
@@ -595,6 +644,10 @@ func updateActionFunc(param *models.ActionFuncParam) (result []*execAction, err
 		if !buildValueParam.IsSystem {
 			param.InputData[ciAttr.Name] = tmpColumn.ValueString
 		}
+		if tmpColumn.ValueString == "" && param.MultiCiData != nil && param.MultiCiData.UniqueIndexColumn[ciAttr.Name] {
+			// 唯一索引列的空值存成NULL
+			tmpColumn.ColumnValue = "reset_null^"
+		}
 		columnList = append(columnList, tmpColumn)
 		//if param.InputData[ciAttr.Name] != param.NowData[ciAttr.Name] {
 		//	param.UpdateColumn = append(param.UpdateColumn, ciAttr.Name)
@@ -746,10 +799,10 @@ func executeActionFunc(param *models.ActionFuncParam) (result []*execAction, err
 	return
 }
 
-func autofillAffectActionFunc(ciTypeId, guid, nowTime string) {
+func autofillAffectActionFunc(ciTypeId, guid, nowTime string) (err error) {
 	// get attribute
 	var attrTable []*models.SysCiTypeAttrTable
-	err := x.SQL("select * from sys_ci_type_attr where ci_type=?", ciTypeId).Find(&attrTable)
+	err = x.SQL("select * from sys_ci_type_attr where ci_type=?", ciTypeId).Find(&attrTable)
 	if err != nil {
 		log.Error(nil, log.LOGGER_APP, "Try to auto refresh autofill,get attributes data fail", zap.String("ciTypeId", ciTypeId), zap.Error(err))
 		return
@@ -772,8 +825,8 @@ func autofillAffectActionFunc(ciTypeId, guid, nowTime string) {
 	// buildAutofillValue
 	nowData := nowDataList[0]
 	log.Info(nil, log.LOGGER_APP, "autofill now data", log.JsonObj("nowData", nowData))
-	var updateColumnList []*models.CiDataColumnObj
-	var multiRefColumn, updateColumn []string
+	var multiRefColumn []string
+	uniqueIndexColumn := getUniqueIndexColumnMap(ciTypeId, attrTable)
 	for _, attr := range attrTable {
 		if attr.InputType == models.MultiRefType {
 			multiRefData, tmpErr := queryMultiRefMapData(ciTypeId, attr.Name, []string{guid})
@@ -793,6 +846,30 @@ func autofillAffectActionFunc(ciTypeId, guid, nowTime string) {
 			}
 		}
 	}
+	updateColumnList, updateColumn, _ := buildAutofillRowUpdate(guid, attrTable, nowData, uniqueIndexColumn)
+	if len(updateColumnList) == 0 {
+		log.Warn(nil, log.LOGGER_APP, "Try to auto refresh autofill data break,no column in update list", zap.String("guid", guid))
+		return
+	}
+	actions := getAutofillUpdateActions(ciTypeId, guid, nowTime, "autofill", updateColumnList, nowData, multiRefColumn)
+	workItemActions, err := buildWorkItemActions(buildAutofillChainWorkItem(map[string][]*models.AutofillChainObj{ciTypeId: {{Guid: guid, UpdateColumn: updateColumn}}}))
+	if err != nil {
+		return
+	}
+	actions = append(actions, workItemActions...)
+	err = transaction(actions)
+	if err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to auto refresh autofill data,update database fail", zap.Error(err))
+	} else {
+		log.Info(nil, log.LOGGER_APP, "Refresh autofill data success", zap.String("guid", guid))
+		notifyWorkQueue(len(workItemActions))
+	}
+	return
+}
+
+// buildAutofillRowUpdate 按属性顺序重新计算一行数据的自动填充属性,返回与现有值不同的属性及其原值
+func buildAutofillRowUpdate(guid string, attrTable []*models.SysCiTypeAttrTable, nowData map[string]string, uniqueIndexColumn map[string]bool) (updateColumnList []*models.CiDataColumnObj, updateColumn []string, oldValueMap map[string]string) {
+	oldValueMap = make(map[string]string)
 	for _, attr := range attrTable {
 		if attr.DataType == "datetime" && nowData[attr.Name] == "" {
 			delete(nowData, attr.Name)
@@ -802,42 +879,38 @@ func autofillAffectActionFunc(ciTypeId, guid, nowTime string) {
 		}
 		autofillValueList, tmpErr := buildAutofillValue(nowData, attr.AutofillRule, attr.InputType)
 		if tmpErr != nil {
-			log.Error(nil, log.LOGGER_APP, "Try to auto refresh autofill data fail,build value error", zap.String("guid", guid), zap.String("attr", attr.Name), zap.Error(err))
+			log.Error(nil, log.LOGGER_APP, "Try to auto refresh autofill data fail,build value error", zap.String("guid", guid), zap.String("attr", attr.Name), zap.Error(tmpErr))
 			continue
 		}
 		afterAutoBuildData := getAutofillValueString(autofillValueList, attr.InputType)
 		if afterAutoBuildData != nowData[attr.Name] {
 			updateColumn = append(updateColumn, attr.Name)
-			nowData[attr.Name] = getAutofillValueString(autofillValueList, attr.InputType)
-			updateColumnList = append(updateColumnList, &models.CiDataColumnObj{ColumnName: attr.Name, ColumnValue: nowData[attr.Name]})
+			oldValueMap[attr.Name] = nowData[attr.Name]
+			nowData[attr.Name] = afterAutoBuildData
+			if nowData[attr.Name] == "" && uniqueIndexColumn[attr.Name] {
+				updateColumnList = append(updateColumnList, &models.CiDataColumnObj{ColumnName: attr.Name, ColumnValue: "reset_null^"})
+			} else {
+				updateColumnList = append(updateColumnList, &models.CiDataColumnObj{ColumnName: attr.Name, ColumnValue: nowData[attr.Name]})
+			}
 		}
 	}
-	if len(updateColumnList) == 0 {
-		log.Warn(nil, log.LOGGER_APP, "Try to auto refresh autofill data break,no column in update list", zap.String("guid", guid))
-		return
-	}
+	return
+}
+
+// getAutofillUpdateActions 更新自动填充属性并写入历史,historyAction区分自动刷新与一致性检查修复
+func getAutofillUpdateActions(ciTypeId, guid, nowTime, historyAction string, updateColumnList []*models.CiDataColumnObj, nowData map[string]string, multiRefColumn []string) (actions []*execAction) {
 	updateColumnList = append(updateColumnList, &models.CiDataColumnObj{ColumnName: "update_time", ColumnValue: nowTime})
-	// update now && insert history
-	var actions []*execAction
 	actions = append(actions, getUpdateActionByColumnList(updateColumnList, ciTypeId, guid))
-	isConfirm := "no"
-	//if nowData["history_state_confirmed"] == "1" {
-	//	isConfirm = "yes"
-	//}
-	for _, col := range multiRefColumn {
-		delete(nowData, col)
+	historyData := make(models.CiDataMapObj)
+	for k, v := range nowData {
+		historyData[k] = v
 	}
-	nowData["update_time"] = nowTime
-	actions = append(actions, getHistoryActionByData(nowData, ciTypeId, nowTime, &models.SysStateTransitionQuery{Action: "autofill", TargetIsConfirm: isConfirm}))
-	err = transaction(actions)
-	if err != nil {
-		log.Error(nil, log.LOGGER_APP, "Try to auto refresh autofill data,update database fail", zap.Error(err))
-	} else {
-		log.Info(nil, log.LOGGER_APP, "Refresh autofill data success", zap.String("guid", guid))
-		var autofillChainMap = make(map[string][]*models.AutofillChainObj)
-		autofillChainMap[ciTypeId] = []*models.AutofillChainObj{&models.AutofillChainObj{Guid: guid, UpdateColumn: updateColumn}}
-		affectGuidListChan <- autofillChainMap
+	for _, col := range multiRefColumn {
+		delete(historyData, col)
 	}
+	historyData["update_time"] = nowTime
+	actions = append(actions, getHistoryActionByData(historyData, ciTypeId, nowTime, &models.SysStateTransitionQuery{Action: historyAction, TargetIsConfirm: "no"}))
+	return
 }
 
 func buildAttrValue(param *models.BuildAttrValueParam) (result *models.CiDataColumnObj, multiRefAction []*execAction, deleteGuidList []string, err error) {
@@ -982,11 +1055,11 @@ func buildAttrValue(param *models.BuildAttrValueParam) (result *models.CiDataCol
 	return
 }
 
-func getCiRowDataByGuid(ciTypeId string, rowGuidList []string, filters []*models.AutofillFilterObj, inputType string, startRowData map[string]string) (rowMapList []map[string]string, err error) {
+func getCiRowDataByGuid(ciTypeId string, rowGuidList []string, filters []*models.AutofillFilterObj, inputType string, startRowData map[string]string, trace *autofillTrace) (rowMapList []map[string]string, err error) {
 	var filterSqlList []string
 	for _, f := range filters {
 		f.CiType = ciTypeId
-		tmpSql, tmpErr := getFilterSql(f, "", inputType, startRowData)
+		tmpSql, tmpErr := getFilterSql(f, "", inputType, startRowData, trace)
 		if tmpErr != nil {
 			err = fmt.Errorf("Get filter:%s sql error:%s ", f, tmpErr.Error())
 			break
@@ -998,17 +1071,18 @@ func getCiRowDataByGuid(ciTypeId string, rowGuidList []string, filters []*models
 		sql += " AND " + strings.Join(filterSqlList, " AND ")
 	}
 	rowMapList, err = x.QueryString(sql)
+	trace.addQuery(sql, nil, len(rowMapList), err)
 	if err != nil {
 		log.Error(nil, log.LOGGER_APP, "Get ci row data by guid list error", zap.Error(err))
 	}
 	return
 }
 
-func getMultiRefRowData(ciTypeId, attrName, refCiTypeId string, rowGuidList []string, filters []*models.AutofillFilterObj, inputType string, startRowData map[string]string) (rowMapList []map[string]string, err error) {
+func getMultiRefRowData(ciTypeId, attrName, refCiTypeId string, rowGuidList []string, filters []*models.AutofillFilterObj, inputType string, startRowData map[string]string, trace *autofillTrace) (rowMapList []map[string]string, err error) {
 	var filterSqlList []string
 	for _, f := range filters {
 		f.CiType = ciTypeId
-		tmpSql, tmpErr := getFilterSql(f, "t2", inputType, startRowData)
+		tmpSql, tmpErr := getFilterSql(f, "t2", inputType, startRowData, trace)
 		if tmpErr != nil {
 			err = fmt.Errorf("Get filter:%s sql error:%s ", f, tmpErr.Error())
 			break
@@ -1020,6 +1094,7 @@ func getMultiRefRowData(ciTypeId, attrName, refCiTypeId string, rowGuidList []st
 		sql += " AND " + strings.Join(filterSqlList, " AND ")
 	}
 	rowMapList, err = x.QueryString(sql)
+	trace.addQuery(sql, nil, len(rowMapList), err)
 	if err != nil {
 		log.Error(nil, log.LOGGER_APP, "Get ci row data by guid list error", zap.Error(err))
 	}
@@ -1291,6 +1366,7 @@ func GetMultiCiAttributes(multiCiData []*models.MultiCiDataObj) error {
 			break
 		}
 		ciDataObj.Attributes = newAttrs
+		ciDataObj.UniqueIndexColumn = getUniqueIndexColumnMap(ciDataObj.CiTypeId, newAttrs)
 	}
 	if err != nil {
 		return err
@@ -1864,11 +1940,17 @@ func buildMultiRefActions(param *models.BuildAttrValueParam) (actions []*execAct
 	if param.Action == "insert" && inputValue == "" {
 		return
 	}
-	valueList, transInputValueErr := transStringValueToList(inputValue)
+	inputValueList, transInputValueErr := transStringValueToList(inputValue)
 	if transInputValueErr != nil {
 		err = transInputValueErr
 		return
 	}
+	valueList := []string{}
+	for _, v := range inputValueList {
+		if v != "" {
+			valueList = append(valueList, v)
+		}
+	}
 	if len(param.NowData) > 0 {
 		if nowValueList, _ := transStringValueToList(param.NowData[param.AttributeConfig.Name]); len(nowValueList) > 0 {
 			for _, nowItem := range nowValueList {
@@ -1893,6 +1975,11 @@ func buildMultiRefActions(param *models.BuildAttrValueParam) (actions []*execAct
 	tableName := fmt.Sprintf("%s$%s", param.AttributeConfig.CiType, param.AttributeConfig.Name)
 	actions = append(actions, &execAction{Sql: fmt.Sprintf("delete from `%s` where from_guid=?", tableName), Param: []interface{}{rowGuid}})
 	if len(valueList) == 0 {
+		// 清空时写一条to_guid为空的历史记录,asOf查询取到这一组时返回空集合
+		if len(param.NowData) > 0 && param.Action != "delete" {
+			actions = append(actions, &execAction{Sql: fmt.Sprintf("insert into `%s%s`(from_guid,to_guid,seq_no,history_to_id,history_time) value (?,'',0,0,?)", HistoryTablePrefix, tableName),
+				Param: []interface{}{rowGuid, param.NowTime}})
+		}
 		return
 	}
 	for i, to := range valueList {
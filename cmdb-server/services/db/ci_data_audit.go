@@ -0,0 +1,186 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+)
+
+const (
+	auditDefaultPageSize = 100
+	auditKeyNameMaxLen   = 255
+)
+
+// auditIgnoreColumns 系统维护的时间与人员字段不记入审计变更
+var auditIgnoreColumns = map[string]bool{"guid": true, "create_time": true, "create_user": true, "update_time": true, "update_user": true, "confirm_time": true}
+
+func copyAuditRowData(input map[string]string) map[string]string {
+	output := make(map[string]string)
+	for k, v := range input {
+		output[k] = v
+	}
+	return output
+}
+
+// buildCiDataAuditAction 生成一行数据的审计日志,与数据修改放在同一个事务中
+// oldData与inputData是执行action前的现有数据与输入数据,action执行时会改写这两份数据
+func buildCiDataAuditAction(param *models.ActionFuncParam, oldData, inputData map[string]string) *execAction {
+	action := param.Transition.Action
+	var newData map[string]string
+	switch action {
+	case models.DataActionInsert, models.DataActionUpdate:
+		newData = param.InputData
+	case models.DataActionDelete:
+		newData = map[string]string{}
+	default:
+		newData = param.NowData
+	}
+	content := models.CiDataAuditContent{Action: action, SourceState: oldData["state"], TargetState: param.Transition.TargetStateName, Changes: []*models.CiDataAuditChangeObj{}}
+	for _, attr := range param.Attributes {
+		if auditIgnoreColumns[attr.Name] {
+			continue
+		}
+		newValue, b := newData[attr.Name]
+		if !b && action != models.DataActionDelete {
+			if attr.InputType != models.MultiRefType {
+				continue
+			}
+			// 多选引用处理完后会从输入数据中移除,取原始输入
+			if newValue, b = inputData[attr.Name]; !b {
+				continue
+			}
+		}
+		oldValue, newValue := trimAuditValue(oldData[attr.Name]), trimAuditValue(newValue)
+		if attr.InputType == models.MultiRefType {
+			oldValue, newValue = normalizeAuditMultiRefValue(oldValue), normalizeAuditMultiRefValue(newValue)
+		}
+		if oldValue == newValue {
+			continue
+		}
+		change := models.CiDataAuditChangeObj{Attr: attr.Name, OldValue: oldValue, NewValue: newValue}
+		if attr.InputType == models.PasswordInputType || attr.Sensitive == "yes" {
+			change.Masked = true
+			if oldValue != "" {
+				change.OldValue = models.PasswordDisplay
+			}
+			if newValue != "" {
+				change.NewValue = models.PasswordDisplay
+			}
+		}
+		content.Changes = append(content.Changes, &change)
+	}
+	keyName := newData["key_name"]
+	if keyName == "" {
+		keyName = oldData["key_name"]
+	}
+	if keyNameRunes := []rune(keyName); len(keyNameRunes) > auditKeyNameMaxLen {
+		keyName = string(keyNameRunes[:auditKeyNameMaxLen])
+	}
+	operation := param.Operation
+	if operation == "" {
+		operation = action
+	}
+	operator := param.Operator
+	if operator == "" {
+		operator = models.SystemUser
+	}
+	contentBytes, _ := json.Marshal(content)
+	return &execAction{Sql: "insert into sys_log(log_cat,operator,operation,content,request_url,client_host,created_date,data_ci_type,data_guid,data_key_name) values (?,?,?,?,?,?,?,?,?,?)",
+		Param: []interface{}{models.AuditLogCat, operator, operation, string(contentBytes), "", "", param.NowTime, param.CiType, param.InputData["guid"], keyName}}
+}
+
+func trimAuditValue(value string) string {
+	if value == "reset_null^" || value == "0000-00-00 00:00:00" {
+		return ""
+	}
+	return value
+}
+
+// normalizeAuditMultiRefValue 多选引用的值可能是json列表或逗号分隔,统一成排序后逗号分隔
+func normalizeAuditMultiRefValue(value string) string {
+	if value == "" {
+		return value
+	}
+	var guidList []string
+	if strings.HasPrefix(value, "[") {
+		if err := json.Unmarshal([]byte(value), &guidList); err != nil {
+			return value
+		}
+	} else {
+		guidList = strings.Split(value, ",")
+	}
+	sort.Strings(guidList)
+	return strings.Join(guidList, ",")
+}
+
+// QueryCiDataAudit 按数据guid、ci类型、操作人与时间范围查询数据审计日志,按时间倒序
+func QueryCiDataAudit(param *models.CiDataAuditQueryParam) (pageInfo models.PageInfo, rowData []*models.CiDataAuditObj, err error) {
+	rowData = []*models.CiDataAuditObj{}
+	baseSql := "select id,operator,operation,content,created_date,data_ci_type,data_guid,data_key_name from sys_log where log_cat=?"
+	queryParam := []interface{}{models.AuditLogCat}
+	if param.Guid != "" {
+		baseSql += " and data_guid=?"
+		queryParam = append(queryParam, param.Guid)
+	}
+	if param.CiType != "" {
+		baseSql += " and data_ci_type=?"
+		queryParam = append(queryParam, param.CiType)
+	}
+	if param.GuidLimited {
+		if len(param.LegalGuidList) == 0 {
+			return
+		}
+		guidFilterSql, guidFilterParam := createListParams(param.LegalGuidList, "")
+		baseSql += " and data_guid in (" + guidFilterSql + ")"
+		queryParam = append(queryParam, guidFilterParam...)
+	}
+	if param.Operator != "" {
+		baseSql += " and operator=?"
+		queryParam = append(queryParam, param.Operator)
+	}
+	if param.StartTime != "" {
+		baseSql += " and created_date>=?"
+		queryParam = append(queryParam, param.StartTime)
+	}
+	if param.EndTime != "" {
+		baseSql += " and created_date<=?"
+		queryParam = append(queryParam, param.EndTime)
+	}
+	if param.Pageable == nil {
+		param.Pageable = &models.PageInfo{StartIndex: 0, PageSize: auditDefaultPageSize}
+	}
+	pageInfo.StartIndex = param.Pageable.StartIndex
+	pageInfo.PageSize = param.Pageable.PageSize
+	pageInfo.TotalRows = queryCount(baseSql, queryParam...)
+	baseSql += " order by id desc"
+	pageSql, pageParam := transPageInfoToSQL(*param.Pageable)
+	baseSql += pageSql
+	queryParam = append(queryParam, pageParam...)
+	queryRows, queryErr := x.QueryString(append([]interface{}{baseSql}, queryParam...)...)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query ci data audit log fail,%s ", queryErr.Error())
+		return
+	}
+	for _, row := range queryRows {
+		auditObj := models.CiDataAuditObj{CiType: row["data_ci_type"], Guid: row["data_guid"], KeyName: row["data_key_name"], Operator: row["operator"],
+			Operation: row["operation"], CreatedDate: row["created_date"], Changes: []*models.CiDataAuditChangeObj{}}
+		auditObj.Id, _ = strconv.Atoi(row["id"])
+		var content models.CiDataAuditContent
+		if unmarshalErr := json.Unmarshal([]byte(row["content"]), &content); unmarshalErr != nil {
+			log.Warn(nil, log.LOGGER_APP, "Ci data audit log content illegal", zap.String("id", row["id"]), zap.Error(unmarshalErr))
+		} else {
+			auditObj.Action, auditObj.SourceState, auditObj.TargetState = content.Action, content.SourceState, content.TargetState
+			if content.Changes != nil {
+				auditObj.Changes = content.Changes
+			}
+		}
+		rowData = append(rowData, &auditObj)
+	}
+	return
+}
@@ -0,0 +1,298 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+)
+
+const (
+	ciDataBulkDefaultChunkSize = 200
+	ciDataBulkMaxChunkSize     = 1000
+	ciDataBulkMaxRows          = 50000
+)
+
+type ciDataBulkChunk struct {
+	Item       *models.CiDataBulkItem
+	StartIndex int
+	Rows       []models.CiDataMapObj
+}
+
+// CreateCiDataBulkJob 校验批量操作参数并提交异步任务,任务按分片执行,每个分片一个事务,进度通过批量任务表查询
+func CreateCiDataBulkJob(param *models.CiDataBulkParam, reqContext *models.JobRequestContext) (job *models.SysCiDataBulkJobTable, err error) {
+	if param.Mode == "" {
+		param.Mode = models.CiDataBulkModeChunkAtomic
+	}
+	if param.Mode != models.CiDataBulkModeChunkAtomic && param.Mode != models.CiDataBulkModeBestEffort {
+		err = fmt.Errorf("Bulk mode:%s illegal,must be %s or %s ", param.Mode, models.CiDataBulkModeChunkAtomic, models.CiDataBulkModeBestEffort)
+		return
+	}
+	if param.ChunkSize <= 0 {
+		param.ChunkSize = ciDataBulkDefaultChunkSize
+	} else if param.ChunkSize > ciDataBulkMaxChunkSize {
+		err = fmt.Errorf("Bulk chunk size can not bigger than %d ", ciDataBulkMaxChunkSize)
+		return
+	}
+	totalCount := 0
+	for i, item := range param.Items {
+		if item.CiType == "" || item.Operation == "" {
+			err = fmt.Errorf("Item:%d ciType and operation can not empty ", i)
+			return
+		}
+		opActions, tmpErr := getActionByOperation(item.CiType, item.Operation)
+		if tmpErr != nil {
+			err = fmt.Errorf("Item:%d %s", i, tmpErr.Error())
+			return
+		}
+		item.Action = opActions[0]
+		totalCount += len(item.InputData)
+	}
+	if totalCount == 0 {
+		err = fmt.Errorf("Bulk rows can not empty ")
+		return
+	}
+	if totalCount > ciDataBulkMaxRows {
+		err = fmt.Errorf("Bulk rows:%d can not more than %d ", totalCount, ciDataBulkMaxRows)
+		return
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	job = &models.SysCiDataBulkJobTable{Id: "bulk_" + guid.CreateGuid(), Mode: param.Mode, Status: "running", ChunkSize: param.ChunkSize, TotalCount: totalCount, CreateUser: reqContext.Operator, CreateTime: nowTime, UpdateTime: nowTime}
+	_, err = x.Exec("insert into sys_ci_data_bulk_job(id,mode,status,chunk_size,total_count,done_count,success_count,fail_count,create_user,create_time,update_time) values (?,?,?,?,?,0,0,0,?,?,?)",
+		job.Id, job.Mode, job.Status, job.ChunkSize, job.TotalCount, job.CreateUser, job.CreateTime, job.UpdateTime)
+	if err != nil {
+		err = fmt.Errorf("Try to create bulk job fail,%s ", err.Error())
+		return
+	}
+	sysJob, submitErr := SubmitJob(models.JobTypeCiDataBulk, models.CiDataBulkJobParam{BulkJob: job.Id, Param: param}, reqContext)
+	if submitErr != nil {
+		job.Status, job.ErrorMsg = "fail", submitErr.Error()
+		if saveErr := saveCiDataBulkProgress(job, nil); saveErr != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to save bulk job status fail", zap.String("job", job.Id), zap.Error(saveErr))
+		}
+		err = submitErr
+		return
+	}
+	job.Job = sysJob.Id
+	if _, err = x.Exec("update sys_ci_data_bulk_job set job=? where id=?", job.Job, job.Id); err != nil {
+		err = fmt.Errorf("Try to update bulk job fail,%s ", err.Error())
+	}
+	return
+}
+
+func handleCiDataBulkJob(ctx context.Context, job *models.SysJobTable, reqContext *models.JobRequestContext) (result interface{}, err error) {
+	var param models.CiDataBulkJobParam
+	if err = json.Unmarshal([]byte(job.Param), &param); err != nil {
+		err = fmt.Errorf("Try to json unmarshal job param fail,%s ", err.Error())
+		return
+	}
+	if param.Param == nil {
+		err = fmt.Errorf("Bulk param can not empty ")
+		return
+	}
+	bulkJob, err := GetCiDataBulkJob(param.BulkJob)
+	if err != nil {
+		return
+	}
+	handleParam := models.HandleCiDataParam{Operator: reqContext.Operator, Roles: reqContext.Roles, Permission: true, UserToken: reqContext.UserToken}
+	runCiDataBulkJob(bulkJob, splitCiDataBulkChunk(param.Param), handleParam)
+	result = bulkJob
+	return
+}
+
+func splitCiDataBulkChunk(param *models.CiDataBulkParam) (chunkList []*ciDataBulkChunk) {
+	rowIndex := 0
+	for _, item := range param.Items {
+		for start := 0; start < len(item.InputData); start += param.ChunkSize {
+			end := start + param.ChunkSize
+			if end > len(item.InputData) {
+				end = len(item.InputData)
+			}
+			chunkList = append(chunkList, &ciDataBulkChunk{Item: item, StartIndex: rowIndex + start, Rows: item.InputData[start:end]})
+		}
+		rowIndex += len(item.InputData)
+	}
+	return
+}
+
+func runCiDataBulkJob(job *models.SysCiDataBulkJobTable, chunkList []*ciDataBulkChunk, handleParam models.HandleCiDataParam) {
+	log.Info(nil, log.LOGGER_APP, "start bulk ci data job", zap.String("job", job.Id), zap.Int("total", job.TotalCount))
+	stopFlag := false
+	for _, chunk := range chunkList {
+		var resultList []*models.SysCiDataBulkRowTable
+		if stopFlag {
+			resultList = buildCiDataBulkRowResult(job.Id, chunk, "skip", "skip by previous chunk fail")
+		} else {
+			resultList = handleCiDataBulkChunk(job, chunk, handleParam)
+			for _, result := range resultList {
+				if result.Status == "fail" && job.Mode == models.CiDataBulkModeChunkAtomic {
+					stopFlag = true
+					job.ErrorMsg = result.ErrorMsg
+					break
+				}
+			}
+		}
+		for _, result := range resultList {
+			job.DoneCount += 1
+			if result.Status == "success" {
+				job.SuccessCount += 1
+			} else {
+				job.FailCount += 1
+			}
+		}
+		if err := saveCiDataBulkProgress(job, resultList); err != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to save bulk job progress fail", zap.String("job", job.Id), zap.Error(err))
+		}
+	}
+	if job.FailCount == 0 {
+		job.Status = "success"
+	} else if job.SuccessCount > 0 {
+		job.Status = "partial"
+	} else {
+		job.Status = "fail"
+	}
+	if err := saveCiDataBulkProgress(job, nil); err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to save bulk job status fail", zap.String("job", job.Id), zap.Error(err))
+	}
+	log.Info(nil, log.LOGGER_APP, "bulk ci data job done", zap.String("job", job.Id), zap.String("status", job.Status), zap.Int("success", job.SuccessCount), zap.Int("fail", job.FailCount))
+}
+
+// handleCiDataBulkChunk 一个分片作为一个事务执行,chunk-atomic模式下分片失败后停止,已提交的分片不回滚
+// best-effort模式下分片失败后逐行重试,拿到每一行的错误
+func handleCiDataBulkChunk(job *models.SysCiDataBulkJobTable, chunk *ciDataBulkChunk, handleParam models.HandleCiDataParam) (resultList []*models.SysCiDataBulkRowTable) {
+	inputData := copyCiDataBulkRows(chunk.Rows)
+	if err := doCiDataBulkOperation(chunk.Item, inputData, handleParam); err == nil {
+		resultList = buildCiDataBulkRowResult(job.Id, chunk, "success", "")
+		fillCiDataBulkNewGuid(chunk.Item, resultList, inputData)
+		return
+	} else if job.Mode == models.CiDataBulkModeChunkAtomic || len(chunk.Rows) == 1 {
+		resultList = buildCiDataBulkRowResult(job.Id, chunk, "fail", err.Error())
+		return
+	}
+	for i, row := range chunk.Rows {
+		rowInput := copyCiDataBulkRows([]models.CiDataMapObj{row})
+		rowResult := buildCiDataBulkRowResult(job.Id, &ciDataBulkChunk{Item: chunk.Item, StartIndex: chunk.StartIndex + i, Rows: []models.CiDataMapObj{row}}, "success", "")
+		if err := doCiDataBulkOperation(chunk.Item, rowInput, handleParam); err != nil {
+			rowResult[0].Status = "fail"
+			rowResult[0].ErrorMsg = err.Error()
+		} else {
+			fillCiDataBulkNewGuid(chunk.Item, rowResult, rowInput)
+		}
+		resultList = append(resultList, rowResult...)
+	}
+	return
+}
+
+func doCiDataBulkOperation(item *models.CiDataBulkItem, inputData []models.CiDataMapObj, handleParam models.HandleCiDataParam) (err error) {
+	handleParam.CiTypeId = item.CiType
+	handleParam.Operation = item.Operation
+	handleParam.InputData = inputData
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Handle ci data operation panic:%v ", r)
+		}
+	}()
+	_, _, err = HandleCiDataOperation(handleParam)
+	return
+}
+
+// copyCiDataBulkRows 数据操作会改写输入行(如新增时写入guid),重试时需要使用原始数据
+func copyCiDataBulkRows(rows []models.CiDataMapObj) (output []models.CiDataMapObj) {
+	for _, row := range rows {
+		newRow := make(models.CiDataMapObj)
+		for k, v := range row {
+			newRow[k] = v
+		}
+		output = append(output, newRow)
+	}
+	return
+}
+
+func buildCiDataBulkRowResult(jobId string, chunk *ciDataBulkChunk, status, errorMsg string) (resultList []*models.SysCiDataBulkRowTable) {
+	for i, row := range chunk.Rows {
+		resultList = append(resultList, &models.SysCiDataBulkRowTable{Job: jobId, RowIndex: chunk.StartIndex + i, CiType: chunk.Item.CiType, Operation: chunk.Item.Operation, Guid: row["guid"], Status: status, ErrorMsg: errorMsg})
+	}
+	return
+}
+
+func fillCiDataBulkNewGuid(item *models.CiDataBulkItem, resultList []*models.SysCiDataBulkRowTable, inputData []models.CiDataMapObj) {
+	for i, result := range resultList {
+		if i >= len(inputData) {
+			break
+		}
+		if item.Action == "insert" {
+			result.NewGuid = inputData[i]["guid"]
+		}
+		result.Guid = inputData[i]["guid"]
+	}
+}
+
+func saveCiDataBulkProgress(job *models.SysCiDataBulkJobTable, resultList []*models.SysCiDataBulkRowTable) error {
+	var actions []*execAction
+	for _, result := range resultList {
+		actions = append(actions, &execAction{Sql: "insert into sys_ci_data_bulk_row(job,row_index,ci_type,operation,guid,new_guid,status,error_msg) values (?,?,?,?,?,?,?,?)",
+			Param: []interface{}{result.Job, result.RowIndex, result.CiType, result.Operation, result.Guid, result.NewGuid, result.Status, result.ErrorMsg}})
+	}
+	job.UpdateTime = time.Now().Format(models.DateTimeFormat)
+	actions = append(actions, &execAction{Sql: "update sys_ci_data_bulk_job set status=?,done_count=?,success_count=?,fail_count=?,error_msg=?,update_time=? where id=?",
+		Param: []interface{}{job.Status, job.DoneCount, job.SuccessCount, job.FailCount, job.ErrorMsg, job.UpdateTime, job.Id}})
+	return transaction(actions)
+}
+
+func GetCiDataBulkJob(jobId string) (job *models.SysCiDataBulkJobTable, err error) {
+	var jobRows []*models.SysCiDataBulkJobTable
+	err = x.SQL("select * from sys_ci_data_bulk_job where id=?", jobId).Find(&jobRows)
+	if err != nil {
+		err = fmt.Errorf("Try to query bulk job fail,%s ", err.Error())
+		return
+	}
+	if len(jobRows) == 0 {
+		err = fmt.Errorf("Can not find bulk job:%s ", jobId)
+		return
+	}
+	job = jobRows[0]
+	// 执行任务已结束但批量任务仍为running,说明执行实例中途退出
+	if job.Status == "running" && job.Job != "" {
+		jobStatusRows, queryErr := x.QueryString("select status from sys_job where id=?", job.Job)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to query bulk job status fail,%s ", queryErr.Error())
+			return
+		}
+		if len(jobStatusRows) > 0 && jobStatusRows[0]["status"] != models.JobStatusWait && jobStatusRows[0]["status"] != models.JobStatusRunning {
+			job.Status = "interrupted"
+		}
+	}
+	return
+}
+
+func QueryCiDataBulkRow(jobId string, param *models.QueryRequestParam) (pageInfo models.PageInfo, rowData []*models.SysCiDataBulkRowTable, err error) {
+	rowData = []*models.SysCiDataBulkRowTable{}
+	filterSql, queryColumn, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysCiDataBulkRowTable{}, PrimaryKey: "id"})
+	if err != nil {
+		return
+	}
+	baseSql := fmt.Sprintf("SELECT %s FROM sys_ci_data_bulk_row WHERE job=? %s ", queryColumn, filterSql)
+	queryParam = append([]interface{}{jobId}, queryParam...)
+	if !strings.Contains(strings.ToLower(filterSql), "order by") {
+		baseSql += " ORDER BY row_index "
+	}
+	if param.Paging {
+		pageInfo.StartIndex = param.Pageable.StartIndex
+		pageInfo.PageSize = param.Pageable.PageSize
+		pageInfo.TotalRows = queryCount(baseSql, queryParam...)
+		pageSql, pageParam := transPageInfoToSQL(*param.Pageable)
+		baseSql += pageSql
+		queryParam = append(queryParam, pageParam...)
+	}
+	err = x.SQL(baseSql, queryParam...).Find(&rowData)
+	if err != nil {
+		err = fmt.Errorf("Try to query bulk job rows fail,%s ", err.Error())
+	}
+	return
+}
@@ -0,0 +1,303 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+)
+
+// DataDiff 对比同一条ci数据的两个历史版本,from和to可以是历史id或时间,为空时to取最新版本,from取to的上一个版本
+func DataDiff(inputGuid, from, to string, userRoles []string) (result *models.CiDataDiffResult, err error) {
+	ciTypeId, err := getCiTypeByGuid(inputGuid)
+	if err != nil {
+		return
+	}
+	var toRow, fromRow map[string]string
+	if to == "" {
+		toRow, err = getDiffHistoryRow(fmt.Sprintf("select * from `%s%s` where guid=? order by id desc limit 1", HistoryTablePrefix, ciTypeId), inputGuid)
+	} else {
+		toRow, err = getDiffHistoryRowByPoint(ciTypeId, inputGuid, to)
+	}
+	if err != nil {
+		return
+	}
+	if len(toRow) == 0 {
+		err = fmt.Errorf("Can not find history data with guid:%s to:%s ", inputGuid, to)
+		return
+	}
+	if from == "" {
+		fromRow, err = getDiffHistoryRow(fmt.Sprintf("select * from `%s%s` where guid=? and id<? order by id desc limit 1", HistoryTablePrefix, ciTypeId), inputGuid, toRow["id"])
+	} else {
+		fromRow, err = getDiffHistoryRowByPoint(ciTypeId, inputGuid, from)
+		if err == nil && len(fromRow) == 0 {
+			err = fmt.Errorf("Can not find history data with guid:%s from:%s ", inputGuid, from)
+		}
+	}
+	if err != nil {
+		return
+	}
+	if len(fromRow) > 0 {
+		fromId, _ := strconv.Atoi(fromRow["id"])
+		toId, _ := strconv.Atoi(toRow["id"])
+		if fromId >= toId {
+			err = fmt.Errorf("Diff from version:%s must be earlier than to version:%s ", fromRow["id"], toRow["id"])
+			return
+		}
+	}
+	attrs, getAttrErr := GetCiAttrByCiType(ciTypeId, true)
+	if getAttrErr != nil {
+		err = fmt.Errorf("Try to get ci attribute with ciType:%s error,%s ", ciTypeId, getAttrErr.Error())
+		return
+	}
+	result, err = buildCiDataDiff(ciTypeId, inputGuid, attrs, fromRow, toRow, userRoles)
+	return
+}
+
+// ViewConfirmDiff 返回视图确认窗口内每条待确认数据相对于上一次确认版本的变更
+func ViewConfirmDiff(param models.ViewData, userRoles []string) (result []*models.CiDataDiffResult, err error) {
+	result = []*models.CiDataDiffResult{}
+	_, editableGuidList, err := getViewEditableGuidList(param)
+	if err != nil {
+		return
+	}
+	ciAttrMap := make(map[string][]*models.SysCiTypeAttrTable)
+	legalGuidMap := make(map[string]map[string]bool)
+	for _, rowGuid := range editableGuidList {
+		ciTypeId, tmpErr := getCiTypeByGuid(rowGuid)
+		if tmpErr != nil {
+			err = tmpErr
+			break
+		}
+		// 与DataDiff一样,没有查询权限的数据不返回
+		legalMap, b := legalGuidMap[ciTypeId]
+		if !b {
+			legalAll, legalGuidList, permissionErr := ValidateCiDataPermission(userRoles, ciTypeId, "", models.DataActionQuery)
+			if permissionErr != nil {
+				err = permissionErr
+				break
+			}
+			if !legalAll {
+				legalMap = make(map[string]bool)
+				for _, v := range legalGuidList {
+					legalMap[v] = true
+				}
+			}
+			legalGuidMap[ciTypeId] = legalMap
+		}
+		if legalMap != nil && !legalMap[rowGuid] {
+			continue
+		}
+		if _, b := ciAttrMap[ciTypeId]; !b {
+			attrs, getAttrErr := GetCiAttrByCiType(ciTypeId, true)
+			if getAttrErr != nil {
+				err = fmt.Errorf("Try to get ci attribute with ciType:%s error,%s ", ciTypeId, getAttrErr.Error())
+				break
+			}
+			ciAttrMap[ciTypeId] = attrs
+		}
+		toRow, tmpErr := getDiffHistoryRow(fmt.Sprintf("select * from `%s%s` where guid=? order by id desc limit 1", HistoryTablePrefix, ciTypeId), rowGuid)
+		if tmpErr != nil {
+			err = tmpErr
+			break
+		}
+		if len(toRow) == 0 || toRow["history_state_confirmed"] == "1" {
+			continue
+		}
+		fromRow, tmpErr := getDiffHistoryRow(fmt.Sprintf("select * from `%s%s` where guid=? and id<? and history_state_confirmed=1 order by id desc limit 1", HistoryTablePrefix, ciTypeId), rowGuid, toRow["id"])
+		if tmpErr != nil {
+			err = tmpErr
+			break
+		}
+		diffObj, tmpErr := buildCiDataDiff(ciTypeId, rowGuid, ciAttrMap[ciTypeId], fromRow, toRow, userRoles)
+		if tmpErr != nil {
+			err = tmpErr
+			break
+		}
+		if len(diffObj.Changes) > 0 {
+			result = append(result, diffObj)
+		}
+	}
+	return
+}
+
+func getCiTypeByGuid(inputGuid string) (ciTypeId string, err error) {
+	lastIndex := strings.LastIndex(inputGuid, "_")
+	if lastIndex <= 0 {
+		err = fmt.Errorf("Guid:%s illegal ", inputGuid)
+		return
+	}
+	ciTypeId = inputGuid[:lastIndex]
+	return
+}
+
+func getDiffHistoryRowByPoint(ciTypeId, inputGuid, point string) (row map[string]string, err error) {
+	if historyId, parseErr := strconv.Atoi(point); parseErr == nil {
+		return getDiffHistoryRow(fmt.Sprintf("select * from `%s%s` where guid=? and id=?", HistoryTablePrefix, ciTypeId), inputGuid, historyId)
+	}
+	pointTime, parseErr := time.ParseInLocation(models.DateTimeFormat, point, time.Local)
+	if parseErr != nil {
+		err = fmt.Errorf("Diff version:%s illegal,should be history id or time with format %s ", point, models.DateTimeFormat)
+		return
+	}
+	return getDiffHistoryRow(fmt.Sprintf("select * from `%s%s` where guid=? and history_time<=? order by id desc limit 1", HistoryTablePrefix, ciTypeId), inputGuid, pointTime.Format(models.DateTimeFormat))
+}
+
+func getDiffHistoryRow(sql string, params ...interface{}) (row map[string]string, err error) {
+	queryParams := append([]interface{}{sql}, params...)
+	queryRows, queryErr := x.QueryString(queryParams...)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query history data fail,%s ", queryErr.Error())
+		return
+	}
+	if len(queryRows) > 0 {
+		row = queryRows[0]
+	}
+	return
+}
+
+func buildCiDataDiff(ciTypeId, inputGuid string, attrs []*models.SysCiTypeAttrTable, fromRow, toRow map[string]string, userRoles []string) (result *models.CiDataDiffResult, err error) {
+	result = &models.CiDataDiffResult{Guid: inputGuid, CiType: ciTypeId, KeyName: toRow["key_name"], ToId: toRow["id"], ToTime: toRow["history_time"], Changes: []*models.CiDataAttrDiffObj{}}
+	if len(fromRow) > 0 {
+		result.FromId = fromRow["id"]
+		result.FromTime = fromRow["history_time"]
+	}
+	for _, attr := range attrs {
+		if attr.Name == "guid" {
+			continue
+		}
+		diffObj := models.CiDataAttrDiffObj{Attr: attr.Name, DisplayName: attr.DisplayName, InputType: attr.InputType}
+		if attr.InputType == models.MultiRefType {
+			var fromRefList, toRefList []*models.MultiRefTable
+			if result.FromTime != "" {
+				if fromRefList, err = getMultiRefAsOfTableData(ciTypeId, attr.Name, result.FromTime, []string{inputGuid}, []string{}); err != nil {
+					break
+				}
+			}
+			if toRefList, err = getMultiRefAsOfTableData(ciTypeId, attr.Name, result.ToTime, []string{inputGuid}, []string{}); err != nil {
+				break
+			}
+			addedList, removedList := compareMultiRefGuidList(fromRefList, toRefList)
+			if len(addedList) == 0 && len(removedList) == 0 {
+				continue
+			}
+			if diffObj.Added, err = getDiffRefKeyNameList(attr.RefCiType, addedList, result.ToTime); err != nil {
+				break
+			}
+			if diffObj.Removed, err = getDiffRefKeyNameList(attr.RefCiType, removedList, result.FromTime); err != nil {
+				break
+			}
+			result.Changes = append(result.Changes, &diffObj)
+			continue
+		}
+		oldValue, newValue := fromRow[attr.Name], toRow[attr.Name]
+		if oldValue == newValue {
+			continue
+		}
+		if attr.InputType == "password" || attr.Sensitive == "yes" {
+			if !isDiffAttrVisible(ciTypeId, inputGuid, attr, userRoles) {
+				diffObj.Masked = true
+				diffObj.OldValue, diffObj.NewValue = models.PasswordDisplay, models.PasswordDisplay
+				result.Changes = append(result.Changes, &diffObj)
+				continue
+			}
+		}
+		if attr.RefCiType != "" {
+			if diffObj.OldValue, err = getDiffRefKeyName(attr.RefCiType, oldValue, result.FromTime); err != nil {
+				break
+			}
+			if diffObj.NewValue, err = getDiffRefKeyName(attr.RefCiType, newValue, result.ToTime); err != nil {
+				break
+			}
+		} else {
+			diffObj.OldValue, diffObj.NewValue = oldValue, newValue
+		}
+		result.Changes = append(result.Changes, &diffObj)
+	}
+	return
+}
+
+// 密码字段始终脱敏,敏感字段在有该属性的查询权限时才展示明文,与查询敏感字段明文的校验一致
+func isDiffAttrVisible(ciTypeId, inputGuid string, attr *models.SysCiTypeAttrTable, userRoles []string) bool {
+	if attr.InputType == "password" {
+		return false
+	}
+	legalAll, legalGuidList, err := ValidateCiDataPermission(userRoles, ciTypeId, attr.Id, models.DataActionQuery)
+	if err != nil {
+		log.Debug(nil, log.LOGGER_APP, "diff attr permission check fail", zap.String("guid", inputGuid), zap.String("attr", attr.Name), zap.Error(err))
+		return false
+	}
+	if legalAll {
+		return true
+	}
+	for _, v := range legalGuidList {
+		if v == inputGuid {
+			return true
+		}
+	}
+	return false
+}
+
+func compareMultiRefGuidList(fromRefList, toRefList []*models.MultiRefTable) (addedList, removedList []string) {
+	fromMap, toMap := make(map[string]int), make(map[string]int)
+	for _, v := range fromRefList {
+		fromMap[v.ToGuid] = 1
+	}
+	for _, v := range toRefList {
+		toMap[v.ToGuid] = 1
+		if _, b := fromMap[v.ToGuid]; !b {
+			addedList = append(addedList, v.ToGuid)
+		}
+	}
+	for _, v := range fromRefList {
+		if _, b := toMap[v.ToGuid]; !b {
+			removedList = append(removedList, v.ToGuid)
+		}
+	}
+	return
+}
+
+func getDiffRefKeyName(refCiType, refGuid, asOfTime string) (result *models.CiDataRefDataObj, err error) {
+	if refGuid == "" {
+		return
+	}
+	refList, err := getDiffRefKeyNameList(refCiType, []string{refGuid}, asOfTime)
+	if err == nil && len(refList) > 0 {
+		result = refList[0]
+	}
+	return
+}
+
+// 按版本时间解析引用数据的key_name,在该时间点已不存在的数据只返回guid
+func getDiffRefKeyNameList(refCiType string, guidList []string, asOfTime string) (result []*models.CiDataRefDataObj, err error) {
+	if len(guidList) == 0 {
+		return
+	}
+	var refRowDatas []*models.CiDataRefDataObj
+	if asOfTime != "" {
+		err = x.SQL(fmt.Sprintf("select guid,key_name from `%s%s` where id in (select max(id) from `%s%s` where guid in ('%s') and history_time<=? group by guid)",
+			HistoryTablePrefix, refCiType, HistoryTablePrefix, refCiType, strings.Join(guidList, "','")), asOfTime).Find(&refRowDatas)
+	} else {
+		err = x.SQL(fmt.Sprintf("select guid,key_name from `%s` where guid in ('%s')", refCiType, strings.Join(guidList, "','"))).Find(&refRowDatas)
+	}
+	if err != nil {
+		err = fmt.Errorf("Try to query ref ciType:%s key name fail,%s ", refCiType, err.Error())
+		return
+	}
+	refRowMap := make(map[string]*models.CiDataRefDataObj)
+	for _, refRow := range refRowDatas {
+		refRowMap[refRow.Guid] = refRow
+	}
+	for _, refGuid := range guidList {
+		if refRow, b := refRowMap[refGuid]; b {
+			result = append(result, refRow)
+		} else {
+			result = append(result, &models.CiDataRefDataObj{Guid: refGuid})
+		}
+	}
+	return
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/expression"
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
 	"go.uber.org/zap"
@@ -72,9 +74,15 @@ func CiDataQuery(ciType string, param *models.QueryRequestParam, permission *mod
 	keyMap["history_state_confirmed"] = "history_state_confirmed"
 	keyMap["history_time"] = "history_time"
 	param.ResultColumns = append([]string{"guid"}, resultColumns.GetNameList()...)
-	// 多对多条件转换
-	var appendFilters []*models.QueryRequestFilterObj
-	for _, v := range param.Filters {
+	asOfTime, err := getDialectAsOfTime(param.Dialect)
+	if err != nil {
+		return
+	}
+	// 多对多条件与refPath条件转换成guid过滤
+	err = walkQueryFilters(param, func(v *models.QueryRequestFilterObj) error {
+		if v.Operator == "refPath" {
+			return resolveRefPathFilter(v, ciType, param.Roles)
+		}
 		tmpMultiAttr := &models.SysCiTypeAttrTable{}
 		for _, attr := range ciAttrs {
 			if v.Name == attr.Name {
@@ -84,28 +92,32 @@ func CiDataQuery(ciType string, param *models.QueryRequestParam, permission *mod
 				break
 			}
 		}
-		if tmpMultiAttr.Id != "" {
-			multiTableData, getErr := getMultiRefTableData(tmpMultiAttr.CiType, tmpMultiAttr.Name, []string{}, transInterfaceToStringList(v.Value))
-			if getErr != nil {
-				err = getErr
-				return
-			}
-			tmpGuidFilterList := []interface{}{}
-			for _, row := range multiTableData {
-				tmpGuidFilterList = append(tmpGuidFilterList, row.FromGuid)
-			}
-			appendFilters = append(appendFilters, &models.QueryRequestFilterObj{
-				Name:     "guid",
-				Operator: "in",
-				Value:    tmpGuidFilterList,
-			})
+		if tmpMultiAttr.Id == "" {
+			return nil
+		}
+		var multiTableData []*models.MultiRefTable
+		var getErr error
+		if asOfTime != "" {
+			multiTableData, getErr = getMultiRefAsOfTableData(tmpMultiAttr.CiType, tmpMultiAttr.Name, asOfTime, []string{}, transInterfaceToStringList(v.Value))
+		} else {
+			multiTableData, getErr = getMultiRefTableData(tmpMultiAttr.CiType, tmpMultiAttr.Name, []string{}, transInterfaceToStringList(v.Value))
 		}
+		if getErr != nil {
+			return getErr
+		}
+		v.GuidList = []string{}
+		for _, row := range multiTableData {
+			v.GuidList = append(v.GuidList, row.FromGuid)
+		}
+		return nil
+	})
+	if err != nil {
+		return
 	}
-	if len(appendFilters) > 0 {
-		log.Info(nil, log.LOGGER_APP, "appendFilters", log.JsonObj("data", appendFilters))
-		param.Filters = append(param.Filters, appendFilters...)
+	filterSql, queryColumn, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: false, KeyMap: keyMap, PrimaryKey: "guid", Prefix: "tt", GuidColumn: "tt.guid"})
+	if err != nil {
+		return
 	}
-	filterSql, queryColumn, queryParam := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: false, KeyMap: keyMap, PrimaryKey: "guid", Prefix: "tt"})
 	var baseSql string
 	if !permission.Legal {
 		if strings.Contains(filterSql, "ORDER BY") {
@@ -150,9 +162,17 @@ func CiDataQuery(ciType string, param *models.QueryRequestParam, permission *mod
 		subBaseSql := fmt.Sprintf("select * from `%s%s` where id in (select max(id) from `%s%s` where history_state_confirmed=1 and guid in (select guid from `%s`) group by guid)",
 			HistoryTablePrefix, ciType, HistoryTablePrefix, ciType, ciType)
 		baseSql = fmt.Sprintf("SELECT %s FROM (%s) tt WHERE 1=1 %s ", queryColumn, subBaseSql, filterSql)
+	} else if param.Dialect.QueryMode == "asOf" {
+		// 取每个guid在asOf时间点及之前的最后一条历史记录,已删除的数据不返回
+		historyFlag = true
+		if queryColumn != " * " {
+			queryColumn += ",tt.history_action,tt.history_state_confirmed,tt.history_time,tt.id"
+		}
+		baseSql = fmt.Sprintf("SELECT %s FROM %s tt WHERE 1=1 %s ", queryColumn, getAsOfTableSql(ciType, asOfTime), filterSql)
 	} else {
 		baseSql = fmt.Sprintf("SELECT %s FROM `%s` tt WHERE 1=1 %s ", queryColumn, ciType, filterSql)
 	}
+	queryStartTime := time.Now()
 	if param.Paging {
 		pageInfo.StartIndex = param.Pageable.StartIndex
 		pageInfo.PageSize = param.Pageable.PageSize
@@ -168,6 +188,9 @@ func CiDataQuery(ciType string, param *models.QueryRequestParam, permission *mod
 		err = fmt.Errorf("Query database fail,%s ", queryErr.Error())
 		return
 	}
+	if models.Config.Database.QueryStatEnable {
+		RecordCiQueryStat(ciType, param.Dialect.QueryMode, param, keyMap, time.Since(queryStartTime).Milliseconds())
+	}
 	if len(queryRowData) == 0 {
 		return
 	}
@@ -211,7 +234,7 @@ func CiDataQuery(ciType string, param *models.QueryRequestParam, permission *mod
 	}
 	if len(refAttrs) > 0 && !fromCore {
 		if historyFlag {
-			err = fetchRefAttrHistoryData(rowData, refAttrs)
+			err = fetchRefAttrHistoryData(rowData, refAttrs, asOfTime)
 		} else {
 			err = fetchRefAttrData(rowData, refAttrs)
 		}
@@ -240,7 +263,11 @@ func CiDataQuery(ciType string, param *models.QueryRequestParam, permission *mod
 		}
 	}
 	if len(multiRefAttrs) > 0 {
-		err = fetchMultiRefAttrData(rowData, multiRefAttrs, historyFlag)
+		if asOfTime != "" {
+			err = fetchMultiRefAttrAsOfData(rowData, multiRefAttrs, asOfTime)
+		} else {
+			err = fetchMultiRefAttrData(rowData, multiRefAttrs, historyFlag)
+		}
 		if err != nil {
 			return
 		}
@@ -300,6 +327,76 @@ func addFiltersToSQL(queryParam *models.QueryRequestParam, transParam *models.Tr
 	return
 }
 
+// resolveRefPathFilter refPath条件的value为以ciType开头的表达式,如 host.unit>unit[{key_name like 'prod'}] ,解析出满足表达式的起点数据guid
+// 路径上每段ci都按查询权限收窄到可查的数据,过滤条件的属性规则与关联路径查询一致
+func resolveRefPathFilter(filter *models.QueryRequestFilterObj, ciType string, roles []string) (err error) {
+	express, _ := filter.Value.(string)
+	express = strings.TrimSpace(express)
+	if express == "" {
+		err = fmt.Errorf("RefPath filter value must be a non-empty expression ")
+		return
+	}
+	expr, parseErr := expression.Parse(express)
+	if parseErr != nil {
+		err = fmt.Errorf("RefPath filter expression:%s illegal,%s ", express, parseErr.Error())
+		return
+	}
+	if expr.FirstSegment().CiType != ciType {
+		err = fmt.Errorf("RefPath filter expression:%s must start with ciType:%s ", express, ciType)
+		return
+	}
+	if err = limitRefPathPermission(expr, roles); err != nil {
+		err = fmt.Errorf("RefPath filter expression:%s illegal,%s ", express, err.Error())
+		return
+	}
+	guidList, queryErr := queryExpressResult(expr, map[string]string{}, true)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query refPath filter expression:%s fail,%s ", express, queryErr.Error())
+		return
+	}
+	filter.GuidList = []string{}
+	filter.GuidList = append(filter.GuidList, guidList...)
+	return
+}
+
+// limitRefPathPermission 路径上每段ci的过滤属性需可查,并把该段收窄到角色可查的数据
+func limitRefPathPermission(expr *expression.Expression, roles []string) (err error) {
+	provider := getExpressAttrProvider()
+	legalGuidMap := make(map[string][]string)
+	for i, segment := range expr.Segments {
+		attrMap, exist, queryErr := provider(segment.CiType)
+		if queryErr != nil {
+			return queryErr
+		}
+		if !exist {
+			return fmt.Errorf("Hop %d ciType:%s is not exist ", i, segment.CiType)
+		}
+		for _, segmentFilter := range segment.Filters {
+			if err = checkGraphQueryFilterAttr(i, attrMap[segmentFilter.Attr], segmentFilter.Attr, segment.CiType, roles); err != nil {
+				return
+			}
+		}
+		legalGuidList, b := legalGuidMap[segment.CiType]
+		if !b {
+			legalAll, tmpGuidList, permissionErr := ValidateCiDataPermission(roles, segment.CiType, "", models.DataActionQuery)
+			if permissionErr != nil {
+				return permissionErr
+			}
+			if !legalAll {
+				if len(tmpGuidList) == 0 {
+					return fmt.Errorf("Hop %d ciType:%s permission deny ", i, segment.CiType)
+				}
+				legalGuidList = tmpGuidList
+			}
+			legalGuidMap[segment.CiType] = legalGuidList
+		}
+		if legalGuidList != nil {
+			segment.Filters = append(segment.Filters, &expression.Filter{Attr: "guid", Operator: "in", ValueList: legalGuidList})
+		}
+	}
+	return
+}
+
 func handleQueryRowObject(attrName string, row map[string]interface{}) {
 	if row[attrName] == nil {
 		return
@@ -400,7 +497,8 @@ func fetchRefAttrData(rowData []map[string]interface{}, refAttrs []*models.CiDat
 	return err
 }
 
-func fetchRefAttrHistoryData(rowData []map[string]interface{}, refAttrs []*models.CiDataQueryRefAttrObj) error {
+// asOfTime不为空时,引用数据取asOf时间点的版本,否则取行数据history_time时的版本
+func fetchRefAttrHistoryData(rowData []map[string]interface{}, refAttrs []*models.CiDataQueryRefAttrObj, asOfTime string) error {
 	var err error
 	for _, row := range rowData {
 		for _, refAttr := range refAttrs {
@@ -418,11 +516,15 @@ func fetchRefAttrHistoryData(rowData []map[string]interface{}, refAttrs []*model
 		refRowMap := make(map[string]*models.CiDataRefDataObj)
 		for _, historyGuid := range refAttr.HistoryGuidList {
 			tmpFetchData := models.CiDataRefDataObj{HistoryTime: ""}
+			compareTime := historyGuid.HistoryTime
+			if asOfTime != "" {
+				compareTime = asOfTime
+			}
 			for _, refRow := range refRowDatas {
 				if historyGuid.Guid != refRow.Guid {
 					continue
 				}
-				if refRow.HistoryTime > historyGuid.HistoryTime {
+				if refRow.HistoryTime > compareTime {
 					break
 				}
 				if refRow.HistoryTime > tmpFetchData.HistoryTime {
@@ -462,6 +564,97 @@ func fetchMultiRefAttrData(rowData []map[string]interface{}, multiRefAttrs []*mo
 	return err
 }
 
+func fetchMultiRefAttrAsOfData(rowData []map[string]interface{}, multiRefAttrs []*models.CiDataQueryRefAttrObj, asOfTime string) error {
+	var err error
+	rowGuidList := []string{}
+	for _, row := range rowData {
+		rowGuidList = append(rowGuidList, row["guid"].(string))
+	}
+	for _, attr := range multiRefAttrs {
+		multiTableData, tmpErr := getMultiRefAsOfTableData(attr.Attribute.CiType, attr.Attribute.Name, asOfTime, rowGuidList, []string{})
+		if tmpErr != nil {
+			err = fmt.Errorf("Try to query multi ref attr:%s refCiType:%s fail,%s ", attr.Attribute.Name, attr.Attribute.RefCiType, tmpErr.Error())
+			break
+		}
+		toGuidList := []string{}
+		for _, row := range multiTableData {
+			toGuidList = append(toGuidList, row.ToGuid)
+		}
+		refRowDatas := []*models.CiDataRefDataObj{}
+		if len(toGuidList) > 0 {
+			tmpErr = x.SQL(fmt.Sprintf("select guid,key_name,history_time from %s t where guid in ('%s')", getAsOfTableSql(attr.Attribute.RefCiType, asOfTime), strings.Join(toGuidList, "','"))).Find(&refRowDatas)
+			if tmpErr != nil {
+				err = fmt.Errorf("Try to query multi ref attr:%s refCiType:%s history data fail,%s ", attr.Attribute.Name, attr.Attribute.RefCiType, tmpErr.Error())
+				break
+			}
+		}
+		refRowMap := make(map[string]*models.CiDataRefDataObj)
+		for _, refRow := range refRowDatas {
+			refRowMap[refRow.Guid] = refRow
+		}
+		guidGroupMap := make(map[string][]*models.CiDataRefDataObj)
+		for _, row := range multiTableData {
+			refRow, b := refRowMap[row.ToGuid]
+			if !b {
+				continue
+			}
+			guidGroupMap[row.FromGuid] = append(guidGroupMap[row.FromGuid], &models.CiDataRefDataObj{Guid: refRow.Guid, KeyName: refRow.KeyName, HistoryTime: refRow.HistoryTime})
+		}
+		attr.MultiRefObj = guidGroupMap
+	}
+	return err
+}
+
+func getDialectAsOfTime(dialect *models.QueryRequestDialect) (asOfTime string, err error) {
+	if dialect == nil || dialect.QueryMode != "asOf" {
+		return
+	}
+	if dialect.AsOf == "" {
+		err = fmt.Errorf("Dialect asOf can not empty when queryMode is asOf ")
+		return
+	}
+	asOfTimeObj, parseErr := time.ParseInLocation(models.DateTimeFormat, dialect.AsOf, time.Local)
+	if parseErr != nil {
+		err = fmt.Errorf("Dialect asOf:%s illegal,format should be %s ", dialect.AsOf, models.DateTimeFormat)
+		return
+	}
+	asOfTime = asOfTimeObj.Format(models.DateTimeFormat)
+	return
+}
+
+// 返回ciType在asOf时间点的快照子查询,asOfTime需先经过getDialectAsOfTime校验
+func getAsOfTableSql(ciType, asOfTime string) string {
+	return fmt.Sprintf("(select * from `%s%s` where id in (select max(id) from `%s%s` where history_time<='%s' group by guid) and history_action<>'delete')",
+		HistoryTablePrefix, ciType, HistoryTablePrefix, ciType, asOfTime)
+}
+
+// 多对多关系每次变更都会整组写入历史表,取asOf时间点及之前最后一次写入的那一组
+// history_time只精确到秒,同一秒内的多次变更按id区分:每组从seq_no为0的记录开始,取asOf之前最后一个组头及之后的记录
+// 清空时写入的是to_guid为空的一条记录,取到后过滤掉即为空集合
+func getAsOfMultiRefTableSql(ciType, attrName, asOfTime string) string {
+	return fmt.Sprintf("(select t1.from_guid,t1.to_guid,t1.seq_no from `%s%s$%s` t1 where t1.to_guid<>'' and t1.history_time<='%s' and t1.id>=(select max(t2.id) from `%s%s$%s` t2 where t2.from_guid=t1.from_guid and t2.seq_no=0 and t2.history_time<='%s'))",
+		HistoryTablePrefix, ciType, attrName, asOfTime, HistoryTablePrefix, ciType, attrName, asOfTime)
+}
+
+func getMultiRefAsOfTableData(ciType, attrName, asOfTime string, fromGuidList, toGuidList []string) (result []*models.MultiRefTable, err error) {
+	if ciType == "" || attrName == "" {
+		err = fmt.Errorf("get multiRef data illegal with ciType:%s and attrName:%s", ciType, attrName)
+		return
+	}
+	baseSql := fmt.Sprintf("select from_guid,to_guid from %s tm where 1=1 ", getAsOfMultiRefTableSql(ciType, attrName, asOfTime))
+	if len(fromGuidList) > 0 {
+		baseSql += fmt.Sprintf("and from_guid in ('%s') ", strings.Join(fromGuidList, "','"))
+	}
+	if len(toGuidList) > 0 {
+		baseSql += fmt.Sprintf("and to_guid in ('%s') ", strings.Join(toGuidList, "','"))
+	}
+	baseSql += "order by from_guid,seq_no"
+	if err = x.SQL(baseSql).Find(&result); err != nil {
+		err = fmt.Errorf("get multiRef history data error with database:%s ", err.Error())
+	}
+	return
+}
+
 func fetchExtRefAttrData(refAttrs []*models.CiDataQueryRefAttrObj) (err error) {
 	for _, refAttr := range refAttrs {
 		entitySplit := strings.Split(refAttr.Attribute.ExtRefEntity, ":")
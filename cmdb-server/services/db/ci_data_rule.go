@@ -6,24 +6,27 @@ import (
 	"math"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/expression"
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
 	"go.uber.org/zap"
 )
 
 var (
-	affectGuidListChan   = make(chan map[string][]*models.AutofillChainObj, 100)
-	affectCiTypeChan     = make(chan string, 10)
-	uniquePathHandleChan = make(chan []*models.AutoActiveHandleParam, 100)
-	specialEqualChar     = models.SEPERATOR + "=" + models.SEPERATOR
-	specialSeparateChar  = "," + models.SEPERATOR
-	specialAndChar       = "&" + models.SEPERATOR
-	specialNullChar      = "NULL" + models.SEPERATOR
+	specialEqualChar    = models.SEPERATOR + "=" + models.SEPERATOR
+	specialSeparateChar = "," + models.SEPERATOR
+	specialAndChar      = "&" + models.SEPERATOR
+	specialNullChar     = "NULL" + models.SEPERATOR
 )
 
 func buildAutofillValue(columnMap map[string]string, rule, attrInputType string) (newValueList []string, err error) {
+	return buildAutofillValueWithTrace(columnMap, rule, attrInputType, nil)
+}
+
+// buildAutofillValueWithTrace trace不为空时记录每段规则的取值与执行的sql,用于规则解释
+func buildAutofillValueWithTrace(columnMap map[string]string, rule, attrInputType string, trace *autofillTrace) (newValueList []string, err error) {
+	defer trace.enter()()
 	log.Debug(nil, log.LOGGER_APP, "-----start buildAutofillValue columnMap", log.JsonObj("map", columnMap), zap.String("rule", rule))
 	if rule == "" {
 		return
@@ -46,7 +49,7 @@ func buildAutofillValue(columnMap map[string]string, rule, attrInputType string)
 		}
 	}
 	if calcFillFlag {
-		calcResult, calcErr := buildCalcFillValue(columnMap, ruleList)
+		calcResult, calcErr := buildCalcFillValue(columnMap, ruleList, trace)
 		if calcErr != nil {
 			err = fmt.Errorf("buildCalcFillValue fail,%s ", calcErr.Error())
 			return
@@ -63,11 +66,13 @@ func buildAutofillValue(columnMap map[string]string, rule, attrInputType string)
 	var autofillSubIndex, ruleSubIndex []int
 	isSpecialStruct := false
 	for i, ruleObj := range ruleList {
+		segment := trace.startSegment(i, ruleObj)
 		// json结构表达式
 		if ruleObj.Type == "rule" {
-			tmpValueList, tmpIsAutofill, tmpErr := getRuleValue(columnMap, ruleObj.Value)
+			tmpValueList, tmpIsAutofill, tmpErr := getRuleValue(columnMap, ruleObj.Value, trace)
 			if tmpErr != nil {
 				err = fmt.Errorf("Try to get autofill reference data with rule value:%s fail,%s ", ruleObj.Value, tmpErr.Error())
+				trace.finishSegment(segment, nil, err)
 				break
 			}
 			log.Debug(nil, log.LOGGER_APP, "make resultValueList 2", zap.Strings("list", tmpValueList))
@@ -77,7 +82,7 @@ func buildAutofillValue(columnMap map[string]string, rule, attrInputType string)
 				autofillSubIndex = append(autofillSubIndex, i)
 				for _, autofillObj := range tmpValueList {
 					log.Debug(nil, log.LOGGER_APP, "sub autofill rule", zap.String("rule", autofillObj))
-					autofillSubResult, tmpErr := buildAutofillValue(columnMap, autofillObj, models.AutofillRuleType)
+					autofillSubResult, tmpErr := buildAutofillValueWithTrace(columnMap, autofillObj, models.AutofillRuleType, trace)
 					if tmpErr != nil {
 						log.Error(nil, log.LOGGER_APP, "sub autofill rule error", zap.Error(tmpErr))
 						err = fmt.Errorf("sub autofill rule error:%s ", tmpErr.Error())
@@ -88,17 +93,20 @@ func buildAutofillValue(columnMap map[string]string, rule, attrInputType string)
 					}
 				}
 				if err != nil {
+					trace.finishSegment(segment, nil, err)
 					break
 				}
 				log.Debug(nil, log.LOGGER_APP, "auto fill decode result 2", zap.Strings("valueList", newTmpValueList))
 				tmpValueList = newTmpValueList
 			}
+			trace.finishSegment(segment, tmpValueList, nil)
 			ruleSubIndex = append(ruleSubIndex, i)
 			ruleObjValueList = append(ruleObjValueList, tmpValueList)
 			log.Debug(nil, log.LOGGER_APP, "make resultValueList 3", zap.Strings("list", tmpValueList), zap.String("ruleObjValueList", fmt.Sprintf("%s", ruleObjValueList)), zap.String("ruleSubIndex", fmt.Sprintf("%v", ruleSubIndex)))
 		} else if ruleObj.Type == "delimiter" {
 			// 连接符
 			ruleObjValueList = append(ruleObjValueList, []string{ruleObj.Value})
+			trace.finishSegment(segment, []string{ruleObj.Value}, nil)
 		} else if ruleObj.Type == "specialDelimiter" {
 			if ruleObj.Value == "=" {
 				ruleObj.Value = specialEqualChar
@@ -107,6 +115,7 @@ func buildAutofillValue(columnMap map[string]string, rule, attrInputType string)
 				ruleObj.Value = specialAndChar
 			}
 			ruleObjValueList = append(ruleObjValueList, []string{ruleObj.Value})
+			trace.finishSegment(segment, []string{ruleObj.Value}, nil)
 			isSpecialStruct = true
 		}
 	}
@@ -190,7 +199,7 @@ func buildAutofillValue(columnMap map[string]string, rule, attrInputType string)
 	return
 }
 
-func getRuleValue(rowData map[string]string, ruleString string) (resultValueList []string, isTypeAutofill bool, err error) {
+func getRuleValue(rowData map[string]string, ruleString string, trace *autofillTrace) (resultValueList []string, isTypeAutofill bool, err error) {
 	var ruleList []*models.AutofillValueObj
 	err = json.Unmarshal([]byte(ruleString), &ruleList)
 	if err != nil {
@@ -207,7 +216,9 @@ func getRuleValue(rowData map[string]string, ruleString string) (resultValueList
 		rowDataList = append(rowDataList, rowData)
 	} else {
 		// 如果是嵌套递归的，因为是从过滤规则中开始填充，则没有原始行数据，需要查出所有行数据来
-		rowDataList, err = x.QueryString("select * from `" + ruleList[0].CiTypeId + "`")
+		querySql := "select * from `" + ruleList[0].CiTypeId + "`"
+		rowDataList, err = x.QueryString(querySql)
+		trace.addQuery(querySql, nil, len(rowDataList), err)
 	}
 	isTypeAutofill = false
 	for i, rule := range ruleList {
@@ -251,7 +262,7 @@ func getRuleValue(rowData map[string]string, ruleString string) (resultValueList
 						//}
 					}
 					log.Debug(nil, log.LOGGER_APP, "tmpGuidList 1", zap.Strings("guidList", tmpGuidList), zap.String("attr", tmpAttrSplit[1]))
-					rowDataList, err = getCiRowDataByGuid(rule.CiTypeId, tmpGuidList, rule.Filters, tmpAttrInputType, rowData)
+					rowDataList, err = getCiRowDataByGuid(rule.CiTypeId, tmpGuidList, rule.Filters, tmpAttrInputType, rowData, trace)
 				} else {
 					// 直接从rowDataList里面的相应attr拿下一个关联的guid列表
 					for _, tmpRowData := range rowDataList {
@@ -263,12 +274,12 @@ func getRuleValue(rowData map[string]string, ruleString string) (resultValueList
 					}
 					// 需要再去关联表里面查出关联的目标行
 					log.Debug(nil, log.LOGGER_APP, "tmpGuidList multi 2", zap.Strings("guidList", tmpGuidList), zap.String("attr", tmpAttrSplit[1]))
-					rowDataList, err = getMultiRefRowData(tmpAttrSplit[0], tmpAttrSplit[1], rule.CiTypeId, tmpGuidList, rule.Filters, tmpAttrInputType, rowData)
+					rowDataList, err = getMultiRefRowData(tmpAttrSplit[0], tmpAttrSplit[1], rule.CiTypeId, tmpGuidList, rule.Filters, tmpAttrInputType, rowData, trace)
 				}
 			}
 			log.Debug(nil, log.LOGGER_APP, "tmpRowDataObj", zap.Int("len", len(rowDataList)))
 		} else {
-			rowDataList, err = getReferRowDataByFilter(rule.CiTypeId, tmpAttrSplit[1], rule.Filters, rowDataList, isMultiRef, tmpAttrInputType, rowData)
+			rowDataList, err = getReferRowDataByFilter(rule.CiTypeId, tmpAttrSplit[1], rule.Filters, rowDataList, isMultiRef, tmpAttrInputType, rowData, trace)
 		}
 		if err != nil {
 			break
@@ -281,11 +292,11 @@ func getRuleValue(rowData map[string]string, ruleString string) (resultValueList
 	return
 }
 
-func getReferRowDataByFilter(ciTypeId, attr string, filters []*models.AutofillFilterObj, rowDataList []map[string]string, multiRef bool, inputType string, startRowData map[string]string) (rowMapList []map[string]string, err error) {
+func getReferRowDataByFilter(ciTypeId, attr string, filters []*models.AutofillFilterObj, rowDataList []map[string]string, multiRef bool, inputType string, startRowData map[string]string, trace *autofillTrace) (rowMapList []map[string]string, err error) {
 	var filterSqlList, rowGuidList []string
 	for _, f := range filters {
 		f.CiType = ciTypeId
-		tmpSql, tmpErr := getFilterSql(f, "t1", inputType, startRowData)
+		tmpSql, tmpErr := getFilterSql(f, "t1", inputType, startRowData, trace)
 		if tmpErr != nil {
 			err = fmt.Errorf("Get filter:%s sql error:%s ", f, tmpErr.Error())
 			break
@@ -309,18 +320,19 @@ func getReferRowDataByFilter(ciTypeId, attr string, filters []*models.AutofillFi
 	}
 	log.Debug(nil, log.LOGGER_APP, "getReferRowDataByFilter", zap.String("sql", sql))
 	rowMapList, err = x.QueryString(sql)
+	trace.addQuery(sql, nil, len(rowMapList), err)
 	if err != nil {
 		log.Error(nil, log.LOGGER_APP, "Get reference row data by filter fail", zap.Error(err))
 	}
 	return
 }
 
-func getFilterSql(filter *models.AutofillFilterObj, prefix, inputType string, startRowData map[string]string) (sql string, err error) {
+func getFilterSql(filter *models.AutofillFilterObj, prefix, inputType string, startRowData map[string]string, trace *autofillTrace) (sql string, err error) {
 	var valueString, columnString, filterSqlColumn string
 	var valueList []string
 	if filter.Type == "autoFill" {
 		tmpValueString := filter.Value.(string)
-		valueList, err = buildAutofillValue(startRowData, tmpValueString, inputType)
+		valueList, err = buildAutofillValueWithTrace(startRowData, tmpValueString, inputType, trace)
 		log.Debug(nil, log.LOGGER_APP, "getFilterSql value", zap.Strings("valueList", valueList))
 		if err != nil {
 			err = fmt.Errorf("Build filter value error:%s ", err.Error())
@@ -381,7 +393,9 @@ func getFilterSql(filter *models.AutofillFilterObj, prefix, inputType string, st
 			multiSql = " and " + multiSql
 		}
 		log.Debug(nil, log.LOGGER_APP, "query multi sql", zap.String("multiSql", multiSql))
-		queryRows, queryErr := x.QueryString(fmt.Sprintf("select * from `%s$%s` where 1=1 %s", filter.CiType, columnString, multiSql))
+		multiQuerySql := fmt.Sprintf("select * from `%s$%s` where 1=1 %s", filter.CiType, columnString, multiSql)
+		queryRows, queryErr := x.QueryString(multiQuerySql)
+		trace.addQuery(multiQuerySql, nil, len(queryRows), queryErr)
 		if queryErr != nil {
 			err = fmt.Errorf("getFilterSql:Try to query multiRef fail,%s ", queryErr.Error())
 			return
@@ -500,40 +514,8 @@ func GetCiDataByFilters(attrId string, filterMap map[string]string, reqParam mod
 		}
 		return
 	}
-	//Example: [{"filter_1":{"left":"host_resource:[guid]","operator":"in","right":{"type":"expression","value":"app_instance.unit>unit.resource_set>resource_set~(resource_set)host_resource:[guid]"}}}]
-	var filters []map[string]models.CiDataRefFilterObj
-	err = json.Unmarshal([]byte(attrTable[0].RefFilter), &filters)
-	if err != nil {
-		err = fmt.Errorf("Json unmarshal filters string fail,%s ", err.Error())
-		return
-	}
-	if len(filters) == 0 {
-		err = fmt.Errorf("Get ci reference data fail,filters string illgeal ")
-		return
-	}
-	if _, b := filterMap[attrTable[0].Name]; b {
-		delete(filterMap, attrTable[0].Name)
-	}
-	var filterSqlList []string
-	for _, filter := range filters[0] {
-		tmpFilterSql, tmpErr := getRefFilterSql(&filter, filterMap)
-		if tmpErr != nil {
-			err = tmpErr
-			break
-		}
-		filterSqlList = append(filterSqlList, tmpFilterSql)
-	}
+	rowStringData, filterSqlList, err := getRefFilterRows(attrTable[0].RefCiType, attrTable[0].Name, attrTable[0].RefFilter, filterMap)
 	if err != nil {
-		err = fmt.Errorf("Get ci reference data fail when build filter sql,%s ", err.Error())
-		return
-	}
-	querySql := fmt.Sprintf("select guid,key_name from `%s` order by update_time desc", attrTable[0].RefCiType)
-	if len(filterSqlList) > 0 {
-		querySql = fmt.Sprintf("select guid,key_name from `%s` where 1=1 AND (%s) order by update_time desc", attrTable[0].RefCiType, strings.Join(filterSqlList, ") AND ("))
-	}
-	rowStringData, queryErr := x.QueryString(querySql)
-	if queryErr != nil {
-		err = queryErr
 		return
 	}
 	if reqParam.Paging == false && len(reqParam.Filters) == 0 {
@@ -565,6 +547,42 @@ func GetCiDataByFilters(attrId string, filterMap map[string]string, reqParam mod
 	return
 }
 
+// getRefFilterRows 按引用过滤规则查询被引用ci中满足条件的guid与key_name,filterMap为当前数据行
+func getRefFilterRows(refCiType, attrName, refFilter string, filterMap map[string]string) (rowStringData []map[string]string, filterSqlList []string, err error) {
+	//Example: [{"filter_1":{"left":"host_resource:[guid]","operator":"in","right":{"type":"expression","value":"app_instance.unit>unit.resource_set>resource_set~(resource_set)host_resource:[guid]"}}}]
+	var filters []map[string]models.CiDataRefFilterObj
+	err = json.Unmarshal([]byte(refFilter), &filters)
+	if err != nil {
+		err = fmt.Errorf("Json unmarshal filters string fail,%s ", err.Error())
+		return
+	}
+	if len(filters) == 0 {
+		err = fmt.Errorf("Get ci reference data fail,filters string illgeal ")
+		return
+	}
+	if _, b := filterMap[attrName]; b {
+		delete(filterMap, attrName)
+	}
+	for _, filter := range filters[0] {
+		tmpFilterSql, tmpErr := getRefFilterSql(&filter, filterMap)
+		if tmpErr != nil {
+			err = tmpErr
+			break
+		}
+		filterSqlList = append(filterSqlList, tmpFilterSql)
+	}
+	if err != nil {
+		err = fmt.Errorf("Get ci reference data fail when build filter sql,%s ", err.Error())
+		return
+	}
+	querySql := fmt.Sprintf("select guid,key_name from `%s` order by update_time desc", refCiType)
+	if len(filterSqlList) > 0 {
+		querySql = fmt.Sprintf("select guid,key_name from `%s` where 1=1 AND (%s) order by update_time desc", refCiType, strings.Join(filterSqlList, ") AND ("))
+	}
+	rowStringData, err = x.QueryString(querySql)
+	return
+}
+
 func getRefFilterSql(filter *models.CiDataRefFilterObj, filterMap map[string]string) (sql string, err error) {
 	startCiType := filter.Left[:strings.LastIndex(filter.Left, "[")]
 	//if _, b := filterMap[startCiType]; b {
@@ -612,50 +630,30 @@ type expressionSqlObj struct {
 	ResultColumn    string
 	RefColumn       string
 	MultiRefTable   string
+	WhereParams     []interface{}
 }
 
 func getConditionExpressResult(express, startCiType string, filterMap map[string]string, permission bool) (result []string, err error) {
-	var filterParams, tmpSplitList []string
-	tmpExpress := express
-	if strings.Contains(tmpExpress, "'") {
-		tmpSplitList = strings.Split(tmpExpress, "'")
-		tmpExpress = ""
-		for i, v := range tmpSplitList {
-			if i%2 == 0 {
-				if i == len(tmpSplitList)-1 {
-					tmpExpress += v
-				} else {
-					tmpExpress += fmt.Sprintf("%s'$%d'", v, i/2)
-				}
-			} else {
-				filterParams = append(filterParams, strings.ReplaceAll(v, "'", ""))
-			}
-		}
+	exprList, parseErr := expression.ParseList(express)
+	if parseErr != nil {
+		err = fmt.Errorf("Express:%s illegal,%s ", express, parseErr.Error())
+		return
+	}
+	if len(exprList) == 1 {
+		result, err = queryExpressResult(exprList[0], filterMap, permission)
+		return
 	}
 	// 表达式可以用逗号隔开多段，最终每段的值取与的关系，要符合所有段的配置
-	filterSplitChar := "^^filter_split^^"
-	tmpExpress = strings.ReplaceAll(tmpExpress, "},{", filterSplitChar)
-	if strings.Contains(tmpExpress, ",") {
-		tmpList := [][]string{}
-		for _, v := range strings.Split(tmpExpress, ",") {
-			v = strings.ReplaceAll(v, filterSplitChar, "},{")
-			for ii, vv := range filterParams {
-				v = strings.ReplaceAll(v, fmt.Sprintf("$%d", ii), vv)
-			}
-			tmpResult, tmpErr := getExpressResultList(v, startCiType, filterMap, permission)
-			if tmpErr != nil {
-				err = tmpErr
-				break
-			}
-			tmpList = append(tmpList, tmpResult)
-		}
-		if err != nil {
+	tmpList := [][]string{}
+	for _, expr := range exprList {
+		tmpResult, tmpErr := queryExpressResult(expr, filterMap, permission)
+		if tmpErr != nil {
+			err = tmpErr
 			return
 		}
-		result = getSameElementList(tmpList)
-	} else {
-		result, err = getExpressResultList(express, startCiType, filterMap, permission)
+		tmpList = append(tmpList, tmpResult)
 	}
+	result = getSameElementList(tmpList)
 	return
 }
 
@@ -682,95 +680,49 @@ func getSameElementList(input [][]string) []string {
 func getExpressResultList(express, startCiType string, filterMap map[string]string, permission bool) (result []string, err error) {
 	log.Debug(nil, log.LOGGER_APP, "getExpressResultList", zap.String("express", express))
 	// Example expression -> "host_resource_instance.resource_set>resource_set~(resource_set)unit[{key_name eq 'hhh'},{code in ['u','v']}]:[guid]"
-	var ciList, filterParams, tmpSplitList []string
-	// replace content 'xxx' to '$1' in case of content have '>~.:()[]'
-	if strings.Contains(express, "'") {
-		tmpSplitList = strings.Split(express, "'")
-		express = ""
-		for i, v := range tmpSplitList {
-			if i%2 == 0 {
-				if i == len(tmpSplitList)-1 {
-					express += v
-				} else {
-					express += fmt.Sprintf("%s'$%d$'", v, i/2)
-				}
-			} else {
-				filterParams = append(filterParams, strings.ReplaceAll(v, "'", ""))
-			}
-		}
-	}
-	if permission {
-		firstGuidIndex := strings.Index(express, ":[guid]")
-		if firstGuidIndex > 0 {
-			express = express[:firstGuidIndex] + express[firstGuidIndex+7:]
-		}
-	}
-	// split with > or ~
-	var cursor int
-	for i, v := range express {
-		if v == 62 || v == 126 {
-			ciList = append(ciList, express[cursor:i])
-			cursor = i
-		}
+	expr, parseErr := expression.Parse(express)
+	if parseErr != nil {
+		err = fmt.Errorf("Express:%s illegal,%s ", express, parseErr.Error())
+		return
 	}
-	ciList = append(ciList, express[cursor:])
-	// analyze each ci segment
+	result, err = queryExpressResult(expr, filterMap, permission)
+	return
+}
+
+// queryExpressResult 把表达式转成关联查询,permission为true时返回起点ci的guid,否则返回终点ci的结果列
+func queryExpressResult(expr *expression.Expression, filterMap map[string]string, permission bool) (result []string, err error) {
 	var expressionSqlList []*expressionSqlObj
-	for i, ci := range ciList {
-		eso := expressionSqlObj{IndexTableName: fmt.Sprintf("t%d", i)}
-		if strings.HasPrefix(ci, ">") {
-			eso.LeftJoinColumn = ciList[i-1][strings.LastIndex(ciList[i-1], ".")+1:]
-			ci = ci[1:]
-		} else if strings.HasPrefix(ci, "~") {
-			eso.RightJoinColumn = ci[2:strings.Index(ci, ")")]
+	for i, segment := range expr.Segments {
+		eso := expressionSqlObj{IndexTableName: fmt.Sprintf("t%d", i), Table: segment.CiType, ResultColumn: segment.ResultColumn}
+		if segment.Join == expression.JoinForward {
+			eso.LeftJoinColumn = expr.Segments[i-1].Attr
+		} else if segment.Join == expression.JoinBackward {
+			eso.RightJoinColumn = segment.RefAttr
 			eso.RefColumn = eso.RightJoinColumn
-			ci = ci[strings.Index(ci, ")")+1:]
-		}
-		// ASCII . -> 46 , [ -> 91 , ] -> 93 , : -> 58 , { -> 123 , } -> 125
-		for j, v := range ci {
-			if v == 46 || v == 58 || v == 91 {
-				eso.Table = ci[:j]
-				ci = ci[j:]
-				break
-			}
-		}
-		if eso.Table == "" {
-			eso.Table = ci
 		}
-		if ci[0] == 91 {
-			tmpFilterStr := ci[2 : len(ci)-2]
-			for j, v := range ci {
-				if v == 46 || v == 58 {
-					tmpFilterStr = ci[2 : j-2]
-					ci = ci[j:]
-					break
-				}
-			}
-			for _, v := range strings.Split(tmpFilterStr, "},{") {
-				tmpFilterList := strings.Split(v, " ")
-				if len(tmpFilterList) < 2 {
-					continue
-				}
-				if len(tmpFilterList) > 2 {
-					eso.WhereSql += " and " + buildConditionSql(fmt.Sprintf("%s.%s", eso.IndexTableName, tmpFilterList[0]), tmpFilterList[1], tmpFilterList[2], []string{})
-				} else {
-					eso.WhereSql += " and " + buildConditionSql(fmt.Sprintf("%s.%s", eso.IndexTableName, tmpFilterList[0]), tmpFilterList[1], "", []string{})
-				}
+		for _, filter := range segment.Filters {
+			tmpSql, tmpParams, tmpErr := buildExpressFilterSql(fmt.Sprintf("%s.`%s`", eso.IndexTableName, filter.Attr), filter)
+			if tmpErr != nil {
+				err = tmpErr
+				return
 			}
+			eso.WhereSql += " and " + tmpSql
+			eso.WhereParams = append(eso.WhereParams, tmpParams...)
 		}
-		if ci[0] == 58 {
-			eso.ResultColumn = ci[2 : len(ci)-1]
-		}
-		if i == 0 && !permission && len(ciList) > 1 {
+		if i == 0 && !permission && len(expr.Segments) > 1 {
 			continue
 		}
 		expressionSqlList = append(expressionSqlList, &eso)
 	}
-	log.Debug(nil, log.LOGGER_APP, "getExpressResultList expressionSqlList", log.JsonObj("expressionSqlList", expressionSqlList))
+	log.Debug(nil, log.LOGGER_APP, "queryExpressResult expressionSqlList", log.JsonObj("expressionSqlList", expressionSqlList))
 	eLen := len(expressionSqlList)
 	if eLen == 0 {
 		return
 	}
+	if !permission && expressionSqlList[eLen-1].ResultColumn == "" {
+		err = fmt.Errorf("Express:%s need a result column like :[guid] ", expr.Source)
+		return
+	}
 	checkFilterAttrMultiRef(expressionSqlList)
 	eLen = eLen - 1
 	sql := fmt.Sprintf("select %s.%s from ", expressionSqlList[eLen].IndexTableName, expressionSqlList[eLen].ResultColumn)
@@ -778,6 +730,7 @@ func getExpressResultList(express, startCiType string, filterMap map[string]stri
 		sql = fmt.Sprintf("select %s.guid from ", expressionSqlList[0].IndexTableName)
 	}
 	var whereSql string
+	var whereParams []interface{}
 	for i, v := range expressionSqlList {
 		tmpTableName := v.Table
 		if v.MultiRefTable != "" {
@@ -797,6 +750,7 @@ func getExpressResultList(express, startCiType string, filterMap map[string]stri
 		}
 		if v.WhereSql != "" {
 			whereSql += v.WhereSql
+			whereParams = append(whereParams, v.WhereParams...)
 		}
 		if i == 0 {
 			//if len(ciList) == 1 {
@@ -837,11 +791,8 @@ func getExpressResultList(express, startCiType string, filterMap map[string]stri
 	if whereSql != "" {
 		sql += " where 1=1 " + whereSql
 	}
-	for i, v := range filterParams {
-		sql = strings.ReplaceAll(sql, fmt.Sprintf("$%d$", i), v)
-	}
 	log.Debug(nil, log.LOGGER_APP, "Expression filter sql", zap.String("sql", sql))
-	queryResults, queryErr := x.QueryString(sql)
+	queryResults, queryErr := x.QueryString(append([]interface{}{sql}, whereParams...)...)
 	if queryErr != nil {
 		err = fmt.Errorf("Query expression filter sql error,%s ", queryErr.Error())
 	} else {
@@ -952,31 +903,17 @@ func checkFilterAttrMultiRef(expressionSqlList []*expressionSqlObj) {
 	}
 }
 
-func StartConsumeAffectGuidMap() {
-	log.Debug(nil, log.LOGGER_APP, "Start consume affect guid map cron job")
-	for {
-		autofillChainMap := <-affectGuidListChan
-		go handleAffectGuidMap(autofillChainMap)
-	}
-}
-
-func StartConsumeAffectCiType() {
-	log.Debug(nil, log.LOGGER_APP, "Start consume affect ci type cron job")
-	for {
-		ciType := <-affectCiTypeChan
-		go handleAffectCiType(ciType)
-	}
-}
-
-func handleAffectGuidMap(autofillChainMap map[string][]*models.AutofillChainObj) {
+// handleAffectGuidMap 找出自动填充依赖了这些数据行修改属性的数据,放入队列逐行重新计算
+func handleAffectGuidMap(autofillChainMap map[string][]*models.AutofillChainObj) (err error) {
 	log.Debug(nil, log.LOGGER_APP, "Start handle affect guid list")
-	//if len(autofillChainMap) > 0 {
-	//	return
-	//}
 	affectCiMap := make(map[string]*models.AutofillChainCiColumn)
 	for k, rows := range autofillChainMap {
 		ciDepColumnList, tmpErr := getCiTypeAutofillDepColumn(k)
-		if tmpErr != nil || len(ciDepColumnList) == 0 {
+		if tmpErr != nil {
+			err = tmpErr
+			return
+		}
+		if len(ciDepColumnList) == 0 {
 			continue
 		}
 		for _, row := range rows {
@@ -996,46 +933,31 @@ func handleAffectGuidMap(autofillChainMap map[string][]*models.AutofillChainObj)
 		log.Debug(nil, log.LOGGER_APP, "End handle affect guid list,no ci to update")
 		return
 	}
-	nowTime := time.Now().Format(models.DateTimeFormat)
+	var itemList []*workQueueItem
 	for _, attr := range affectCiMap {
 		affectGuidList := findAutofillGuidDepList(attr)
 		log.Debug(nil, log.LOGGER_APP, "Handle affect autofill guid list", zap.Strings("affect", affectGuidList))
-		if len(affectGuidList) == 0 {
-			continue
-		}
-		// Do update affect row
 		for _, row := range affectGuidList {
-			autofillAffectActionFunc(attr.CiTypeId, row, nowTime)
-		}
-	}
-	for _, rows := range autofillChainMap {
-		for _, row := range rows {
-			if row.MultiColumnDelMap != nil {
-				log.Debug(nil, log.LOGGER_APP, "MultiColumnDelMap", log.JsonObj("data", row.MultiColumnDelMap))
-				for _, guidList := range row.MultiColumnDelMap {
-					for _, rowGuid := range guidList {
-						if lastIndex := strings.LastIndex(rowGuid, "_"); lastIndex >= 0 {
-							tmpCiType := rowGuid[:lastIndex]
-							autofillAffectActionFunc(tmpCiType, rowGuid, nowTime)
-						}
-					}
-				}
-			}
+			itemList = append(itemList, buildAutofillGuidWorkItem(attr.CiTypeId, row))
 		}
 	}
+	err = enqueueWorkItem(itemList)
+	return
 }
 
-func handleAffectCiType(ciType string) {
+func handleAffectCiType(ciType string) (err error) {
 	log.Info(nil, log.LOGGER_APP, "start handle affect ci type autofill mode", zap.String("ciType", ciType))
-	queryRows, err := x.QueryString(fmt.Sprintf("select guid from `%s`", ciType))
-	if err != nil {
-		log.Error(nil, log.LOGGER_APP, "Try to handle affect ci type fail,query ci data error", zap.Error(err))
+	queryRows, queryErr := x.QueryString(fmt.Sprintf("select guid from `%s`", ciType))
+	if queryErr != nil {
+		err = fmt.Errorf("Try to handle affect ci type fail,query ci data error,%s ", queryErr.Error())
 		return
 	}
-	nowTime := time.Now().Format(models.DateTimeFormat)
+	var itemList []*workQueueItem
 	for _, row := range queryRows {
-		autofillAffectActionFunc(ciType, row["guid"], nowTime)
+		itemList = append(itemList, buildAutofillGuidWorkItem(ciType, row["guid"]))
 	}
+	err = enqueueWorkItem(itemList)
+	return
 }
 
 // 查询其它ci中自动填充用到该ciType的ci,比如说 A->B.b C->B.c,则查询出自动填充中用了ciType:B的 A[b],C[c]
@@ -1233,83 +1155,52 @@ func queryAutofillRightRows(sourceCiTypeId, targetCiTypeId, attr string, rowList
 	return
 }
 
-func StartConsumeUniquePathHandle() {
-	log.Info(nil, log.LOGGER_APP, "Start consume unique path trigger cron job")
-	for {
-		uniquePathList := <-uniquePathHandleChan
-		go consumeUniquePathHandle(uniquePathList)
-	}
-}
-
-func consumeUniquePathHandle(uniquePathList []*models.AutoActiveHandleParam) {
-	for _, uniquePathObj := range uniquePathList {
-		tmpInputData := []models.CiDataMapObj{}
-		guidList := []string{}
-		for _, v := range uniquePathObj.Data {
-			tmpInputData = append(tmpInputData, v)
-			guidList = append(guidList, v["guid"])
-		}
-		log.Info(nil, log.LOGGER_APP, "Start to active unique path handle", zap.Strings("guid", guidList), zap.String("operation", uniquePathObj.Operation))
-		handleParam := models.HandleCiDataParam{InputData: tmpInputData, CiTypeId: uniquePathObj.CiType, Operation: uniquePathObj.Operation, Operator: uniquePathObj.User, Roles: []string{}, Permission: false, FromCore: false, FromUniquePath: true}
-		_, _, err := HandleCiDataOperation(handleParam)
-		if err != nil {
-			log.Error(nil, log.LOGGER_APP, "Unique path handle fail", zap.Error(err))
-		}
+func consumeUniquePathHandle(uniquePathObj *models.AutoActiveHandleParam) (err error) {
+	tmpInputData := []models.CiDataMapObj{}
+	guidList := []string{}
+	for _, v := range uniquePathObj.Data {
+		tmpInputData = append(tmpInputData, v)
+		guidList = append(guidList, v["guid"])
 	}
-}
-
-func getLeftFilterResultList(left, operator, value string, rightValueList []string, filterMap map[string]string) (valueList []string, err error) {
-	log.Debug(nil, log.LOGGER_APP, "getLeftFilterResultList", zap.String("left before", left))
-	column := left[strings.LastIndex(left, "[")+1 : strings.LastIndex(left, "]")]
-	left = left[:strings.LastIndex(left, ":")] + fmt.Sprintf("[{%s}]", buildLeftExpressCondition(column, operator, value, rightValueList))
-	log.Debug(nil, log.LOGGER_APP, "getLeftFilterResultList", zap.String("left after", left))
-	valueList, err = getExpressResultList(left, "", filterMap, true)
+	log.Info(nil, log.LOGGER_APP, "Start to active unique path handle", zap.Strings("guid", guidList), zap.String("operation", uniquePathObj.Operation))
+	handleParam := models.HandleCiDataParam{InputData: tmpInputData, CiTypeId: uniquePathObj.CiType, Operation: uniquePathObj.Operation, Operator: uniquePathObj.User, Roles: []string{}, Permission: false, FromCore: false, FromUniquePath: true}
+	_, _, err = HandleCiDataOperation(handleParam)
 	if err != nil {
-		err = fmt.Errorf("Try to analyze filter left express fail,%s ", err.Error())
+		log.Error(nil, log.LOGGER_APP, "Unique path handle fail", zap.Error(err))
 	}
 	return
 }
 
-func buildLeftExpressCondition(column, operator, value string, valueList []string) string {
-	if operator == "in" && len(valueList) == 0 && value != "" {
-		valueList = strings.Split(strings.ReplaceAll(value[1:len(value)-1], "'", ""), ",")
+func getLeftFilterResultList(left, operator, value string, rightValueList []string, filterMap map[string]string) (valueList []string, err error) {
+	log.Debug(nil, log.LOGGER_APP, "getLeftFilterResultList", zap.String("left", left))
+	expr, parseErr := expression.Parse(left)
+	if parseErr != nil {
+		err = fmt.Errorf("Try to analyze filter left express fail,%s ", parseErr.Error())
+		return
 	}
-	value = strings.ReplaceAll(value, "'", "")
-	if !strings.Contains(column, "`") {
-		if pointIndex := strings.Index(column, "."); pointIndex > 0 {
-			column = fmt.Sprintf("%s.`%s`", column[:pointIndex], column[pointIndex+1:])
-		} else {
-			column = fmt.Sprintf("`%s`", column)
+	// 把终点ci的结果列换成过滤条件,查出满足条件的起点guid
+	lastSegment := expr.LastSegment()
+	if lastSegment.ResultColumn == "" {
+		err = fmt.Errorf("Filter left express:%s need a column like :[code] ", left)
+		return
+	}
+	filter := expression.Filter{Attr: lastSegment.ResultColumn, Operator: operator, HasValue: true, ValueList: rightValueList}
+	if len(rightValueList) > 0 {
+		filter.Value = rightValueList[0]
+	} else {
+		filter.Value = strings.ReplaceAll(value, "'", "")
+		if (operator == "in" || operator == "notIn") && strings.HasPrefix(filter.Value, "[") && strings.HasSuffix(filter.Value, "]") {
+			filter.ValueList = strings.Split(filter.Value[1:len(filter.Value)-1], ",")
 		}
 	}
-	var sql string
-	switch operator {
-	case "in":
-		sql = fmt.Sprintf("%s in ['%s']", column, strings.Join(valueList, "','"))
-		break
-	case "eq":
-		sql = fmt.Sprintf("%s %s '%s'", column, operator, value)
-		break
-	case "ne":
-		sql = fmt.Sprintf("%s %s '%s'", column, operator, value)
-		break
-	case "notNull":
-		sql = fmt.Sprintf("%s %s", column, operator)
-		break
-	case "null":
-		sql = fmt.Sprintf("%s %s", column, operator)
-		break
-	case "notEmpty":
-		sql = fmt.Sprintf("%s %s", column, operator)
-		break
-	case "empty":
-		sql = fmt.Sprintf("%s %s", column, operator)
-		break
-	case "like":
-		sql = fmt.Sprintf("%s %s '%s'", column, operator, value)
-		break
+	lastSegment.Filters = append(lastSegment.Filters, &filter)
+	lastSegment.ResultColumn = ""
+	log.Debug(nil, log.LOGGER_APP, "getLeftFilterResultList", zap.String("express", expr.String()))
+	valueList, err = queryExpressResult(expr, filterMap, true)
+	if err != nil {
+		err = fmt.Errorf("Try to analyze filter left express fail,%s ", err.Error())
 	}
-	return sql
+	return
 }
 
 func transStringToList(input string) (output []string) {
@@ -1326,19 +1217,22 @@ func transStringToList(input string) (output []string) {
 	return
 }
 
-func buildCalcFillValue(columnMap map[string]string, ruleList []*models.AutofillObj) (result float64, err error) {
+func buildCalcFillValue(columnMap map[string]string, ruleList []*models.AutofillObj, trace *autofillTrace) (result float64, err error) {
 	log.Debug(nil, log.LOGGER_APP, "-----start buildCalcFillValue columnMap", log.JsonObj("map", columnMap), log.JsonObj("ruleList", ruleList))
 	var lastCalcSymbol, lastCalcFunc string
 	var calcValue float64
 	for i, ruleObj := range ruleList {
 		// json结构表达式
 		tmpCalcValue := float64(0)
+		segment := trace.startSegment(i, ruleObj)
 		if ruleObj.Type == "rule" {
-			tmpValueList, _, tmpErr := getRuleValue(columnMap, ruleObj.Value)
+			tmpValueList, _, tmpErr := getRuleValue(columnMap, ruleObj.Value, trace)
 			if tmpErr != nil {
 				err = fmt.Errorf("Try to get autofill reference data with rule value:%s fail,%s ", ruleObj.Value, tmpErr.Error())
+				trace.finishSegment(segment, nil, err)
 				break
 			}
+			trace.finishSegment(segment, tmpValueList, nil)
 			if lastCalcFunc == "sum" {
 				for _, tmpValue := range tmpValueList {
 					tmpFloatValue, _ := strconv.ParseFloat(tmpValue, 64)
@@ -0,0 +1,399 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/expression"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+)
+
+// graphQueryHop 路径中的一段ci,在sql中的表别名为 t下标
+type graphQueryHop struct {
+	meta    *models.CiGraphQueryHopMeta
+	segment *expression.Segment
+	attrMap map[string]*models.SysCiTypeAttrTable
+	filters []*models.QueryRequestFilterObj
+}
+
+// QueryCiGraph 按路径模式把每一段ci编译成一条join查询,table按路径分页,nested按第一段ci分页后组装成树
+func QueryCiGraph(param *models.CiGraphQueryParam) (result *models.CiGraphQueryResult, err error) {
+	if param.ResultType == "" {
+		param.ResultType = models.GraphQueryResultTable
+	}
+	if param.ResultType != models.GraphQueryResultTable && param.ResultType != models.GraphQueryResultNested {
+		err = fmt.Errorf("ResultType:%s illegal,should be table or nested ", param.ResultType)
+		return
+	}
+	if param.Pageable == nil {
+		param.Pageable = &models.PageInfo{StartIndex: 0, PageSize: models.GraphQueryDefaultPageSize}
+	}
+	if param.Pageable.StartIndex < 0 || param.Pageable.PageSize <= 0 || param.Pageable.PageSize > models.GraphQueryMaxPageSize {
+		err = fmt.Errorf("Page size should be 1-%d ", models.GraphQueryMaxPageSize)
+		return
+	}
+	hops, err := buildGraphQueryHops(param)
+	if err != nil {
+		return
+	}
+	fromSql, filterSql, filterParams, err := compileGraphQuery(hops, param.Roles)
+	if err != nil {
+		return
+	}
+	result = &models.CiGraphQueryResult{PageInfo: models.PageInfo{StartIndex: param.Pageable.StartIndex, PageSize: param.Pageable.PageSize}, Contents: []map[string]interface{}{}}
+	for _, hop := range hops {
+		result.Hops = append(result.Hops, hop.meta)
+	}
+	if param.ResultType == models.GraphQueryResultTable {
+		err = queryGraphTable(hops, fromSql, filterSql, filterParams, result)
+	} else {
+		err = queryGraphNested(hops, fromSql, filterSql, filterParams, result)
+	}
+	return
+}
+
+func buildGraphQueryHops(param *models.CiGraphQueryParam) (hops []*graphQueryHop, err error) {
+	expr, parseErr := expression.ParsePattern(param.Pattern)
+	if parseErr != nil {
+		err = fmt.Errorf("Pattern:%s illegal,%s ", param.Pattern, parseErr.Error())
+		return
+	}
+	if len(expr.Segments) > models.GraphQueryMaxHops {
+		err = fmt.Errorf("Pattern:%s illegal,at most %d ci are allowed ", param.Pattern, models.GraphQueryMaxHops)
+		return
+	}
+	if len(param.Hops) > len(expr.Segments) {
+		err = fmt.Errorf("Hops length:%d is more than pattern ci num:%d ", len(param.Hops), len(expr.Segments))
+		return
+	}
+	provider := getExpressAttrProvider()
+	errList, err := resolveGraphPatternRefAttr(expr, provider)
+	if err != nil {
+		return
+	}
+	// 引用属性推断失败时不再校验,避免同一位置重复报错
+	if len(errList) == 0 {
+		if errList, err = expression.Validate(expr, provider); err != nil {
+			return
+		}
+	}
+	if len(errList) > 0 {
+		var messageList []string
+		for _, v := range errList {
+			messageList = append(messageList, v.Error())
+		}
+		err = fmt.Errorf("Pattern:%s illegal,%s ", param.Pattern, strings.Join(messageList, ";"))
+		return
+	}
+	aliasExist := make(map[string]bool)
+	for i, segment := range expr.Segments {
+		hopParam := &models.CiGraphQueryHopObj{}
+		if i < len(param.Hops) && param.Hops[i] != nil {
+			hopParam = param.Hops[i]
+		}
+		attrMap, _, _ := provider(segment.CiType)
+		hop := graphQueryHop{segment: segment, attrMap: attrMap, filters: hopParam.Filters,
+			meta: &models.CiGraphQueryHopMeta{Index: i, Alias: hopParam.Alias, CiType: segment.CiType, Columns: hopParam.Columns}}
+		if hop.meta.Alias == "" {
+			hop.meta.Alias = segment.CiType
+			if aliasExist[hop.meta.Alias] {
+				hop.meta.Alias = fmt.Sprintf("%s_%d", segment.CiType, i)
+			}
+		}
+		if !models.ValidateNormalString(hop.meta.Alias) || aliasExist[hop.meta.Alias] {
+			err = fmt.Errorf("Hop %d alias:%s illegal or duplicate ", i, hop.meta.Alias)
+			return
+		}
+		aliasExist[hop.meta.Alias] = true
+		if segment.Join == expression.JoinForward {
+			hop.meta.RefAttr = expr.Segments[i-1].CiType + "." + expr.Segments[i-1].Attr
+		} else if segment.Join == expression.JoinBackward {
+			hop.meta.RefAttr = segment.CiType + "." + segment.RefAttr
+		}
+		if len(hop.meta.Columns) == 0 {
+			hop.meta.Columns = []string{"guid", "key_name"}
+		}
+		if segment.ResultColumn != "" && !isGraphQueryColumnExist(hop.meta.Columns, segment.ResultColumn) {
+			hop.meta.Columns = append(hop.meta.Columns, segment.ResultColumn)
+		}
+		for _, column := range hop.meta.Columns {
+			attr, b := attrMap[column]
+			if !b {
+				err = fmt.Errorf("Hop %d column:%s is not exist in ciType:%s ", i, column, segment.CiType)
+				return
+			}
+			if attr.InputType == models.MultiRefType {
+				err = fmt.Errorf("Hop %d column:%s is multiRef attr,please add it to pattern as a hop ", i, column)
+				return
+			}
+		}
+		filterAttrList := []string{}
+		for _, filter := range hop.filters {
+			filterAttrList = append(filterAttrList, filter.Name)
+		}
+		for _, filter := range segment.Filters {
+			filterAttrList = append(filterAttrList, filter.Attr)
+		}
+		for _, attrName := range filterAttrList {
+			if err = checkGraphQueryFilterAttr(i, attrMap[attrName], attrName, segment.CiType, param.Roles); err != nil {
+				return
+			}
+		}
+		hops = append(hops, &hop)
+	}
+	return
+}
+
+// checkGraphQueryFilterAttr 多选引用的值不在本表,不能直接过滤;密码与敏感属性可以用过滤条件逐步猜出值,要求有该属性的查询权限
+func checkGraphQueryFilterAttr(index int, attr *models.SysCiTypeAttrTable, attrName, ciType string, roles []string) error {
+	if attr == nil {
+		return fmt.Errorf("Hop %d filter attr:%s is not exist in ciType:%s ", index, attrName, ciType)
+	}
+	if attr.InputType == models.MultiRefType {
+		return fmt.Errorf("Hop %d filter attr:%s is multiRef attr,please add it to pattern as a hop ", index, attrName)
+	}
+	if attr.InputType != models.PasswordInputType && attr.Sensitive != "yes" {
+		return nil
+	}
+	legalAll, _, err := ValidateCiDataPermission(roles, ciType, attr.Id, models.DataActionQuery)
+	if err != nil {
+		return err
+	}
+	if !legalAll {
+		return fmt.Errorf("Hop %d filter attr:%s permission deny ", index, attrName)
+	}
+	return nil
+}
+
+// resolveGraphPatternRefAttr 模式中>前省略的引用属性,取上一段ci指向下一段ci的唯一引用属性
+func resolveGraphPatternRefAttr(expr *expression.Expression, provider expression.AttrProvider) (errList []*expression.Error, err error) {
+	for i := 1; i < len(expr.Segments); i++ {
+		lastSegment, segment := expr.Segments[i-1], expr.Segments[i]
+		if segment.Join != expression.JoinForward || lastSegment.Attr != "" {
+			continue
+		}
+		attrMap, exist, queryErr := provider(lastSegment.CiType)
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		if !exist {
+			continue
+		}
+		var matchList []string
+		for name, attr := range attrMap {
+			if (attr.InputType == "ref" || attr.InputType == models.MultiRefType) && attr.RefCiType == segment.CiType {
+				matchList = append(matchList, name)
+			}
+		}
+		sort.Strings(matchList)
+		if len(matchList) == 1 {
+			lastSegment.Attr = matchList[0]
+		} else if len(matchList) == 0 {
+			errList = append(errList, &expression.Error{Pos: segment.Pos, Message: fmt.Sprintf("ciType '%s' has no reference attribute to '%s'", lastSegment.CiType, segment.CiType)})
+		} else {
+			errList = append(errList, &expression.Error{Pos: segment.Pos, Message: fmt.Sprintf("ciType '%s' has more than one reference attribute to '%s': %s, please use %s.attr", lastSegment.CiType, segment.CiType, strings.Join(matchList, ","), lastSegment.CiType)})
+		}
+	}
+	return
+}
+
+func isGraphQueryColumnExist(columns []string, column string) bool {
+	for _, v := range columns {
+		if v == column {
+			return true
+		}
+	}
+	return false
+}
+
+// compileGraphQuery 生成join与过滤条件,多选引用经过 ciType$attr 中间表join,没有全部查询权限的ci按可查guid过滤
+func compileGraphQuery(hops []*graphQueryHop, roles []string) (fromSql, filterSql string, filterParams []interface{}, err error) {
+	fromSql = fmt.Sprintf("`%s` t0", hops[0].meta.CiType)
+	for i := 1; i < len(hops); i++ {
+		lastSegment, segment := hops[i-1].segment, hops[i].segment
+		if segment.Join == expression.JoinForward {
+			attr := hops[i-1].attrMap[lastSegment.Attr]
+			if attr.InputType == models.MultiRefType {
+				fromSql += fmt.Sprintf(" join `%s$%s` m%d on m%d.from_guid=t%d.guid join `%s` t%d on t%d.guid=m%d.to_guid", lastSegment.CiType, attr.Name, i, i, i-1, segment.CiType, i, i, i)
+			} else {
+				fromSql += fmt.Sprintf(" join `%s` t%d on t%d.guid=t%d.`%s`", segment.CiType, i, i, i-1, attr.Name)
+			}
+		} else {
+			attr := hops[i].attrMap[segment.RefAttr]
+			if attr.InputType == models.MultiRefType {
+				fromSql += fmt.Sprintf(" join `%s$%s` m%d on m%d.to_guid=t%d.guid join `%s` t%d on t%d.guid=m%d.from_guid", segment.CiType, attr.Name, i, i, i-1, segment.CiType, i, i, i)
+			} else {
+				fromSql += fmt.Sprintf(" join `%s` t%d on t%d.`%s`=t%d.guid", segment.CiType, i, i, attr.Name, i-1)
+			}
+		}
+	}
+	legalGuidMap := make(map[string][]string)
+	for i, hop := range hops {
+		for _, filter := range hop.segment.Filters {
+			tmpSql, tmpParams, tmpErr := buildExpressFilterSql(fmt.Sprintf("t%d.`%s`", i, filter.Attr), filter)
+			if tmpErr != nil {
+				err = tmpErr
+				return
+			}
+			filterSql += " AND " + tmpSql
+			filterParams = append(filterParams, tmpParams...)
+		}
+		keyMap := make(map[string]string)
+		for name := range hop.attrMap {
+			keyMap[name] = name
+		}
+		transParam := models.TransFiltersParam{Prefix: fmt.Sprintf("t%d.", i), KeyMap: keyMap}
+		for _, filter := range hop.filters {
+			if tmpSql, tmpParams := buildFilterConditionSql(filter, &transParam); tmpSql != "" {
+				filterSql += " AND " + tmpSql
+				filterParams = append(filterParams, tmpParams...)
+			}
+		}
+		legalGuidList, b := legalGuidMap[hop.meta.CiType]
+		if !b {
+			legalAll, tmpGuidList, permissionErr := ValidateCiDataPermission(roles, hop.meta.CiType, "", models.DataActionQuery)
+			if permissionErr != nil {
+				err = permissionErr
+				return
+			}
+			if !legalAll {
+				legalGuidList = tmpGuidList
+				if legalGuidList == nil {
+					legalGuidList = []string{}
+				}
+			}
+			legalGuidMap[hop.meta.CiType] = legalGuidList
+		}
+		if legalGuidList != nil {
+			specSql, specParams := createListParams(legalGuidList, "")
+			if specSql == "" {
+				specSql = "''"
+			}
+			filterSql += fmt.Sprintf(" AND t%d.guid in (%s)", i, specSql)
+			filterParams = append(filterParams, specParams...)
+		}
+	}
+	return
+}
+
+func buildGraphQuerySelectSql(hops []*graphQueryHop) string {
+	var columnList []string
+	for i, hop := range hops {
+		columnList = append(columnList, fmt.Sprintf("t%d.guid as `_guid_%d`", i, i))
+		for _, column := range hop.meta.Columns {
+			columnList = append(columnList, fmt.Sprintf("t%d.`%s` as `%s.%s`", i, column, hop.meta.Alias, column))
+		}
+	}
+	return strings.Join(columnList, ",")
+}
+
+func buildGraphQueryOrderSql(hops []*graphQueryHop) string {
+	var orderList []string
+	for i := range hops {
+		orderList = append(orderList, fmt.Sprintf("t%d.guid", i))
+	}
+	return " order by " + strings.Join(orderList, ",")
+}
+
+// graphQueryString 查询加上执行时间上限,避免路径过宽拖垮数据库
+func graphQueryString(sql string, params []interface{}) (queryRows []map[string]string, err error) {
+	sql = fmt.Sprintf("select /*+ MAX_EXECUTION_TIME(%d) */ %s", models.GraphQueryTimeoutMs, sql)
+	queryRows, err = x.QueryString(append([]interface{}{sql}, params...)...)
+	if err != nil {
+		err = fmt.Errorf("Try to query graph data fail,%s ", err.Error())
+	}
+	return
+}
+
+func queryGraphTable(hops []*graphQueryHop, fromSql, filterSql string, filterParams []interface{}, result *models.CiGraphQueryResult) (err error) {
+	countRows, err := graphQueryString(fmt.Sprintf("count(1) as num from %s where 1=1 %s", fromSql, filterSql), filterParams)
+	if err != nil {
+		return
+	}
+	if len(countRows) > 0 {
+		result.PageInfo.TotalRows, _ = strconv.Atoi(countRows[0]["num"])
+	}
+	pageSql, pageParams := transPageInfoToSQL(result.PageInfo)
+	queryRows, err := graphQueryString(fmt.Sprintf("%s from %s where 1=1 %s %s %s", buildGraphQuerySelectSql(hops), fromSql, filterSql, buildGraphQueryOrderSql(hops), pageSql), append(filterParams, pageParams...))
+	if err != nil {
+		return
+	}
+	for _, row := range queryRows {
+		rowData := make(map[string]interface{})
+		for _, hop := range hops {
+			for _, column := range hop.meta.Columns {
+				key := hop.meta.Alias + "." + column
+				rowData[key] = maskGraphQueryValue(hop.attrMap[column], row[key])
+			}
+		}
+		result.Contents = append(result.Contents, rowData)
+	}
+	return
+}
+
+// queryGraphNested 先按第一段ci分页取guid,再取这些guid下的全部路径组装成树,路径数超过上限时截断
+func queryGraphNested(hops []*graphQueryHop, fromSql, filterSql string, filterParams []interface{}, result *models.CiGraphQueryResult) (err error) {
+	countRows, err := graphQueryString(fmt.Sprintf("count(distinct t0.guid) as num from %s where 1=1 %s", fromSql, filterSql), filterParams)
+	if err != nil {
+		return
+	}
+	if len(countRows) > 0 {
+		result.PageInfo.TotalRows, _ = strconv.Atoi(countRows[0]["num"])
+	}
+	pageSql, pageParams := transPageInfoToSQL(result.PageInfo)
+	rootRows, err := graphQueryString(fmt.Sprintf("distinct t0.guid from %s where 1=1 %s order by t0.guid %s", fromSql, filterSql, pageSql), append(filterParams, pageParams...))
+	if err != nil || len(rootRows) == 0 {
+		return
+	}
+	var rootGuidList []string
+	for _, row := range rootRows {
+		rootGuidList = append(rootGuidList, row["guid"])
+	}
+	rootSpecSql, rootParams := createListParams(rootGuidList, "")
+	queryParams := append(append([]interface{}{}, filterParams...), rootParams...)
+	queryParams = append(queryParams, models.GraphQueryMaxNestedRows+1)
+	queryRows, err := graphQueryString(fmt.Sprintf("%s from %s where 1=1 %s AND t0.guid in (%s) %s limit ?", buildGraphQuerySelectSql(hops), fromSql, filterSql, rootSpecSql, buildGraphQueryOrderSql(hops)), queryParams)
+	if err != nil {
+		return
+	}
+	if len(queryRows) > models.GraphQueryMaxNestedRows {
+		queryRows = queryRows[:models.GraphQueryMaxNestedRows]
+		result.Truncated = true
+	}
+	nodeMap := make(map[string]map[string]interface{})
+	for _, row := range queryRows {
+		var pathKey string
+		var parentNode map[string]interface{}
+		for i, hop := range hops {
+			pathKey += "/" + row[fmt.Sprintf("_guid_%d", i)]
+			node, b := nodeMap[pathKey]
+			if !b {
+				node = make(map[string]interface{})
+				for _, column := range hop.meta.Columns {
+					node[column] = maskGraphQueryValue(hop.attrMap[column], row[hop.meta.Alias+"."+column])
+				}
+				if i < len(hops)-1 {
+					node[hops[i+1].meta.Alias] = []map[string]interface{}{}
+				}
+				nodeMap[pathKey] = node
+				if parentNode == nil {
+					result.Contents = append(result.Contents, node)
+				} else {
+					parentNode[hop.meta.Alias] = append(parentNode[hop.meta.Alias].([]map[string]interface{}), node)
+				}
+			}
+			parentNode = node
+		}
+	}
+	return
+}
+
+func maskGraphQueryValue(attr *models.SysCiTypeAttrTable, value string) string {
+	if value != "" && (attr.InputType == models.PasswordInputType || attr.Sensitive == "yes") {
+		return models.PasswordDisplay
+	}
+	return value
+}
@@ -0,0 +1,323 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+)
+
+const impactQueryBatchSize = 500
+
+// impactLink 一条引用关系,source是本跳出发的一端,neighbor是本跳新走到的一端
+type impactLink struct {
+	From           string
+	To             string
+	Source         string
+	Attr           *models.SysCiTypeAttrTable
+	Neighbor       string
+	NeighborCiType string
+	Direction      string
+}
+
+// ciImpactWalker 按跳数逐层遍历数据引用,每层按ci类型和属性批量查询
+type ciImpactWalker struct {
+	param         *models.CiImpactParam
+	outAttrMap    map[string][]*models.SysCiTypeAttrTable
+	inAttrMap     map[string][]*models.SysCiTypeAttrTable
+	ciTypeNameMap map[string]string
+	stateMachine  map[string]string
+	ciTypeFilter  map[string]bool
+	attrFilter    map[string]bool
+	stateFilter   map[string]bool
+	legalGuidMap  map[string]map[string]bool
+	nodeMap       map[string]*models.CiImpactNodeObj
+	parentMap     map[string]string
+	edgeMap       map[string]bool
+	result        *models.CiImpactResult
+}
+
+// QueryCiImpact 从一行数据出发沿ref与multiRef引用向上下游遍历,返回可见的数据节点和引用边
+// 遍历不进入被过滤掉或没有查询权限的数据,已访问过的数据只记录边不再继续遍历,边指回当前路径上的祖先时标记为环
+func QueryCiImpact(param *models.CiImpactParam) (result *models.CiImpactResult, err error) {
+	rootCiType, err := getCiTypeByGuid(param.Guid)
+	if err != nil {
+		return
+	}
+	walker, err := newCiImpactWalker(param)
+	if err != nil {
+		return
+	}
+	if _, b := walker.ciTypeNameMap[rootCiType]; !b {
+		err = fmt.Errorf("Can not find ciType:%s ", rootCiType)
+		return
+	}
+	legalMap, err := walker.getLegalGuidMap(rootCiType)
+	if err != nil {
+		return
+	}
+	if legalMap != nil && !legalMap[param.Guid] {
+		err = fmt.Errorf("Row:%s permission deny ", param.Guid)
+		return
+	}
+	if err = walker.loadNodes(rootCiType, []string{param.Guid}, 0, "", true); err != nil {
+		return
+	}
+	if _, b := walker.nodeMap[param.Guid]; !b {
+		err = fmt.Errorf("Can not find ci data with guid:%s ", param.Guid)
+		return
+	}
+	frontier := make(map[string]map[string][]string)
+	if param.Direction == models.ImpactDirectionBoth {
+		frontier[models.ImpactDirectionUpstream] = map[string][]string{rootCiType: {param.Guid}}
+		frontier[models.ImpactDirectionDownstream] = map[string][]string{rootCiType: {param.Guid}}
+	} else {
+		frontier[param.Direction] = map[string][]string{rootCiType: {param.Guid}}
+	}
+	for depth := 1; depth <= param.Depth && len(frontier) > 0 && !walker.result.Truncated; depth++ {
+		if frontier, err = walker.walk(frontier, depth); err != nil {
+			return
+		}
+	}
+	result = walker.result
+	return
+}
+
+func newCiImpactWalker(param *models.CiImpactParam) (walker *ciImpactWalker, err error) {
+	walker = &ciImpactWalker{param: param, outAttrMap: make(map[string][]*models.SysCiTypeAttrTable), inAttrMap: make(map[string][]*models.SysCiTypeAttrTable),
+		ciTypeNameMap: make(map[string]string), stateMachine: make(map[string]string), ciTypeFilter: buildImpactFilter(param.CiTypes), attrFilter: buildImpactFilter(param.Attrs), stateFilter: buildImpactFilter(param.States),
+		legalGuidMap: make(map[string]map[string]bool), nodeMap: make(map[string]*models.CiImpactNodeObj), parentMap: make(map[string]string), edgeMap: make(map[string]bool),
+		result: &models.CiImpactResult{Root: param.Guid, Direction: param.Direction, Depth: param.Depth, Nodes: []*models.CiImpactNodeObj{}, Edges: []*models.CiImpactEdgeObj{}}}
+	ciTypeRows, queryErr := x.QueryString("select id,display_name,state_machine from sys_ci_type where status='created'")
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query ciType fail,%s ", queryErr.Error())
+		return
+	}
+	for _, row := range ciTypeRows {
+		walker.ciTypeNameMap[row["id"]] = row["display_name"]
+		walker.stateMachine[row["id"]] = row["state_machine"]
+	}
+	var refAttrs []*models.SysCiTypeAttrTable
+	err = x.SQL("select t1.id,t1.ci_type,t1.name,t1.display_name,t1.input_type,t1.ref_ci_type from sys_ci_type_attr t1 left join sys_ci_type t2 on t1.ci_type=t2.id where t1.status='created' and t2.status='created' and t1.input_type in ('ref',?) and t1.ref_ci_type<>''",
+		models.MultiRefType).Find(&refAttrs)
+	if err != nil {
+		err = fmt.Errorf("Try to query reference attributes fail,%s ", err.Error())
+		return
+	}
+	for _, attr := range refAttrs {
+		if len(walker.attrFilter) > 0 && !walker.attrFilter[attr.Id] && !walker.attrFilter[attr.Name] {
+			continue
+		}
+		if _, b := walker.ciTypeNameMap[attr.RefCiType]; !b {
+			continue
+		}
+		walker.outAttrMap[attr.CiType] = append(walker.outAttrMap[attr.CiType], attr)
+		walker.inAttrMap[attr.RefCiType] = append(walker.inAttrMap[attr.RefCiType], attr)
+	}
+	return
+}
+
+func buildImpactFilter(inputList []string) map[string]bool {
+	filterMap := make(map[string]bool)
+	for _, v := range inputList {
+		if v = strings.TrimSpace(v); v != "" {
+			filterMap[v] = true
+		}
+	}
+	return filterMap
+}
+
+// walk 走一跳,返回下一跳要继续的数据
+func (w *ciImpactWalker) walk(frontier map[string]map[string][]string, depth int) (nextFrontier map[string]map[string][]string, err error) {
+	var links []*impactLink
+	for _, direction := range []string{models.ImpactDirectionUpstream, models.ImpactDirectionDownstream} {
+		typeGuidMap := frontier[direction]
+		for _, ciType := range sortedImpactKeys(typeGuidMap) {
+			attrMap := w.outAttrMap
+			if direction == models.ImpactDirectionDownstream {
+				attrMap = w.inAttrMap
+			}
+			for _, attr := range attrMap[ciType] {
+				attrLinks, queryErr := queryImpactLinks(attr, typeGuidMap[ciType], direction)
+				if queryErr != nil {
+					err = queryErr
+					return
+				}
+				links = append(links, attrLinks...)
+			}
+		}
+	}
+	// 本跳新走到的数据按ci类型批量加载,过滤后放入节点
+	newGuidMap := make(map[string]map[string][]string)
+	newGuidExist := make(map[string]bool)
+	for _, link := range links {
+		if _, b := w.nodeMap[link.Neighbor]; b || newGuidExist[link.Neighbor] {
+			continue
+		}
+		newGuidExist[link.Neighbor] = true
+		if _, b := newGuidMap[link.Direction]; !b {
+			newGuidMap[link.Direction] = make(map[string][]string)
+		}
+		newGuidMap[link.Direction][link.NeighborCiType] = append(newGuidMap[link.Direction][link.NeighborCiType], link.Neighbor)
+	}
+	for _, direction := range []string{models.ImpactDirectionUpstream, models.ImpactDirectionDownstream} {
+		for _, ciType := range sortedImpactKeys(newGuidMap[direction]) {
+			if err = w.loadNodes(ciType, newGuidMap[direction][ciType], depth, direction, false); err != nil {
+				return
+			}
+		}
+	}
+	nextFrontier = make(map[string]map[string][]string)
+	nextGuidExist := make(map[string]bool)
+	for _, link := range links {
+		node, b := w.nodeMap[link.Neighbor]
+		if !b {
+			continue
+		}
+		edgeKey := link.From + "^" + link.Attr.Id + "^" + link.To
+		if w.edgeMap[edgeKey] {
+			continue
+		}
+		w.edgeMap[edgeKey] = true
+		isNew := newGuidExist[link.Neighbor] && node.Depth == depth
+		w.result.Edges = append(w.result.Edges, &models.CiImpactEdgeObj{From: link.From, To: link.To, Attr: link.Attr.Id, AttrName: link.Attr.DisplayName, InputType: link.Attr.InputType,
+			Cycle: !isNew && w.isAncestor(link.Neighbor, link.Source)})
+		if isNew && node.Direction == link.Direction && !nextGuidExist[node.Guid] {
+			nextGuidExist[node.Guid] = true
+			w.parentMap[node.Guid] = link.Source
+			if _, b := nextFrontier[node.Direction]; !b {
+				nextFrontier[node.Direction] = make(map[string][]string)
+			}
+			nextFrontier[node.Direction][node.CiType] = append(nextFrontier[node.Direction][node.CiType], node.Guid)
+		}
+	}
+	return
+}
+
+// isAncestor 判断guid是否在从根走到current的路径上(含current本身)
+func (w *ciImpactWalker) isAncestor(guid, current string) bool {
+	for current != "" {
+		if current == guid {
+			return true
+		}
+		current = w.parentMap[current]
+	}
+	return false
+}
+
+// queryImpactLinks upstream查本行引用的数据,downstream查引用本行的数据,From始终是引用方
+func queryImpactLinks(attr *models.SysCiTypeAttrTable, guidList []string, direction string) (links []*impactLink, err error) {
+	for start := 0; start < len(guidList); start += impactQueryBatchSize {
+		end := start + impactQueryBatchSize
+		if end > len(guidList) {
+			end = len(guidList)
+		}
+		specSql, params := createListParams(guidList[start:end], "")
+		var querySql string
+		if attr.InputType == models.MultiRefType {
+			matchColumn := "from_guid"
+			if direction == models.ImpactDirectionDownstream {
+				matchColumn = "to_guid"
+			}
+			querySql = fmt.Sprintf("select from_guid as guid,to_guid as ref_guid from `%s$%s` where %s in (%s)", attr.CiType, attr.Name, matchColumn, specSql)
+		} else {
+			matchColumn := "guid"
+			if direction == models.ImpactDirectionDownstream {
+				matchColumn = "`" + attr.Name + "`"
+			}
+			querySql = fmt.Sprintf("select guid,`%s` as ref_guid from `%s` where %s in (%s) and `%s`<>''", attr.Name, attr.CiType, matchColumn, specSql, attr.Name)
+		}
+		queryRows, queryErr := x.QueryString(append([]interface{}{querySql}, params...)...)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to query reference data of attr:%s fail,%s ", attr.Id, queryErr.Error())
+			return
+		}
+		for _, row := range queryRows {
+			if row["ref_guid"] == "" {
+				continue
+			}
+			link := impactLink{From: row["guid"], To: row["ref_guid"], Attr: attr, Direction: direction}
+			if direction == models.ImpactDirectionUpstream {
+				link.Source, link.Neighbor, link.NeighborCiType = row["guid"], row["ref_guid"], attr.RefCiType
+			} else {
+				link.Source, link.Neighbor, link.NeighborCiType = row["ref_guid"], row["guid"], attr.CiType
+			}
+			links = append(links, &link)
+		}
+	}
+	return
+}
+
+// loadNodes 加载数据并按ci类型、状态、查询权限过滤,超过节点上限时标记截断
+func (w *ciImpactWalker) loadNodes(ciType string, guidList []string, depth int, direction string, isRoot bool) (err error) {
+	if !isRoot && len(w.ciTypeFilter) > 0 && !w.ciTypeFilter[ciType] {
+		return
+	}
+	legalMap, err := w.getLegalGuidMap(ciType)
+	if err != nil {
+		return
+	}
+	for start := 0; start < len(guidList); start += impactQueryBatchSize {
+		end := start + impactQueryBatchSize
+		if end > len(guidList) {
+			end = len(guidList)
+		}
+		specSql, params := createListParams(guidList[start:end], "")
+		// 数据的state字段存的是状态名称,要按ci类型的状态机才能对应到sys_state
+		querySql := fmt.Sprintf("select t1.guid,t1.key_name,t1.state,t2.id as state_id from `%s` t1 left join sys_state t2 on t2.state_machine=? and t2.name=t1.state where t1.guid in (%s) order by t1.guid", ciType, specSql)
+		queryRows, queryErr := x.QueryString(append([]interface{}{querySql, w.stateMachine[ciType]}, params...)...)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to query ciType:%s data fail,%s ", ciType, queryErr.Error())
+			return
+		}
+		for _, row := range queryRows {
+			if legalMap != nil && !legalMap[row["guid"]] {
+				continue
+			}
+			if !isRoot && len(w.stateFilter) > 0 && !w.stateFilter[row["state_id"]] && !w.stateFilter[row["state"]] {
+				continue
+			}
+			if len(w.nodeMap) >= w.param.Limit {
+				w.result.Truncated = true
+				return
+			}
+			if row["state_id"] == "" {
+				row["state_id"] = row["state"]
+			}
+			node := models.CiImpactNodeObj{Guid: row["guid"], CiType: ciType, CiTypeName: w.ciTypeNameMap[ciType], KeyName: row["key_name"], State: row["state_id"],
+				StateName: row["state"], Depth: depth, Direction: direction}
+			w.nodeMap[node.Guid] = &node
+			w.result.Nodes = append(w.result.Nodes, &node)
+		}
+	}
+	return
+}
+
+// getLegalGuidMap 返回nil表示该ci类型的数据全部可查
+func (w *ciImpactWalker) getLegalGuidMap(ciType string) (legalMap map[string]bool, err error) {
+	if cacheMap, b := w.legalGuidMap[ciType]; b {
+		return cacheMap, nil
+	}
+	legalAll, legalGuidList, err := ValidateCiDataPermission(w.param.Roles, ciType, "", models.DataActionQuery)
+	if err != nil {
+		return
+	}
+	if !legalAll {
+		legalMap = make(map[string]bool)
+		for _, v := range legalGuidList {
+			legalMap[v] = true
+		}
+	}
+	w.legalGuidMap[ciType] = legalMap
+	return
+}
+
+func sortedImpactKeys(input map[string][]string) []string {
+	keys := []string{}
+	for k := range input {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
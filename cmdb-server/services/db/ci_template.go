@@ -0,0 +1,277 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+)
+
+var (
+	// ciTemplateNullColumns 模板属性中有外键或允许为空的列,空值存成NULL
+	ciTemplateNullColumns = map[string]string{"ref_ci_type": "", "ref_type": "", "select_list": ""}
+	// ciTemplatePropagateColumns 同步到已有ci属性的元数据列,不包括字段排序
+	ciTemplatePropagateColumns = []string{"display_name", "description", "text_validate", "ref_name", "ref_filter", "ref_update_state_validate", "ref_confirm_state_validate",
+		"select_list", "ui_search_order", "ui_nullable", "nullable", "editable", "display_by_default", "permission_usage", "reset_on_edit", "customizable",
+		"autofillable", "autofill_rule", "autofill_type", "edit_group_control", "edit_group_value", "ext_ref_entity", "confirm_nullable", "sensitive"}
+	// ciTemplateStructColumns 影响表结构的列,只能同步到还没创建的属性
+	ciTemplateStructColumns = []string{"input_type", "data_type", "data_length", "ref_ci_type", "ref_type", "unique_constraint"}
+)
+
+func GetCiTemplateDetail(templateId string) (result *models.CiTemplateObj, err error) {
+	var templateRows []*models.SysCiTemplateTable
+	err = x.SQL("select * from sys_ci_template where id=?", templateId).Find(&templateRows)
+	if err != nil {
+		err = fmt.Errorf("Try to get ci template:%s fail,%s ", templateId, err.Error())
+		return
+	}
+	if len(templateRows) == 0 {
+		err = fmt.Errorf("Can not find ci template with id:%s ", templateId)
+		return
+	}
+	result = &models.CiTemplateObj{Id: templateRows[0].Id, Description: templateRows[0].Description, ImageFile: templateRows[0].ImageFile, StateMachine: templateRows[0].StateMachine, Attributes: []*models.SysCiTemplateAttrTable{}, CiTypes: []string{}}
+	err = x.SQL("select * from sys_ci_template_attr where ci_template=? order by ui_form_order", templateId).Find(&result.Attributes)
+	if err != nil {
+		err = fmt.Errorf("Try to get ci template attribute fail,%s ", err.Error())
+		return
+	}
+	result.CiTypes, err = getCiTemplateDerivedCiTypes(templateId)
+	return
+}
+
+func getCiTemplateDerivedCiTypes(templateId string) (ciTypeList []string, err error) {
+	ciTypeList = []string{}
+	var ciTypeRows []*models.SysCiTypeTable
+	err = x.SQL("select id from sys_ci_type where ci_template=? and status<>'deleted' order by id", templateId).Find(&ciTypeRows)
+	if err != nil {
+		err = fmt.Errorf("Try to get ci type with template:%s fail,%s ", templateId, err.Error())
+		return
+	}
+	for _, row := range ciTypeRows {
+		ciTypeList = append(ciTypeList, row.Id)
+	}
+	return
+}
+
+func CreateCiTemplate(param *models.SysCiTemplateTable) (err error) {
+	if !stateMachineNameRegexp.MatchString(param.Id) || len(param.Id) > 32 {
+		err = fmt.Errorf("Ci template id:%s illegal,only support [a-zA-Z0-9_] and max length is 32 ", param.Id)
+		return
+	}
+	if param.StateMachine == "" {
+		err = fmt.Errorf("Ci template state machine can not empty ")
+		return
+	}
+	_, err = x.Exec("insert into sys_ci_template(id,description,image_file,state_machine) value (?,?,?,?)", param.Id, param.Description, NewNullString(param.ImageFile), param.StateMachine)
+	if err != nil {
+		err = fmt.Errorf("Try to create ci template:%s fail,%s ", param.Id, err.Error())
+	}
+	return
+}
+
+// UpdateCiTemplate 修改模板的状态机不会影响已经创建的ci类型
+func UpdateCiTemplate(param *models.SysCiTemplateTable) (err error) {
+	_, err = x.Exec("update sys_ci_template set description=?,image_file=?,state_machine=? where id=?", param.Description, NewNullString(param.ImageFile), param.StateMachine, param.Id)
+	if err != nil {
+		err = fmt.Errorf("Try to update ci template:%s fail,%s ", param.Id, err.Error())
+	}
+	return
+}
+
+func DeleteCiTemplate(templateId string) (err error) {
+	ciTypeList, err := getCiTemplateDerivedCiTypes(templateId)
+	if err != nil {
+		return
+	}
+	if len(ciTypeList) > 0 {
+		err = fmt.Errorf("Ci template:%s is used by ci type:%s ", templateId, strings.Join(ciTypeList, ","))
+		return
+	}
+	var actions []*execAction
+	actions = append(actions, &execAction{Sql: "delete from sys_ci_template_attr where ci_template=?", Param: []interface{}{templateId}})
+	actions = append(actions, &execAction{Sql: "delete from sys_ci_template where id=?", Param: []interface{}{templateId}})
+	if err = transaction(actions); err != nil {
+		err = fmt.Errorf("Try to delete ci template:%s fail,%s ", templateId, err.Error())
+	}
+	return
+}
+
+func buildCiTemplateAttrParam(param *models.SysCiTemplateAttrTable) (err error) {
+	if !stateMachineNameRegexp.MatchString(param.Name) || strings.ToLower(param.Name) == "id" {
+		err = fmt.Errorf("Attribute name:%s is illegal ", param.Name)
+		return
+	}
+	if param.DisplayName == "" || param.InputType == "" || param.DataType == "" {
+		err = fmt.Errorf("Attribute displayName,inputType and dataType can not empty ")
+		return
+	}
+	param.Id = param.CiTemplate + models.SysTableIdConnector + param.Name
+	if strings.Contains(param.DataType, "(") {
+		tmpDataType := param.DataType[:strings.Index(param.DataType, "(")]
+		param.DataLength, _ = strconv.Atoi(param.DataType[strings.Index(param.DataType, "(")+1 : len(param.DataType)-1])
+		param.DataType = tmpDataType
+	}
+	param.Status = "notCreated"
+	param.Source = "template"
+	if param.Customizable == "" {
+		param.Customizable = "yes"
+	}
+	if param.EditGroupControl == "" {
+		param.EditGroupControl = "no"
+	}
+	if param.ConfirmNullable == "" {
+		param.ConfirmNullable = "yes"
+	}
+	if param.Sensitive == "" {
+		param.Sensitive = "no"
+	}
+	return
+}
+
+func CreateCiTemplateAttr(param *models.SysCiTemplateAttrTable) (err error) {
+	if err = buildCiTemplateAttrParam(param); err != nil {
+		return
+	}
+	action, _ := GetInsertTableExecAction("sys_ci_template_attr", *param, ciTemplateNullColumns)
+	if err = transaction([]*execAction{action}); err != nil {
+		err = fmt.Errorf("Try to create ci template attribute:%s fail,%s ", param.Id, err.Error())
+	}
+	return
+}
+
+func UpdateCiTemplateAttr(param *models.SysCiTemplateAttrTable) (err error) {
+	if err = buildCiTemplateAttrParam(param); err != nil {
+		return
+	}
+	action, _ := GetUpdateTableExecAction("sys_ci_template_attr", "id", param.Id, *param, ciTemplateNullColumns)
+	if err = transaction([]*execAction{action}); err != nil {
+		err = fmt.Errorf("Try to update ci template attribute:%s fail,%s ", param.Id, err.Error())
+	}
+	return
+}
+
+// DeleteCiTemplateAttr 只删除模板属性,已经继承该属性的ci类型不受影响
+func DeleteCiTemplateAttr(templateId, attrId string) (err error) {
+	_, err = x.Exec("delete from sys_ci_template_attr where id=? and ci_template=?", attrId, templateId)
+	if err != nil {
+		err = fmt.Errorf("Try to delete ci template attribute:%s fail,%s ", attrId, err.Error())
+	}
+	return
+}
+
+// PlanCiTemplatePropagate 对比模板属性与各ci类型的属性,缺少的属性新增,模板来源的属性按模板更新
+func PlanCiTemplatePropagate(templateId string, param *models.CiTemplatePropagateParam) (plan *models.CiTemplatePropagatePlan, actions []*execAction, err error) {
+	templateObj, err := GetCiTemplateDetail(templateId)
+	if err != nil {
+		return
+	}
+	plan = &models.CiTemplatePropagatePlan{CiTemplate: templateId, CiTypes: []*models.CiTemplatePropagateCiObj{}}
+	ciTypeList := templateObj.CiTypes
+	if len(param.CiTypes) > 0 {
+		derivedMap := make(map[string]bool)
+		for _, ciType := range templateObj.CiTypes {
+			derivedMap[ciType] = true
+		}
+		for _, ciType := range param.CiTypes {
+			if !derivedMap[ciType] {
+				err = fmt.Errorf("Ci type:%s is not created by template:%s ", ciType, templateId)
+				return
+			}
+		}
+		ciTypeList = param.CiTypes
+	}
+	for _, ciType := range ciTypeList {
+		ciAttrs, queryErr := GetCiAttrByCiType(ciType, false)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to get ci:%s attributes fail,%s ", ciType, queryErr.Error())
+			return
+		}
+		ciAttrMap := make(map[string]*models.SysCiTypeAttrTable)
+		for _, attr := range ciAttrs {
+			ciAttrMap[attr.Name] = attr
+		}
+		ciObj := models.CiTemplatePropagateCiObj{CiType: ciType, NewAttrs: []string{}, ChangedAttrs: []*models.ModelAttrChangeObj{}, SkippedAttrs: []*models.CiTemplatePropagateSkipObj{}}
+		for _, templateAttr := range templateObj.Attributes {
+			ciAttr, b := ciAttrMap[templateAttr.Name]
+			if !b {
+				ciObj.NewAttrs = append(ciObj.NewAttrs, templateAttr.Name)
+				actions = append(actions, getCiAttrInsertActionByTemplate(ciType, templateAttr))
+				continue
+			}
+			if ciAttr.Source != "template" {
+				ciObj.SkippedAttrs = append(ciObj.SkippedAttrs, &models.CiTemplatePropagateSkipObj{Attr: ciAttr.Name, Reason: "attribute is custom in ci type"})
+				continue
+			}
+			templateValueMap, ciValueMap := getXormColumnValueMap(*templateAttr), getXormColumnValueMap(*ciAttr)
+			changeObj := models.ModelAttrChangeObj{Id: ciAttr.Id, Fields: []*models.ModelFieldChangeObj{}}
+			var updateColumns []string
+			var updateParams []interface{}
+			for _, column := range ciTemplatePropagateColumns {
+				if templateValueMap[column] != ciValueMap[column] {
+					changeObj.Fields = append(changeObj.Fields, &models.ModelFieldChangeObj{Field: column, OldValue: ciValueMap[column], NewValue: templateValueMap[column]})
+					updateColumns = append(updateColumns, fmt.Sprintf("`%s`=?", column))
+					updateParams = append(updateParams, transCiTemplateColumnValue(column, templateValueMap[column]))
+				}
+			}
+			for _, column := range ciTemplateStructColumns {
+				if templateValueMap[column] == ciValueMap[column] {
+					continue
+				}
+				if ciAttr.Status != "notCreated" {
+					ciObj.SkippedAttrs = append(ciObj.SkippedAttrs, &models.CiTemplatePropagateSkipObj{Attr: ciAttr.Name, Field: column, Reason: fmt.Sprintf("attribute is %s,please use schema migration to change %s", ciAttr.Status, column)})
+					continue
+				}
+				changeObj.Fields = append(changeObj.Fields, &models.ModelFieldChangeObj{Field: column, OldValue: ciValueMap[column], NewValue: templateValueMap[column]})
+				updateColumns = append(updateColumns, fmt.Sprintf("`%s`=?", column))
+				updateParams = append(updateParams, transCiTemplateColumnValue(column, templateValueMap[column]))
+			}
+			if len(updateColumns) == 0 {
+				continue
+			}
+			ciObj.ChangedAttrs = append(ciObj.ChangedAttrs, &changeObj)
+			actions = append(actions, &execAction{Sql: fmt.Sprintf("update sys_ci_type_attr set %s where id=?", strings.Join(updateColumns, ",")), Param: append(updateParams, ciAttr.Id)})
+		}
+		plan.CiTypes = append(plan.CiTypes, &ciObj)
+	}
+	return
+}
+
+func ApplyCiTemplatePropagate(templateId string, param *models.CiTemplatePropagateParam) (plan *models.CiTemplatePropagatePlan, err error) {
+	plan, actions, err := PlanCiTemplatePropagate(templateId, param)
+	if err != nil || len(actions) == 0 {
+		return
+	}
+	if err = transaction(actions); err != nil {
+		err = fmt.Errorf("Try to propagate ci template:%s attributes fail,%s ", templateId, err.Error())
+		return
+	}
+	plan.Applied = true
+	return
+}
+
+func transCiTemplateColumnValue(column, value string) interface{} {
+	if column == "data_length" || column == "ui_search_order" {
+		intValue, _ := strconv.Atoi(value)
+		return intValue
+	}
+	if _, b := ciTemplateNullColumns[column]; b {
+		return NewNullString(value)
+	}
+	return value
+}
+
+func getXormColumnValueMap(obj interface{}) map[string]string {
+	result := make(map[string]string)
+	t := reflect.TypeOf(obj)
+	v := reflect.ValueOf(obj)
+	for i := 0; i < t.NumField(); i++ {
+		tmpXormTag := t.Field(i).Tag.Get("xorm")
+		if tmpXormTag == "" || tmpXormTag == "-" {
+			continue
+		}
+		result[tmpXormTag] = fmt.Sprintf("%v", v.Field(i).Interface())
+	}
+	return result
+}
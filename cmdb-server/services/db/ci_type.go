@@ -350,6 +350,17 @@ func CreateCiTable(ciTypeId string) error {
 	if err != nil {
 		return fmt.Errorf("Try to create table %s fail,%s ", ciTypeId, err.Error())
 	}
+	// 新表没有数据,唯一索引直接建立
+	uniqueIndexList, uniqueIndexErr := getCiTypeUniqueIndexList(ciTypeId, ciAttrRows)
+	if uniqueIndexErr != nil {
+		return uniqueIndexErr
+	}
+	for _, uniqueIndex := range uniqueIndexList {
+		actions = append(actions, &execAction{Sql: fmt.Sprintf("ALTER TABLE `%s` ADD UNIQUE INDEX `%s` (`%s`)", ciTypeId, uniqueIndex.IndexName, strings.Join(uniqueIndex.Attrs, "`,`"))})
+	}
+	if len(uniqueIndexList) > 0 {
+		actions = append(actions, &execAction{Sql: "UPDATE sys_ci_type_unique_key SET status='created' WHERE ci_type=?", Param: []interface{}{ciTypeId}})
+	}
 	if len(actions) > 0 {
 		if createIndexErr := transaction(actions); createIndexErr != nil {
 			log.Error(nil, log.LOGGER_APP, "Try to create ci table index fail", zap.String("ciType", ciTypeId), zap.Error(createIndexErr))
@@ -108,26 +108,30 @@ func CiAttrCreateByTemplate(ciTypeId, ciTemplateId string) error {
 	}
 	var actions []*execAction
 	for _, row := range ciAttrTemplateData {
-		execSql := ciAttrInsertSql
-		execParams := []interface{}{ciTypeId + models.SysTableIdConnector + row.Name, ciTypeId, row.Name, row.DisplayName, row.Description, row.Status, row.InputType, row.DataType,
-			row.DataLength, row.TextValidate, row.RefName, row.RefFilter, row.RefUpdateStateValidate, row.RefConfirmStateValidate, row.UiSearchOrder,
-			row.UiFormOrder, row.UniqueConstraint, row.UiNullable, row.Nullable, row.Editable, row.DisplayByDefault, row.PermissionUsage, row.ResetOnEdit,
-			row.Source, row.Customizable, row.AutofillAble, row.AutofillRule, row.AutofillType, row.EditGroupControl, row.EditGroupValues, row.ExtRefEntity, row.ConfirmNullable, row.Sensitive}
-		if row.RefType != "" && row.RefCiType != "" {
-			execSql = strings.ReplaceAll(execSql, ") VALUE", ",ref_type,ref_ci_type) VALUE")
-			execSql = execSql[:len(execSql)-1] + ",?,?)"
-			execParams = append(execParams, row.RefType, row.RefCiType)
-		}
-		if row.SelectList != "" {
-			execSql = strings.ReplaceAll(execSql, ") VALUE", ",select_list) VALUE")
-			execSql = execSql[:len(execSql)-1] + ",?)"
-			execParams = append(execParams, row.SelectList)
-		}
-		actions = append(actions, &execAction{Sql: execSql, Param: execParams})
+		actions = append(actions, getCiAttrInsertActionByTemplate(ciTypeId, row))
 	}
 	return transaction(actions)
 }
 
+func getCiAttrInsertActionByTemplate(ciTypeId string, row *models.SysCiTemplateAttrTable) *execAction {
+	execSql := ciAttrInsertSql
+	execParams := []interface{}{ciTypeId + models.SysTableIdConnector + row.Name, ciTypeId, row.Name, row.DisplayName, row.Description, row.Status, row.InputType, row.DataType,
+		row.DataLength, row.TextValidate, row.RefName, row.RefFilter, row.RefUpdateStateValidate, row.RefConfirmStateValidate, row.UiSearchOrder,
+		row.UiFormOrder, row.UniqueConstraint, row.UiNullable, row.Nullable, row.Editable, row.DisplayByDefault, row.PermissionUsage, row.ResetOnEdit,
+		row.Source, row.Customizable, row.AutofillAble, row.AutofillRule, row.AutofillType, row.EditGroupControl, row.EditGroupValues, row.ExtRefEntity, row.ConfirmNullable, row.Sensitive}
+	if row.RefType != "" && row.RefCiType != "" {
+		execSql = strings.ReplaceAll(execSql, ") VALUE", ",ref_type,ref_ci_type) VALUE")
+		execSql = execSql[:len(execSql)-1] + ",?,?)"
+		execParams = append(execParams, row.RefType, row.RefCiType)
+	}
+	if row.SelectList != "" {
+		execSql = strings.ReplaceAll(execSql, ") VALUE", ",select_list) VALUE")
+		execSql = execSql[:len(execSql)-1] + ",?)"
+		execParams = append(execParams, row.SelectList)
+	}
+	return &execAction{Sql: execSql, Param: execParams}
+}
+
 func CiAttrUpdate(param *models.SysCiTypeAttrTable) (updateAutoFill bool, err error) {
 	updateAutoFill = false
 	ciAttrData, err := GetCiAttrById(param.Id)
@@ -160,12 +164,26 @@ func CiAttrUpdate(param *models.SysCiTypeAttrTable) (updateAutoFill bool, err er
 		if strings.Contains(param.DataType, "(") {
 			param.DataLength, _ = strconv.Atoi(param.DataType[strings.Index(param.DataType, "(")+1 : len(param.DataType)-1])
 		}
+		if models.Config.Database.UniqueIndexEnable && ciAttrData.UniqueConstraint != "yes" && param.UniqueConstraint == "yes" {
+			if err = checkUniqueIndexDuplicate(ciAttrData.CiType, []*models.SysCiTypeAttrTable{ciAttrData}); err != nil {
+				return updateAutoFill, err
+			}
+		}
 		newColumnDef := fmt.Sprintf("%s(%d)", ciAttrData.DataType, param.DataLength)
 		if ciAttrData.DataType == "text" || ciAttrData.DataType == "datetime" {
 			newColumnDef = ciAttrData.DataType
 		}
 		if ciAttrData.DataLength != param.DataLength {
 			if ciAttrData.DataType == "varchar" || ciAttrData.DataType == "int" {
+				if ciAttrData.DataType == "varchar" && param.DataLength < ciAttrData.DataLength {
+					invalidCount, invalidGuidList, checkErr := checkSchemaInvalidData(ciAttrData, ciAttrData.DataType, param.DataLength)
+					if checkErr != nil {
+						return updateAutoFill, checkErr
+					}
+					if invalidCount > 0 {
+						return updateAutoFill, fmt.Errorf("Attr:%s have %d rows longer than %d,such as:%s ", param.Id, invalidCount, param.DataLength, strings.Join(invalidGuidList, ","))
+					}
+				}
 				alterSql := fmt.Sprintf("alter table `%s` modify column `%s` %s", ciAttrData.CiType, ciAttrData.Name, newColumnDef)
 				alertHistorySql := fmt.Sprintf("alter table `%s%s` modify column `%s` %s", HistoryTablePrefix, ciAttrData.CiType, ciAttrData.Name, newColumnDef)
 				if param.Nullable == "no" {
@@ -214,6 +232,9 @@ func CiAttrUpdate(param *models.SysCiTypeAttrTable) (updateAutoFill bool, err er
 			err = transaction(actions)
 		}
 	}
+	if err == nil && ciAttrData.Status == "created" && ciAttrData.UniqueConstraint != param.UniqueConstraint {
+		err = SyncCiTypeUniqueIndex(ciAttrData.CiType)
+	}
 	return updateAutoFill, err
 }
 
@@ -222,6 +243,17 @@ func CiAttrDelete(ciAttrId string) error {
 	if err != nil {
 		return err
 	}
+	keyRows, err := QueryCiTypeUniqueKey(ciAttrData.CiType)
+	if err != nil {
+		return err
+	}
+	for _, key := range keyRows {
+		for _, keyAttr := range strings.Split(key.Attrs, ",") {
+			if keyAttr == ciAttrData.Name {
+				return fmt.Errorf("Attr:%s is used by unique key:%s,please delete unique key first ", ciAttrData.Name, key.Name)
+			}
+		}
+	}
 	if ciAttrData.Status == "notCreated" {
 		_, err = x.Exec("DELETE FROM sys_ci_type_attr WHERE id=?", ciAttrId)
 	} else {
@@ -233,7 +265,9 @@ func CiAttrDelete(ciAttrId string) error {
 			actions = append(actions, &execAction{Sql: alterSql, Param: []interface{}{}})
 			actions = append(actions, &execAction{Sql: alertHistorySql, Param: []interface{}{}})
 		}
-		err = transaction(actions)
+		if err = transaction(actions); err == nil && ciAttrData.UniqueConstraint == "yes" {
+			err = SyncCiTypeUniqueIndex(ciAttrData.CiType)
+		}
 	}
 	return err
 }
@@ -264,7 +298,9 @@ func CiAttrRollback(ciAttrId string) error {
 		actions = append(actions, &execAction{Sql: alterSql, Param: []interface{}{}})
 		actions = append(actions, &execAction{Sql: alertHistorySql, Param: []interface{}{}})
 	}
-	err = transaction(actions)
+	if err = transaction(actions); err == nil && ciAttrData.UniqueConstraint == "yes" {
+		err = SyncCiTypeUniqueIndex(ciAttrData.CiType)
+	}
 	return err
 }
 
@@ -328,7 +364,7 @@ func CiAttrApply(ciTypeId, ciAttrId string, updateAutofill bool) error {
 	}
 	if ciAttrData.Status == "created" {
 		if updateAutofill {
-			affectCiTypeChan <- ciTypeId
+			return enqueueAffectCiType(ciTypeId)
 		}
 		return nil
 	}
@@ -366,6 +402,8 @@ func CiAttrApply(ciTypeId, ciAttrId string, updateAutofill bool) error {
 	if updateStatusErr != nil {
 		log.Error(nil, log.LOGGER_APP, "Update ci attr status error", zap.String("ciAttrId", ciAttrId), zap.Error(err))
 		err = fmt.Errorf("Update ci attr database fail,%s ", updateStatusErr.Error())
+	} else if ciAttrData.UniqueConstraint == "yes" {
+		err = SyncCiTypeUniqueIndex(ciTypeId)
 	}
 	return err
 }
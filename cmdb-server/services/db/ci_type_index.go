@@ -0,0 +1,364 @@
+package db
+
+import (
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+)
+
+const (
+	ciTypeIndexTargetNow     = "now"
+	ciTypeIndexTargetHistory = "history"
+	ciTypeIndexTargetAll     = "all"
+	defaultSlowQueryMs       = 500
+	ciQueryStatSuggestLimit  = 20
+)
+
+var (
+	ciQueryStatLock sync.Mutex
+	ciQueryStatMap  = make(map[string]*models.SysCiQueryStatTable)
+	// 能用上索引的过滤操作符,等值条件放在索引前面,范围条件放在后面
+	ciQueryEqualOperator = map[string]bool{"eq": true, "in": true, "null": true, "is": true}
+	ciQueryRangeOperator = map[string]bool{"lt": true, "gt": true, "lte": true, "gte": true, "between": true, "startsWith": true}
+)
+
+func QueryCiTypeIndex(ciTypeId string) (result []*models.CiTypeIndexObj, err error) {
+	result = []*models.CiTypeIndexObj{}
+	var indexRows []*models.SysCiTypeIndexTable
+	if err = x.SQL("select * from sys_ci_type_index where ci_type=? order by create_time", ciTypeId).Find(&indexRows); err != nil {
+		err = fmt.Errorf("Try to query ci type index fail,%s ", err.Error())
+		return
+	}
+	nowIndexMap, historyIndexMap, queryErr := getCiTypeTableIndexMap(ciTypeId)
+	if queryErr != nil {
+		err = queryErr
+		return
+	}
+	for _, row := range indexRows {
+		indexName := buildCiTableIndexName("ix", ciTypeId, row.Name)
+		_, nowExist := nowIndexMap[indexName]
+		_, historyExist := historyIndexMap[indexName]
+		result = append(result, &models.CiTypeIndexObj{SysCiTypeIndexTable: row, NowExist: nowExist, HistoryExist: historyExist})
+	}
+	return
+}
+
+func CreateCiTypeIndex(ciTypeId string, param *models.CiTypeIndexParam, operator string) (rowData *models.SysCiTypeIndexTable, err error) {
+	if !uniqueKeyNameRegexp.MatchString(param.Name) {
+		err = fmt.Errorf("Index name:%s illegal,only letters,numbers and _ are allowed ", param.Name)
+		return
+	}
+	if param.Target == "" {
+		param.Target = ciTypeIndexTargetNow
+	}
+	if param.Target != ciTypeIndexTargetNow && param.Target != ciTypeIndexTargetHistory && param.Target != ciTypeIndexTargetAll {
+		err = fmt.Errorf("Index target:%s illegal,should be now/history/all ", param.Target)
+		return
+	}
+	if _, err = GetCiTypeById(ciTypeId); err != nil {
+		return
+	}
+	attrRows, getErr := GetCiAttrByCiType(ciTypeId, false)
+	if getErr != nil {
+		err = fmt.Errorf("Try to get ci attributes fail,%s ", getErr.Error())
+		return
+	}
+	if _, err = validateIndexAttrs(attrRows, param.Attrs); err != nil {
+		return
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	rowData = &models.SysCiTypeIndexTable{Id: ciTypeId + models.SysTableIdConnector + param.Name, CiType: ciTypeId, Name: param.Name, Attrs: strings.Join(param.Attrs, ","),
+		Target: param.Target, Status: "notCreated", CreateUser: operator, CreateTime: nowTime, UpdateTime: nowTime}
+	existRows, _ := x.QueryString("select id from sys_ci_type_index where id=?", rowData.Id)
+	if len(existRows) > 0 {
+		err = fmt.Errorf("Index:%s already exists ", param.Name)
+		return
+	}
+	_, err = x.Exec("insert into sys_ci_type_index(id,ci_type,name,attrs,target,status,create_user,create_time,update_time) values (?,?,?,?,?,?,?,?,?)",
+		rowData.Id, rowData.CiType, rowData.Name, rowData.Attrs, rowData.Target, rowData.Status, rowData.CreateUser, rowData.CreateTime, rowData.UpdateTime)
+	if err != nil {
+		err = fmt.Errorf("Try to insert ci type index fail,%s ", err.Error())
+	}
+	return
+}
+
+// ApplyCiTypeIndex 在ci表和历史表上建立索引,已经存在的索引会跳过
+func ApplyCiTypeIndex(ciTypeId, indexId string) (rowData *models.SysCiTypeIndexTable, err error) {
+	if rowData, err = getCiTypeIndexRow(ciTypeId, indexId); err != nil {
+		return
+	}
+	ciTypeData, getErr := GetCiTypeById(ciTypeId)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	if ciTypeData.Status != "created" {
+		err = fmt.Errorf("Ci type %s is not created ", ciTypeId)
+		return
+	}
+	attrRows, getErr := GetCiAttrByCiType(ciTypeId, true)
+	if getErr != nil {
+		err = fmt.Errorf("Try to get ci attributes fail,%s ", getErr.Error())
+		return
+	}
+	attrList := strings.Split(rowData.Attrs, ",")
+	if _, err = validateIndexAttrs(attrRows, attrList); err != nil {
+		return
+	}
+	nowIndexMap, historyIndexMap, queryErr := getCiTypeTableIndexMap(ciTypeId)
+	if queryErr != nil {
+		err = queryErr
+		return
+	}
+	indexName := buildCiTableIndexName("ix", ciTypeId, rowData.Name)
+	columnSql := "`" + strings.Join(attrList, "`,`") + "`"
+	if _, b := nowIndexMap[indexName]; !b && rowData.Target != ciTypeIndexTargetHistory {
+		if err = execSchemaAlterSql(fmt.Sprintf("ALTER TABLE `%s` ADD INDEX `%s` (%s)", ciTypeId, indexName, columnSql), true); err != nil {
+			err = fmt.Errorf("Try to create index %s on table %s fail,%s ", indexName, ciTypeId, err.Error())
+			return
+		}
+	}
+	if _, b := historyIndexMap[indexName]; !b && rowData.Target != ciTypeIndexTargetNow {
+		if err = execSchemaAlterSql(fmt.Sprintf("ALTER TABLE `%s%s` ADD INDEX `%s` (%s)", HistoryTablePrefix, ciTypeId, indexName, columnSql), true); err != nil {
+			err = fmt.Errorf("Try to create index %s on table %s%s fail,%s ", indexName, HistoryTablePrefix, ciTypeId, err.Error())
+			return
+		}
+	}
+	rowData.Status, rowData.UpdateTime = "created", time.Now().Format(models.DateTimeFormat)
+	if _, err = x.Exec("update sys_ci_type_index set status=?,update_time=? where id=?", rowData.Status, rowData.UpdateTime, rowData.Id); err != nil {
+		err = fmt.Errorf("Try to update ci type index status fail,%s ", err.Error())
+	}
+	return
+}
+
+func DeleteCiTypeIndex(ciTypeId, indexId string) (err error) {
+	rowData, getErr := getCiTypeIndexRow(ciTypeId, indexId)
+	if getErr != nil {
+		return getErr
+	}
+	nowIndexMap, historyIndexMap, queryErr := getCiTypeTableIndexMap(ciTypeId)
+	if queryErr != nil {
+		return queryErr
+	}
+	indexName := buildCiTableIndexName("ix", ciTypeId, rowData.Name)
+	if _, b := nowIndexMap[indexName]; b {
+		if _, err = x.Exec(fmt.Sprintf("ALTER TABLE `%s` DROP INDEX `%s`", ciTypeId, indexName)); err != nil {
+			return fmt.Errorf("Try to drop index %s on table %s fail,%s ", indexName, ciTypeId, err.Error())
+		}
+	}
+	if _, b := historyIndexMap[indexName]; b {
+		if _, err = x.Exec(fmt.Sprintf("ALTER TABLE `%s%s` DROP INDEX `%s`", HistoryTablePrefix, ciTypeId, indexName)); err != nil {
+			return fmt.Errorf("Try to drop index %s on table %s%s fail,%s ", indexName, HistoryTablePrefix, ciTypeId, err.Error())
+		}
+	}
+	if _, err = x.Exec("delete from sys_ci_type_index where id=?", rowData.Id); err != nil {
+		err = fmt.Errorf("Try to delete ci type index fail,%s ", err.Error())
+	}
+	return
+}
+
+func getCiTypeIndexRow(ciTypeId, indexId string) (rowData *models.SysCiTypeIndexTable, err error) {
+	var indexRows []*models.SysCiTypeIndexTable
+	if err = x.SQL("select * from sys_ci_type_index where id=? and ci_type=?", indexId, ciTypeId).Find(&indexRows); err != nil {
+		err = fmt.Errorf("Try to query ci type index fail,%s ", err.Error())
+		return
+	}
+	if len(indexRows) == 0 {
+		err = fmt.Errorf("Can not find index:%s in ciType:%s ", indexId, ciTypeId)
+		return
+	}
+	rowData = indexRows[0]
+	return
+}
+
+// getCiTypeTableIndexMap 查询ci表和历史表上已有的全部索引,key为索引名,value为按顺序的列名
+func getCiTypeTableIndexMap(ciTypeId string) (nowIndexMap, historyIndexMap map[string][]string, err error) {
+	nowIndexMap, historyIndexMap = make(map[string][]string), make(map[string][]string)
+	historyTableName := HistoryTablePrefix + ciTypeId
+	queryRows, queryErr := x.QueryString("SELECT TABLE_NAME,INDEX_NAME,COLUMN_NAME FROM information_schema.STATISTICS WHERE TABLE_SCHEMA=? AND TABLE_NAME in (?,?) ORDER BY TABLE_NAME,INDEX_NAME,SEQ_IN_INDEX",
+		models.Config.Database.DataBase, ciTypeId, historyTableName)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query table:%s index fail,%s ", ciTypeId, queryErr.Error())
+		return
+	}
+	for _, row := range queryRows {
+		if row["TABLE_NAME"] == historyTableName {
+			historyIndexMap[row["INDEX_NAME"]] = append(historyIndexMap[row["INDEX_NAME"]], row["COLUMN_NAME"])
+		} else {
+			nowIndexMap[row["INDEX_NAME"]] = append(nowIndexMap[row["INDEX_NAME"]], row["COLUMN_NAME"])
+		}
+	}
+	return
+}
+
+// RecordCiQueryStat 记录ci数据查询用到的过滤条件和耗时,先在内存中聚合,定时写入数据库
+func RecordCiQueryStat(ciType, queryMode string, param *models.QueryRequestParam, keyMap map[string]string, costMs int64) {
+	if queryMode == "" {
+		queryMode = "new"
+	}
+	var filterList []string
+	for _, filter := range param.Filters {
+		if keyMap[filter.Name] == "" || filter.Name == "guid" {
+			continue
+		}
+		filterList = append(filterList, fmt.Sprintf("%s:%s", filter.Name, filter.Operator))
+	}
+	sortAttr := ""
+	if param.Sorting != nil && keyMap[param.Sorting.Field] != "" {
+		sortAttr = param.Sorting.Field
+	}
+	if len(filterList) == 0 && sortAttr == "" {
+		return
+	}
+	sort.Strings(filterList)
+	filterAttrs := strings.Join(filterList, ",")
+	statId := fmt.Sprintf("%x", md5.Sum([]byte(strings.Join([]string{ciType, queryMode, filterAttrs, sortAttr}, "|"))))
+	slowQueryMs := models.Config.Database.SlowQueryMs
+	if slowQueryMs <= 0 {
+		slowQueryMs = defaultSlowQueryMs
+	}
+	ciQueryStatLock.Lock()
+	statObj, b := ciQueryStatMap[statId]
+	if !b {
+		statObj = &models.SysCiQueryStatTable{Id: statId, CiType: ciType, QueryMode: queryMode, FilterAttrs: filterAttrs, SortAttr: sortAttr}
+		ciQueryStatMap[statId] = statObj
+	}
+	statObj.QueryCount += 1
+	if costMs >= slowQueryMs {
+		statObj.SlowCount += 1
+	}
+	statObj.TotalCostMs += costMs
+	if costMs > statObj.MaxCostMs {
+		statObj.MaxCostMs = costMs
+	}
+	statObj.LastTime = time.Now().Format(models.DateTimeFormat)
+	ciQueryStatLock.Unlock()
+}
+
+func StartFlushCiQueryStat() {
+	t := time.NewTicker(60 * time.Second).C
+	for {
+		<-t
+		flushCiQueryStat()
+	}
+}
+
+func flushCiQueryStat() {
+	ciQueryStatLock.Lock()
+	statMap := ciQueryStatMap
+	ciQueryStatMap = make(map[string]*models.SysCiQueryStatTable)
+	ciQueryStatLock.Unlock()
+	for _, statObj := range statMap {
+		_, err := x.Exec("insert into sys_ci_query_stat(id,ci_type,query_mode,filter_attrs,sort_attr,query_count,slow_count,total_cost_ms,max_cost_ms,last_time) values (?,?,?,?,?,?,?,?,?,?) "+
+			"on duplicate key update query_count=query_count+values(query_count),slow_count=slow_count+values(slow_count),total_cost_ms=total_cost_ms+values(total_cost_ms),max_cost_ms=greatest(max_cost_ms,values(max_cost_ms)),last_time=values(last_time)",
+			statObj.Id, statObj.CiType, statObj.QueryMode, statObj.FilterAttrs, statObj.SortAttr, statObj.QueryCount, statObj.SlowCount, statObj.TotalCostMs, statObj.MaxCostMs, statObj.LastTime)
+		if err != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to save ci query stat fail", zap.String("ciType", statObj.CiType), zap.Error(err))
+		}
+	}
+}
+
+// QueryCiTypeIndexSuggest 根据慢查询的过滤条件给出索引建议,已有索引能覆盖的会标记出来
+func QueryCiTypeIndexSuggest(ciTypeId string) (result []*models.CiTypeIndexSuggestObj, err error) {
+	result = []*models.CiTypeIndexSuggestObj{}
+	var statRows []*models.SysCiQueryStatTable
+	err = x.SQL("select * from sys_ci_query_stat where ci_type=? and slow_count>0 order by slow_count desc,total_cost_ms desc limit ?", ciTypeId, ciQueryStatSuggestLimit).Find(&statRows)
+	if err != nil {
+		err = fmt.Errorf("Try to query ci query stat fail,%s ", err.Error())
+		return
+	}
+	if len(statRows) == 0 {
+		return
+	}
+	attrRows, getErr := GetCiAttrByCiType(ciTypeId, true)
+	if getErr != nil {
+		err = fmt.Errorf("Try to get ci attributes fail,%s ", getErr.Error())
+		return
+	}
+	attrMap := make(map[string]*models.SysCiTypeAttrTable)
+	for _, attr := range attrRows {
+		if attr.InputType != models.MultiRefType && attr.DataType != "text" {
+			attrMap[attr.Name] = attr
+		}
+	}
+	nowIndexMap, historyIndexMap, queryErr := getCiTypeTableIndexMap(ciTypeId)
+	if queryErr != nil {
+		err = queryErr
+		return
+	}
+	existSuggestMap := make(map[string]bool)
+	for _, stat := range statRows {
+		var equalAttrs, rangeAttrs []string
+		for _, filter := range strings.Split(stat.FilterAttrs, ",") {
+			splitIndex := strings.LastIndex(filter, ":")
+			if splitIndex <= 0 {
+				continue
+			}
+			attrName, operator := filter[:splitIndex], filter[splitIndex+1:]
+			if _, b := attrMap[attrName]; !b || attrName == "guid" {
+				continue
+			}
+			if ciQueryEqualOperator[operator] {
+				equalAttrs = append(equalAttrs, attrName)
+			} else if ciQueryRangeOperator[operator] {
+				rangeAttrs = append(rangeAttrs, attrName)
+			}
+		}
+		suggestAttrs := equalAttrs
+		if len(rangeAttrs) > 0 {
+			// 只有第一个范围条件能用上索引
+			suggestAttrs = append(suggestAttrs, rangeAttrs[0])
+		} else if _, b := attrMap[stat.SortAttr]; b && stat.SortAttr != "guid" {
+			suggestAttrs = append(suggestAttrs, stat.SortAttr)
+		}
+		if len(suggestAttrs) == 0 {
+			continue
+		}
+		suggestObj := &models.CiTypeIndexSuggestObj{Stat: stat, Attrs: suggestAttrs, Target: ciTypeIndexTargetNow}
+		existIndexMap := nowIndexMap
+		if stat.QueryMode != "new" {
+			suggestObj.Target = ciTypeIndexTargetHistory
+			existIndexMap = historyIndexMap
+		}
+		suggestKey := suggestObj.Target + ":" + strings.Join(suggestAttrs, ",")
+		if existSuggestMap[suggestKey] {
+			continue
+		}
+		existSuggestMap[suggestKey] = true
+		suggestObj.CoveredBy = findCoverIndex(existIndexMap, suggestAttrs)
+		if suggestObj.CoveredBy != "" {
+			suggestObj.Description = fmt.Sprintf("already covered by index %s", suggestObj.CoveredBy)
+		} else {
+			suggestObj.Description = fmt.Sprintf("slow %d/%d times,avg cost %dms", stat.SlowCount, stat.QueryCount, stat.TotalCostMs/int64(stat.QueryCount))
+		}
+		result = append(result, suggestObj)
+	}
+	return
+}
+
+// findCoverIndex 已有索引的前几列和建议的列一致时认为已经覆盖
+func findCoverIndex(indexMap map[string][]string, attrs []string) string {
+	for indexName, columnList := range indexMap {
+		if len(columnList) < len(attrs) {
+			continue
+		}
+		coverFlag := true
+		for i, attr := range attrs {
+			if columnList[i] != attr {
+				coverFlag = false
+				break
+			}
+		}
+		if coverFlag {
+			return indexName
+		}
+	}
+	return ""
+}
@@ -150,7 +150,7 @@ func getJsonToXormMap(input interface{}) (resultMap map[string]string, idKeyName
 	return resultMap, idKeyName
 }
 
-func transFiltersToSQL(queryParam *models.QueryRequestParam, transParam *models.TransFiltersParam) (filterSql, queryColumn string, param []interface{}) {
+func transFiltersToSQL(queryParam *models.QueryRequestParam, transParam *models.TransFiltersParam) (filterSql, queryColumn string, param []interface{}, err error) {
 	if transParam.Prefix != "" && !strings.HasSuffix(transParam.Prefix, ".") {
 		transParam.Prefix = transParam.Prefix + "."
 	}
@@ -158,61 +158,20 @@ func transFiltersToSQL(queryParam *models.QueryRequestParam, transParam *models.
 		transParam.KeyMap, transParam.PrimaryKey = getJsonToXormMap(transParam.StructObj)
 	}
 	for _, filter := range queryParam.Filters {
-		if transParam.KeyMap[filter.Name] == "" || transParam.KeyMap[filter.Name] == "-" {
-			continue
+		if tmpFilterSql, tmpParams := buildFilterConditionSql(filter, transParam); tmpFilterSql != "" {
+			filterSql += fmt.Sprintf(" AND %s ", tmpFilterSql)
+			param = append(param, tmpParams...)
 		}
-		filterSqlColumn := fmt.Sprintf("%s", transParam.KeyMap[filter.Name])
-		if pointIndex := strings.Index(filterSqlColumn, "."); pointIndex > 0 {
-			filterSqlColumn = filterSqlColumn[:pointIndex+1] + "`" + filterSqlColumn[pointIndex+1:] + "`"
-		} else {
-			if transParam.Prefix != "" {
-				filterSqlColumn = fmt.Sprintf("%s`%s`", transParam.Prefix, transParam.KeyMap[filter.Name])
-			}
+	}
+	if queryParam.FilterGroup != nil {
+		tmpFilterSql, tmpParams, groupErr := buildFilterGroupSql(queryParam.FilterGroup, transParam)
+		if groupErr != nil {
+			err = groupErr
+			return
 		}
-		if filter.Operator == "eq" {
-			if strings.Contains(filterSqlColumn, "time") && filter.Value == "" {
-				continue
-			}
-			filterSql += fmt.Sprintf(" AND %s=? ", filterSqlColumn)
-			param = append(param, filter.Value)
-		} else if filter.Operator == "contains" || filter.Operator == "like" {
-			filterSql += fmt.Sprintf(" AND %s LIKE ? ", filterSqlColumn)
-			param = append(param, fmt.Sprintf("%%%s%%", filter.Value))
-		} else if filter.Operator == "in" {
-			inValueStringList := []string{}
-			if filter.Value != nil {
-				if inValueList, ok := filter.Value.([]interface{}); ok {
-					for _, inValueInterfaceObj := range inValueList {
-						if inValueInterfaceObj == nil {
-							inValueStringList = append(inValueStringList, "")
-						} else {
-							inValueStringList = append(inValueStringList, inValueInterfaceObj.(string))
-						}
-					}
-				}
-			}
-			tmpSpecSql, tmpListParams := createListParams(inValueStringList, "")
-			if tmpSpecSql == "" {
-				tmpSpecSql = "''"
-			}
-			filterSql += fmt.Sprintf(" AND %s in (%s) ", filterSqlColumn, tmpSpecSql)
-			param = append(param, tmpListParams...)
-		} else if filter.Operator == "lt" {
-			filterSql += fmt.Sprintf(" AND %s<=? ", filterSqlColumn)
-			param = append(param, filter.Value)
-		} else if filter.Operator == "gt" {
-			filterSql += fmt.Sprintf(" AND %s>=? ", filterSqlColumn)
-			param = append(param, filter.Value)
-		} else if filter.Operator == "ne" || filter.Operator == "neq" {
-			if strings.Contains(filterSqlColumn, "time") && filter.Value == "" {
-				continue
-			}
-			filterSql += fmt.Sprintf(" AND %s!=? ", filterSqlColumn)
-			param = append(param, filter.Value)
-		} else if filter.Operator == "notNull" || filter.Operator == "isnot" {
-			filterSql += fmt.Sprintf(" AND %s is not null ", filterSqlColumn)
-		} else if filter.Operator == "null" || filter.Operator == "is" {
-			filterSql += fmt.Sprintf(" AND %s is null ", filterSqlColumn)
+		if tmpFilterSql != "" {
+			filterSql += fmt.Sprintf(" AND %s ", tmpFilterSql)
+			param = append(param, tmpParams...)
 		}
 	}
 	if queryParam.Sorting != nil {
@@ -251,6 +210,226 @@ func transFiltersToSQL(queryParam *models.QueryRequestParam, transParam *models.
 	return
 }
 
+// buildFilterGroupSql 把嵌套过滤条件转成带括号的sql,组内没有有效条件时返回空
+// 组内的条件在or/not下忽略会改变结果范围,所以不支持的字段、操作符和between值直接报错
+func buildFilterGroupSql(group *models.QueryRequestFilterGroup, transParam *models.TransFiltersParam) (groupSql string, param []interface{}, err error) {
+	var conditionList []string
+	for _, filter := range group.Filters {
+		if filter == nil {
+			continue
+		}
+		tmpFilterSql, tmpParams := buildFilterConditionSql(filter, transParam)
+		if tmpFilterSql == "" {
+			if err = validateGroupFilter(filter, transParam); err != nil {
+				return
+			}
+			continue
+		}
+		conditionList = append(conditionList, tmpFilterSql)
+		param = append(param, tmpParams...)
+	}
+	for _, subGroup := range group.Groups {
+		if subGroup == nil {
+			continue
+		}
+		tmpGroupSql, tmpParams, subErr := buildFilterGroupSql(subGroup, transParam)
+		if subErr != nil {
+			err = subErr
+			return
+		}
+		if tmpGroupSql != "" {
+			conditionList = append(conditionList, tmpGroupSql)
+			param = append(param, tmpParams...)
+		}
+	}
+	if len(conditionList) == 0 {
+		return
+	}
+	logic := strings.ToLower(group.Logic)
+	if logic == "or" {
+		groupSql = "(" + strings.Join(conditionList, " OR ") + ")"
+	} else {
+		groupSql = "(" + strings.Join(conditionList, " AND ") + ")"
+	}
+	if logic == "not" {
+		groupSql = "NOT " + groupSql
+	}
+	return
+}
+
+// validateGroupFilter 条件组内的条件没有生成sql时判断原因,时间字段的空值与between两端都为空属于正常忽略
+func validateGroupFilter(filter *models.QueryRequestFilterObj, transParam *models.TransFiltersParam) error {
+	if filter.GuidList != nil {
+		return fmt.Errorf("Filter group guid list is not supported in this query ")
+	}
+	if transParam.KeyMap[filter.Name] == "" || transParam.KeyMap[filter.Name] == "-" {
+		return fmt.Errorf("Filter group attr:%s is illegal ", filter.Name)
+	}
+	switch filter.Operator {
+	case "eq", "ne", "neq":
+		return nil
+	case "between":
+		if betweenValues, ok := filter.Value.([]interface{}); ok && len(betweenValues) == 2 {
+			return nil
+		}
+		return fmt.Errorf("Filter group attr:%s between value must be [start,end] ", filter.Name)
+	}
+	return fmt.Errorf("Filter group attr:%s operator:%s is illegal ", filter.Name, filter.Operator)
+}
+
+// buildFilterConditionSql 单个过滤条件转sql,不支持的字段或操作符返回空
+func buildFilterConditionSql(filter *models.QueryRequestFilterObj, transParam *models.TransFiltersParam) (filterSql string, param []interface{}) {
+	if filter == nil {
+		return
+	}
+	if filter.GuidList != nil {
+		if transParam.GuidColumn == "" {
+			return
+		}
+		tmpSpecSql, tmpListParams := createListParams(filter.GuidList, "")
+		if tmpSpecSql == "" {
+			tmpSpecSql = "''"
+		}
+		filterSql = fmt.Sprintf("%s in (%s)", transParam.GuidColumn, tmpSpecSql)
+		param = tmpListParams
+		return
+	}
+	if transParam.KeyMap[filter.Name] == "" || transParam.KeyMap[filter.Name] == "-" {
+		return
+	}
+	filterSqlColumn := fmt.Sprintf("%s", transParam.KeyMap[filter.Name])
+	if pointIndex := strings.Index(filterSqlColumn, "."); pointIndex > 0 {
+		filterSqlColumn = filterSqlColumn[:pointIndex+1] + "`" + filterSqlColumn[pointIndex+1:] + "`"
+	} else {
+		if transParam.Prefix != "" {
+			filterSqlColumn = fmt.Sprintf("%s`%s`", transParam.Prefix, transParam.KeyMap[filter.Name])
+		}
+	}
+	switch filter.Operator {
+	case "eq":
+		if strings.Contains(filterSqlColumn, "time") && filter.Value == "" {
+			return
+		}
+		filterSql = fmt.Sprintf("%s=?", filterSqlColumn)
+		param = append(param, filter.Value)
+	case "contains", "like":
+		filterSql = fmt.Sprintf("%s LIKE ?", filterSqlColumn)
+		param = append(param, fmt.Sprintf("%%%s%%", filter.Value))
+	case "startsWith":
+		filterSql = fmt.Sprintf("%s LIKE ?", filterSqlColumn)
+		param = append(param, fmt.Sprintf("%s%%", filter.Value))
+	case "regexp":
+		filterSql = fmt.Sprintf("%s REGEXP ?", filterSqlColumn)
+		param = append(param, filter.Value)
+	case "in", "notIn":
+		tmpSpecSql, tmpListParams := createListParams(transFilterValueToStringList(filter.Value), "")
+		if tmpSpecSql == "" {
+			tmpSpecSql = "''"
+		}
+		if filter.Operator == "in" {
+			filterSql = fmt.Sprintf("%s in (%s)", filterSqlColumn, tmpSpecSql)
+		} else {
+			filterSql = fmt.Sprintf("%s not in (%s)", filterSqlColumn, tmpSpecSql)
+		}
+		param = tmpListParams
+	case "lt":
+		filterSql = fmt.Sprintf("%s<?", filterSqlColumn)
+		param = append(param, filter.Value)
+	case "lte":
+		filterSql = fmt.Sprintf("%s<=?", filterSqlColumn)
+		param = append(param, filter.Value)
+	case "gt":
+		filterSql = fmt.Sprintf("%s>?", filterSqlColumn)
+		param = append(param, filter.Value)
+	case "gte":
+		filterSql = fmt.Sprintf("%s>=?", filterSqlColumn)
+		param = append(param, filter.Value)
+	case "between":
+		// value为[start,end],包含两端,某一端为空时只按另一端过滤
+		betweenValues, ok := filter.Value.([]interface{})
+		if !ok || len(betweenValues) != 2 {
+			return
+		}
+		startEmpty := betweenValues[0] == nil || betweenValues[0] == ""
+		endEmpty := betweenValues[1] == nil || betweenValues[1] == ""
+		if !startEmpty && !endEmpty {
+			filterSql = fmt.Sprintf("%s BETWEEN ? AND ?", filterSqlColumn)
+			param = append(param, betweenValues[0], betweenValues[1])
+		} else if !startEmpty {
+			filterSql = fmt.Sprintf("%s>=?", filterSqlColumn)
+			param = append(param, betweenValues[0])
+		} else if !endEmpty {
+			filterSql = fmt.Sprintf("%s<=?", filterSqlColumn)
+			param = append(param, betweenValues[1])
+		}
+	case "ne", "neq":
+		if strings.Contains(filterSqlColumn, "time") && filter.Value == "" {
+			return
+		}
+		filterSql = fmt.Sprintf("%s!=?", filterSqlColumn)
+		param = append(param, filter.Value)
+	case "notNull", "isnot":
+		filterSql = fmt.Sprintf("%s is not null", filterSqlColumn)
+	case "null", "is":
+		filterSql = fmt.Sprintf("%s is null", filterSqlColumn)
+	}
+	return
+}
+
+func transFilterValueToStringList(value interface{}) (output []string) {
+	if value == nil {
+		return
+	}
+	if valueList, ok := value.([]interface{}); ok {
+		for _, v := range valueList {
+			if v == nil {
+				output = append(output, "")
+			} else {
+				output = append(output, fmt.Sprintf("%v", v))
+			}
+		}
+	} else if valueList, ok := value.([]string); ok {
+		output = valueList
+	}
+	return
+}
+
+// walkQueryFilters 遍历平铺条件与嵌套条件组中的所有叶子条件
+func walkQueryFilters(queryParam *models.QueryRequestParam, handleFunc func(filter *models.QueryRequestFilterObj) error) (err error) {
+	for _, filter := range queryParam.Filters {
+		if filter == nil {
+			continue
+		}
+		if err = handleFunc(filter); err != nil {
+			return
+		}
+	}
+	if queryParam.FilterGroup != nil {
+		err = walkFilterGroup(queryParam.FilterGroup, handleFunc)
+	}
+	return
+}
+
+func walkFilterGroup(group *models.QueryRequestFilterGroup, handleFunc func(filter *models.QueryRequestFilterObj) error) (err error) {
+	for _, filter := range group.Filters {
+		if filter == nil {
+			continue
+		}
+		if err = handleFunc(filter); err != nil {
+			return
+		}
+	}
+	for _, subGroup := range group.Groups {
+		if subGroup == nil {
+			continue
+		}
+		if err = walkFilterGroup(subGroup, handleFunc); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func transPageInfoToSQL(pageInfo models.PageInfo) (pageSql string, param []interface{}) {
 	pageSql = " LIMIT ?,? "
 	param = append(param, pageInfo.StartIndex)
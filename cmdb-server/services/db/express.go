@@ -0,0 +1,123 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/expression"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+)
+
+// buildExpressFilterSql 表达式过滤条件转成带参数的sql条件
+func buildExpressFilterSql(column string, filter *expression.Filter) (sql string, params []interface{}, err error) {
+	switch filter.Operator {
+	case "in", "notIn":
+		specSql, valueParams := createListParams(filter.ValueList, "")
+		if specSql == "" {
+			specSql = "''"
+		}
+		if filter.Operator == "in" {
+			sql = fmt.Sprintf("%s in (%s)", column, specSql)
+		} else {
+			sql = fmt.Sprintf("%s not in (%s)", column, specSql)
+		}
+		params = valueParams
+	case "eq":
+		sql, params = fmt.Sprintf("%s=?", column), []interface{}{filter.Value}
+	case "ne":
+		sql, params = fmt.Sprintf("%s!=?", column), []interface{}{filter.Value}
+	case "like":
+		sql, params = fmt.Sprintf("%s LIKE ?", column), []interface{}{"%" + filter.Value + "%"}
+	case ">=", "<=":
+		targetValue := "0"
+		if filter.Value != "" {
+			targetValue = transFloatValueToString(filter.Value)
+		}
+		sql, params = fmt.Sprintf("%s%s?", column, filter.Operator), []interface{}{targetValue}
+	case "notNull":
+		sql = fmt.Sprintf("%s IS NOT NULL", column)
+	case "null":
+		sql = fmt.Sprintf("%s IS NULL", column)
+	case "notEmpty":
+		sql = fmt.Sprintf("%s<>''", column)
+	case "empty":
+		sql = fmt.Sprintf("%s=''", column)
+	default:
+		err = fmt.Errorf("Express filter operator:%s not support ", filter.Operator)
+	}
+	return
+}
+
+// ValidateExpress 解析并检查表达式,语法错误与属性错误都带字符位置返回
+func ValidateExpress(express string) (result *models.ExpressValidateResult, err error) {
+	result = &models.ExpressValidateResult{Express: express, Errors: []*models.ExpressErrorObj{}}
+	exprList, parseErr := expression.ParseList(express)
+	if parseErr != nil {
+		if exprErr, ok := parseErr.(*expression.Error); ok {
+			result.Errors = append(result.Errors, &models.ExpressErrorObj{Pos: exprErr.Pos, Message: exprErr.Message})
+			return
+		}
+		err = parseErr
+		return
+	}
+	provider := getExpressAttrProvider()
+	for _, expr := range exprList {
+		errList, validateErr := expression.Validate(expr, provider)
+		if validateErr != nil {
+			err = validateErr
+			return
+		}
+		for _, v := range errList {
+			result.Errors = append(result.Errors, &models.ExpressErrorObj{Pos: v.Pos, Message: v.Message})
+		}
+	}
+	result.Valid = len(result.Errors) == 0
+	return
+}
+
+// getExpressAttrProvider 校验时按ci类型查询一次属性并缓存
+func getExpressAttrProvider() expression.AttrProvider {
+	cacheMap := make(map[string]map[string]*models.SysCiTypeAttrTable)
+	return func(ciType string) (attrMap map[string]*models.SysCiTypeAttrTable, exist bool, err error) {
+		if attrMap, exist = cacheMap[ciType]; exist {
+			return
+		}
+		var ciTypeTable []*models.SysCiTypeTable
+		if err = x.SQL("select id from sys_ci_type where id=? and status not in ('notCreated','deleted')", ciType).Find(&ciTypeTable); err != nil {
+			err = fmt.Errorf("Try to query ciType fail,%s ", err.Error())
+			return
+		}
+		if len(ciTypeTable) == 0 {
+			return
+		}
+		var attrTable []*models.SysCiTypeAttrTable
+		if err = x.SQL("select id,name,input_type,ref_ci_type,`sensitive` from sys_ci_type_attr where ci_type=? and status not in ('notCreated','deleted')", ciType).Find(&attrTable); err != nil {
+			err = fmt.Errorf("Try to query ci attributes fail,%s ", err.Error())
+			return
+		}
+		attrMap, exist = make(map[string]*models.SysCiTypeAttrTable), true
+		for _, attr := range attrTable {
+			attrMap[attr.Name] = attr
+		}
+		cacheMap[ciType] = attrMap
+		return
+	}
+}
+
+// validateExpressWithError 保存配置前检查表达式,有错误时合并成一个error
+func validateExpressWithError(express string) (err error) {
+	result, err := ValidateExpress(express)
+	if err != nil {
+		return
+	}
+	if !result.Valid {
+		var message string
+		for i, v := range result.Errors {
+			if i > 0 {
+				message += ";"
+			}
+			message += fmt.Sprintf("position %d: %s", v.Pos, v.Message)
+		}
+		err = fmt.Errorf("Express:%s illegal,%s ", express, message)
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/expression"
+)
+
+// TestParseLegacyExpress 旧格式的表达式要能原样解析,且过滤条件生成的sql与原来按字符串切分拼接的sql一致
+// 原来的 >= 与 <= 没有取到比较值,固定与0比较,所以不在对比范围内
+func TestParseLegacyExpress(t *testing.T) {
+	testCases := []struct {
+		express string
+		sqlList []string
+	}{
+		{express: "app_system"},
+		{express: "app_system[{key_name eq 'demo'}]", sqlList: []string{"t0.`key_name`='demo'"}},
+		{express: "app_system[{guid in ['app_system_1','app_system_2']}]", sqlList: []string{"t0.`guid` in ('app_system_1','app_system_2')"}},
+		{express: "unit.app_system>app_system"},
+		{express: "unit.app_system>app_system:[code]"},
+		{express: "app_system~(app_system)unit~(unit)app_instance"},
+		{express: "app_instance[{state ne 'deleted'}].unit>unit.app_system>app_system[{code like 'core'}]:[guid]", sqlList: []string{"t0.`state`!='deleted'", "t2.`code` LIKE '%core%'"}},
+		{express: "host_resource[{memory >= '8'},{cpu <= '16'}]"},
+		{express: "host_resource[{ip_address null},{name notNull},{description empty},{code notEmpty}]",
+			sqlList: []string{"t0.`ip_address` IS NULL", "t0.`name` IS NOT NULL", "t0.`description`=''", "t0.`code`<>''"}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.express, func(t *testing.T) {
+			expr, err := expression.Parse(testCase.express)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if expr.String() != testCase.express {
+				t.Errorf("string: got %s, want %s", expr.String(), testCase.express)
+			}
+			if expr.Source != testCase.express {
+				t.Errorf("source: got %s, want %s", expr.Source, testCase.express)
+			}
+			if testCase.sqlList == nil {
+				return
+			}
+			var sqlList []string
+			for i, segment := range expr.Segments {
+				for _, filter := range segment.Filters {
+					filterSql, filterParams, buildErr := buildExpressFilterSql(fmt.Sprintf("t%d.`%s`", i, filter.Attr), filter)
+					if buildErr != nil {
+						t.Fatalf("unexpected error: %s", buildErr.Error())
+					}
+					sqlList = append(sqlList, renderExpressFilterSql(filterSql, filterParams))
+				}
+			}
+			if strings.Join(sqlList, " and ") != strings.Join(testCase.sqlList, " and ") {
+				t.Errorf("sql: got %q, want %q", sqlList, testCase.sqlList)
+			}
+		})
+	}
+}
+
+// renderExpressFilterSql 把参数按原来的写法用单引号拼回sql,便于与旧sql对比
+func renderExpressFilterSql(filterSql string, filterParams []interface{}) string {
+	for _, param := range filterParams {
+		filterSql = strings.Replace(filterSql, "?", fmt.Sprintf("'%v'", param), 1)
+	}
+	return filterSql
+}
@@ -0,0 +1,431 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WeBankPartners/go-common-lib/cipher"
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+)
+
+const (
+	jobDefaultWorkerNum    = 4
+	jobHeartbeatInterval   = 30 * time.Second
+	jobHeartbeatTimeout    = 3 * time.Minute
+	jobScanInterval        = 10 * time.Second
+	jobMaxResumeCount      = 3
+	jobInterruptedErrorMsg = "Job interrupted by server restart "
+)
+
+// jobHandlerFunc 可取消的任务需要在ctx取消后尽快返回
+type jobHandlerFunc func(ctx context.Context, job *models.SysJobTable, reqContext *models.JobRequestContext) (result interface{}, err error)
+
+type jobHandlerObj struct {
+	Handler jobHandlerFunc
+	// 可重入的任务在实例重启后重新执行,否则标记为interrupted
+	Resumable bool
+	// 执行中的任务是否允许取消,会修改数据的任务执行中不允许取消
+	Cancelable bool
+	// 是否允许通过通用任务接口提交,其它类型只能从对应的业务接口提交以经过业务接口的权限检查
+	UserSubmit bool
+}
+
+var (
+	jobHandlerMap = map[string]*jobHandlerObj{
+		models.JobTypeExportReport:         {Handler: handleExportReportJob, Resumable: true, Cancelable: true, UserSubmit: true},
+		models.JobTypeImportCiData:         {Handler: handleImportCiDataJob},
+		models.JobTypeViewConfirm:          {Handler: handleViewConfirmJob},
+		models.JobTypeRefreshImportHistory: {Handler: handleRefreshImportHistoryJob, Resumable: true, Cancelable: true, UserSubmit: true},
+		models.JobTypeAutofillCheck:        {Handler: handleAutofillCheckJob, Resumable: true},
+		models.JobTypeCiDataBulk:           {Handler: handleCiDataBulkJob},
+	}
+	jobNotifyChan    = make(chan string, 100)
+	jobInstance      string
+	jobRunningMap    = make(map[string]context.CancelFunc)
+	jobLocalMapMutex = new(sync.Mutex)
+)
+
+// CheckUserSubmitJob 检查任务类型是否允许通过通用任务接口提交
+func CheckUserSubmitJob(jobType string) (err error) {
+	handler, b := jobHandlerMap[jobType]
+	if !b {
+		err = fmt.Errorf("Job type:%s not support ", jobType)
+		return
+	}
+	if !handler.UserSubmit {
+		err = fmt.Errorf("Job type:%s can not submit directly,please use the related api with async=true ", jobType)
+	}
+	return
+}
+
+// SubmitJob 保存任务后通知空闲的worker执行
+func SubmitJob(jobType string, param interface{}, reqContext *models.JobRequestContext) (job *models.SysJobTable, err error) {
+	if _, b := jobHandlerMap[jobType]; !b {
+		err = fmt.Errorf("Job type:%s not support ", jobType)
+		return
+	}
+	paramBytes, marshalErr := json.Marshal(param)
+	if marshalErr != nil {
+		err = fmt.Errorf("Try to json marshal job param fail,%s ", marshalErr.Error())
+		return
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	job = &models.SysJobTable{Id: "job_" + guid.CreateGuid(), JobType: jobType, Status: models.JobStatusWait, Param: string(paramBytes), Roles: strings.Join(reqContext.Roles, ","), CreateUser: reqContext.Operator, CreateTime: nowTime, UpdateTime: nowTime}
+	// token加密保存,任务可以由任意实例执行,结束后清空
+	if reqContext.UserToken != "" {
+		if job.UserToken, err = cipher.AesEnPassword(models.Config.Wecube.EncryptSeed, reqContext.UserToken); err != nil {
+			err = fmt.Errorf("Try to encrypt job user token fail,%s ", err.Error())
+			return
+		}
+	}
+	_, err = x.Exec("insert into sys_job(id,job_type,status,param,roles,user_token,retry_count,create_user,create_time,update_time) values (?,?,?,?,?,?,0,?,?,?)",
+		job.Id, job.JobType, job.Status, job.Param, job.Roles, NewNullString(job.UserToken), job.CreateUser, job.CreateTime, job.UpdateTime)
+	if err != nil {
+		err = fmt.Errorf("Try to insert job fail,%s ", err.Error())
+		return
+	}
+	select {
+	case jobNotifyChan <- job.Id:
+	default:
+		// 队列已满时由定时扫描兜底
+	}
+	return
+}
+
+func isJobAdmin(roles []string) bool {
+	for _, role := range roles {
+		if strings.ToLower(role) == strings.ToLower(models.AdminRole) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetJob 非管理员只能查看自己提交的任务
+func GetJob(jobId string, reqContext *models.JobRequestContext) (job *models.SysJobTable, err error) {
+	var jobRows []*models.SysJobTable
+	baseSql := "select * from sys_job where id=?"
+	queryParam := []interface{}{jobId}
+	if !isJobAdmin(reqContext.Roles) {
+		baseSql += " and create_user=?"
+		queryParam = append(queryParam, reqContext.Operator)
+	}
+	err = x.SQL(baseSql, queryParam...).Find(&jobRows)
+	if err != nil {
+		err = fmt.Errorf("Try to query job fail,%s ", err.Error())
+		return
+	}
+	if len(jobRows) == 0 {
+		err = fmt.Errorf("Can not find job:%s ", jobId)
+		return
+	}
+	job = jobRows[0]
+	return
+}
+
+// QueryJob 非管理员只返回自己提交的任务
+func QueryJob(param *models.QueryRequestParam, reqContext *models.JobRequestContext) (pageInfo models.PageInfo, rowData []*models.SysJobTable, err error) {
+	rowData = []*models.SysJobTable{}
+	filterSql, _, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysJobTable{}, PrimaryKey: "id"})
+	if err != nil {
+		return
+	}
+	userSql := ""
+	if !isJobAdmin(reqContext.Roles) {
+		userSql = " AND create_user=? "
+		queryParam = append([]interface{}{reqContext.Operator}, queryParam...)
+	}
+	baseSql := fmt.Sprintf("SELECT id,job_type,status,param,error_msg,instance,retry_count,create_user,create_time,start_time,end_time,update_time FROM sys_job WHERE 1=1 %s %s ", userSql, filterSql)
+	if param.Paging {
+		pageInfo.StartIndex = param.Pageable.StartIndex
+		pageInfo.PageSize = param.Pageable.PageSize
+		pageInfo.TotalRows = queryCount(baseSql, queryParam...)
+		pageSql, pageParam := transPageInfoToSQL(*param.Pageable)
+		baseSql += pageSql
+		queryParam = append(queryParam, pageParam...)
+	}
+	err = x.SQL(baseSql, queryParam...).Find(&rowData)
+	if err != nil {
+		err = fmt.Errorf("Try to query job fail,%s ", err.Error())
+	}
+	return
+}
+
+// CancelJob 等待中的任务直接取消,执行中的任务只有可取消类型且在当前实例执行时才能取消
+func CancelJob(jobId string, reqContext *models.JobRequestContext) (err error) {
+	job, err := GetJob(jobId, reqContext)
+	if err != nil {
+		return
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	switch job.Status {
+	case models.JobStatusWait:
+		execResult, execErr := x.Exec("update sys_job set status=?,user_token=null,end_time=?,update_time=? where id=? and status=?", models.JobStatusCancel, nowTime, nowTime, jobId, models.JobStatusWait)
+		if execErr != nil {
+			err = fmt.Errorf("Try to update job status fail,%s ", execErr.Error())
+			return
+		}
+		if affectNum, _ := execResult.RowsAffected(); affectNum > 0 {
+			return
+		}
+		err = fmt.Errorf("Job:%s is already started,please retry ", jobId)
+	case models.JobStatusRunning:
+		if !jobHandlerMap[job.JobType].Cancelable {
+			err = fmt.Errorf("Job type:%s is modifying data,can not cancel when running ", job.JobType)
+			return
+		}
+		jobLocalMapMutex.Lock()
+		cancelFunc, b := jobRunningMap[jobId]
+		jobLocalMapMutex.Unlock()
+		if !b {
+			err = fmt.Errorf("Job:%s is running on instance:%s,please cancel it on that instance ", jobId, job.Instance)
+			return
+		}
+		cancelFunc()
+	default:
+		err = fmt.Errorf("Job:%s is already %s ", jobId, job.Status)
+	}
+	return
+}
+
+// GetJobResult 返回执行成功的任务结果
+func GetJobResult(jobId string, reqContext *models.JobRequestContext) (job *models.SysJobTable, err error) {
+	if job, err = GetJob(jobId, reqContext); err != nil {
+		return
+	}
+	if job.Status != models.JobStatusSuccess {
+		err = fmt.Errorf("Job:%s status is %s,no result to download ", jobId, job.Status)
+	}
+	return
+}
+
+func StartJobWorker() {
+	jobInstance, _ = os.Hostname()
+	jobInstance = fmt.Sprintf("%s_%d", jobInstance, os.Getpid())
+	workerNum := models.Config.Job.WorkerNum
+	if workerNum <= 0 {
+		workerNum = jobDefaultWorkerNum
+	}
+	log.Info(nil, log.LOGGER_APP, "start job worker", zap.String("instance", jobInstance), zap.Int("workerNum", workerNum))
+	for i := 0; i < workerNum; i++ {
+		go startJobWorkerLoop()
+	}
+	t := time.NewTicker(time.Minute).C
+	for {
+		recoverTimeoutJob()
+		<-t
+	}
+}
+
+// recoverTimeoutJob 心跳超时的执行中任务说明执行实例已退出,可重入的任务重新执行,其它标记为中断
+func recoverTimeoutJob() {
+	var jobRows []*models.SysJobTable
+	timeoutTime := time.Now().Add(-jobHeartbeatTimeout).Format(models.DateTimeFormat)
+	if err := x.SQL("select id,job_type,status,retry_count,update_time from sys_job where status=? and update_time<?", models.JobStatusRunning, timeoutTime).Find(&jobRows); err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to query timeout job fail", zap.Error(err))
+		return
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	for _, job := range jobRows {
+		handler, b := jobHandlerMap[job.JobType]
+		var err error
+		if b && handler.Resumable && job.RetryCount < jobMaxResumeCount {
+			_, err = x.Exec("update sys_job set status=?,instance=null,retry_count=retry_count+1,update_time=? where id=? and status=? and update_time=?",
+				models.JobStatusWait, nowTime, job.Id, models.JobStatusRunning, job.UpdateTime)
+			log.Warn(nil, log.LOGGER_APP, "Resume interrupted job", zap.String("job", job.Id), zap.String("jobType", job.JobType))
+		} else {
+			_, err = x.Exec("update sys_job set status=?,error_msg=?,user_token=null,end_time=?,update_time=? where id=? and status=? and update_time=?",
+				models.JobStatusInterrupted, jobInterruptedErrorMsg, nowTime, nowTime, job.Id, models.JobStatusRunning, job.UpdateTime)
+			log.Warn(nil, log.LOGGER_APP, "Mark job interrupted", zap.String("job", job.Id), zap.String("jobType", job.JobType))
+		}
+		if err != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to recover timeout job fail", zap.String("job", job.Id), zap.Error(err))
+		}
+	}
+}
+
+func startJobWorkerLoop() {
+	t := time.NewTicker(jobScanInterval).C
+	for {
+		for {
+			job := claimWaitJob()
+			if job == nil {
+				break
+			}
+			runJob(job)
+		}
+		select {
+		case <-jobNotifyChan:
+		case <-t:
+		}
+	}
+}
+
+// claimWaitJob 抢占最早的等待任务,防止多实例重复执行
+func claimWaitJob() *models.SysJobTable {
+	var jobRows []*models.SysJobTable
+	if err := x.SQL("select * from sys_job where status=? order by create_time limit 10", models.JobStatusWait).Find(&jobRows); err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to query wait job fail", zap.Error(err))
+		return nil
+	}
+	for _, job := range jobRows {
+		nowTime := time.Now().Format(models.DateTimeFormat)
+		execResult, execErr := x.Exec("update sys_job set status=?,instance=?,start_time=?,update_time=? where id=? and status=?", models.JobStatusRunning, jobInstance, nowTime, nowTime, job.Id, models.JobStatusWait)
+		if execErr != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to lock job fail", zap.String("job", job.Id), zap.Error(execErr))
+			continue
+		}
+		if affectNum, _ := execResult.RowsAffected(); affectNum == 0 {
+			continue
+		}
+		job.Status = models.JobStatusRunning
+		job.Instance = jobInstance
+		job.StartTime = nowTime
+		return job
+	}
+	return nil
+}
+
+type jobRunResult struct {
+	Result interface{}
+	Err    error
+}
+
+func runJob(job *models.SysJobTable) {
+	log.Info(nil, log.LOGGER_APP, "start run job", zap.String("job", job.Id), zap.String("jobType", job.JobType))
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	jobLocalMapMutex.Lock()
+	jobRunningMap[job.Id] = cancelFunc
+	jobLocalMapMutex.Unlock()
+	reqContext := models.JobRequestContext{Operator: job.CreateUser}
+	if job.Roles != "" {
+		reqContext.Roles = strings.Split(job.Roles, ",")
+	}
+	if job.UserToken != "" {
+		userToken, decryptErr := cipher.AesDePassword(models.Config.Wecube.EncryptSeed, job.UserToken)
+		if decryptErr != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to decrypt job user token fail", zap.String("job", job.Id), zap.Error(decryptErr))
+		}
+		reqContext.UserToken = userToken
+	}
+	defer func() {
+		cancelFunc()
+		jobLocalMapMutex.Lock()
+		delete(jobRunningMap, job.Id)
+		jobLocalMapMutex.Unlock()
+	}()
+	resultChan := make(chan *jobRunResult, 1)
+	go func() {
+		runResult := jobRunResult{}
+		defer func() {
+			if r := recover(); r != nil {
+				runResult.Err = fmt.Errorf("Job handle panic:%v ", r)
+			}
+			resultChan <- &runResult
+		}()
+		handler, b := jobHandlerMap[job.JobType]
+		if !b {
+			runResult.Err = fmt.Errorf("Job type:%s not support ", job.JobType)
+			return
+		}
+		runResult.Result, runResult.Err = handler.Handler(ctx, job, &reqContext)
+	}()
+	heartbeat := time.NewTicker(jobHeartbeatInterval)
+	defer heartbeat.Stop()
+	var runResult *jobRunResult
+	for runResult == nil {
+		select {
+		case runResult = <-resultChan:
+		case <-heartbeat.C:
+			x.Exec("update sys_job set update_time=? where id=? and status=?", time.Now().Format(models.DateTimeFormat), job.Id, models.JobStatusRunning)
+		case <-ctx.Done():
+			runResult = &jobRunResult{Err: context.Canceled}
+		}
+	}
+	job.Status, job.Result, job.ErrorMsg = models.JobStatusSuccess, "", ""
+	if runResult.Err == context.Canceled {
+		job.Status = models.JobStatusCancel
+		job.ErrorMsg = "Job canceled "
+	} else if runResult.Err != nil {
+		job.Status = models.JobStatusFail
+		job.ErrorMsg = runResult.Err.Error()
+	} else if runResult.Result != nil {
+		resultBytes, marshalErr := json.Marshal(runResult.Result)
+		if marshalErr != nil {
+			job.Status = models.JobStatusFail
+			job.ErrorMsg = fmt.Sprintf("Try to json marshal job result fail,%s ", marshalErr.Error())
+		} else {
+			job.Result = string(resultBytes)
+		}
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	_, err := x.Exec("update sys_job set status=?,result=?,error_msg=?,user_token=null,end_time=?,update_time=? where id=? and status=?", job.Status, NewNullString(job.Result), job.ErrorMsg, nowTime, nowTime, job.Id, models.JobStatusRunning)
+	if err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to save job result fail", zap.String("job", job.Id), zap.Error(err))
+	}
+	log.Info(nil, log.LOGGER_APP, "job done", zap.String("job", job.Id), zap.String("status", job.Status))
+}
+
+func handleExportReportJob(ctx context.Context, job *models.SysJobTable, reqContext *models.JobRequestContext) (result interface{}, err error) {
+	var param models.ExportReportParam
+	if err = json.Unmarshal([]byte(job.Param), &param); err != nil {
+		err = fmt.Errorf("Try to json unmarshal job param fail,%s ", err.Error())
+		return
+	}
+	result, err = ExportReportData(ctx, &param)
+	return
+}
+
+func handleImportCiDataJob(ctx context.Context, job *models.SysJobTable, reqContext *models.JobRequestContext) (result interface{}, err error) {
+	var param models.ImportCiDataJobParam
+	if err = json.Unmarshal([]byte(job.Param), &param); err != nil {
+		err = fmt.Errorf("Try to json unmarshal job param fail,%s ", err.Error())
+		return
+	}
+	if param.Data == nil {
+		err = fmt.Errorf("Import data can not empty ")
+		return
+	}
+	err = ImportCiData(param.Data, reqContext.Operator, param.UseNewGuid)
+	return
+}
+
+func handleViewConfirmJob(ctx context.Context, job *models.SysJobTable, reqContext *models.JobRequestContext) (result interface{}, err error) {
+	var param models.ViewData
+	if err = json.Unmarshal([]byte(job.Param), &param); err != nil {
+		err = fmt.Errorf("Try to json unmarshal job param fail,%s ", err.Error())
+		return
+	}
+	result, err = ViewConfirmAction(param, reqContext.UserToken, reqContext.Operator, reqContext.Roles)
+	return
+}
+
+func handleRefreshImportHistoryJob(ctx context.Context, job *models.SysJobTable, reqContext *models.JobRequestContext) (result interface{}, err error) {
+	var param models.RefreshImportHistoryJobParam
+	if job.Param != "" && job.Param != "null" {
+		if err = json.Unmarshal([]byte(job.Param), &param); err != nil {
+			err = fmt.Errorf("Try to json unmarshal job param fail,%s ", err.Error())
+			return
+		}
+	}
+	err = RefreshReportImportHistoryByImportGuid(ctx, param.Guid)
+	return
+}
+
+func handleAutofillCheckJob(ctx context.Context, job *models.SysJobTable, reqContext *models.JobRequestContext) (result interface{}, err error) {
+	var param models.AutofillCheckParam
+	if err = json.Unmarshal([]byte(job.Param), &param); err != nil {
+		err = fmt.Errorf("Try to json unmarshal job param fail,%s ", err.Error())
+		return
+	}
+	result, err = CheckCiAutofill(&param)
+	return
+}
@@ -0,0 +1,982 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+)
+
+var (
+	modelImportLock sync.Mutex
+	// 属性对比时忽略的字段,这些字段由目标环境自己维护
+	modelAttrIgnoreKeys = []string{"ciTypeAttrId", "status", "displayName", "uiFormOrder", "source", "customizable"}
+	modelTimeIgnoreKeys = []string{"createTime", "createUser", "updateTime", "updateUser"}
+)
+
+type modelImportTarget struct {
+	ciTypeMap      map[string]*models.SysCiTypeTable
+	attrMap        map[string]*models.SysCiTypeAttrTable
+	templateMap    map[string]*models.SysCiTemplateTable
+	baseKeyCodeMap map[string]bool
+	baseKeyCatMap  map[string]bool
+	// 表名 -> 主键 -> 行数据json,用于判断状态机/报表/视图是否有变化
+	rowJsonMap map[string]map[string]string
+}
+
+func ExportModelBundle(ciTypeList []string) (bundle *models.ModelBundle, err error) {
+	bundle = &models.ModelBundle{Version: models.ModelBundleVersion, ExportTime: time.Now().Format(models.DateTimeFormat)}
+	if len(ciTypeList) > 0 {
+		err = findModelRowsIn(&bundle.CiTypes, "select * from sys_ci_type where status<>'deleted' and id in (%s) order by seq_no,id", ciTypeList)
+	} else {
+		err = x.SQL("select * from sys_ci_type where status<>'deleted' order by seq_no,id").Find(&bundle.CiTypes)
+	}
+	if err != nil {
+		err = fmt.Errorf("Try to query ci type table fail,%s ", err.Error())
+		return
+	}
+	var ciTypeIdList, stateMachineList []string
+	stateMachineExistMap := make(map[string]bool)
+	for _, ciType := range bundle.CiTypes {
+		// 图标文件在不同环境的guid不一样,导入时使用模版的图标
+		ciType.ImageFile = ""
+		ciTypeIdList = append(ciTypeIdList, ciType.Id)
+		if ciType.StateMachine != "" && !stateMachineExistMap[ciType.StateMachine] {
+			stateMachineExistMap[ciType.StateMachine] = true
+			stateMachineList = append(stateMachineList, ciType.StateMachine)
+		}
+	}
+	if err = findModelRowsIn(&bundle.CiTypeAttrs, "select * from sys_ci_type_attr where status<>'deleted' and ci_type in (%s) order by ci_type,ui_form_order", ciTypeIdList); err != nil {
+		err = fmt.Errorf("Try to query ci type attr table fail,%s ", err.Error())
+		return
+	}
+	if err = findModelRowsIn(&bundle.StateMachines, "select * from sys_state_machine where id in (%s) order by id", stateMachineList); err != nil {
+		err = fmt.Errorf("Try to query state machine table fail,%s ", err.Error())
+		return
+	}
+	if err = findModelRowsIn(&bundle.States, "select * from sys_state where state_machine in (%s) order by state_machine,id", stateMachineList); err != nil {
+		err = fmt.Errorf("Try to query state table fail,%s ", err.Error())
+		return
+	}
+	if err = findModelRowsIn(&bundle.StateTransitions, "select * from sys_state_transition where state_machine in (%s) order by state_machine,guid", stateMachineList); err != nil {
+		err = fmt.Errorf("Try to query state transition table fail,%s ", err.Error())
+		return
+	}
+	if err = findModelRowsIn(&bundle.Reports, "select * from sys_report where ci_type in (%s) order by id", ciTypeIdList); err != nil {
+		err = fmt.Errorf("Try to query report table fail,%s ", err.Error())
+		return
+	}
+	var reportIdList, viewIdList, graphIdList []string
+	for _, report := range bundle.Reports {
+		reportIdList = append(reportIdList, report.Id)
+	}
+	if err = findModelRowsIn(&bundle.ReportObjects, "select * from sys_report_object where report in (%s) order by report,seq_no", reportIdList); err != nil {
+		err = fmt.Errorf("Try to query report object table fail,%s ", err.Error())
+		return
+	}
+	if err = findModelRowsIn(&bundle.ReportObjectAttrs, "select * from sys_report_object_attr where report_object in (select id from sys_report_object where report in (%s)) order by report_object,id", reportIdList); err != nil {
+		err = fmt.Errorf("Try to query report object attr table fail,%s ", err.Error())
+		return
+	}
+	if err = findModelRowsIn(&bundle.ReportObjectFilters, "select * from sys_report_object_filter where sys_report_object in (select id from sys_report_object where report in (%s)) order by sys_report_object,id", reportIdList); err != nil {
+		err = fmt.Errorf("Try to query report object filter table fail,%s ", err.Error())
+		return
+	}
+	if err = findModelRowsIn(&bundle.Views, "select * from sys_view where report in (%s) order by id", reportIdList); err != nil {
+		err = fmt.Errorf("Try to query view table fail,%s ", err.Error())
+		return
+	}
+	for _, view := range bundle.Views {
+		viewIdList = append(viewIdList, view.Id)
+	}
+	if err = findModelRowsIn(&bundle.Graphs, "select * from sys_graph where `view` in (%s) order by id", viewIdList); err != nil {
+		err = fmt.Errorf("Try to query graph table fail,%s ", err.Error())
+		return
+	}
+	for _, graph := range bundle.Graphs {
+		graphIdList = append(graphIdList, graph.Id)
+	}
+	if err = findModelRowsIn(&bundle.GraphElements, "select * from sys_graph_element where graph in (%s) order by graph,seq_no,id", graphIdList); err != nil {
+		err = fmt.Errorf("Try to query graph element table fail,%s ", err.Error())
+	}
+	return
+}
+
+// PlanModelImport 对比导入包与当前环境,生成导入计划,不修改任何数据
+func PlanModelImport(bundle *models.ModelBundle) (plan *models.ModelImportPlan, err error) {
+	plan = &models.ModelImportPlan{Version: bundle.Version}
+	if bundle.Version != models.ModelBundleVersion {
+		err = fmt.Errorf("Model bundle version:%s is not supported,current version is %s ", bundle.Version, models.ModelBundleVersion)
+		return
+	}
+	target, loadErr := loadModelImportTarget()
+	if loadErr != nil {
+		err = loadErr
+		return
+	}
+	bundleCiTypeMap := make(map[string]*models.SysCiTypeTable)
+	for _, ciType := range bundle.CiTypes {
+		bundleCiTypeMap[ciType.Id] = ciType
+	}
+	bundleAttrMap := make(map[string]*models.SysCiTypeAttrTable)
+	for _, attr := range bundle.CiTypeAttrs {
+		bundleAttrMap[attr.CiType+models.SysTableIdConnector+attr.Name] = attr
+	}
+	bundleStateMachineMap := make(map[string]bool)
+	for _, stateMachine := range bundle.StateMachines {
+		bundleStateMachineMap[stateMachine.Id] = true
+	}
+	// ci type
+	applyCiTypeMap := make(map[string]bool)
+	for _, ciType := range bundle.CiTypes {
+		existCiType, ok := target.ciTypeMap[ciType.Id]
+		if !ok {
+			plan.NewCiTypes = append(plan.NewCiTypes, ciType.Id)
+			template, templateOk := target.templateMap[ciType.CiTemplate]
+			if !templateOk {
+				plan.Errors = append(plan.Errors, fmt.Sprintf("ciType:%s template:%s not found", ciType.Id, ciType.CiTemplate))
+			} else if template.StateMachine != ciType.StateMachine {
+				plan.Warnings = append(plan.Warnings, fmt.Sprintf("ciType:%s state machine will use template's:%s instead of %s", ciType.Id, template.StateMachine, ciType.StateMachine))
+			}
+		} else if existCiType.Status == "deleted" {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("ciType:%s is deleted in current environment", ciType.Id))
+			continue
+		}
+		for _, code := range []string{ciType.CiGroup, ciType.CiLayer} {
+			if code != "" && !target.baseKeyCodeMap[code] {
+				plan.Errors = append(plan.Errors, fmt.Sprintf("ciType:%s base key code:%s not found", ciType.Id, code))
+			}
+		}
+		if ciType.Status == "created" && (!ok || existCiType.Status != "created") {
+			applyCiTypeMap[ciType.Id] = true
+			plan.ApplyCiTypes = append(plan.ApplyCiTypes, ciType.Id)
+		}
+	}
+	// ci type attr
+	for _, attr := range bundle.CiTypeAttrs {
+		attrId := attr.CiType + models.SysTableIdConnector + attr.Name
+		if _, ok := bundleCiTypeMap[attr.CiType]; !ok {
+			if _, targetOk := target.ciTypeMap[attr.CiType]; !targetOk {
+				plan.Errors = append(plan.Errors, fmt.Sprintf("attr:%s ciType:%s not found", attrId, attr.CiType))
+				continue
+			}
+		}
+		if attr.RefCiType != "" {
+			if _, ok := bundleCiTypeMap[attr.RefCiType]; !ok {
+				if refCiType, targetOk := target.ciTypeMap[attr.RefCiType]; !targetOk || refCiType.Status == "deleted" {
+					plan.Errors = append(plan.Errors, fmt.Sprintf("attr:%s ref ciType:%s not found", attrId, attr.RefCiType))
+				}
+			}
+		}
+		if attr.RefType != "" && !target.baseKeyCodeMap[attr.RefType] {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("attr:%s ref type:%s not found", attrId, attr.RefType))
+		}
+		if attr.SelectList != "" && !target.baseKeyCatMap[attr.SelectList] {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("attr:%s select list:%s not found", attrId, attr.SelectList))
+		}
+		existAttr, ok := target.attrMap[attrId]
+		if !ok {
+			plan.NewAttrs = append(plan.NewAttrs, attrId)
+		} else if existAttr.Status == "deleted" {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("attr:%s is deleted in current environment", attrId))
+			continue
+		} else {
+			fieldChangeList := diffModelRowFields(existAttr, attr, modelAttrIgnoreKeys)
+			if len(fieldChangeList) > 0 {
+				if existAttr.Customizable == "no" {
+					plan.Warnings = append(plan.Warnings, fmt.Sprintf("attr:%s is not editable,changes will be ignored", attrId))
+				} else {
+					plan.ChangedAttrs = append(plan.ChangedAttrs, &models.ModelAttrChangeObj{Id: attrId, Fields: fieldChangeList})
+				}
+			}
+			if existAttr.Status == "created" {
+				if existAttr.InputType != attr.InputType || existAttr.DataType != attr.DataType {
+					plan.Warnings = append(plan.Warnings, fmt.Sprintf("attr:%s is created,input type or data type change will be ignored", attrId))
+				}
+				if (existAttr.DataType == "varchar" || existAttr.DataType == "int") && attr.DataLength < existAttr.DataLength {
+					plan.Warnings = append(plan.Warnings, fmt.Sprintf("attr:%s data length will shrink from %d to %d,data may be truncated", attrId, existAttr.DataLength, attr.DataLength))
+				}
+			}
+		}
+		if attr.Status == "created" && !applyCiTypeMap[attr.CiType] && (!ok || existAttr.Status != "created") {
+			if existCiType, ciTypeOk := target.ciTypeMap[attr.CiType]; ciTypeOk && existCiType.Status == "created" {
+				plan.ApplyAttrs = append(plan.ApplyAttrs, attrId)
+			}
+		}
+	}
+	// state machine
+	bundleRowJsonMap := buildModelBundleRowJsonMap(bundle)
+	stateMachineChangeMap := make(map[string]bool)
+	for _, state := range bundle.States {
+		if isModelRowChanged(target, bundleRowJsonMap, "sys_state", state.Id) {
+			stateMachineChangeMap[state.StateMachine] = true
+		}
+	}
+	for _, transition := range bundle.StateTransitions {
+		if !bundleStateMachineMap[transition.StateMachine] {
+			continue
+		}
+		if isModelRowChanged(target, bundleRowJsonMap, "sys_state_transition", transition.Guid) {
+			stateMachineChangeMap[transition.StateMachine] = true
+		}
+	}
+	for _, stateMachine := range bundle.StateMachines {
+		if _, ok := target.rowJsonMap["sys_state_machine"][stateMachine.Id]; !ok {
+			plan.NewStateMachines = append(plan.NewStateMachines, stateMachine.Id)
+		} else if stateMachineChangeMap[stateMachine.Id] || isModelRowChanged(target, bundleRowJsonMap, "sys_state_machine", stateMachine.Id) {
+			plan.ChangedStateMachines = append(plan.ChangedStateMachines, stateMachine.Id)
+		}
+	}
+	// report
+	isCiTypeExist := func(ciType string) bool {
+		if _, ok := bundleCiTypeMap[ciType]; ok {
+			return true
+		}
+		existCiType, ok := target.ciTypeMap[ciType]
+		return ok && existCiType.Status != "deleted"
+	}
+	checkAttrRef := func(object, objectId, attrId string) {
+		if attrId == "" {
+			return
+		}
+		if _, ok := bundleAttrMap[attrId]; ok {
+			return
+		}
+		if existAttr, ok := target.attrMap[attrId]; ok && existAttr.Status != "deleted" {
+			return
+		}
+		plan.MissingAttrRefs = append(plan.MissingAttrRefs, &models.ModelMissingRefObj{Object: object, ObjectId: objectId, Attr: attrId})
+	}
+	reportChangeMap := make(map[string]bool)
+	reportObjectMap := make(map[string]string)
+	for _, reportObject := range bundle.ReportObjects {
+		reportObjectMap[reportObject.Id] = reportObject.Report
+		if !isCiTypeExist(reportObject.CiType) {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("report object:%s ciType:%s not found", reportObject.Id, reportObject.CiType))
+		}
+		checkAttrRef("sys_report_object", reportObject.Id, reportObject.ParentAttr)
+		checkAttrRef("sys_report_object", reportObject.Id, reportObject.MyAttr)
+		if isModelRowChanged(target, bundleRowJsonMap, "sys_report_object", reportObject.Id) {
+			reportChangeMap[reportObject.Report] = true
+		}
+	}
+	for _, reportObjectAttr := range bundle.ReportObjectAttrs {
+		checkAttrRef("sys_report_object_attr", reportObjectAttr.Id, reportObjectAttr.CiTypeAttr)
+		if isModelRowChanged(target, bundleRowJsonMap, "sys_report_object_attr", reportObjectAttr.Id) {
+			reportChangeMap[reportObjectMap[reportObjectAttr.ReportObject]] = true
+		}
+	}
+	for _, reportObjectFilter := range bundle.ReportObjectFilters {
+		if !isCiTypeExist(reportObjectFilter.FilterCiType) {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("report object filter:%s ciType:%s not found", reportObjectFilter.Id, reportObjectFilter.FilterCiType))
+		}
+		if isModelRowChanged(target, bundleRowJsonMap, "sys_report_object_filter", reportObjectFilter.Id) {
+			reportChangeMap[reportObjectMap[reportObjectFilter.SysReportObject]] = true
+		}
+	}
+	bundleReportMap := make(map[string]bool)
+	for _, report := range bundle.Reports {
+		bundleReportMap[report.Id] = true
+		if !isCiTypeExist(report.CiType) {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("report:%s ciType:%s not found", report.Id, report.CiType))
+		}
+		if _, ok := target.rowJsonMap["sys_report"][report.Id]; !ok {
+			plan.NewReports = append(plan.NewReports, report.Id)
+		} else if reportChangeMap[report.Id] || isModelRowChanged(target, bundleRowJsonMap, "sys_report", report.Id) {
+			plan.ChangedReports = append(plan.ChangedReports, report.Id)
+		}
+	}
+	// view
+	viewChangeMap := make(map[string]bool)
+	graphViewMap := make(map[string]string)
+	for _, graph := range bundle.Graphs {
+		graphViewMap[graph.Id] = graph.View
+		if isModelRowChanged(target, bundleRowJsonMap, "sys_graph", graph.Id) {
+			viewChangeMap[graph.View] = true
+		}
+	}
+	for _, graphElement := range bundle.GraphElements {
+		if _, ok := reportObjectMap[graphElement.ReportObject]; !ok {
+			if _, targetOk := target.rowJsonMap["sys_report_object"][graphElement.ReportObject]; !targetOk {
+				plan.Errors = append(plan.Errors, fmt.Sprintf("graph element:%s report object:%s not found", graphElement.Id, graphElement.ReportObject))
+			}
+		}
+		checkAttrRef("sys_graph_element", graphElement.Id, graphElement.EditRefAttr)
+		if isModelRowChanged(target, bundleRowJsonMap, "sys_graph_element", graphElement.Id) {
+			viewChangeMap[graphViewMap[graphElement.Graph]] = true
+		}
+	}
+	for _, view := range bundle.Views {
+		if _, ok := target.rowJsonMap["sys_report"][view.Report]; !ok && !bundleReportMap[view.Report] {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("view:%s report:%s not found", view.Id, view.Report))
+		}
+		checkAttrRef("sys_view", view.Id, view.FilterAttr)
+		if _, ok := target.rowJsonMap["sys_view"][view.Id]; !ok {
+			plan.NewViews = append(plan.NewViews, view.Id)
+		} else if viewChangeMap[view.Id] || isModelRowChanged(target, bundleRowJsonMap, "sys_view", view.Id) {
+			plan.ChangedViews = append(plan.ChangedViews, view.Id)
+		}
+	}
+	for _, missingRef := range plan.MissingAttrRefs {
+		plan.Errors = append(plan.Errors, fmt.Sprintf("%s:%s reference attr:%s not found", missingRef.Object, missingRef.ObjectId, missingRef.Attr))
+	}
+	return
+}
+
+// ApplyModelImport 按导入计划执行,ci类型和属性通过与页面相同的创建/确认流程生成物理表
+// 导入只新增和更新,导入包中没有的ci类型、属性、状态机、报表和视图保持不变,不会被删除
+// 建表和加列无法与元数据放在同一个事务中,执行前记录导入前的元数据,任一步骤失败时删除已建的表和列并还原元数据
+func ApplyModelImport(bundle *models.ModelBundle, operator string) (plan *models.ModelImportPlan, err error) {
+	modelImportLock.Lock()
+	defer modelImportLock.Unlock()
+	if plan, err = PlanModelImport(bundle); err != nil {
+		return
+	}
+	if len(plan.Errors) > 0 {
+		err = fmt.Errorf("Model import plan have %d errors,please check the dry run result ", len(plan.Errors))
+		return
+	}
+	undo, undoErr := newModelImportUndo(bundle)
+	if undoErr != nil {
+		err = undoErr
+		return
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if rollbackErrList := undo.rollback(); len(rollbackErrList) > 0 {
+			err = fmt.Errorf("%s,rollback fail:%s ", err.Error(), strings.Join(rollbackErrList, ";"))
+		} else {
+			log.Warn(nil, log.LOGGER_APP, "Apply model import fail,rollback done", zap.Error(err))
+		}
+	}()
+	if err = applyModelStateMachine(bundle); err != nil {
+		return
+	}
+	updateAutofillMap, applyErr := applyModelCiTypeAndAttr(bundle, undo)
+	if applyErr != nil {
+		err = applyErr
+		return
+	}
+	if err = applyModelCiTypeTable(plan.ApplyCiTypes, undo); err != nil {
+		return
+	}
+	for _, attrId := range plan.ApplyAttrs {
+		ciType := attrId[:strings.Index(attrId, models.SysTableIdConnector)]
+		if err = CheckCiTypeSyncRef(ciType); err != nil {
+			return
+		}
+		attr, getErr := GetCiAttrById(attrId)
+		if getErr != nil {
+			err = getErr
+			return
+		}
+		if attr.InputType != models.MultiRefType || checkTableIfExists(fmt.Sprintf("%s$%s", attr.CiType, attr.Name)) == nil {
+			undo.applyAttrs = append(undo.applyAttrs, attr)
+		}
+		if err = CiAttrApply(ciType, attrId, updateAutofillMap[attrId]); err != nil {
+			err = fmt.Errorf("Try to apply attr:%s fail,%s ", attrId, err.Error())
+			return
+		}
+		delete(updateAutofillMap, attrId)
+		AutoCreateRoleCiTypeAttrPermission(ciType)
+	}
+	for attrId, updateAutofill := range updateAutofillMap {
+		if !updateAutofill {
+			continue
+		}
+		ciType := attrId[:strings.Index(attrId, models.SysTableIdConnector)]
+		if err = CiAttrApply(ciType, attrId, updateAutofill); err != nil {
+			err = fmt.Errorf("Try to apply attr:%s autofill fail,%s ", attrId, err.Error())
+			return
+		}
+	}
+	if err = applyModelReportAndView(bundle, operator); err != nil {
+		return
+	}
+	plan.Applied = true
+	log.Info(nil, log.LOGGER_APP, "Apply model import success", zap.Strings("newCiTypes", plan.NewCiTypes), zap.Strings("newAttrs", plan.NewAttrs))
+	return
+}
+
+// modelImportUndo 导入失败时的回退计划: 导入前的元数据、已确认属性的原定义、已建表的ci类型和已加列的属性
+// 已触发的自动填充数据刷新不在回退范围内
+type modelImportUndo struct {
+	metaActions  []*execAction
+	updatedAttrs []*models.SysCiTypeAttrTable
+	tableCiTypes []string
+	applyAttrs   []*models.SysCiTypeAttrTable
+}
+
+func newModelImportUndo(bundle *models.ModelBundle) (undo *modelImportUndo, err error) {
+	undo = &modelImportUndo{}
+	var ciTypeList, stateMachineList, stateList, transitionList, reportList, reportObjectList, reportObjectAttrList, reportObjectFilterList, viewList, graphList, graphElementList []string
+	ciTypeMap := make(map[string]bool)
+	for _, ciType := range bundle.CiTypes {
+		if !ciTypeMap[ciType.Id] {
+			ciTypeMap[ciType.Id] = true
+			ciTypeList = append(ciTypeList, ciType.Id)
+		}
+	}
+	for _, attr := range bundle.CiTypeAttrs {
+		if !ciTypeMap[attr.CiType] {
+			ciTypeMap[attr.CiType] = true
+			ciTypeList = append(ciTypeList, attr.CiType)
+		}
+	}
+	for _, row := range bundle.StateMachines {
+		stateMachineList = append(stateMachineList, row.Id)
+	}
+	for _, row := range bundle.States {
+		stateList = append(stateList, row.Id)
+	}
+	for _, row := range bundle.StateTransitions {
+		transitionList = append(transitionList, row.Guid)
+	}
+	for _, row := range bundle.Reports {
+		reportList = append(reportList, row.Id)
+	}
+	for _, row := range bundle.ReportObjects {
+		reportObjectList = append(reportObjectList, row.Id)
+	}
+	for _, row := range bundle.ReportObjectAttrs {
+		reportObjectAttrList = append(reportObjectAttrList, row.Id)
+	}
+	for _, row := range bundle.ReportObjectFilters {
+		reportObjectFilterList = append(reportObjectFilterList, row.Id)
+	}
+	for _, row := range bundle.Views {
+		viewList = append(viewList, row.Id)
+	}
+	for _, row := range bundle.Graphs {
+		graphList = append(graphList, row.Id)
+	}
+	for _, row := range bundle.GraphElements {
+		graphElementList = append(graphElementList, row.Id)
+	}
+	if len(ciTypeList) > 0 {
+		// 建表和确认属性时会自动生成角色权限,回退时删除导入前不存在的
+		specSql, params := createListParams(ciTypeList, "")
+		roleRows, queryErr := x.QueryString(append([]interface{}{fmt.Sprintf("select guid from sys_role_ci_type where ci_type in (%s)", specSql)}, params...)...)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to query role ci type fail,%s ", queryErr.Error())
+			return
+		}
+		deleteAction := execAction{Sql: fmt.Sprintf("delete from sys_role_ci_type where ci_type in (%s)", specSql), Param: params}
+		if len(roleRows) > 0 {
+			var roleGuidList []string
+			for _, row := range roleRows {
+				roleGuidList = append(roleGuidList, row["guid"])
+			}
+			guidSpecSql, guidParams := createListParams(roleGuidList, "")
+			deleteAction.Sql += fmt.Sprintf(" and guid not in (%s)", guidSpecSql)
+			deleteAction.Param = append(append([]interface{}{}, params...), guidParams...)
+		}
+		undo.metaActions = append(undo.metaActions, &deleteAction)
+	}
+	tableIdList := []struct {
+		tableName string
+		idColumn  string
+		idList    []string
+	}{
+		{"sys_state_machine", "id", stateMachineList}, {"sys_state", "id", stateList}, {"sys_state_transition", "guid", transitionList},
+		{"sys_ci_type", "id", ciTypeList}, {"sys_ci_type_attr", "ci_type", ciTypeList},
+		{"sys_report", "id", reportList}, {"sys_report_object", "id", reportObjectList}, {"sys_report_object_attr", "id", reportObjectAttrList}, {"sys_report_object_filter", "id", reportObjectFilterList},
+		{"sys_view", "id", viewList}, {"sys_graph", "id", graphList}, {"sys_graph_element", "id", graphElementList},
+	}
+	for _, tableObj := range tableIdList {
+		if len(tableObj.idList) == 0 {
+			continue
+		}
+		specSql, params := createListParams(tableObj.idList, "")
+		rows, queryErr := queryRawRows(tableObj.tableName, fmt.Sprintf("select * from `%s` where `%s` in (%s)", tableObj.tableName, tableObj.idColumn, specSql), params...)
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		undo.metaActions = append(undo.metaActions, &execAction{Sql: fmt.Sprintf("delete from `%s` where `%s` in (%s)", tableObj.tableName, tableObj.idColumn, specSql), Param: params})
+		undo.metaActions = append(undo.metaActions, buildRawRowInsertActions(tableObj.tableName, rows)...)
+	}
+	return
+}
+
+// rollback 先还原已确认属性的列定义,再删除新加的列和新建的表,最后还原元数据
+func (undo *modelImportUndo) rollback() (errList []string) {
+	for i := len(undo.updatedAttrs) - 1; i >= 0; i-- {
+		attr := *undo.updatedAttrs[i]
+		if _, err := CiAttrUpdate(&attr); err != nil {
+			errList = append(errList, fmt.Sprintf("restore attr:%s fail,%s", attr.Id, err.Error()))
+		}
+	}
+	for _, attr := range undo.applyAttrs {
+		if attr.InputType == models.MultiRefType {
+			tableName := fmt.Sprintf("%s$%s", attr.CiType, attr.Name)
+			errList = append(errList, dropModelImportTables([]string{tableName, HistoryTablePrefix + tableName})...)
+			continue
+		}
+		for _, tableName := range []string{attr.CiType, HistoryTablePrefix + attr.CiType} {
+			if err := dropModelImportColumn(tableName, attr.Name); err != nil {
+				errList = append(errList, err.Error())
+			}
+		}
+	}
+	for _, ciType := range undo.tableCiTypes {
+		tableList := []string{}
+		multiRefRows, err := x.QueryString("select name from sys_ci_type_attr where ci_type=? and input_type=?", ciType, models.MultiRefType)
+		if err != nil {
+			errList = append(errList, fmt.Sprintf("query ciType:%s multiRef attr fail,%s", ciType, err.Error()))
+			continue
+		}
+		for _, row := range multiRefRows {
+			tableName := fmt.Sprintf("%s$%s", ciType, row["name"])
+			tableList = append(tableList, tableName, HistoryTablePrefix+tableName)
+		}
+		tableList = append(tableList, HistoryTablePrefix+ciType, ciType)
+		errList = append(errList, dropModelImportTables(tableList)...)
+	}
+	if len(undo.metaActions) > 0 {
+		if err := transactionWithoutForeignCheck(undo.metaActions); err != nil {
+			errList = append(errList, fmt.Sprintf("restore model meta data fail,%s", err.Error()))
+		}
+	}
+	return
+}
+
+func dropModelImportTables(tableList []string) (errList []string) {
+	for _, tableName := range tableList {
+		if _, err := x.Exec(fmt.Sprintf("DROP TABLE IF EXISTS `%s`", tableName)); err != nil {
+			errList = append(errList, fmt.Sprintf("drop table:%s fail,%s", tableName, err.Error()))
+		}
+	}
+	return
+}
+
+// dropModelImportColumn 属性确认失败时列可能还没加上,列存在时才删除
+func dropModelImportColumn(tableName, columnName string) (err error) {
+	queryRows, queryErr := x.QueryString("select count(1) as num from information_schema.columns where table_schema=database() and table_name=? and column_name=?", tableName, columnName)
+	if queryErr != nil {
+		return fmt.Errorf("query table:%s column:%s fail,%s", tableName, columnName, queryErr.Error())
+	}
+	if len(queryRows) == 0 || queryRows[0]["num"] == "0" {
+		return
+	}
+	if _, err = x.Exec(fmt.Sprintf("ALTER TABLE `%s` DROP COLUMN `%s`", tableName, columnName)); err != nil {
+		err = fmt.Errorf("drop table:%s column:%s fail,%s", tableName, columnName, err.Error())
+	}
+	return
+}
+
+func applyModelStateMachine(bundle *models.ModelBundle) (err error) {
+	if len(bundle.StateMachines) == 0 {
+		return
+	}
+	existMap, queryErr := queryModelExistIdMap("select concat('machine:',id) as id from sys_state_machine union all select concat('state:',id) from sys_state union all select concat('transition:',guid) from sys_state_transition")
+	if queryErr != nil {
+		return queryErr
+	}
+	var actions []*execAction
+	var action *execAction
+	for _, stateMachine := range bundle.StateMachines {
+		if existMap["machine:"+stateMachine.Id] {
+			action, _ = GetUpdateTableExecAction("sys_state_machine", "id", stateMachine.Id, *stateMachine, nil)
+		} else {
+			action, _ = GetInsertTableExecAction("sys_state_machine", *stateMachine, nil)
+		}
+		actions = append(actions, action)
+	}
+	for _, state := range bundle.States {
+		if existMap["state:"+state.Id] {
+			action, _ = GetUpdateTableExecAction("sys_state", "id", state.Id, *state, nil)
+		} else {
+			action, _ = GetInsertTableExecAction("sys_state", *state, nil)
+		}
+		actions = append(actions, action)
+	}
+	for _, transition := range bundle.StateTransitions {
+		if existMap["transition:"+transition.Guid] {
+			action, _ = GetUpdateTableExecAction("sys_state_transition", "guid", transition.Guid, *transition, nil)
+		} else {
+			action, _ = GetInsertTableExecAction("sys_state_transition", *transition, nil)
+		}
+		actions = append(actions, action)
+	}
+	if err = transaction(actions); err != nil {
+		err = fmt.Errorf("Try to import state machine fail,%s ", err.Error())
+	}
+	return
+}
+
+func applyModelCiTypeAndAttr(bundle *models.ModelBundle, undo *modelImportUndo) (updateAutofillMap map[string]bool, err error) {
+	updateAutofillMap = make(map[string]bool)
+	for _, bundleCiType := range bundle.CiTypes {
+		ciType := *bundleCiType
+		ciType.ImageFile = ""
+		if ciType.SyncEnable == "" {
+			ciType.SyncEnable = "N"
+		}
+		if _, getErr := GetCiTypeById(ciType.Id); getErr != nil {
+			if err = CiTypesCreate(&ciType); err != nil {
+				err = fmt.Errorf("Try to create ciType:%s fail,%s ", ciType.Id, err.Error())
+				return
+			}
+			continue
+		}
+		if _, err = x.Exec("UPDATE sys_ci_type SET display_name=?,description=?,ci_group=?,ci_layer=?,sync_enable=? where id=?",
+			ciType.DisplayName, ciType.Description, ciType.CiGroup, ciType.CiLayer, ciType.SyncEnable, ciType.Id); err != nil {
+			err = fmt.Errorf("Try to update ciType:%s fail,%s ", ciType.Id, err.Error())
+			return
+		}
+	}
+	for _, bundleAttr := range bundle.CiTypeAttrs {
+		attr := *bundleAttr
+		attr.Id = attr.CiType + models.SysTableIdConnector + attr.Name
+		existAttr, getErr := GetCiAttrById(attr.Id)
+		if getErr != nil {
+			if err = CiAttrCreate(&attr); err != nil {
+				err = fmt.Errorf("Try to create attr:%s fail,%s ", attr.Id, err.Error())
+				return
+			}
+			continue
+		}
+		// 模版自动生成的属性或不可编辑的属性
+		if existAttr.Customizable == "no" || len(diffModelRowFields(existAttr, &attr, modelAttrIgnoreKeys)) == 0 {
+			continue
+		}
+		if existAttr.Status == "created" {
+			undo.updatedAttrs = append(undo.updatedAttrs, existAttr)
+		}
+		updateAutofill, updateErr := CiAttrUpdate(&attr)
+		if updateErr != nil {
+			err = fmt.Errorf("Try to update attr:%s fail,%s ", attr.Id, updateErr.Error())
+			return
+		}
+		updateAutofillMap[attr.Id] = updateAutofill
+	}
+	return
+}
+
+// applyModelCiTypeTable 按引用关系依次确认ci类型,被引用的ci类型需要先确认
+func applyModelCiTypeTable(ciTypeList []string, undo *modelImportUndo) (err error) {
+	pendingMap := make(map[string]bool)
+	for _, ciType := range ciTypeList {
+		pendingMap[ciType] = true
+	}
+	for len(pendingMap) > 0 {
+		var readyList []string
+		for _, ciType := range ciTypeList {
+			if !pendingMap[ciType] {
+				continue
+			}
+			attrList, getErr := GetCiAttrByCiType(ciType, false)
+			if getErr != nil {
+				return getErr
+			}
+			ready := true
+			for _, attr := range attrList {
+				if attr.RefCiType != "" && attr.RefCiType != ciType && pendingMap[attr.RefCiType] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				readyList = append(readyList, ciType)
+			}
+		}
+		if len(readyList) == 0 {
+			var leftList []string
+			for ciType := range pendingMap {
+				leftList = append(leftList, ciType)
+			}
+			sort.Strings(leftList)
+			return fmt.Errorf("CiType:%s reference each other,please apply them manually ", strings.Join(leftList, ","))
+		}
+		for _, ciType := range readyList {
+			if err = CheckCiTypeSyncRef(ciType); err != nil {
+				return
+			}
+			// 已经存在的表不是本次导入创建的,回退时不能删除
+			if checkTableIfExists(ciType) == nil {
+				undo.tableCiTypes = append(undo.tableCiTypes, ciType)
+			}
+			if err = CreateCiTable(ciType); err != nil {
+				return fmt.Errorf("Try to create ciType:%s table fail,%s ", ciType, err.Error())
+			}
+			UpdateCiTypesStatus(ciType, "created")
+			AutoCreateRoleCiTypeDataByCiType(ciType)
+			AutoCreateRoleCiTypeAttrPermission(ciType)
+			delete(pendingMap, ciType)
+		}
+	}
+	return
+}
+
+func applyModelReportAndView(bundle *models.ModelBundle, operator string) (err error) {
+	if len(bundle.Reports) == 0 && len(bundle.Views) == 0 {
+		return
+	}
+	existMap, queryErr := queryModelExistIdMap("select concat('report:',id) as id from sys_report union all select concat('object:',id) from sys_report_object union all select concat('attr:',id) from sys_report_object_attr " +
+		"union all select concat('filter:',id) from sys_report_object_filter union all select concat('view:',id) from sys_view union all select concat('graph:',id) from sys_graph union all select concat('element:',id) from sys_graph_element")
+	if queryErr != nil {
+		return queryErr
+	}
+	nowTime := time.Now()
+	var actions []*execAction
+	appendAction := func(existKey, tableName, primeKey, primeKeyVal string, data interface{}, transNullStr map[string]string) {
+		var action *execAction
+		if existMap[existKey] {
+			action, _ = GetUpdateTableExecAction(tableName, primeKey, primeKeyVal, data, transNullStr)
+		} else {
+			action, _ = GetInsertTableExecAction(tableName, data, transNullStr)
+		}
+		actions = append(actions, action)
+	}
+	for _, bundleReport := range bundle.Reports {
+		report := *bundleReport
+		report.CreateTime, report.CreateUser = nowTime.Format(models.DateTimeFormat), operator
+		report.UpdateTime, report.UpdateUser = report.CreateTime, operator
+		if existMap["report:"+report.Id] {
+			actions = append(actions, &execAction{Sql: "update sys_report set name=?,ci_type=?,update_time=?,update_user=?,used_by_view=?,used_by_export=?,sql_cache=null where id=?",
+				Param: []interface{}{report.Name, report.CiType, report.UpdateTime, report.UpdateUser, report.UsedByView, report.UsedByExport, report.Id}})
+		} else {
+			appendAction("report:"+report.Id, "sys_report", "id", report.Id, report, nil)
+		}
+	}
+	reportObjectNullStr := map[string]string{"parent_object": "true", "parent_attr": "true", "my_attr": "true"}
+	for _, reportObject := range sortModelReportObjects(bundle.ReportObjects) {
+		appendAction("object:"+reportObject.Id, "sys_report_object", "id", reportObject.Id, *reportObject, reportObjectNullStr)
+	}
+	for _, reportObjectAttr := range bundle.ReportObjectAttrs {
+		appendAction("attr:"+reportObjectAttr.Id, "sys_report_object_attr", "id", reportObjectAttr.Id, *reportObjectAttr, nil)
+	}
+	for _, reportObjectFilter := range bundle.ReportObjectFilters {
+		appendAction("filter:"+reportObjectFilter.Id, "sys_report_object_filter", "id", reportObjectFilter.Id, *reportObjectFilter, nil)
+	}
+	for _, bundleView := range bundle.Views {
+		view := *bundleView
+		if existMap["view:"+view.Id] {
+			actions = append(actions, &execAction{Sql: "update sys_view set name=?,report=?,editable=?,suport_version=?,multiple=?,update_time=?,update_user=?,filter_attr=?,filter_value=? where id=?",
+				Param: []interface{}{view.Name, view.Report, view.Editable, view.SuportVersion, view.Multiple, nowTime, operator, NewNullString(view.FilterAttr), view.FilterValue, view.Id}})
+		} else {
+			view.CreateTime, view.CreateUser, view.UpdateTime, view.UpdateUser = nowTime, operator, nowTime, operator
+			appendAction("view:"+view.Id, "sys_view", "id", view.Id, view, map[string]string{"filter_attr": "true"})
+		}
+	}
+	for _, graph := range bundle.Graphs {
+		appendAction("graph:"+graph.Id, "sys_graph", "id", graph.Id, *graph, nil)
+	}
+	graphElementNullStr := map[string]string{"parent_element": "true", "edit_ref_attr": "true"}
+	for _, bundleGraphElement := range sortModelGraphElements(bundle.GraphElements) {
+		graphElement := *bundleGraphElement
+		if graphElement.SeqNo == "" {
+			graphElement.SeqNo = "0"
+		}
+		appendAction("element:"+graphElement.Id, "sys_graph_element", "id", graphElement.Id, graphElement, graphElementNullStr)
+	}
+	if err = transaction(actions); err != nil {
+		err = fmt.Errorf("Try to import report and view fail,%s ", err.Error())
+	}
+	return
+}
+
+// sortModelReportObjects 父对象排在子对象前面,保证外键插入顺序
+func sortModelReportObjects(input []*models.SysReportObjectTable) (output []*models.SysReportObjectTable) {
+	doneMap := make(map[string]bool)
+	idMap := make(map[string]bool)
+	for _, row := range input {
+		idMap[row.Id] = true
+	}
+	for len(output) < len(input) {
+		beforeLen := len(output)
+		for _, row := range input {
+			if doneMap[row.Id] {
+				continue
+			}
+			if row.ParentObject == "" || !idMap[row.ParentObject] || doneMap[row.ParentObject] {
+				doneMap[row.Id] = true
+				output = append(output, row)
+			}
+		}
+		if len(output) == beforeLen {
+			break
+		}
+	}
+	return
+}
+
+func sortModelGraphElements(input []*models.SysGraphElementTable) (output []*models.SysGraphElementTable) {
+	doneMap := make(map[string]bool)
+	idMap := make(map[string]bool)
+	for _, row := range input {
+		idMap[row.Id] = true
+	}
+	for len(output) < len(input) {
+		beforeLen := len(output)
+		for _, row := range input {
+			if doneMap[row.Id] {
+				continue
+			}
+			if row.ParentElement == "" || !idMap[row.ParentElement] || doneMap[row.ParentElement] {
+				doneMap[row.Id] = true
+				output = append(output, row)
+			}
+		}
+		if len(output) == beforeLen {
+			break
+		}
+	}
+	return
+}
+
+func loadModelImportTarget() (target *modelImportTarget, err error) {
+	target = &modelImportTarget{ciTypeMap: make(map[string]*models.SysCiTypeTable), attrMap: make(map[string]*models.SysCiTypeAttrTable),
+		templateMap: make(map[string]*models.SysCiTemplateTable), rowJsonMap: make(map[string]map[string]string)}
+	var ciTypeRows []*models.SysCiTypeTable
+	if err = x.SQL("select * from sys_ci_type").Find(&ciTypeRows); err != nil {
+		err = fmt.Errorf("Try to query ci type table fail,%s ", err.Error())
+		return
+	}
+	for _, row := range ciTypeRows {
+		target.ciTypeMap[row.Id] = row
+	}
+	var attrRows []*models.SysCiTypeAttrTable
+	if err = x.SQL("select * from sys_ci_type_attr").Find(&attrRows); err != nil {
+		err = fmt.Errorf("Try to query ci type attr table fail,%s ", err.Error())
+		return
+	}
+	for _, row := range attrRows {
+		target.attrMap[row.Id] = row
+	}
+	var templateRows []*models.SysCiTemplateTable
+	if err = x.SQL("select * from sys_ci_template").Find(&templateRows); err != nil {
+		err = fmt.Errorf("Try to query ci template table fail,%s ", err.Error())
+		return
+	}
+	for _, row := range templateRows {
+		target.templateMap[row.Id] = row
+	}
+	if target.baseKeyCodeMap, err = queryModelExistIdMap("select id from sys_basekey_code"); err != nil {
+		return
+	}
+	if target.baseKeyCatMap, err = queryModelExistIdMap("select id from sys_basekey_cat"); err != nil {
+		return
+	}
+	var stateMachineRows []*models.SysStateMachineTable
+	var stateRows []*models.SysStateTable
+	var transitionRows []*models.SysStateTransitionTable
+	var reportRows []*models.SysReportTable
+	var reportObjectRows []*models.SysReportObjectTable
+	var reportObjectAttrRows []*models.SysReportObjectAttrTable
+	var reportObjectFilterRows []*models.SysReportObjectFilterTable
+	var viewRows []*models.SysViewTable
+	var graphRows []*models.SysGraphTable
+	var graphElementRows []*models.SysGraphElementTable
+	tableRowsMap := map[string]interface{}{"sys_state_machine": &stateMachineRows, "sys_state": &stateRows, "sys_state_transition": &transitionRows,
+		"sys_report": &reportRows, "sys_report_object": &reportObjectRows, "sys_report_object_attr": &reportObjectAttrRows, "sys_report_object_filter": &reportObjectFilterRows,
+		"sys_view": &viewRows, "sys_graph": &graphRows, "sys_graph_element": &graphElementRows}
+	for tableName, rows := range tableRowsMap {
+		if err = x.SQL("select * from " + tableName).Find(rows); err != nil {
+			err = fmt.Errorf("Try to query %s table fail,%s ", tableName, err.Error())
+			return
+		}
+	}
+	target.rowJsonMap = buildModelRowJsonMap(stateMachineRows, stateRows, transitionRows, reportRows, reportObjectRows, reportObjectAttrRows, reportObjectFilterRows, viewRows, graphRows, graphElementRows)
+	return
+}
+
+func buildModelBundleRowJsonMap(bundle *models.ModelBundle) map[string]map[string]string {
+	return buildModelRowJsonMap(bundle.StateMachines, bundle.States, bundle.StateTransitions, bundle.Reports, bundle.ReportObjects, bundle.ReportObjectAttrs,
+		bundle.ReportObjectFilters, bundle.Views, bundle.Graphs, bundle.GraphElements)
+}
+
+func buildModelRowJsonMap(stateMachines, states, transitions, reports, reportObjects, reportObjectAttrs, reportObjectFilters, views, graphs, graphElements interface{}) map[string]map[string]string {
+	return map[string]map[string]string{
+		"sys_state_machine":        getModelRowJsonMap(stateMachines, "id", nil),
+		"sys_state":                getModelRowJsonMap(states, "id", nil),
+		"sys_state_transition":     getModelRowJsonMap(transitions, "guid", nil),
+		"sys_report":               getModelRowJsonMap(reports, "id", modelTimeIgnoreKeys),
+		"sys_report_object":        getModelRowJsonMap(reportObjects, "reportObjectId", nil),
+		"sys_report_object_attr":   getModelRowJsonMap(reportObjectAttrs, "reportObjectAttrId", nil),
+		"sys_report_object_filter": getModelRowJsonMap(reportObjectFilters, "reportObjectFilterId", nil),
+		"sys_view":                 getModelRowJsonMap(views, "viewId", modelTimeIgnoreKeys),
+		"sys_graph":                getModelRowJsonMap(graphs, "graphId", nil),
+		"sys_graph_element":        getModelRowJsonMap(graphElements, "graphElementId", nil),
+	}
+}
+
+// getModelRowJsonMap 把表数据转成 主键->json 的map,json的key是有序的,可以直接比较
+func getModelRowJsonMap(rowList interface{}, idKey string, ignoreKeys []string) map[string]string {
+	result := make(map[string]string)
+	var rowMapList []map[string]interface{}
+	rowBytes, _ := json.Marshal(rowList)
+	json.Unmarshal(rowBytes, &rowMapList)
+	for _, rowMap := range rowMapList {
+		rowId := fmt.Sprintf("%v", rowMap[idKey])
+		for _, key := range ignoreKeys {
+			delete(rowMap, key)
+		}
+		tmpBytes, _ := json.Marshal(rowMap)
+		result[rowId] = string(tmpBytes)
+	}
+	return result
+}
+
+func isModelRowChanged(target *modelImportTarget, bundleRowJsonMap map[string]map[string]string, tableName, rowId string) bool {
+	targetRowJson, ok := target.rowJsonMap[tableName][rowId]
+	if !ok {
+		return true
+	}
+	return targetRowJson != bundleRowJsonMap[tableName][rowId]
+}
+
+func diffModelRowFields(oldRow, newRow interface{}, ignoreKeys []string) (fieldChangeList []*models.ModelFieldChangeObj) {
+	var oldMap, newMap map[string]interface{}
+	oldBytes, _ := json.Marshal(oldRow)
+	newBytes, _ := json.Marshal(newRow)
+	json.Unmarshal(oldBytes, &oldMap)
+	json.Unmarshal(newBytes, &newMap)
+	for _, key := range ignoreKeys {
+		delete(oldMap, key)
+		delete(newMap, key)
+	}
+	var keyList []string
+	for key := range newMap {
+		keyList = append(keyList, key)
+	}
+	sort.Strings(keyList)
+	for _, key := range keyList {
+		oldValue, newValue := fmt.Sprintf("%v", oldMap[key]), fmt.Sprintf("%v", newMap[key])
+		if oldValue != newValue {
+			fieldChangeList = append(fieldChangeList, &models.ModelFieldChangeObj{Field: key, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+	return
+}
+
+func findModelRowsIn(result interface{}, sqlFormat string, idList []string) (err error) {
+	if len(idList) == 0 {
+		return
+	}
+	specSql, params := createListParams(idList, "")
+	err = x.SQL(fmt.Sprintf(sqlFormat, specSql), params...).Find(result)
+	return
+}
+
+func queryModelExistIdMap(sql string) (existMap map[string]bool, err error) {
+	existMap = make(map[string]bool)
+	queryRows, queryErr := x.QueryString(sql)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query exist id fail,%s ", queryErr.Error())
+		return
+	}
+	for _, row := range queryRows {
+		existMap[row["id"]] = true
+	}
+	return
+}
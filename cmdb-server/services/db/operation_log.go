@@ -14,12 +14,17 @@ func SaveOperationLog(param *models.SysLogTable) {
 		param.LogCat, param.Operator, param.Operation, param.Content, param.RequestUrl, param.ClientHost, time.Now().Format(models.DateTimeFormat), param.DataCiType, param.DataGuid, param.DataKeyName, param.Response)
 	if err != nil {
 		log.Error(nil, log.LOGGER_APP, "Save operation log fail", zap.Error(err))
+		return
 	}
+	notifyOperationLogSeal()
 }
 
 func QueryOperationLog(param *models.QueryRequestParam) (pageInfo models.PageInfo, rowData []*models.SysLogTable, err error) {
 	rowData = []*models.SysLogTable{}
-	filterSql, queryColumn, queryParam := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysLogTable{}, PrimaryKey: "id"})
+	filterSql, queryColumn, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysLogTable{}, PrimaryKey: "id"})
+	if err != nil {
+		return
+	}
 	baseSql := fmt.Sprintf("SELECT %s FROM sys_log WHERE 1=1 %s ", queryColumn, filterSql)
 	if param.Paging {
 		pageInfo.StartIndex = param.Pageable.StartIndex
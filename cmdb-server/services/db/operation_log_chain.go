@@ -0,0 +1,640 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+	"xorm.io/xorm"
+)
+
+const (
+	logChainHeadId       = "default"
+	logChainBatchSize    = 500
+	logChainIssueLimit   = 100
+	logArchiveBatchSize  = 1000
+	logArchiveDefaultDir = "logs/archive"
+)
+
+var (
+	logChainSealLock   sync.Mutex
+	logArchiveLock     sync.Mutex
+	logChainSealSignal = make(chan struct{}, 1)
+)
+
+// operationLogHashObj 参与哈希计算的日志字段,字段顺序固定
+type operationLogHashObj struct {
+	PrevHash    string `json:"prevHash"`
+	ChainSeq    string `json:"chainSeq"`
+	Id          string `json:"id"`
+	LogCat      string `json:"logCat"`
+	Operator    string `json:"operator"`
+	Operation   string `json:"operation"`
+	Content     string `json:"content"`
+	RequestUrl  string `json:"requestUrl"`
+	ClientHost  string `json:"clientHost"`
+	CreatedDate string `json:"createdDate"`
+	DataCiType  string `json:"dataCiType"`
+	DataGuid    string `json:"dataGuid"`
+	DataKeyName string `json:"dataKeyName"`
+	Response    string `json:"response"`
+}
+
+// logChainHead 链头,HeadSign为其余字段的签名,防止链头被改写后整段日志连同链头一起重算
+type logChainHead struct {
+	LastSeq     int64  `json:"lastSeq"`
+	LastHash    string `json:"lastHash"`
+	ArchiveSeq  int64  `json:"archiveSeq"`
+	ArchiveHash string `json:"archiveHash"`
+	HeadSign    string `json:"-"`
+}
+
+func computeOperationLogHash(prevHash, chainSeq string, row map[string]string) string {
+	hashObj := operationLogHashObj{PrevHash: prevHash, ChainSeq: chainSeq, Id: row["id"], LogCat: row["log_cat"], Operator: row["operator"], Operation: row["operation"],
+		Content: row["content"], RequestUrl: row["request_url"], ClientHost: row["client_host"], CreatedDate: row["created_date"], DataCiType: row["data_ci_type"],
+		DataGuid: row["data_guid"], DataKeyName: row["data_key_name"], Response: row["response"]}
+	hashBytes, _ := json.Marshal(hashObj)
+	return signLogContent(hashBytes)
+}
+
+// checkLogChainKey 没有密钥时任何人都能重算整条链,所以不配置chain_key时拒绝封链与校验
+func checkLogChainKey() error {
+	if models.Config.OperationLog.ChainKey == "" {
+		return fmt.Errorf("Operation log chain_key is empty,log chain is unkeyed and can not be sealed or verified ")
+	}
+	return nil
+}
+
+func signLogContent(content []byte) string {
+	mac := hmac.New(sha256.New, []byte(models.Config.OperationLog.ChainKey))
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func signLogChainHead(head logChainHead) string {
+	headBytes, _ := json.Marshal(head)
+	return signLogContent(headBytes)
+}
+
+func parseLogChainHead(row map[string]string) *logChainHead {
+	head := logChainHead{LastHash: row["last_hash"], ArchiveHash: row["archive_hash"], HeadSign: row["head_sign"]}
+	head.LastSeq, _ = strconv.ParseInt(row["last_seq"], 10, 64)
+	head.ArchiveSeq, _ = strconv.ParseInt(row["archive_seq"], 10, 64)
+	return &head
+}
+
+func signLogArchiveManifest(manifest models.LogArchiveManifest) string {
+	manifest.Signature = ""
+	manifestBytes, _ := json.Marshal(manifest)
+	return signLogContent(manifestBytes)
+}
+
+// getLogChainHead 链头还未创建时返回空链头,signValid表示链头签名是否正确
+func getLogChainHead() (head *logChainHead, signValid bool, err error) {
+	headRows, queryErr := x.QueryString("select last_seq,last_hash,archive_seq,archive_hash,head_sign from sys_log_chain where id=?", logChainHeadId)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query log chain head fail,%s ", queryErr.Error())
+		return
+	}
+	if len(headRows) == 0 {
+		head, signValid = &logChainHead{}, true
+		return
+	}
+	head = parseLogChainHead(headRows[0])
+	signValid = signLogChainHead(*head) == head.HeadSign
+	return
+}
+
+// sealOperationLog 给未封链的日志按提交顺序分配链序号与哈希
+// 审计日志在数据事务中写入,id顺序与提交顺序不一定一致,所以链按封链顺序而不是id顺序
+func sealOperationLog() (err error) {
+	if err = checkLogChainKey(); err != nil {
+		return
+	}
+	logChainSealLock.Lock()
+	defer logChainSealLock.Unlock()
+	for {
+		sealCount, sealErr := sealOperationLogBatch()
+		if sealErr != nil {
+			err = sealErr
+			return
+		}
+		if sealCount < logChainBatchSize {
+			return
+		}
+	}
+}
+
+func sealOperationLogBatch() (sealCount int, err error) {
+	session := x.NewSession()
+	defer session.Close()
+	if err = session.Begin(); err != nil {
+		err = fmt.Errorf("Try to begin log chain transaction fail,%s ", err.Error())
+		return
+	}
+	// 多实例时用链头行锁串行封链
+	if _, err = session.Exec("insert ignore into sys_log_chain(id,last_seq,last_hash,archive_seq,archive_hash,head_sign) values (?,0,'',0,'',?)", logChainHeadId, signLogChainHead(logChainHead{})); err != nil {
+		session.Rollback()
+		err = fmt.Errorf("Try to init log chain head fail,%s ", err.Error())
+		return
+	}
+	head, err := lockLogChainHead(session)
+	if err != nil {
+		session.Rollback()
+		return
+	}
+	lastSeq, lastHash := head.LastSeq, head.LastHash
+	logRows, queryErr := session.QueryString("select * from sys_log where chain_seq is null order by id limit ?", logChainBatchSize)
+	if queryErr != nil {
+		session.Rollback()
+		err = fmt.Errorf("Try to query unsealed log fail,%s ", queryErr.Error())
+		return
+	}
+	if len(logRows) == 0 {
+		session.Rollback()
+		return
+	}
+	for _, row := range logRows {
+		lastSeq++
+		rowHash := computeOperationLogHash(lastHash, strconv.FormatInt(lastSeq, 10), row)
+		if _, err = session.Exec("update sys_log set chain_seq=?,prev_hash=?,hash=? where id=? and chain_seq is null", lastSeq, lastHash, rowHash, row["id"]); err != nil {
+			session.Rollback()
+			err = fmt.Errorf("Try to seal log:%s fail,%s ", row["id"], err.Error())
+			return
+		}
+		lastHash = rowHash
+	}
+	head.LastSeq, head.LastHash = lastSeq, lastHash
+	if _, err = session.Exec("update sys_log_chain set last_seq=?,last_hash=?,head_sign=?,update_time=? where id=?", lastSeq, lastHash, signLogChainHead(*head), time.Now().Format(models.DateTimeFormat), logChainHeadId); err != nil {
+		session.Rollback()
+		err = fmt.Errorf("Try to update log chain head fail,%s ", err.Error())
+		return
+	}
+	if err = session.Commit(); err != nil {
+		err = fmt.Errorf("Try to commit log chain fail,%s ", err.Error())
+		return
+	}
+	sealCount = len(logRows)
+	return
+}
+
+// lockLogChainHead 锁住链头并校验签名,签名不对时不再往上续链,避免把被改写的链头重新签名
+func lockLogChainHead(session *xorm.Session) (head *logChainHead, err error) {
+	headRows, queryErr := session.QueryString("select last_seq,last_hash,archive_seq,archive_hash,head_sign from sys_log_chain where id=? for update", logChainHeadId)
+	if queryErr != nil || len(headRows) == 0 {
+		err = fmt.Errorf("Try to lock log chain head fail,%v ", queryErr)
+		return
+	}
+	head = parseLogChainHead(headRows[0])
+	if signLogChainHead(*head) != head.HeadSign {
+		err = fmt.Errorf("Log chain head signature is illegal,please verify the log chain ")
+	}
+	return
+}
+
+// VerifyOperationLogChain 按链序号校验日志,检查序号缺失、前后哈希不连续、内容被修改以及链头是否一致
+// startSeq与endSeq为0时校验从上次归档位置到链头的全部日志
+func VerifyOperationLogChain(startSeq, endSeq int64) (result *models.LogChainVerifyResult, err error) {
+	if err = checkLogChainKey(); err != nil {
+		return
+	}
+	head, signValid, err := getLogChainHead()
+	if err != nil {
+		return
+	}
+	result = &models.LogChainVerifyResult{ArchiveSeq: head.ArchiveSeq, HeadSeq: head.LastSeq, Issues: []*models.LogChainIssueObj{}}
+	if !signValid {
+		addLogChainIssue(result, "head", head.LastSeq, "", "chain head signature is illegal")
+	}
+	if err = verifyLogArchivePosition(result, head); err != nil {
+		return
+	}
+	fromSeq, prevHash, checkPrev := head.ArchiveSeq, head.ArchiveHash, true
+	if startSeq > fromSeq+1 {
+		// 从中间开始校验时第一条无法确认上一条哈希
+		fromSeq, checkPrev = startSeq-1, false
+	}
+	toSeq := head.LastSeq
+	if endSeq > 0 && endSeq < toSeq {
+		toSeq = endSeq
+	}
+	result.StartSeq, result.EndSeq = fromSeq+1, toSeq
+	expectSeq, cursorSeq := fromSeq+1, fromSeq
+	var lastRowSeq int64
+	for cursorSeq < toSeq {
+		logRows, queryErr := x.QueryString("select * from sys_log where chain_seq>? and chain_seq<=? order by chain_seq limit ?", cursorSeq, toSeq, logChainBatchSize)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to query sealed log fail,%s ", queryErr.Error())
+			return
+		}
+		if len(logRows) == 0 {
+			break
+		}
+		for _, row := range logRows {
+			rowSeq, _ := strconv.ParseInt(row["chain_seq"], 10, 64)
+			if rowSeq != expectSeq {
+				addLogChainIssue(result, "gap", expectSeq, "", fmt.Sprintf("log chain seq %d-%d is missing", expectSeq, rowSeq-1))
+				checkPrev = false
+			}
+			if checkPrev && row["prev_hash"] != prevHash {
+				addLogChainIssue(result, "prevHash", rowSeq, row["id"], "prev hash is not equal to the hash of previous log")
+			}
+			if computeOperationLogHash(row["prev_hash"], row["chain_seq"], row) != row["hash"] {
+				addLogChainIssue(result, "hash", rowSeq, row["id"], "log content is not match the hash")
+			}
+			prevHash, checkPrev, expectSeq, lastRowSeq = row["hash"], true, rowSeq+1, rowSeq
+			cursorSeq = rowSeq
+			result.CheckedCount++
+		}
+	}
+	if expectSeq <= toSeq {
+		addLogChainIssue(result, "gap", expectSeq, "", fmt.Sprintf("log chain seq %d-%d is missing", expectSeq, toSeq))
+	}
+	if toSeq == head.LastSeq && lastRowSeq == head.LastSeq && lastRowSeq > 0 && prevHash != head.LastHash {
+		addLogChainIssue(result, "head", lastRowSeq, "", "last log hash is not equal to the chain head")
+	}
+	// 链序号超出链头说明链头被回退,期间其它实例正常封链时链头也会前进,所以重新读链头再比较
+	overRows, queryErr := x.QueryString("select max(chain_seq) as seq from sys_log where chain_seq>?", head.LastSeq)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query log over chain head fail,%s ", queryErr.Error())
+		return
+	}
+	if len(overRows) > 0 && overRows[0]["seq"] != "" {
+		maxSeq, _ := strconv.ParseInt(overRows[0]["seq"], 10, 64)
+		latestHead, _, headErr := getLogChainHead()
+		if headErr != nil {
+			err = headErr
+			return
+		}
+		if maxSeq > latestHead.LastSeq {
+			addLogChainIssue(result, "head", latestHead.LastSeq+1, "", fmt.Sprintf("sealed log seq %d is after the chain head", maxSeq))
+		}
+	}
+	unsealedRows, queryErr := x.QueryString("select count(1) as num from sys_log where chain_seq is null")
+	if queryErr != nil {
+		err = fmt.Errorf("Try to count unsealed log fail,%s ", queryErr.Error())
+		return
+	}
+	if len(unsealedRows) > 0 {
+		result.UnsealedCount, _ = strconv.Atoi(unsealedRows[0]["num"])
+	}
+	result.Valid = len(result.Issues) == 0
+	return
+}
+
+// verifyLogArchivePosition 检查各归档段首尾相连,且链头的归档位置与最后一个归档一致
+func verifyLogArchivePosition(result *models.LogChainVerifyResult, head *logChainHead) (err error) {
+	var archiveRows []*models.SysLogArchiveTable
+	if err = x.SQL("select id,start_seq,end_seq,first_prev_hash,last_hash from sys_log_archive order by start_seq,id").Find(&archiveRows); err != nil {
+		err = fmt.Errorf("Try to query log archive fail,%s ", err.Error())
+		return
+	}
+	var archiveSeq int64
+	archiveHash := ""
+	for _, archive := range archiveRows {
+		if archive.StartSeq != archiveSeq+1 {
+			addLogChainIssue(result, "archive", archive.StartSeq, "", fmt.Sprintf("archive %d seq %d-%d is not continuous with previous archive end seq %d", archive.Id, archive.StartSeq, archive.EndSeq, archiveSeq))
+		} else if archive.FirstPrevHash != archiveHash {
+			addLogChainIssue(result, "archive", archive.StartSeq, "", fmt.Sprintf("archive %d first prev hash is not equal to the last hash of previous archive", archive.Id))
+		}
+		archiveSeq, archiveHash = archive.EndSeq, archive.LastHash
+	}
+	if head.ArchiveSeq != archiveSeq || head.ArchiveHash != archiveHash {
+		addLogChainIssue(result, "head", head.ArchiveSeq, "", fmt.Sprintf("chain head archive position %d is not match the latest archive end seq %d", head.ArchiveSeq, archiveSeq))
+	}
+	return
+}
+
+func addLogChainIssue(result *models.LogChainVerifyResult, issueType string, seq int64, logId, message string) {
+	if len(result.Issues) >= logChainIssueLimit {
+		result.Truncated = true
+		return
+	}
+	result.Issues = append(result.Issues, &models.LogChainIssueObj{Type: issueType, Seq: seq, LogId: logId, Message: message})
+}
+
+// ArchiveOperationLog 把超过保留天数的已封链日志写入gzip压缩的ndjson文件和签名清单,然后从sys_log删除
+// 只归档从上次归档位置开始连续过期的一段,保证各归档文件与库中剩余日志首尾相连
+func ArchiveOperationLog(retentionDays int) (archive *models.SysLogArchiveTable, err error) {
+	if retentionDays <= 0 {
+		err = fmt.Errorf("Retention days must be greater than 0 ")
+		return
+	}
+	if err = sealOperationLog(); err != nil {
+		return
+	}
+	logArchiveLock.Lock()
+	defer logArchiveLock.Unlock()
+	head, signValid, err := getLogChainHead()
+	if err != nil {
+		return
+	}
+	if !signValid {
+		err = fmt.Errorf("Log chain head signature is illegal,can not archive ")
+		return
+	}
+	// 上次归档后没删完的日志先清理
+	if err = deleteArchivedOperationLog(head.ArchiveSeq); err != nil {
+		return
+	}
+	cutoffTime := time.Now().AddDate(0, 0, -retentionDays).Format(models.DateTimeFormat)
+	endSeq := head.LastSeq
+	keepRows, queryErr := x.QueryString("select min(chain_seq) as seq from sys_log where chain_seq>? and created_date>=?", head.ArchiveSeq, cutoffTime)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query log archive range fail,%s ", queryErr.Error())
+		return
+	}
+	if len(keepRows) > 0 && keepRows[0]["seq"] != "" {
+		keepSeq, _ := strconv.ParseInt(keepRows[0]["seq"], 10, 64)
+		endSeq = keepSeq - 1
+	}
+	if endSeq <= head.ArchiveSeq {
+		return
+	}
+	verifyResult, verifyErr := VerifyOperationLogChain(head.ArchiveSeq+1, endSeq)
+	if verifyErr != nil {
+		err = verifyErr
+		return
+	}
+	if !verifyResult.Valid {
+		err = fmt.Errorf("Log chain verify fail in seq %d-%d,can not archive,first issue:%s ", head.ArchiveSeq+1, endSeq, verifyResult.Issues[0].Message)
+		return
+	}
+	archiveDir := models.Config.OperationLog.ArchiveDir
+	if archiveDir == "" {
+		archiveDir = logArchiveDefaultDir
+	}
+	if err = os.MkdirAll(archiveDir, 0755); err != nil {
+		err = fmt.Errorf("Try to make log archive dir fail,%s ", err.Error())
+		return
+	}
+	nowTime := time.Now()
+	fileName := fmt.Sprintf("sys_log_%d_%d_%s.ndjson.gz", head.ArchiveSeq+1, endSeq, nowTime.Format("20060102150405"))
+	filePath := filepath.Join(archiveDir, fileName)
+	manifestPath := filePath + ".manifest.json"
+	manifest, err := writeOperationLogArchiveFile(filePath, head.ArchiveSeq, head.ArchiveHash, endSeq)
+	if err != nil {
+		os.Remove(filePath)
+		return
+	}
+	manifest.FileName = fileName
+	manifest.CreateTime = nowTime.Format(models.DateTimeFormat)
+	manifest.Signature = signLogArchiveManifest(*manifest)
+	manifestBytes, _ := json.MarshalIndent(manifest, "", "  ")
+	if err = ioutil.WriteFile(manifestPath, manifestBytes, 0644); err != nil {
+		os.Remove(filePath)
+		err = fmt.Errorf("Try to write log archive manifest fail,%s ", err.Error())
+		return
+	}
+	archive = &models.SysLogArchiveTable{FileName: fileName, StartSeq: manifest.StartSeq, EndSeq: manifest.EndSeq, RowCount: manifest.RowCount, StartDate: manifest.StartDate, EndDate: manifest.EndDate,
+		FirstPrevHash: manifest.FirstPrevHash, LastHash: manifest.LastHash, FileSha256: manifest.FileSha256, Signature: manifest.Signature, CreateTime: manifest.CreateTime}
+	if err = saveOperationLogArchive(archive, head.ArchiveSeq); err != nil {
+		os.Remove(filePath)
+		os.Remove(manifestPath)
+		archive = nil
+		return
+	}
+	err = deleteArchivedOperationLog(endSeq)
+	return
+}
+
+func writeOperationLogArchiveFile(filePath string, fromSeq int64, prevHash string, endSeq int64) (manifest *models.LogArchiveManifest, err error) {
+	file, createErr := os.Create(filePath)
+	if createErr != nil {
+		err = fmt.Errorf("Try to create log archive file fail,%s ", createErr.Error())
+		return
+	}
+	defer file.Close()
+	fileHash := sha256.New()
+	gzipWriter := gzip.NewWriter(io.MultiWriter(file, fileHash))
+	manifest = &models.LogArchiveManifest{StartSeq: fromSeq + 1, EndSeq: endSeq, FirstPrevHash: prevHash}
+	cursorSeq := fromSeq
+	for cursorSeq < endSeq {
+		logRows, queryErr := x.QueryString("select * from sys_log where chain_seq>? and chain_seq<=? order by chain_seq limit ?", cursorSeq, endSeq, logArchiveBatchSize)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to query archive log fail,%s ", queryErr.Error())
+			return
+		}
+		if len(logRows) == 0 {
+			break
+		}
+		for _, row := range logRows {
+			lineBytes, _ := json.Marshal(row)
+			if _, err = gzipWriter.Write(append(lineBytes, '\n')); err != nil {
+				err = fmt.Errorf("Try to write log archive file fail,%s ", err.Error())
+				return
+			}
+			if manifest.RowCount == 0 {
+				manifest.StartDate = row["created_date"]
+			}
+			manifest.EndDate = row["created_date"]
+			manifest.LastHash = row["hash"]
+			manifest.RowCount++
+			cursorSeq, _ = strconv.ParseInt(row["chain_seq"], 10, 64)
+		}
+	}
+	if err = gzipWriter.Close(); err != nil {
+		err = fmt.Errorf("Try to close log archive file fail,%s ", err.Error())
+		return
+	}
+	manifest.FileSha256 = hex.EncodeToString(fileHash.Sum(nil))
+	return
+}
+
+// saveOperationLogArchive 记录归档并推进链头的归档位置,归档位置已被其它实例推进时放弃
+func saveOperationLogArchive(archive *models.SysLogArchiveTable, archiveSeq int64) (err error) {
+	session := x.NewSession()
+	defer session.Close()
+	if err = session.Begin(); err != nil {
+		err = fmt.Errorf("Try to begin log archive transaction fail,%s ", err.Error())
+		return
+	}
+	head, err := lockLogChainHead(session)
+	if err != nil {
+		session.Rollback()
+		return
+	}
+	if head.ArchiveSeq != archiveSeq {
+		session.Rollback()
+		err = fmt.Errorf("Log archive position changed,another archive may be running ")
+		return
+	}
+	if _, err = session.Exec("insert into sys_log_archive(file_name,start_seq,end_seq,row_count,start_date,end_date,first_prev_hash,last_hash,file_sha256,signature,create_time) values (?,?,?,?,?,?,?,?,?,?,?)",
+		archive.FileName, archive.StartSeq, archive.EndSeq, archive.RowCount, archive.StartDate, archive.EndDate, archive.FirstPrevHash, archive.LastHash, archive.FileSha256, archive.Signature, archive.CreateTime); err != nil {
+		session.Rollback()
+		err = fmt.Errorf("Try to save log archive fail,%s ", err.Error())
+		return
+	}
+	head.ArchiveSeq, head.ArchiveHash = archive.EndSeq, archive.LastHash
+	if _, err = session.Exec("update sys_log_chain set archive_seq=?,archive_hash=?,head_sign=? where id=?", archive.EndSeq, archive.LastHash, signLogChainHead(*head), logChainHeadId); err != nil {
+		session.Rollback()
+		err = fmt.Errorf("Try to update log chain archive position fail,%s ", err.Error())
+		return
+	}
+	if err = session.Commit(); err != nil {
+		err = fmt.Errorf("Try to commit log archive fail,%s ", err.Error())
+	}
+	return
+}
+
+func deleteArchivedOperationLog(archiveSeq int64) (err error) {
+	for {
+		execResult, execErr := x.Exec("delete from sys_log where chain_seq<=? limit ?", archiveSeq, logArchiveBatchSize)
+		if execErr != nil {
+			err = fmt.Errorf("Try to delete archived log fail,%s ", execErr.Error())
+			return
+		}
+		if affectNum, _ := execResult.RowsAffected(); affectNum < logArchiveBatchSize {
+			return
+		}
+	}
+}
+
+func QueryOperationLogArchive() (rowData []*models.SysLogArchiveTable, err error) {
+	rowData = []*models.SysLogArchiveTable{}
+	err = x.SQL("select * from sys_log_archive order by id desc").Find(&rowData)
+	if err != nil {
+		err = fmt.Errorf("Try to query log archive fail,%s ", err.Error())
+	}
+	return
+}
+
+// VerifyOperationLogArchive 校验归档文件的清单签名、文件摘要以及文件内日志的哈希链
+func VerifyOperationLogArchive(archiveId int) (result *models.LogChainVerifyResult, err error) {
+	if err = checkLogChainKey(); err != nil {
+		return
+	}
+	var archiveRows []*models.SysLogArchiveTable
+	if err = x.SQL("select * from sys_log_archive where id=?", archiveId).Find(&archiveRows); err != nil {
+		err = fmt.Errorf("Try to query log archive fail,%s ", err.Error())
+		return
+	}
+	if len(archiveRows) == 0 {
+		err = fmt.Errorf("Can not find log archive with id:%d ", archiveId)
+		return
+	}
+	archive := archiveRows[0]
+	result = &models.LogChainVerifyResult{StartSeq: archive.StartSeq, EndSeq: archive.EndSeq, Issues: []*models.LogChainIssueObj{}}
+	archiveDir := models.Config.OperationLog.ArchiveDir
+	if archiveDir == "" {
+		archiveDir = logArchiveDefaultDir
+	}
+	filePath := filepath.Join(archiveDir, archive.FileName)
+	manifestBytes, readErr := ioutil.ReadFile(filePath + ".manifest.json")
+	if readErr != nil {
+		err = fmt.Errorf("Try to read log archive manifest fail,%s ", readErr.Error())
+		return
+	}
+	var manifest models.LogArchiveManifest
+	if err = json.Unmarshal(manifestBytes, &manifest); err != nil {
+		err = fmt.Errorf("Try to parse log archive manifest fail,%s ", err.Error())
+		return
+	}
+	if signLogArchiveManifest(manifest) != manifest.Signature {
+		addLogChainIssue(result, "archive", 0, "", "manifest signature is illegal")
+	}
+	if manifest.Signature != archive.Signature || manifest.FileSha256 != archive.FileSha256 || manifest.LastHash != archive.LastHash || manifest.RowCount != archive.RowCount {
+		addLogChainIssue(result, "archive", 0, "", "manifest is not match the archive record")
+	}
+	file, openErr := os.Open(filePath)
+	if openErr != nil {
+		err = fmt.Errorf("Try to open log archive file fail,%s ", openErr.Error())
+		return
+	}
+	defer file.Close()
+	fileHash := sha256.New()
+	gzipReader, gzipErr := gzip.NewReader(io.TeeReader(file, fileHash))
+	if gzipErr != nil {
+		err = fmt.Errorf("Try to read log archive file fail,%s ", gzipErr.Error())
+		return
+	}
+	scanner := bufio.NewScanner(gzipReader)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	prevHash, expectSeq := manifest.FirstPrevHash, manifest.StartSeq
+	for scanner.Scan() {
+		var row map[string]string
+		if unmarshalErr := json.Unmarshal(scanner.Bytes(), &row); unmarshalErr != nil {
+			addLogChainIssue(result, "archive", expectSeq, "", "archive line is not legal json")
+			continue
+		}
+		rowSeq, _ := strconv.ParseInt(row["chain_seq"], 10, 64)
+		if rowSeq != expectSeq {
+			addLogChainIssue(result, "gap", expectSeq, "", fmt.Sprintf("log chain seq %d-%d is missing", expectSeq, rowSeq-1))
+		} else if row["prev_hash"] != prevHash {
+			addLogChainIssue(result, "prevHash", rowSeq, row["id"], "prev hash is not equal to the hash of previous log")
+		}
+		if computeOperationLogHash(row["prev_hash"], row["chain_seq"], row) != row["hash"] {
+			addLogChainIssue(result, "hash", rowSeq, row["id"], "log content is not match the hash")
+		}
+		prevHash, expectSeq = row["hash"], rowSeq+1
+		result.CheckedCount++
+	}
+	if scanErr := scanner.Err(); scanErr != nil {
+		addLogChainIssue(result, "archive", expectSeq, "", "read archive file fail,"+scanErr.Error())
+	}
+	// 读完剩余内容,保证文件摘要覆盖整个文件
+	io.Copy(ioutil.Discard, file)
+	if hex.EncodeToString(fileHash.Sum(nil)) != manifest.FileSha256 {
+		addLogChainIssue(result, "archive", 0, "", "archive file sha256 is not match the manifest")
+	}
+	if result.CheckedCount != manifest.RowCount || prevHash != manifest.LastHash {
+		addLogChainIssue(result, "archive", expectSeq, "", "archive file content is not match the manifest")
+	}
+	result.Valid = len(result.Issues) == 0
+	return
+}
+
+// notifyOperationLogSeal 写日志后通知封链任务尽快封链,任务已有待处理的通知时直接返回,不阻塞写日志
+func notifyOperationLogSeal() {
+	select {
+	case logChainSealSignal <- struct{}{}:
+	default:
+	}
+}
+
+// StartOperationLogChainJob 定时或收到写日志通知时封链,并按保留天数每天归档一次过期日志
+func StartOperationLogChainJob() {
+	if err := checkLogChainKey(); err != nil {
+		log.Error(nil, log.LOGGER_APP, "Operation log chain job disabled,audit log will not be sealed or archived", zap.Error(err))
+		return
+	}
+	log.Info(nil, log.LOGGER_APP, "start operation log chain job")
+	t := time.NewTicker(60 * time.Second).C
+	lastArchiveDate := ""
+	for {
+		select {
+		case <-t:
+		case <-logChainSealSignal:
+		}
+		if err := sealOperationLog(); err != nil {
+			log.Error(nil, log.LOGGER_APP, "Seal operation log fail", zap.Error(err))
+			continue
+		}
+		retentionDays := models.Config.OperationLog.RetentionDays
+		nowDate := time.Now().Format("2006-01-02")
+		if retentionDays <= 0 || nowDate == lastArchiveDate {
+			continue
+		}
+		lastArchiveDate = nowDate
+		archive, err := ArchiveOperationLog(retentionDays)
+		if err != nil {
+			log.Error(nil, log.LOGGER_APP, "Archive operation log fail", zap.Error(err))
+		} else if archive != nil {
+			log.Info(nil, log.LOGGER_APP, "Archive operation log done", zap.String("file", archive.FileName), zap.Int("rowCount", archive.RowCount))
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+)
+
+const (
+	refFilterPreviewPageSize     = 500
+	refFilterPreviewMaxViolation = 1000
+)
+
+// PreviewAttrRefFilter 按准备保存的引用过滤规则列出可选的引用数据,并逐行检查现有数据的引用值是否会变得不合法
+func PreviewAttrRefFilter(ciType, ciAttrId string, param *models.AttrRefFilterPreviewParam) (result *models.AttrRefFilterPreviewResult, err error) {
+	attr, err := GetCiAttrById(ciAttrId)
+	if err != nil {
+		return
+	}
+	if attr.CiType != ciType {
+		err = fmt.Errorf("Attribute:%s is not belong to ciType:%s ", ciAttrId, ciType)
+		return
+	}
+	if attr.RefCiType == "" || (attr.InputType != "ref" && attr.InputType != models.MultiRefType) {
+		err = fmt.Errorf("Attribute:%s is not reference type ", ciAttrId)
+		return
+	}
+	refFilter := strings.TrimSpace(param.RefFilter)
+	if refFilter == "[]" {
+		refFilter = ""
+	}
+	if refFilter != "" {
+		if err = ValidateAttrRefFilter(refFilter); err != nil {
+			return
+		}
+	}
+	result = &models.AttrRefFilterPreviewResult{CiType: ciType, CiTypeAttr: attr.Name, RefCiType: attr.RefCiType, RefFilter: refFilter, Candidates: []*models.CiDataRefDataObj{}, Violations: []*models.AttrRefFilterViolationObj{}}
+	filterMap := make(map[string]string)
+	if param.Guid != "" {
+		if filterMap, err = getCiRowWithMultiRefData(ciType, param.Guid); err != nil {
+			return
+		}
+	}
+	candidateRows, err := queryRefFilterCandidateRows(attr, refFilter, filterMap)
+	if err != nil {
+		return
+	}
+	for _, row := range candidateRows {
+		result.Candidates = append(result.Candidates, &models.CiDataRefDataObj{Guid: row["guid"], KeyName: row["key_name"]})
+	}
+	err = checkRefFilterViolation(attr, refFilter, result)
+	return
+}
+
+// CheckAttrRefFilterChange 属性更新前检查新的引用过滤规则,现有数据有不满足规则的引用值时拒绝更新
+func CheckAttrRefFilterChange(param *models.SysCiTypeAttrTable) (err error) {
+	attr, err := GetCiAttrById(param.Id)
+	if err != nil {
+		return
+	}
+	if attr.RefFilter == param.RefFilter || attr.Status == "notCreated" || attr.RefCiType == "" {
+		return
+	}
+	result, err := PreviewAttrRefFilter(attr.CiType, attr.Id, &models.AttrRefFilterPreviewParam{RefFilter: param.RefFilter})
+	if err != nil {
+		return
+	}
+	if result.ViolationCount > 0 {
+		var guidList []string
+		for i, v := range result.Violations {
+			if i >= 10 {
+				break
+			}
+			guidList = append(guidList, v.Guid)
+		}
+		err = fmt.Errorf("Attr:%s have %d rows reference data illegal with new filter,such as:%s ", attr.Id, result.ViolationCount, strings.Join(guidList, ","))
+	}
+	return
+}
+
+func queryRefFilterCandidateRows(attr *models.SysCiTypeAttrTable, refFilter string, filterMap map[string]string) (rowList []map[string]string, err error) {
+	if refFilter == "" {
+		rowList, err = x.QueryString(fmt.Sprintf("select guid,key_name from `%s` order by update_time desc", attr.RefCiType))
+		if err != nil {
+			err = fmt.Errorf("Try to query reference ci data fail,%s ", err.Error())
+		}
+		return
+	}
+	rowList, _, err = getRefFilterRows(attr.RefCiType, attr.Name, refFilter, filterMap)
+	return
+}
+
+// refFilterEvaluator 检查现有数据时,与数据行无关的过滤条件只生成一次sql并按页批量查询,
+// 与数据行有关的条件按其用到的列值缓存候选数据,引用同一上级数据的行只查询一次
+type refFilterEvaluator struct {
+	attr           *models.SysCiTypeAttrTable
+	staticSqlList  []string
+	dynamicFilters []models.CiDataRefFilterObj
+	dependColumns  []string
+	candidateCache map[string]*refFilterCandidateObj
+}
+
+type refFilterCandidateObj struct {
+	GuidMap map[string]bool
+	Err     error
+}
+
+var refFilterIdentRegexp = regexp.MustCompile(`[\w-]+`)
+
+func newRefFilterEvaluator(attr *models.SysCiTypeAttrTable, refFilter string) (evaluator *refFilterEvaluator, err error) {
+	var filters []map[string]models.CiDataRefFilterObj
+	if err = json.Unmarshal([]byte(refFilter), &filters); err != nil {
+		err = fmt.Errorf("Json unmarshal filters string fail,%s ", err.Error())
+		return
+	}
+	if len(filters) == 0 {
+		err = fmt.Errorf("Get ci reference data fail,filters string illgeal ")
+		return
+	}
+	evaluator = &refFilterEvaluator{attr: attr, candidateCache: make(map[string]*refFilterCandidateObj)}
+	dependColumnMap := make(map[string]bool)
+	for _, filter := range filters[0] {
+		isPath := strings.Contains(filter.Left, ">") || strings.Contains(filter.Left, "~")
+		if filter.Right.Type != "expression" && !isPath {
+			tmpFilter := filter
+			tmpSql, tmpErr := getRefFilterSql(&tmpFilter, map[string]string{})
+			if tmpErr != nil {
+				err = fmt.Errorf("Get ci reference data fail when build filter sql,%s ", tmpErr.Error())
+				return
+			}
+			evaluator.staticSqlList = append(evaluator.staticSqlList, tmpSql)
+			continue
+		}
+		// 表达式与路径条件只会读取其中出现的ci类型与属性对应的列值
+		evaluator.dynamicFilters = append(evaluator.dynamicFilters, filter)
+		for _, name := range refFilterIdentRegexp.FindAllString(fmt.Sprintf("%s %v", filter.Left, filter.Right.Value), -1) {
+			if name != attr.Name && !dependColumnMap[name] {
+				dependColumnMap[name] = true
+				evaluator.dependColumns = append(evaluator.dependColumns, name)
+			}
+		}
+	}
+	sort.Strings(evaluator.dependColumns)
+	return
+}
+
+// checkValues 返回每行引用值中不满足过滤条件的值,rowList为同一页数据
+func (e *refFilterEvaluator) checkValues(rowList []map[string]string, rowValueMap map[string][]string) (invalidMap map[string][]string, errMap map[string]error, err error) {
+	invalidMap, errMap = make(map[string][]string), make(map[string]error)
+	if len(e.dynamicFilters) == 0 {
+		var valueList []string
+		for _, values := range rowValueMap {
+			valueList = append(valueList, values...)
+		}
+		validMap, queryErr := e.queryCandidate(e.staticSqlList, models.DistinctStringList(valueList, []string{}))
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		for rowGuid, values := range rowValueMap {
+			for _, value := range values {
+				if !validMap[value] {
+					invalidMap[rowGuid] = append(invalidMap[rowGuid], value)
+				}
+			}
+		}
+		return
+	}
+	for _, row := range rowList {
+		values := rowValueMap[row["guid"]]
+		if len(values) == 0 {
+			continue
+		}
+		candidate := e.getRowCandidate(row)
+		if candidate.Err != nil {
+			errMap[row["guid"]] = candidate.Err
+			continue
+		}
+		for _, value := range values {
+			if !candidate.GuidMap[value] {
+				invalidMap[row["guid"]] = append(invalidMap[row["guid"]], value)
+			}
+		}
+	}
+	return
+}
+
+func (e *refFilterEvaluator) getRowCandidate(row map[string]string) *refFilterCandidateObj {
+	var keyList []string
+	for _, column := range e.dependColumns {
+		if value, b := row[column]; b {
+			keyList = append(keyList, column+"="+value)
+		}
+	}
+	cacheKey := strings.Join(keyList, "\n")
+	if candidate, b := e.candidateCache[cacheKey]; b {
+		return candidate
+	}
+	candidate := refFilterCandidateObj{}
+	filterMap := make(map[string]string)
+	for k, v := range row {
+		filterMap[k] = v
+	}
+	delete(filterMap, e.attr.Name)
+	sqlList := append([]string{}, e.staticSqlList...)
+	for _, filter := range e.dynamicFilters {
+		tmpFilter := filter
+		tmpSql, tmpErr := getRefFilterSql(&tmpFilter, filterMap)
+		if tmpErr != nil {
+			candidate.Err = fmt.Errorf("Get ci reference data fail when build filter sql,%s ", tmpErr.Error())
+			break
+		}
+		sqlList = append(sqlList, tmpSql)
+	}
+	if candidate.Err == nil {
+		candidate.GuidMap, candidate.Err = e.queryCandidate(sqlList, nil)
+	}
+	e.candidateCache[cacheKey] = &candidate
+	return &candidate
+}
+
+// queryCandidate 查询满足条件的被引用数据,guidList不为空时只在这些数据中查询
+func (e *refFilterEvaluator) queryCandidate(sqlList []string, guidList []string) (guidMap map[string]bool, err error) {
+	guidMap = make(map[string]bool)
+	querySql := fmt.Sprintf("select guid from `%s` where 1=1", e.attr.RefCiType)
+	if len(sqlList) > 0 {
+		querySql += fmt.Sprintf(" AND (%s)", strings.Join(sqlList, ") AND ("))
+	}
+	var queryParams []interface{}
+	if guidList != nil {
+		if len(guidList) == 0 {
+			return
+		}
+		guidSpecSql, guidParams := createListParams(guidList, "")
+		querySql += fmt.Sprintf(" AND guid in (%s)", guidSpecSql)
+		queryParams = guidParams
+	}
+	queryRows, queryErr := x.QueryString(append([]interface{}{querySql}, queryParams...)...)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query reference ci data fail,%s ", queryErr.Error())
+		return
+	}
+	for _, row := range queryRows {
+		guidMap[row["guid"]] = true
+	}
+	return
+}
+
+func checkRefFilterViolation(attr *models.SysCiTypeAttrTable, refFilter string, result *models.AttrRefFilterPreviewResult) (err error) {
+	if refFilter == "" {
+		return
+	}
+	evaluator, err := newRefFilterEvaluator(attr, refFilter)
+	if err != nil {
+		return
+	}
+	for startIndex := 0; ; startIndex += refFilterPreviewPageSize {
+		rowList, queryErr := x.QueryString(fmt.Sprintf("select * from `%s` order by guid limit ?,?", attr.CiType), startIndex, refFilterPreviewPageSize)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to query ci data fail,%s ", queryErr.Error())
+			return
+		}
+		if len(rowList) == 0 {
+			break
+		}
+		result.RowCount += len(rowList)
+		var multiRefData map[string][]string
+		if attr.InputType == models.MultiRefType {
+			guidList := make([]string, 0, len(rowList))
+			for _, row := range rowList {
+				guidList = append(guidList, row["guid"])
+			}
+			if multiRefData, err = queryMultiRefMapData(attr.CiType, attr.Name, guidList); err != nil {
+				return
+			}
+		}
+		rowValueMap := make(map[string][]string)
+		for _, row := range rowList {
+			if attr.InputType == models.MultiRefType {
+				rowValueMap[row["guid"]] = multiRefData[row["guid"]]
+			} else if row[attr.Name] != "" {
+				rowValueMap[row["guid"]] = []string{row[attr.Name]}
+			}
+		}
+		invalidMap, errMap, checkErr := evaluator.checkValues(rowList, rowValueMap)
+		if checkErr != nil {
+			err = checkErr
+			return
+		}
+		for _, row := range rowList {
+			valueList := rowValueMap[row["guid"]]
+			if len(valueList) == 0 {
+				continue
+			}
+			violation := models.AttrRefFilterViolationObj{Guid: row["guid"], KeyName: row["key_name"], Values: valueList, InvalidValues: []string{}}
+			if rowErr, b := errMap[row["guid"]]; b {
+				log.Warn(nil, log.LOGGER_APP, "Check ref filter violation fail", zap.String("guid", row["guid"]), zap.Error(rowErr))
+				violation.ErrorMsg = rowErr.Error()
+			} else if invalidValues, b := invalidMap[row["guid"]]; b {
+				violation.InvalidValues = invalidValues
+			} else {
+				continue
+			}
+			result.ViolationCount++
+			if len(result.Violations) < refFilterPreviewMaxViolation {
+				result.Violations = append(result.Violations, &violation)
+			} else {
+				result.ViolationTruncated = true
+			}
+		}
+		if len(rowList) < refFilterPreviewPageSize {
+			break
+		}
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -528,6 +529,12 @@ func QueryReportData(reportId string, queryRequestParam *models.QueryRequestPara
 		return
 	}
 
+	asOfTime, tmpErr := getDialectAsOfTime(queryRequestParam.Dialect)
+	if tmpErr != nil {
+		err = tmpErr
+		return
+	}
+
 	roDataIds := []string{}
 	for i := range roData {
 		roDataIds = append(roDataIds, roData[i]["id"])
@@ -581,8 +588,13 @@ func QueryReportData(reportId string, queryRequestParam *models.QueryRequestPara
 				ciAttrName := myAttr
 				multiRefTable = myTable
 				if isAttributeMultiRef(ciTypeTable, ciAttrName) == true {
-					multiRefTable = fmt.Sprintf("(select `%s`.*,`%s$%s`.to_guid as `%s` from `%s` left join `%s$%s` on `%s`.guid=`%s$%s`.from_guid)",
-						ciTypeTable, ciTypeTable, ciAttrName, ciAttrName, ciTypeTable, ciTypeTable, ciAttrName, ciTypeTable, ciTypeTable, ciAttrName)
+					if asOfTime != "" {
+						multiRefTable = fmt.Sprintf("(select ta.*,tm.to_guid as `%s` from %s ta left join %s tm on ta.guid=tm.from_guid)",
+							ciAttrName, getAsOfTableSql(ciTypeTable, asOfTime), getAsOfMultiRefTableSql(ciTypeTable, ciAttrName, asOfTime))
+					} else {
+						multiRefTable = fmt.Sprintf("(select `%s`.*,`%s$%s`.to_guid as `%s` from `%s` left join `%s$%s` on `%s`.guid=`%s$%s`.from_guid)",
+							ciTypeTable, ciTypeTable, ciAttrName, ciAttrName, ciTypeTable, ciTypeTable, ciAttrName, ciTypeTable, ciTypeTable, ciAttrName)
+					}
 				}
 				ciTypeTableMapMultiRef[myTable] = multiRefTable
 			} else {
@@ -590,8 +602,13 @@ func QueryReportData(reportId string, queryRequestParam *models.QueryRequestPara
 				ciAttrName := parentAttr
 				multiRefTable = parentTable
 				if isAttributeMultiRef(ciTypeTable, ciAttrName) == true {
-					multiRefTable = fmt.Sprintf("(select `%s`.*,`%s$%s`.to_guid as `%s` from `%s` left join `%s$%s` on `%s`.guid=`%s$%s`.from_guid)",
-						ciTypeTable, ciTypeTable, ciAttrName, ciAttrName, ciTypeTable, ciTypeTable, ciAttrName, ciTypeTable, ciTypeTable, ciAttrName)
+					if asOfTime != "" {
+						multiRefTable = fmt.Sprintf("(select ta.*,tm.to_guid as `%s` from %s ta left join %s tm on ta.guid=tm.from_guid)",
+							ciAttrName, getAsOfTableSql(ciTypeTable, asOfTime), getAsOfMultiRefTableSql(ciTypeTable, ciAttrName, asOfTime))
+					} else {
+						multiRefTable = fmt.Sprintf("(select `%s`.*,`%s$%s`.to_guid as `%s` from `%s` left join `%s$%s` on `%s`.guid=`%s$%s`.from_guid)",
+							ciTypeTable, ciTypeTable, ciAttrName, ciAttrName, ciTypeTable, ciTypeTable, ciAttrName, ciTypeTable, ciTypeTable, ciAttrName)
+					}
 				}
 				ciTypeTableMapMultiRef[parentTable] = multiRefTable
 			}
@@ -611,7 +628,11 @@ func QueryReportData(reportId string, queryRequestParam *models.QueryRequestPara
 		// 当前 ro 的父节点的表名为 ro.parent_attr 中 "__" 前的值
 		tmpTableName := ciTypeTableMapMultiRef[roData[i]["ci_type"]]
 		if !strings.HasPrefix(tmpTableName, "(") {
-			tmpTableName = "`" + tmpTableName + "`"
+			if asOfTime != "" {
+				tmpTableName = getAsOfTableSql(tmpTableName, asOfTime)
+			} else {
+				tmpTableName = "`" + tmpTableName + "`"
+			}
 		}
 		if i != 0 {
 			parentTable = roData[i]["parent_attr"][:strings.Index(roData[i]["parent_attr"], "__")]
@@ -634,8 +655,20 @@ func QueryReportData(reportId string, queryRequestParam *models.QueryRequestPara
 	}
 	resultSqlCmd += sqlCmdPostfix
 
-	// 处理查询的过滤条件
-	filterSql, _, queryParam := transFiltersToSQL(queryRequestParam, &models.TransFiltersParam{KeyMap: filterKeyMap})
+	// 处理查询的过滤条件,refPath条件以报表根ci类型为起点
+	err = walkQueryFilters(queryRequestParam, func(filter *models.QueryRequestFilterObj) error {
+		if filter.Operator == "refPath" {
+			return resolveRefPathFilter(filter, roData[0]["ci_type"], queryRequestParam.Roles)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	filterSql, _, queryParam, err := transFiltersToSQL(queryRequestParam, &models.TransFiltersParam{KeyMap: filterKeyMap, GuidColumn: "t1.guid"})
+	if err != nil {
+		return
+	}
 	querySql := fmt.Sprintf("%s WHERE 1=1 %s ", resultSqlCmd, filterSql)
 	if queryRequestParam.Paging {
 		pageInfo.StartIndex = queryRequestParam.Pageable.StartIndex
@@ -654,13 +687,15 @@ func QueryReportData(reportId string, queryRequestParam *models.QueryRequestPara
 		return
 	}
 
-	// 将查询的 sqlcmd 保存到 report table, 不包括过滤条件部分
-	cacheSqlCmd := "UPDATE sys_report SET update_time=?,update_user=?,sql_cache=? WHERE id=?"
-	updateTime := time.Now().Format(models.DateTimeFormat)
-	sqlOrArgs = []interface{}{cacheSqlCmd, updateTime, user, resultSqlCmd, reportId}
-	_, tmpErr = x.QueryString(sqlOrArgs...)
-	if tmpErr != nil {
-		log.Error(nil, log.LOGGER_APP, "Cache resultSqlCmd in report table error", zap.String("reportId", reportId), zap.Error(tmpErr))
+	// 将查询的 sqlcmd 保存到 report table, 不包括过滤条件部分,历史快照查询不缓存
+	if asOfTime == "" {
+		cacheSqlCmd := "UPDATE sys_report SET update_time=?,update_user=?,sql_cache=? WHERE id=?"
+		updateTime := time.Now().Format(models.DateTimeFormat)
+		sqlOrArgs = []interface{}{cacheSqlCmd, updateTime, user, resultSqlCmd, reportId}
+		_, tmpErr = x.QueryString(sqlOrArgs...)
+		if tmpErr != nil {
+			log.Error(nil, log.LOGGER_APP, "Cache resultSqlCmd in report table error", zap.String("reportId", reportId), zap.Error(tmpErr))
+		}
 	}
 
 	if len(curRoData) > 0 {
@@ -1435,7 +1470,10 @@ func QueryReportFlatStruct(reportId string) (rowData *models.QueryReport, err er
 
 func QueryReportObject(param *models.QueryRequestParam) (pageInfo models.PageInfo, rowData []*models.SysReportObjectTable, err error) {
 	rowData = []*models.SysReportObjectTable{}
-	filterSql, queryColumn, queryParam := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysReportObjectTable{}, PrimaryKey: "id"})
+	filterSql, queryColumn, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysReportObjectTable{}, PrimaryKey: "id"})
+	if err != nil {
+		return
+	}
 	baseSql := fmt.Sprintf("SELECT %s FROM sys_report_object WHERE 1=1 %s ", queryColumn, filterSql)
 	if param.Paging {
 		pageInfo.StartIndex = param.Pageable.StartIndex
@@ -1451,7 +1489,10 @@ func QueryReportObject(param *models.QueryRequestParam) (pageInfo models.PageInf
 
 func QueryReportAttr(param *models.QueryRequestParam) (pageInfo models.PageInfo, rowData []*models.SysReportObjectAttrTable, err error) {
 	rowData = []*models.SysReportObjectAttrTable{}
-	filterSql, queryColumn, queryParam := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysReportObjectAttrTable{}, PrimaryKey: "id"})
+	filterSql, queryColumn, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysReportObjectAttrTable{}, PrimaryKey: "id"})
+	if err != nil {
+		return
+	}
 	baseSql := fmt.Sprintf("SELECT %s FROM sys_report_object_attr WHERE 1=1 %s ", queryColumn, filterSql)
 	if param.Paging {
 		pageInfo.StartIndex = param.Pageable.StartIndex
@@ -1465,7 +1506,8 @@ func QueryReportAttr(param *models.QueryRequestParam) (pageInfo models.PageInfo,
 	return
 }
 
-func ExportReportData(param *models.ExportReportParam) (result *models.ExportReportResult, err error) {
+// ExportReportData ctx取消时在下一个ci类型查询前停止导出
+func ExportReportData(ctx context.Context, param *models.ExportReportParam) (result *models.ExportReportResult, err error) {
 	var reportRows []*models.SysReportTable
 	if err = x.SQL("select id,ci_type from sys_report where id=?", param.ReportId).Find(&reportRows); err != nil {
 		return
@@ -1499,11 +1541,14 @@ func ExportReportData(param *models.ExportReportParam) (result *models.ExportRep
 			attrMap[row.ReportObject] = []*models.SysReportObjectAttrTable{row}
 		}
 	}
-	result.CiData, err = getExportReportCiData(&rootReportObject, param.RootCiData, reportObjectRows, attrMap, reportObjectCiTypeMap)
+	result.CiData, err = getExportReportCiData(ctx, &rootReportObject, param.RootCiData, reportObjectRows, attrMap, reportObjectCiTypeMap)
 	return
 }
 
-func getExportReportCiData(reportObject *models.SysReportObjectTable, guids []string, reportObjects []*models.SysReportObjectTable, attrMap map[string][]*models.SysReportObjectAttrTable, reportObjectCiTypeMap map[string]string) (result []*models.ExportReportCiData, err error) {
+func getExportReportCiData(ctx context.Context, reportObject *models.SysReportObjectTable, guids []string, reportObjects []*models.SysReportObjectTable, attrMap map[string][]*models.SysReportObjectAttrTable, reportObjectCiTypeMap map[string]string) (result []*models.ExportReportCiData, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
 	exportObj := models.ExportReportCiData{CiType: reportObject.CiType, ParentCiType: reportObjectCiTypeMap[reportObject.ParentObject]}
 	var guidFilterValues []interface{}
 	for _, v := range guids {
@@ -1561,7 +1606,7 @@ func getExportReportCiData(reportObject *models.SysReportObjectTable, guids []st
 					childGuids = append(childGuids, getRefGuidStringList(rowValue)...)
 				}
 			}
-			childCiData, getChildDataErr := getExportReportCiData(v, childGuids, reportObjects, attrMap, reportObjectCiTypeMap)
+			childCiData, getChildDataErr := getExportReportCiData(ctx, v, childGuids, reportObjects, attrMap, reportObjectCiTypeMap)
 			if getChildDataErr != nil {
 				err = fmt.Errorf("get child ci type:%s data fail,%s ", v.CiType, getChildDataErr.Error())
 				break
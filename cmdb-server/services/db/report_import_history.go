@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -12,7 +13,10 @@ import (
 
 func QueryReportImportHistory(param *models.QueryRequestParam) (pageInfo models.PageInfo, rowData []*models.SysReportImportHistoryObj, err error) {
 	rowData = []*models.SysReportImportHistoryObj{}
-	filterSql, _, queryParam := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysReportImportHistoryObj{}, PrimaryKey: "guid", Prefix: "tt"})
+	filterSql, _, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysReportImportHistoryObj{}, PrimaryKey: "guid", Prefix: "tt"})
+	if err != nil {
+		return
+	}
 	baseSql := fmt.Sprintf("SELECT tt.*, sr.name as report_name, sct.display_name as root_ci_type_name FROM sys_report_import_history tt left join sys_report sr on tt.report = sr.id left join sys_ci_type sct on tt.root_ci_type = sct.id WHERE 1=1 %s ", filterSql)
 	if param.Paging {
 		pageInfo.StartIndex = param.Pageable.StartIndex
@@ -453,3 +457,61 @@ func RefreshReportImportHistory(multiCiData []*models.MultiCiDataObj) (err error
 	}
 	return
 }
+
+// RefreshReportImportHistoryByGuidMap 根据导入映射表重新检查导入数据的唯一性与必填项
+func RefreshReportImportHistoryByGuidMap(importGuidMapTable []*models.SysCiImportGuidMapTable) (err error) {
+	var multiCiData []*models.MultiCiDataObj
+	var ciTypeMap = make(map[string]bool)
+	for _, row := range importGuidMapTable {
+		if _, exists := ciTypeMap[row.CiType]; !exists {
+			ciTypeMap[row.CiType] = true
+			multiCiData = append(multiCiData, &models.MultiCiDataObj{CiTypeId: row.CiType})
+		}
+	}
+	if err = GetMultiCiAttributes(multiCiData); err != nil {
+		return
+	}
+	if err = GetUniqueAndNotNullColumn(multiCiData, importGuidMapTable); err != nil {
+		return
+	}
+	err = RefreshReportImportHistory(multiCiData)
+	return
+}
+
+// RefreshReportImportHistoryByImportGuid importGuid为空时刷新所有created状态的导入记录,ctx取消时在下一条记录前停止
+func RefreshReportImportHistoryByImportGuid(ctx context.Context, importGuid string) (err error) {
+	var importGuidList []string
+	if importGuid != "" {
+		importGuidList = []string{importGuid}
+	} else {
+		importHistoryRowData, queryErr := QueryReportImportHistoryByStatus("created")
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		for _, rowData := range importHistoryRowData {
+			importGuidList = append(importGuidList, rowData.Guid)
+		}
+	}
+	for _, tmpImportGuid := range importGuidList {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		importGuidMapTable, queryErr := QueryCiImportGuidMapByReportImportGuid(tmpImportGuid)
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		if len(importGuidMapTable) == 0 {
+			if importGuid != "" {
+				err = fmt.Errorf("report import guid is invalid")
+				return
+			}
+			continue
+		}
+		if err = RefreshReportImportHistoryByGuidMap(importGuidMapTable); err != nil {
+			return
+		}
+	}
+	return
+}
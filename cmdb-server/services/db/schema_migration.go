@@ -0,0 +1,271 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+)
+
+var (
+	schemaMigrationLock     sync.Mutex
+	schemaMigrationDataType = []string{"varchar", "text", "int", "float", "datetime"}
+)
+
+// PlanSchemaMigration 生成已确认属性的字段类型/长度变更计划,并校验现有数据(包括历史表)是否能转换成新类型
+func PlanSchemaMigration(ciAttrId string, param *models.SchemaMigrationParam) (plan *models.SchemaMigrationPlan, err error) {
+	ciAttr, getErr := GetCiAttrById(ciAttrId)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	if ciAttr.Status != "created" {
+		err = fmt.Errorf("Attr:%s is not created,please update it directly ", ciAttrId)
+		return
+	}
+	if ciAttr.Name == "guid" || ciAttr.InputType == models.MultiRefType {
+		err = fmt.Errorf("Attr:%s with input type:%s can not change data type ", ciAttrId, ciAttr.InputType)
+		return
+	}
+	newDataType, newDataLength := strings.ToLower(param.DataType), param.DataLength
+	if strings.Contains(newDataType, "(") {
+		newDataLength, _ = strconv.Atoi(newDataType[strings.Index(newDataType, "(")+1 : len(newDataType)-1])
+		newDataType = newDataType[:strings.Index(newDataType, "(")]
+	}
+	dataTypeLegal := false
+	for _, dataType := range schemaMigrationDataType {
+		if dataType == newDataType {
+			dataTypeLegal = true
+			break
+		}
+	}
+	if !dataTypeLegal {
+		err = fmt.Errorf("Data type:%s illegal,should be one of %s ", newDataType, strings.Join(schemaMigrationDataType, "/"))
+		return
+	}
+	if newDataType == "text" {
+		indexName, checkErr := checkAttrInUniqueIndex(ciAttr.CiType, ciAttr.Name)
+		if checkErr != nil {
+			err = checkErr
+			return
+		}
+		if indexName != "" {
+			err = fmt.Errorf("Attr:%s is used by unique index:%s,can not change to text ", ciAttrId, indexName)
+			return
+		}
+	}
+	if newDataType == "datetime" || newDataType == "float" {
+		newDataLength = 0
+	} else if newDataLength <= 0 {
+		err = fmt.Errorf("Data length:%d illegal with data type:%s ", newDataLength, newDataType)
+		return
+	}
+	oldDataLength := ciAttr.DataLength
+	if ciAttr.DataType == "datetime" || ciAttr.DataType == "float" {
+		oldDataLength = 0
+	}
+	if ciAttr.DataType == newDataType && oldDataLength == newDataLength {
+		err = fmt.Errorf("Attr:%s data type is already %s(%d) ", ciAttrId, newDataType, newDataLength)
+		return
+	}
+	plan = &models.SchemaMigrationPlan{CiType: ciAttr.CiType, CiTypeAttr: ciAttr.Id, OldDataType: ciAttr.DataType, OldDataLength: oldDataLength,
+		NewDataType: newDataType, NewDataLength: newDataLength, InvalidGuidList: []string{}}
+	plan.ChangeType = getSchemaChangeType(ciAttr.DataType, oldDataLength, newDataType, newDataLength)
+	newAttr := *ciAttr
+	newAttr.DataType, newAttr.DataLength = newDataType, newDataLength
+	plan.ForwardSqlList = buildSchemaAlterSqlList(&newAttr)
+	plan.RollbackSqlList = buildSchemaAlterSqlList(ciAttr)
+	if plan.ChangeType != models.SchemaChangeWiden {
+		plan.InvalidCount, plan.InvalidGuidList, err = checkSchemaInvalidData(ciAttr, newDataType, newDataLength)
+		if err != nil {
+			return
+		}
+	}
+	plan.Executable = plan.InvalidCount == 0
+	return
+}
+
+// ApplySchemaMigration 先改历史表再改数据表,数据表失败时把已执行的历史表语句回滚
+func ApplySchemaMigration(ciAttrId string, param *models.SchemaMigrationParam, operator string) (record *models.SysSchemaMigrationTable, err error) {
+	schemaMigrationLock.Lock()
+	defer schemaMigrationLock.Unlock()
+	plan, planErr := PlanSchemaMigration(ciAttrId, param)
+	if planErr != nil {
+		err = planErr
+		return
+	}
+	if !plan.Executable {
+		err = fmt.Errorf("Attr:%s have %d rows can not convert to %s(%d),such as:%s ", ciAttrId, plan.InvalidCount, plan.NewDataType, plan.NewDataLength, strings.Join(plan.InvalidGuidList, ","))
+		return
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	record = &models.SysSchemaMigrationTable{Id: "sm_" + guid.CreateGuid(), CiType: plan.CiType, CiTypeAttr: plan.CiTypeAttr, ChangeType: plan.ChangeType,
+		OldDataType: plan.OldDataType, OldDataLength: plan.OldDataLength, NewDataType: plan.NewDataType, NewDataLength: plan.NewDataLength,
+		ForwardSql: strings.Join(plan.ForwardSqlList, ";\n"), RollbackSql: strings.Join(plan.RollbackSqlList, ";\n"), Status: "running", CreateUser: operator, CreateTime: nowTime, UpdateTime: nowTime}
+	_, err = x.Exec("insert into sys_schema_migration(id,ci_type,ci_type_attr,change_type,old_data_type,old_data_length,new_data_type,new_data_length,forward_sql,rollback_sql,status,create_user,create_time,update_time) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?)",
+		record.Id, record.CiType, record.CiTypeAttr, record.ChangeType, record.OldDataType, record.OldDataLength, record.NewDataType, record.NewDataLength,
+		record.ForwardSql, record.RollbackSql, record.Status, record.CreateUser, record.CreateTime, record.UpdateTime)
+	if err != nil {
+		err = fmt.Errorf("Try to insert schema migration record fail,%s ", err.Error())
+		return
+	}
+	if err = execSchemaMigrationSql(plan.ForwardSqlList, plan.RollbackSqlList, plan.ChangeType == models.SchemaChangeWiden); err == nil {
+		if _, err = x.Exec("update sys_ci_type_attr set data_type=?,data_length=? where id=?", plan.NewDataType, plan.NewDataLength, plan.CiTypeAttr); err != nil {
+			err = fmt.Errorf("Try to update attr data type fail,%s ", err.Error())
+		}
+	}
+	record.Status, record.UpdateTime = "ok", time.Now().Format(models.DateTimeFormat)
+	if err != nil {
+		record.Status, record.ErrorMsg = "fail", err.Error()
+	}
+	if _, updateErr := x.Exec("update sys_schema_migration set status=?,error_msg=?,update_time=? where id=?", record.Status, record.ErrorMsg, record.UpdateTime, record.Id); updateErr != nil {
+		log.Error(nil, log.LOGGER_APP, "Update schema migration status fail", zap.String("id", record.Id), zap.Error(updateErr))
+	}
+	return
+}
+
+// RollbackSchemaMigration 按原类型重新生成一次迁移,同样需要校验数据能否转换回原类型
+func RollbackSchemaMigration(migrationId, operator string) (record *models.SysSchemaMigrationTable, err error) {
+	var migrationRows []*models.SysSchemaMigrationTable
+	if err = x.SQL("select * from sys_schema_migration where id=?", migrationId).Find(&migrationRows); err != nil {
+		err = fmt.Errorf("Try to query schema migration fail,%s ", err.Error())
+		return
+	}
+	if len(migrationRows) == 0 {
+		err = fmt.Errorf("Can not find schema migration with id:%s ", migrationId)
+		return
+	}
+	migration := migrationRows[0]
+	if migration.Status != "ok" {
+		err = fmt.Errorf("Schema migration:%s status is %s,only ok migration can rollback ", migrationId, migration.Status)
+		return
+	}
+	ciAttr, getErr := GetCiAttrById(migration.CiTypeAttr)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	if ciAttr.DataType != migration.NewDataType || (migration.NewDataLength > 0 && ciAttr.DataLength != migration.NewDataLength) {
+		err = fmt.Errorf("Attr:%s data type has been changed after migration:%s ", migration.CiTypeAttr, migrationId)
+		return
+	}
+	if record, err = ApplySchemaMigration(migration.CiTypeAttr, &models.SchemaMigrationParam{DataType: migration.OldDataType, DataLength: migration.OldDataLength}, operator); err != nil {
+		return
+	}
+	if record.Status == "ok" {
+		x.Exec("update sys_schema_migration set status='rollback',update_time=? where id=?", time.Now().Format(models.DateTimeFormat), migrationId)
+	}
+	return
+}
+
+func QuerySchemaMigration(ciType string) (rowData []*models.SysSchemaMigrationTable, err error) {
+	rowData = []*models.SysSchemaMigrationTable{}
+	if err = x.SQL("select * from sys_schema_migration where ci_type=? order by create_time desc", ciType).Find(&rowData); err != nil {
+		err = fmt.Errorf("Try to query schema migration fail,%s ", err.Error())
+	}
+	return
+}
+
+func getSchemaChangeType(oldDataType string, oldDataLength int, newDataType string, newDataLength int) string {
+	if oldDataType == newDataType {
+		if newDataLength > oldDataLength {
+			return models.SchemaChangeWiden
+		}
+		return models.SchemaChangeNarrow
+	}
+	if oldDataType == "varchar" && newDataType == "text" && newDataLength >= oldDataLength {
+		return models.SchemaChangeWiden
+	}
+	// int最多11位(含符号),datetime固定19位
+	if (oldDataType == "int" && newDataLength >= 11) || (oldDataType == "datetime" && newDataLength >= 19) {
+		if newDataType == "varchar" || newDataType == "text" {
+			return models.SchemaChangeWiden
+		}
+	}
+	return models.SchemaChangeTypeChange
+}
+
+func buildSchemaAlterSqlList(ciAttr *models.SysCiTypeAttrTable) []string {
+	attrSql, historyAttrSql := buildColumnSqlFromCiAttr(ciAttr)
+	return []string{fmt.Sprintf("ALTER TABLE `%s%s` MODIFY COLUMN %s", HistoryTablePrefix, ciAttr.CiType, historyAttrSql),
+		fmt.Sprintf("ALTER TABLE `%s` MODIFY COLUMN %s", ciAttr.CiType, attrSql)}
+}
+
+// checkSchemaInvalidData 查询数据表和历史表中不能转换成新类型的数据
+func checkSchemaInvalidData(ciAttr *models.SysCiTypeAttrTable, newDataType string, newDataLength int) (invalidCount int, invalidGuidList []string, err error) {
+	invalidGuidList = []string{}
+	column := fmt.Sprintf("`%s`", ciAttr.Name)
+	var condition string
+	var params []interface{}
+	switch newDataType {
+	case "varchar", "text":
+		condition = fmt.Sprintf("char_length(%s)>?", column)
+		params = append(params, newDataLength)
+	case "int":
+		condition = fmt.Sprintf("%s is not null and (cast(%s as char) not regexp ? or cast(%s as decimal(65,0)) not between -2147483648 and 2147483647)", column, column, column)
+		params = append(params, `^-?[0-9]+$`)
+	case "float":
+		condition = fmt.Sprintf("%s is not null and cast(%s as char) not regexp ?", column, column)
+		params = append(params, `^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+	case "datetime":
+		condition = fmt.Sprintf("%s is not null and (cast(%s as char)='' or str_to_date(cast(%s as char),?) is null)", column, column, column)
+		params = append(params, "%Y-%m-%d %H:%i:%s")
+	}
+	baseSql := fmt.Sprintf("select guid from `%s` where %s union select guid from `%s%s` where %s", ciAttr.CiType, condition, HistoryTablePrefix, ciAttr.CiType, condition)
+	queryParams := append(append([]interface{}{}, params...), params...)
+	countRows, countErr := x.QueryString(append([]interface{}{"select count(1) as num from (" + baseSql + ") sub_query"}, queryParams...)...)
+	if countErr != nil {
+		err = fmt.Errorf("Try to check attr:%s data with new type fail,%s ", ciAttr.Id, countErr.Error())
+		return
+	}
+	if len(countRows) > 0 {
+		invalidCount, _ = strconv.Atoi(countRows[0]["num"])
+	}
+	if invalidCount == 0 {
+		return
+	}
+	guidRows, queryErr := x.QueryString(append([]interface{}{baseSql + fmt.Sprintf(" limit %d", models.SchemaInvalidGuidLimit)}, queryParams...)...)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query attr:%s invalid data fail,%s ", ciAttr.Id, queryErr.Error())
+		return
+	}
+	for _, row := range guidRows {
+		invalidGuidList = append(invalidGuidList, row["guid"])
+	}
+	return
+}
+
+func execSchemaMigrationSql(forwardSqlList, rollbackSqlList []string, online bool) (err error) {
+	for i, forwardSql := range forwardSqlList {
+		if execErr := execSchemaAlterSql(forwardSql, online); execErr != nil {
+			err = fmt.Errorf("Try to exec sql:%s fail,%s ", forwardSql, execErr.Error())
+			// DDL不能在事务中回滚,需要把已经执行成功的语句反向执行
+			for j := i - 1; j >= 0; j-- {
+				if _, rollbackErr := x.Exec(rollbackSqlList[j]); rollbackErr != nil {
+					log.Error(nil, log.LOGGER_APP, "Rollback schema migration sql fail", zap.String("sql", rollbackSqlList[j]), zap.Error(rollbackErr))
+					err = fmt.Errorf("%s and rollback sql:%s fail,%s ", err.Error(), rollbackSqlList[j], rollbackErr.Error())
+				}
+			}
+			return
+		}
+	}
+	return
+}
+
+// execSchemaAlterSql 扩容时优先使用在线DDL,数据库不支持时再使用默认方式
+func execSchemaAlterSql(alterSql string, online bool) (err error) {
+	if online {
+		if _, err = x.Exec(alterSql + ", ALGORITHM=INPLACE, LOCK=NONE"); err == nil {
+			return
+		}
+		log.Warn(nil, log.LOGGER_APP, "Online alter table fail,try to use default algorithm", zap.String("sql", alterSql), zap.Error(err))
+	}
+	_, err = x.Exec(alterSql)
+	return
+}
@@ -0,0 +1,373 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+)
+
+const (
+	stateIdMaxLength = 32
+)
+
+var (
+	stateMachineNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+	stateTransitionActions = map[string]bool{"insert": true, "update": true, "delete": true, "confirm": true}
+)
+
+func QueryStateMachineList() (rowData []*models.GetStateMachineList, err error) {
+	rowData = []*models.GetStateMachineList{}
+	var machineTable []*models.SysStateMachineTable
+	err = x.SQL("select * from sys_state_machine order by id").Find(&machineTable)
+	if err != nil {
+		err = fmt.Errorf("Try to get sys_state_machine table data fail,%s ", err.Error())
+		return
+	}
+	var machineList []string
+	for _, machine := range machineTable {
+		machineList = append(machineList, machine.Id)
+	}
+	if len(machineList) == 0 {
+		return
+	}
+	if rowData, err = GetStateMachineStateList(machineList); err != nil {
+		return
+	}
+	var transitionTable []*models.SysStateTransitionTable
+	err = x.SQL("select * from sys_state_transition order by state_machine,current_state").Find(&transitionTable)
+	if err != nil {
+		err = fmt.Errorf("Try to get sys_state_transition table data fail,%s ", err.Error())
+		return
+	}
+	transitionMap := make(map[string][]*models.SysStateTransitionTable)
+	for _, transition := range transitionTable {
+		transitionMap[transition.StateMachine] = append(transitionMap[transition.StateMachine], transition)
+	}
+	for _, machine := range rowData {
+		if transitions, b := transitionMap[machine.Id]; b {
+			machine.Transitions = transitions
+		} else {
+			machine.Transitions = []*models.SysStateTransitionTable{}
+		}
+	}
+	return
+}
+
+// PlanStateMachine 校验状态机定义,并计算删除状态对已有ci数据的影响
+func PlanStateMachine(param *models.StateMachineParam, isNew bool) (plan *models.StateMachineSavePlan, err error) {
+	plan = &models.StateMachineSavePlan{Id: param.Id, Errors: []string{}, Warnings: []string{}, NewStates: []string{}, RemovedStates: []string{}, CiTypes: []string{}, Impacts: []*models.StateMachineImpactObj{}}
+	if !stateMachineNameRegexp.MatchString(param.Id) || len(param.Id) > stateIdMaxLength {
+		plan.Errors = append(plan.Errors, fmt.Sprintf("State machine id:%s illegal,only support [a-zA-Z0-9_] and max length is %d", param.Id, stateIdMaxLength))
+		return
+	}
+	var existMachineRows []*models.SysStateMachineTable
+	err = x.SQL("select * from sys_state_machine where id=?", param.Id).Find(&existMachineRows)
+	if err != nil {
+		err = fmt.Errorf("Try to query state machine fail,%s ", err.Error())
+		return
+	}
+	if isNew && len(existMachineRows) > 0 {
+		plan.Errors = append(plan.Errors, fmt.Sprintf("State machine:%s already exists", param.Id))
+		return
+	}
+	if !isNew && len(existMachineRows) == 0 {
+		err = fmt.Errorf("Can not find state machine:%s ", param.Id)
+		return
+	}
+	normalizeStateMachineParam(param)
+	validateStateMachineParam(param, plan)
+	if isNew {
+		for _, state := range param.States {
+			plan.NewStates = append(plan.NewStates, state.Name)
+		}
+		return
+	}
+	var existStateRows []*models.SysStateTable
+	err = x.SQL("select * from sys_state where state_machine=?", param.Id).Find(&existStateRows)
+	if err != nil {
+		err = fmt.Errorf("Try to query state list fail,%s ", err.Error())
+		return
+	}
+	newStateMap := make(map[string]bool)
+	for _, state := range param.States {
+		newStateMap[state.Id] = true
+	}
+	existStateMap := make(map[string]bool)
+	for _, state := range existStateRows {
+		existStateMap[state.Id] = true
+		if !newStateMap[state.Id] {
+			plan.RemovedStates = append(plan.RemovedStates, state.Name)
+		}
+	}
+	for _, state := range param.States {
+		if !existStateMap[state.Id] {
+			plan.NewStates = append(plan.NewStates, state.Name)
+		}
+	}
+	var ciTypeRows []*models.SysCiTypeTable
+	err = x.SQL("select id from sys_ci_type where state_machine=? and status<>'notCreated' order by id", param.Id).Find(&ciTypeRows)
+	if err != nil {
+		err = fmt.Errorf("Try to query ci type with state machine fail,%s ", err.Error())
+		return
+	}
+	for _, ciType := range ciTypeRows {
+		plan.CiTypes = append(plan.CiTypes, ciType.Id)
+	}
+	if len(plan.RemovedStates) == 0 {
+		return
+	}
+	// 删除的状态上还有数据时,这些数据将无法再进行状态转换
+	stateSpecSql, stateParams := createListParams(plan.RemovedStates, "")
+	for _, ciType := range plan.CiTypes {
+		var impactRows []*models.StateMachineImpactObj
+		queryErr := x.SQL(fmt.Sprintf("select '%s' as ci_type,state,count(1) as row_count from `%s` where state in (%s) group by state", ciType, ciType, stateSpecSql), stateParams...).Find(&impactRows)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to count ci:%s data in removed states fail,%s ", ciType, queryErr.Error())
+			return
+		}
+		plan.Impacts = append(plan.Impacts, impactRows...)
+	}
+	return
+}
+
+// normalizeStateMachineParam 状态id统一为 状态机__状态名 ,状态转换中的状态可以填id或者名称
+func normalizeStateMachineParam(param *models.StateMachineParam) {
+	statePrefix := param.Id + models.SysTableIdConnector
+	toStateId := func(input string) string {
+		if input == "" || strings.HasPrefix(input, statePrefix) {
+			return input
+		}
+		return statePrefix + input
+	}
+	for _, state := range param.States {
+		state.Name = strings.TrimSpace(state.Name)
+		state.Id = statePrefix + state.Name
+		state.StateMachine = param.Id
+		if state.UniquePathTrigger == "" {
+			state.UniquePathTrigger = "no"
+		}
+		if state.IsConfirm == "" {
+			state.IsConfirm = "no"
+		}
+	}
+	param.StartState = toStateId(param.StartState)
+	param.FinalState = toStateId(param.FinalState)
+	for _, transition := range param.Transitions {
+		if transition.Guid == "" {
+			transition.Guid = guid.CreateGuid()
+		}
+		transition.StateMachine = param.Id
+		transition.CurrentState = toStateId(transition.CurrentState)
+		transition.TargetState = toStateId(transition.TargetState)
+		if transition.OperationFormType == "" {
+			transition.OperationFormType = "editable_form"
+		}
+		if transition.OperationMultiple == "" {
+			transition.OperationMultiple = "yes"
+		}
+	}
+}
+
+func validateStateMachineParam(param *models.StateMachineParam, plan *models.StateMachineSavePlan) {
+	stateMap := make(map[string]*models.SysStateTable)
+	confirmExist := false
+	for _, state := range param.States {
+		if !stateMachineNameRegexp.MatchString(state.Name) {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("State name:%s illegal,only support [a-zA-Z0-9_]", state.Name))
+			continue
+		}
+		if len(state.Id) > stateIdMaxLength {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("State id:%s too long,max length is %d", state.Id, stateIdMaxLength))
+		}
+		if _, b := stateMap[state.Id]; b {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("State name:%s duplicate", state.Name))
+			continue
+		}
+		if (state.IsConfirm != "yes" && state.IsConfirm != "no") || (state.UniquePathTrigger != "yes" && state.UniquePathTrigger != "no") {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("State:%s isConfirm and uniquePathTrigger only support yes or no", state.Name))
+		}
+		if state.IsConfirm == "yes" {
+			confirmExist = true
+		}
+		stateMap[state.Id] = state
+	}
+	if !confirmExist {
+		plan.Errors = append(plan.Errors, "State machine must have at least one confirm state")
+	}
+	if _, b := stateMap[param.StartState]; !b {
+		plan.Errors = append(plan.Errors, fmt.Sprintf("Start state:%s is not in state list", param.StartState))
+	}
+	if param.FinalState != "" {
+		if _, b := stateMap[param.FinalState]; !b {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("Final state:%s is not in state list", param.FinalState))
+		}
+	}
+	nextStateMap := make(map[string][]string)
+	operationMap := make(map[string]bool)
+	for _, transition := range param.Transitions {
+		if transition.Operation == "" || transition.OperationEn == "" {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("Transition:%s operation and operationEn can not empty", transition.Guid))
+		}
+		if !stateTransitionActions[transition.Action] {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("Transition:%s action:%s illegal,only support insert,update,delete,confirm", transition.OperationEn, transition.Action))
+		}
+		_, currentExist := stateMap[transition.CurrentState]
+		_, targetExist := stateMap[transition.TargetState]
+		if !currentExist || !targetExist {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("Transition:%s current state:%s or target state:%s is not in state list", transition.OperationEn, transition.CurrentState, transition.TargetState))
+			continue
+		}
+		if guardErr := validateTransitionGuard(transition); guardErr != nil {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("Transition:%s %s", transition.OperationEn, guardErr.Error()))
+		}
+		operationKey := transition.CurrentState + "^" + transition.OperationEn
+		if operationMap[operationKey] {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("Transition operation:%s duplicate in state:%s", transition.OperationEn, stateMap[transition.CurrentState].Name))
+		}
+		operationMap[operationKey] = true
+		nextStateMap[transition.CurrentState] = append(nextStateMap[transition.CurrentState], transition.TargetState)
+	}
+	// 唯一路径触发的状态会自动执行下一个转换,所以只能有一个出口
+	for _, state := range param.States {
+		if state.UniquePathTrigger != "yes" {
+			continue
+		}
+		if nextCount := len(nextStateMap[state.Id]); nextCount > 1 {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("Unique path trigger state:%s must have only one transition,but got %d", state.Name, nextCount))
+		} else if nextCount == 0 {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("Unique path trigger state:%s has no transition", state.Name))
+		}
+	}
+	if _, b := stateMap[param.StartState]; !b {
+		return
+	}
+	reachMap := map[string]bool{param.StartState: true}
+	queue := []string{param.StartState}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range nextStateMap[current] {
+			if !reachMap[next] {
+				reachMap[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	var unreachableList []string
+	for _, state := range param.States {
+		if !reachMap[state.Id] {
+			unreachableList = append(unreachableList, state.Name)
+		} else if len(nextStateMap[state.Id]) == 0 && state.Id != param.FinalState {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("State:%s has no transition to leave", state.Name))
+		}
+	}
+	if len(unreachableList) > 0 {
+		sort.Strings(unreachableList)
+		plan.Errors = append(plan.Errors, fmt.Sprintf("States:%s can not reach from start state", strings.Join(unreachableList, ",")))
+	}
+}
+
+// SaveStateMachine 新增或整体更新状态机,删除的状态上还有数据时需要force确认
+func SaveStateMachine(param *models.StateMachineParam, isNew, force bool) (plan *models.StateMachineSavePlan, err error) {
+	plan, err = PlanStateMachine(param, isNew)
+	if err != nil {
+		return
+	}
+	if len(plan.Errors) > 0 {
+		err = fmt.Errorf("State machine validate fail,%s ", strings.Join(plan.Errors, ";"))
+		return
+	}
+	if len(plan.Impacts) > 0 && !force {
+		err = fmt.Errorf("There are ci data in removed states:%s ,please confirm with force ", strings.Join(plan.RemovedStates, ","))
+		return
+	}
+	machineRow := models.SysStateMachineTable{Id: param.Id, Description: param.Description, StartState: param.StartState, FinalState: param.FinalState}
+	var actions []*execAction
+	if isNew {
+		action, _ := GetInsertTableExecAction("sys_state_machine", machineRow, map[string]string{"final_state": ""})
+		actions = append(actions, action)
+	}
+	actions = append(actions, &execAction{Sql: "delete from sys_state_transition where state_machine=?", Param: []interface{}{param.Id}})
+	var existStateRows []*models.SysStateTable
+	if !isNew {
+		err = x.SQL("select id from sys_state where state_machine=?", param.Id).Find(&existStateRows)
+		if err != nil {
+			err = fmt.Errorf("Try to query state list fail,%s ", err.Error())
+			return
+		}
+	}
+	existStateMap := make(map[string]bool)
+	for _, state := range existStateRows {
+		existStateMap[state.Id] = true
+	}
+	newStateMap := make(map[string]bool)
+	for _, state := range param.States {
+		newStateMap[state.Id] = true
+		var action *execAction
+		if existStateMap[state.Id] {
+			action, _ = GetUpdateTableExecAction("sys_state", "id", state.Id, *state, nil)
+		} else {
+			action, _ = GetInsertTableExecAction("sys_state", *state, nil)
+		}
+		actions = append(actions, action)
+	}
+	for _, state := range existStateRows {
+		if !newStateMap[state.Id] {
+			action, _ := GetDeleteTableExecAction("sys_state", "id", state.Id)
+			actions = append(actions, action)
+		}
+	}
+	for _, transition := range param.Transitions {
+		action, _ := GetInsertTableExecAction("sys_state_transition", *transition, nil)
+		actions = append(actions, action)
+	}
+	if !isNew {
+		action, _ := GetUpdateTableExecAction("sys_state_machine", "id", param.Id, machineRow, map[string]string{"final_state": ""})
+		actions = append(actions, action)
+	}
+	if err = transaction(actions); err != nil {
+		err = fmt.Errorf("Try to save state machine:%s fail,%s ", param.Id, err.Error())
+		return
+	}
+	plan.Applied = true
+	return
+}
+
+func DeleteStateMachine(machineId string) (err error) {
+	var ciTypeRows []*models.SysCiTypeTable
+	err = x.SQL("select id from sys_ci_type where state_machine=?", machineId).Find(&ciTypeRows)
+	if err != nil {
+		err = fmt.Errorf("Try to query ci type with state machine fail,%s ", err.Error())
+		return
+	}
+	if len(ciTypeRows) > 0 {
+		var ciTypeList []string
+		for _, row := range ciTypeRows {
+			ciTypeList = append(ciTypeList, row.Id)
+		}
+		err = fmt.Errorf("State machine:%s is used by ci type:%s ", machineId, strings.Join(ciTypeList, ","))
+		return
+	}
+	var templateRows []*models.SysCiTemplateTable
+	err = x.SQL("select id from sys_ci_template where state_machine=?", machineId).Find(&templateRows)
+	if err != nil {
+		err = fmt.Errorf("Try to query ci template with state machine fail,%s ", err.Error())
+		return
+	}
+	if len(templateRows) > 0 {
+		err = fmt.Errorf("State machine:%s is used by ci template:%s ", machineId, templateRows[0].Id)
+		return
+	}
+	var actions []*execAction
+	actions = append(actions, &execAction{Sql: "delete from sys_state_transition where state_machine=?", Param: []interface{}{machineId}})
+	actions = append(actions, &execAction{Sql: "delete from sys_state where state_machine=?", Param: []interface{}{machineId}})
+	actions = append(actions, &execAction{Sql: "delete from sys_state_machine where id=?", Param: []interface{}{machineId}})
+	if err = transaction(actions); err != nil {
+		err = fmt.Errorf("Try to delete state machine:%s fail,%s ", machineId, err.Error())
+	}
+	return
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/expression"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+)
+
+// guardExtraOperators 守卫在表达式过滤条件之外额外支持的单值操作符
+var guardExtraOperators = []string{"contains", "gt", "lt", "regexp"}
+
+type transitionGuardCondition struct {
+	Express   string
+	Attr      string
+	Operator  string
+	Value     string
+	ValueList []string
+}
+
+// parseGuardFilter 用表达式过滤条件的解析器解析 [{attr op 'value'},{attr in ['a','b']}]
+func parseGuardFilter(express string) (conditions []*transitionGuardCondition, err error) {
+	express = strings.TrimSpace(express)
+	if express == "" {
+		return
+	}
+	filterList, parseErr := expression.ParseFilters(express, guardExtraOperators...)
+	if parseErr != nil {
+		err = fmt.Errorf("Guard express:%s illegal,%s ", express, parseErr.Error())
+		return
+	}
+	for _, filter := range filterList {
+		condition := transitionGuardCondition{Express: filter.String(), Attr: filter.Attr, Operator: filter.Operator, Value: filter.Value, ValueList: filter.ValueList}
+		if condition.Operator == "regexp" {
+			if _, err = regexp.Compile(condition.Value); err != nil {
+				err = fmt.Errorf("Guard condition:%s regexp illegal,%s ", condition.Express, err.Error())
+				return
+			}
+		}
+		conditions = append(conditions, &condition)
+	}
+	if len(conditions) == 0 {
+		err = fmt.Errorf("Guard express:%s can not find any condition ", express)
+	}
+	return
+}
+
+func matchGuardCondition(condition *transitionGuardCondition, value string) bool {
+	switch condition.Operator {
+	case "eq":
+		return value == condition.Value
+	case "ne":
+		return value != condition.Value
+	case "in", "notIn":
+		exist := false
+		for _, v := range condition.ValueList {
+			if v == value {
+				exist = true
+				break
+			}
+		}
+		return exist == (condition.Operator == "in")
+	case "like", "contains":
+		return strings.Contains(value, condition.Value)
+	case "null", "empty":
+		return value == ""
+	case "notNull", "notEmpty":
+		return value != ""
+	case "regexp":
+		matched, _ := regexp.MatchString(condition.Value, value)
+		return matched
+	case "gt", "lt", ">=", "<=":
+		compareResult := strings.Compare(value, condition.Value)
+		leftFloat, leftErr := strconv.ParseFloat(value, 64)
+		rightFloat, rightErr := strconv.ParseFloat(condition.Value, 64)
+		if leftErr == nil && rightErr == nil {
+			compareResult = 0
+			if leftFloat > rightFloat {
+				compareResult = 1
+			} else if leftFloat < rightFloat {
+				compareResult = -1
+			}
+		}
+		switch condition.Operator {
+		case "gt":
+			return compareResult > 0
+		case "lt":
+			return compareResult < 0
+		case ">=":
+			return compareResult >= 0
+		default:
+			return compareResult <= 0
+		}
+	}
+	return false
+}
+
+// validateTransitionGuard 保存状态机时检查转换守卫的语法
+func validateTransitionGuard(transition *models.SysStateTransitionTable) (err error) {
+	if _, err = parseGuardFilter(transition.GuardFilter); err != nil {
+		return
+	}
+	_, err = parseGuardFilter(transition.RefStateGuard)
+	return
+}
+
+// checkTransitionGuard 检查数据行是否满足状态转换的守卫条件,返回每个不满足的守卫的原因
+func checkTransitionGuard(param *models.ActionFuncParam) (failList []string, err error) {
+	transition := param.Transition
+	if transition.GuardFilter == "" && transition.RequiredAttrs == "" && transition.RefStateGuard == "" {
+		return
+	}
+	rowData := make(map[string]string)
+	for k, v := range param.NowData {
+		rowData[k] = v
+	}
+	for k, v := range param.InputData {
+		rowData[k] = v
+	}
+	attrMap := make(map[string]*models.SysCiTypeAttrTable)
+	for _, attr := range param.Attributes {
+		attrMap[attr.Name] = attr
+	}
+	for _, attrName := range strings.Split(transition.RequiredAttrs, ",") {
+		attrName = strings.TrimSpace(attrName)
+		if attrName == "" {
+			continue
+		}
+		value := rowData[attrName]
+		if attr, b := attrMap[attrName]; b && attr.InputType == models.MultiRefType {
+			if valueList, _ := transStringValueToList(value); len(valueList) == 0 {
+				value = ""
+			}
+		}
+		if value == "" {
+			failList = append(failList, fmt.Sprintf("required attr:%s is empty", attrName))
+		}
+	}
+	filterConditions, parseErr := parseGuardFilter(transition.GuardFilter)
+	if parseErr != nil {
+		err = fmt.Errorf("Transition:%s guard filter illegal,%s ", transition.OperationEn, parseErr.Error())
+		return
+	}
+	for _, condition := range filterConditions {
+		if !matchGuardCondition(condition, rowData[condition.Attr]) {
+			failList = append(failList, fmt.Sprintf("guard %s not match,value:'%s'", condition.Express, rowData[condition.Attr]))
+		}
+	}
+	refConditions, parseErr := parseGuardFilter(transition.RefStateGuard)
+	if parseErr != nil {
+		err = fmt.Errorf("Transition:%s reference state guard illegal,%s ", transition.OperationEn, parseErr.Error())
+		return
+	}
+	for _, condition := range refConditions {
+		attr, b := attrMap[condition.Attr]
+		if !b || attr.RefCiType == "" {
+			failList = append(failList, fmt.Sprintf("reference guard %s attr is not a reference attribute", condition.Express))
+			continue
+		}
+		var refGuidList []string
+		if attr.InputType == models.MultiRefType {
+			refGuidList, _ = transStringValueToList(rowData[attr.Name])
+		} else if rowData[attr.Name] != "" {
+			refGuidList = []string{rowData[attr.Name]}
+		}
+		if len(refGuidList) == 0 {
+			continue
+		}
+		guidSpecSql, guidParams := createListParams(refGuidList, "")
+		queryRows, queryErr := x.QueryString(append([]interface{}{fmt.Sprintf("select guid,key_name,state from `%s` where guid in (%s)", attr.RefCiType, guidSpecSql)}, guidParams...)...)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to query reference ci:%s state fail,%s ", attr.RefCiType, queryErr.Error())
+			return
+		}
+		for _, refRow := range queryRows {
+			if !matchGuardCondition(condition, refRow["state"]) {
+				failList = append(failList, fmt.Sprintf("reference guard %s not match,%s:%s state is %s", condition.Express, attr.RefCiType, refRow["key_name"], refRow["state"]))
+			}
+		}
+	}
+	return
+}
@@ -1,32 +1,44 @@
 package db
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
-	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/tools"
 	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
 	"go.uber.org/zap"
 )
 
+var syncPushLock = new(sync.Mutex)
+
 func SyncPush(inputData *models.SysSyncRecordTable) {
 	if !models.Config.Sync.MasterEnable {
 		return
 	}
+	// 串行推送,保证同步记录id递增并且每个文件能带上前一个推送成功的记录id
+	syncPushLock.Lock()
+	defer syncPushLock.Unlock()
 	inputData.ID = fmt.Sprintf("cmdb_sync_%s", strings.ReplaceAll(time.Now().Format("20060102150405.99999"), ".", ""))
 	inputData.SourceID = inputData.ID
 	inputData.SyncType = models.Config.Sync.Type
 	inputData.Source = models.Config.Sync.Source
 	inputData.Target = models.Config.Sync.Target
-	inputData.RemoteRepo = fmt.Sprintf("%s__%s", models.Config.Sync.NexusAddress, models.Config.Sync.NexusRepo)
 	inputData.CreateTime = time.Now()
 	inputData.Status = "ok"
 	defer AddSyncRecord(inputData)
+	transport, err := GetSyncTransport()
+	if err != nil {
+		inputData.Status = "fail"
+		inputData.ErrorMsg = err.Error()
+		return
+	}
+	inputData.RemoteRepo = transport.RemoteRepo()
 	contentBytes, err := json.Marshal(inputData.ContentData)
 	if err != nil {
 		inputData.Status = "fail"
@@ -34,30 +46,56 @@ func SyncPush(inputData *models.SysSyncRecordTable) {
 		return
 	}
 	inputData.Content = string(contentBytes)
+	if inputData.PrevSourceId, err = getPrevPushSyncId(inputData.ID); err != nil {
+		inputData.Status = "fail"
+		inputData.ErrorMsg = err.Error()
+		return
+	}
+	if err = uploadSyncRecord(transport, inputData); err != nil {
+		inputData.Status = "fail"
+		inputData.ErrorMsg = err.Error()
+	}
+}
+
+func uploadSyncRecord(transport SyncTransport, inputData *models.SysSyncRecordTable) (err error) {
 	inputDataBytes, _ := json.Marshal(inputData)
 	tmpFileName := fmt.Sprintf("%s.json", inputData.ID)
 	tmpFilePath := models.TmpSyncDir + tmpFileName
 	err = os.WriteFile(tmpFilePath, inputDataBytes, 0644)
 	if err != nil {
-		inputData.Status = "fail"
-		inputData.ErrorMsg = fmt.Sprintf("write tmp file:%s fail,%s ", tmpFilePath, err.Error())
+		err = fmt.Errorf("write tmp file:%s fail,%s ", tmpFilePath, err.Error())
 		return
 	}
-	nexusParam := tools.NexusReqParam{
-		UserName:   models.Config.Sync.NexusUser,
-		Password:   models.Config.Sync.NexusPwd,
-		RepoUrl:    models.Config.Sync.NexusAddress,
-		Repository: models.Config.Sync.NexusRepo,
-		TimeoutSec: 30,
-		FileParams: []*tools.NexusFileParam{{SourceFilePath: tmpFilePath, DestFilePath: models.SyncNexusDir + tmpFileName}},
-	}
-	_, err = tools.UploadFile(&nexusParam)
-	if err != nil {
-		inputData.Status = "fail"
-		inputData.ErrorMsg = fmt.Sprintf("upload sync file:%s to nexus fail,%s ", tmpFilePath, err.Error())
+	err = transport.Upload(tmpFilePath, tmpFileName)
+	os.Remove(tmpFilePath)
+	return
+}
+
+func getPrevPushSyncId(syncId string) (prevId string, err error) {
+	queryRows, queryErr := x.QueryString("select max(id) as id from sys_sync_record where status='ok' and id<?", syncId)
+	if queryErr != nil {
+		err = fmt.Errorf("query prev sync record fail,%s ", queryErr.Error())
 		return
 	}
-	os.Remove(tmpFilePath)
+	if len(queryRows) > 0 {
+		prevId = queryRows[0]["id"]
+	}
+	return
+}
+
+// RetrySyncPush 主环境重新推送失败的同步记录
+func RetrySyncPush(syncRecord *models.SysSyncRecordTable) (err error) {
+	syncPushLock.Lock()
+	defer syncPushLock.Unlock()
+	transport, err := GetSyncTransport()
+	if err == nil {
+		syncRecord.RemoteRepo = transport.RemoteRepo()
+		if syncRecord.PrevSourceId, err = getPrevPushSyncId(syncRecord.ID); err == nil {
+			err = uploadSyncRecord(transport, syncRecord)
+		}
+	}
+	UpdateSyncRecord(syncRecord.ID, syncRecord.RetryCount, err)
+	return
 }
 
 func SyncPushConfirmView(param *models.ViewData, confirmOutput []models.CiDataMapObj) {
@@ -277,11 +315,260 @@ func GetMatchSyncDataRecord() (syncRecord *models.SysSyncRecordTable, dataList [
 	if len(syncRecordRows) == 0 {
 		return
 	}
+	// 超过重试次数后停止自动重试,等待人工重试,避免后面的数据先于失败的数据同步
+	if syncRecordRows[0].Status == "fail" && syncRecordRows[0].RetryCount >= models.SyncMaxRetryCount {
+		log.Warn(nil, log.LOGGER_APP, "sync record retry count exceed,wait for manual retry", zap.String("syncRecord", syncRecordRows[0].ID), zap.Int("retryCount", syncRecordRows[0].RetryCount))
+		return
+	}
 	syncRecord = syncRecordRows[0]
-	err = x.SQL("select * from sys_sync_data where sync_record=? order by id", syncRecord.ID).Find(&dataList)
+	dataList, err = GetSyncDataByRecord(syncRecord.ID)
+	return
+}
+
+func GetSyncDataByRecord(syncRecordId string) (dataList []*models.SysSyncDataTable, err error) {
+	err = x.SQL("select * from sys_sync_data where sync_record=? order by id", syncRecordId).Find(&dataList)
 	if err != nil {
 		err = fmt.Errorf("query sync data row fail,%s ", err.Error())
+	}
+	return
+}
+
+func GetSyncRecordById(syncRecordId string) (syncRecord *models.SysSyncRecordTable, err error) {
+	var syncRecordRows []*models.SysSyncRecordTable
+	err = x.SQL("select * from sys_sync_record where id=?", syncRecordId).Find(&syncRecordRows)
+	if err != nil {
+		err = fmt.Errorf("query sync record row fail,%s ", err.Error())
+		return
+	}
+	if len(syncRecordRows) == 0 {
+		err = fmt.Errorf("Can not find sync record:%s ", syncRecordId)
+		return
+	}
+	syncRecord = syncRecordRows[0]
+	return
+}
+
+// GetSyncSourceIdMap 备环境已经记录的同步文件
+func GetSyncSourceIdMap() (sourceIdMap map[string]string, err error) {
+	sourceIdMap = make(map[string]string)
+	queryRows, queryErr := x.QueryString("select source_id,status from sys_sync_record")
+	if queryErr != nil {
+		err = fmt.Errorf("query sync source id fail,%s ", queryErr.Error())
+		return
+	}
+	for _, row := range queryRows {
+		sourceIdMap[row["source_id"]] = row["status"]
+	}
+	return
+}
+
+// GetSyncGapFloorSourceId 缺失检查的下限,取最近一次全量快照,没有快照时取最早的同步记录
+func GetSyncGapFloorSourceId() (sourceId string, err error) {
+	queryRows, queryErr := x.QueryString("select max(source_id) as source_id from sys_sync_record where data_category=? and action_func='Snapshot' and status='ok'", models.SyncCategorySnapshot)
+	if queryErr == nil && (len(queryRows) == 0 || queryRows[0]["source_id"] == "") {
+		queryRows, queryErr = x.QueryString("select min(source_id) as source_id from sys_sync_record")
+	}
+	if queryErr != nil {
+		err = fmt.Errorf("query sync gap floor source id fail,%s ", queryErr.Error())
+		return
+	}
+	if len(queryRows) > 0 {
+		sourceId = queryRows[0]["source_id"]
+	}
+	return
+}
+
+// UpdateSyncRecordContent 补全缺失同步文件的记录内容
+func UpdateSyncRecordContent(syncRecord *models.SysSyncRecordTable) (err error) {
+	_, err = x.Exec("update sys_sync_record set action_func=?,data_category=?,data_type=?,content=?,operator=?,update_time=? where id=?",
+		syncRecord.ActionFunc, syncRecord.DataCategory, syncRecord.DataType, syncRecord.Content, syncRecord.Operator, time.Now(), syncRecord.ID)
+	if err != nil {
+		err = fmt.Errorf("update sync record content fail,%s ", err.Error())
+	}
+	return
+}
+
+// ResetSyncDataForRetry 把同步记录中未成功的数据重置为待处理
+func ResetSyncDataForRetry(syncRecordId string) (err error) {
+	_, err = x.Exec("update sys_sync_data set status='wait',retry_count=retry_count+1,update_time=? where sync_record=? and status<>'ok'", time.Now(), syncRecordId)
+	if err != nil {
+		err = fmt.Errorf("reset sync data fail,%s ", err.Error())
+	}
+	return
+}
+
+// GetSyncSnapshotCiTypes 主环境开启同步的ci类型,全量快照按ci类型逐个导出
+func GetSyncSnapshotCiTypes() (ciTypeList []string, err error) {
+	var ciTypeRows []*models.SysCiTypeTable
+	err = x.SQL("select id from sys_ci_type where sync_enable='yes' and status='created' order by id").Find(&ciTypeRows)
+	if err != nil {
+		err = fmt.Errorf("query sync enable ci types fail,%s ", err.Error())
+		return
+	}
+	for _, row := range ciTypeRows {
+		ciTypeList = append(ciTypeList, row.Id)
+	}
+	return
+}
+
+// BuildSyncSnapshotCiType 导出单个ci类型的数据表、历史表以及多选引用表和它的历史表
+func BuildSyncSnapshotCiType(ciType string) (snapshotObj *models.SyncSnapshotCiTypeObj, err error) {
+	snapshotObj = &models.SyncSnapshotCiTypeObj{CiType: ciType, MultiRef: make(map[string][]map[string]*string), MultiRefHistory: make(map[string][]map[string]*string)}
+	if snapshotObj.Rows, err = querySnapshotRows(ciType); err != nil {
+		return
+	}
+	if snapshotObj.History, err = querySnapshotRows(HistoryTablePrefix + ciType); err != nil {
 		return
 	}
+	attrs, getAttrErr := GetCiAttrByCiType(ciType, true)
+	if getAttrErr != nil {
+		err = fmt.Errorf("Try to get ci attribute with ciType:%s error,%s ", ciType, getAttrErr.Error())
+		return
+	}
+	for _, attr := range attrs {
+		if attr.InputType != models.MultiRefType {
+			continue
+		}
+		tableName := fmt.Sprintf("%s$%s", ciType, attr.Name)
+		if snapshotObj.MultiRef[attr.Name], err = querySnapshotRows(tableName); err != nil {
+			return
+		}
+		if snapshotObj.MultiRefHistory[attr.Name], err = querySnapshotRows(HistoryTablePrefix + tableName); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func querySnapshotRows(tableName string) (result []map[string]*string, err error) {
+	return queryRawRows(tableName, fmt.Sprintf("select * from `%s`", tableName))
+}
+
+// queryRawRows 按列原样查询,null保留为nil,与空字符串区分
+func queryRawRows(tableName, querySql string, params ...interface{}) (result []map[string]*string, err error) {
+	rows, queryErr := x.DB().Query(querySql, params...)
+	if queryErr != nil {
+		err = fmt.Errorf("query table:%s data fail,%s ", tableName, queryErr.Error())
+		return
+	}
+	defer rows.Close()
+	columns, _ := rows.Columns()
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		scanArgs := make([]interface{}, len(columns))
+		for i := range values {
+			scanArgs[i] = &values[i]
+		}
+		if err = rows.Scan(scanArgs...); err != nil {
+			err = fmt.Errorf("scan table:%s data fail,%s ", tableName, err.Error())
+			return
+		}
+		rowMap := make(map[string]*string)
+		for i, column := range columns {
+			if values[i].Valid {
+				value := values[i].String
+				rowMap[column] = &value
+			} else {
+				rowMap[column] = nil
+			}
+		}
+		result = append(result, rowMap)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("query table:%s data fail,%s ", tableName, err.Error())
+	}
+	return
+}
+
+// ApplySyncSnapshot 备环境用全量快照重建ci数据和历史数据,ci类型模型需要已经同步到备环境
+// 快照按清单中的ci类型逐个加载并写入同一个事务,任一ci类型失败时整体回滚
+// 快照之后的同步记录会被清理,重建后从快照位置继续增量同步
+func ApplySyncSnapshot(syncRecord *models.SysSyncRecordTable, manifest *models.SyncSnapshotManifestObj, loadPart func(part *models.SyncSnapshotPartObj) (*models.SyncSnapshotCiTypeObj, error)) (err error) {
+	session := x.NewSession()
+	defer session.Close()
+	if err = session.Begin(); err != nil {
+		err = fmt.Errorf("apply sync snapshot fail,%s ", err.Error())
+		return
+	}
+	execFunc := func(actions []*execAction) (execErr error) {
+		for _, action := range actions {
+			if _, execErr = session.Exec(append([]interface{}{action.Sql}, action.Param...)...); execErr != nil {
+				return
+			}
+		}
+		return
+	}
+	defer func() {
+		if err != nil {
+			session.Rollback()
+			err = fmt.Errorf("apply sync snapshot fail,%s ", err.Error())
+		}
+	}()
+	var actions []*execAction
+	actions = append(actions, &execAction{Sql: "delete from sys_sync_data where sync_record in (select id from sys_sync_record where source_id>=?)", Param: []interface{}{syncRecord.SourceID}})
+	actions = append(actions, &execAction{Sql: "delete from sys_sync_record where source_id>=?", Param: []interface{}{syncRecord.SourceID}})
+	actions = append(actions, &execAction{Sql: "INSERT INTO sys_sync_record (id,sync_type,remote_repo,action_func,data_category,data_type,content,source,source_id,target,status,retry_count,error_msg,create_time,operator) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)",
+		Param: []interface{}{syncRecord.ID, syncRecord.SyncType, syncRecord.RemoteRepo, syncRecord.ActionFunc, syncRecord.DataCategory, syncRecord.DataType, "", syncRecord.Source, syncRecord.SourceID, syncRecord.Target, "ok", 0, "", syncRecord.CreateTime, syncRecord.Operator}})
+	if err = execFunc(actions); err != nil {
+		return
+	}
+	for _, part := range manifest.Parts {
+		ciTypeRows, getCiTypeErr := GetCiTypeRows([]string{part.CiType})
+		if getCiTypeErr != nil {
+			err = getCiTypeErr
+			return
+		}
+		if len(ciTypeRows) == 0 || ciTypeRows[0].Status != "created" {
+			err = fmt.Errorf("ciType:%s not created in slave,please sync model first ", part.CiType)
+			return
+		}
+		snapshotObj, loadErr := loadPart(part)
+		if loadErr != nil {
+			err = fmt.Errorf("load ciType:%s snapshot fail,%s ", part.CiType, loadErr.Error())
+			return
+		}
+		if err = execFunc(buildSnapshotTableActions(snapshotObj.CiType, snapshotObj.Rows)); err != nil {
+			return
+		}
+		if err = execFunc(buildSnapshotTableActions(HistoryTablePrefix+snapshotObj.CiType, snapshotObj.History)); err != nil {
+			return
+		}
+		for attrName, refRows := range snapshotObj.MultiRef {
+			tableName := fmt.Sprintf("%s$%s", snapshotObj.CiType, attrName)
+			if err = execFunc(buildSnapshotTableActions(tableName, refRows)); err != nil {
+				return
+			}
+			if err = execFunc(buildSnapshotTableActions(HistoryTablePrefix+tableName, snapshotObj.MultiRefHistory[attrName])); err != nil {
+				return
+			}
+		}
+	}
+	err = session.Commit()
+	return
+}
+
+// buildSnapshotTableActions 清空表后按快照原样写入
+func buildSnapshotTableActions(tableName string, rows []map[string]*string) (actions []*execAction) {
+	actions = append(actions, &execAction{Sql: fmt.Sprintf("delete from `%s`", tableName)})
+	actions = append(actions, buildRawRowInsertActions(tableName, rows)...)
+	return
+}
+
+// buildRawRowInsertActions 按原样写入,nil写为null,空字符串保持为空字符串
+func buildRawRowInsertActions(tableName string, rows []map[string]*string) (actions []*execAction) {
+	for _, row := range rows {
+		var columnList, specCharList []string
+		var params []interface{}
+		for k, v := range row {
+			columnList = append(columnList, fmt.Sprintf("`%s`", k))
+			specCharList = append(specCharList, "?")
+			if v == nil {
+				params = append(params, nil)
+			} else {
+				params = append(params, *v)
+			}
+		}
+		actions = append(actions, &execAction{Sql: fmt.Sprintf("insert into `%s`(%s) values (%s)", tableName, strings.Join(columnList, ","), strings.Join(specCharList, ",")), Param: params})
+	}
 	return
 }
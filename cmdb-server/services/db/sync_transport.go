@@ -0,0 +1,281 @@
+package db
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/tools"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+)
+
+const (
+	SyncTransportNexus = "nexus"
+	SyncTransportLocal = "local"
+	SyncTransportHttp  = "http"
+
+	SyncHeaderTimestamp = "X-Sync-Timestamp"
+	SyncHeaderSignature = "X-Sync-Signature"
+	syncSignExpireSec   = 300
+)
+
+var syncFileNameRegexp = regexp.MustCompile(`^cmdb_sync_[0-9]+\.json$`)
+
+// SyncTransport 主备同步文件的传输方式,主环境上传同步文件,备环境列出并下载同步文件
+type SyncTransport interface {
+	Upload(localFilePath, fileName string) error
+	ListFiles() (fileNameList []string, err error)
+	Download(fileNameList []string, destDir string) error
+	RemoteRepo() string
+}
+
+func GetSyncTransport() (transport SyncTransport, err error) {
+	syncConfig := models.Config.Sync
+	switch strings.ToLower(syncConfig.Transport) {
+	case "", SyncTransportNexus:
+		transport = &nexusSyncTransport{}
+	case SyncTransportLocal:
+		if syncConfig.LocalDir == "" {
+			err = fmt.Errorf("sync local_dir can not empty with transport:%s ", syncConfig.Transport)
+			return
+		}
+		transport = &localSyncTransport{Dir: syncConfig.LocalDir}
+	case SyncTransportHttp:
+		if syncConfig.SyncKey == "" {
+			err = fmt.Errorf("sync sync_key can not empty with transport:%s ", syncConfig.Transport)
+			return
+		}
+		if models.Config.Sync.MasterEnable && syncConfig.HttpAddress == "" {
+			err = fmt.Errorf("sync http_address can not empty with transport:%s ", syncConfig.Transport)
+			return
+		}
+		if models.Config.Sync.SlaveEnable && syncConfig.LocalDir == "" {
+			err = fmt.Errorf("sync local_dir can not empty with transport:%s ", syncConfig.Transport)
+			return
+		}
+		transport = &httpSyncTransport{Address: strings.TrimSuffix(syncConfig.HttpAddress, "/"), Key: syncConfig.SyncKey, localSyncTransport: localSyncTransport{Dir: syncConfig.LocalDir}}
+	default:
+		err = fmt.Errorf("sync transport:%s illegal,should be nexus/local/http ", syncConfig.Transport)
+	}
+	return
+}
+
+// SignSyncContent http方式下主环境推送文件的签名,内容为 timestamp.fileName.body
+func SignSyncContent(key, timestamp, fileName string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "." + fileName + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func ValidateSyncSignature(timestamp, fileName, signature string, body []byte) (err error) {
+	if models.Config.Sync.SyncKey == "" {
+		return fmt.Errorf("sync key is empty ")
+	}
+	unixTime, parseErr := strconv.ParseInt(timestamp, 10, 64)
+	if parseErr != nil {
+		return fmt.Errorf("sync timestamp:%s illegal ", timestamp)
+	}
+	if diff := time.Now().Unix() - unixTime; diff > syncSignExpireSec || diff < -syncSignExpireSec {
+		return fmt.Errorf("sync timestamp:%s expire ", timestamp)
+	}
+	expectSign := SignSyncContent(models.Config.Sync.SyncKey, timestamp, fileName, body)
+	if !hmac.Equal([]byte(expectSign), []byte(signature)) {
+		return fmt.Errorf("sync signature validate fail ")
+	}
+	return
+}
+
+// IsSyncFileNameLegal 同步文件名会拼到本地目录路径上,只接受主环境生成的文件名格式
+func IsSyncFileNameLegal(fileName string) bool {
+	return syncFileNameRegexp.MatchString(fileName)
+}
+
+// SaveSyncReceiveFile 备环境保存主环境通过http推送过来的同步文件
+func SaveSyncReceiveFile(fileName string, body []byte) (err error) {
+	if !syncFileNameRegexp.MatchString(fileName) {
+		return fmt.Errorf("sync file name:%s illegal ", fileName)
+	}
+	if models.Config.Sync.LocalDir == "" {
+		return fmt.Errorf("sync local_dir is empty ")
+	}
+	if err = os.MkdirAll(models.Config.Sync.LocalDir, 0755); err != nil {
+		return fmt.Errorf("make sync receive dir fail,%s ", err.Error())
+	}
+	filePath := filepath.Join(models.Config.Sync.LocalDir, fileName)
+	// 先写临时文件再改名,防止备环境读到写了一半的文件
+	tmpFilePath := filePath + ".tmp"
+	if err = os.WriteFile(tmpFilePath, body, 0644); err != nil {
+		return fmt.Errorf("write sync receive file fail,%s ", err.Error())
+	}
+	if err = os.Rename(tmpFilePath, filePath); err != nil {
+		err = fmt.Errorf("rename sync receive file fail,%s ", err.Error())
+	}
+	return
+}
+
+type nexusSyncTransport struct{}
+
+func (t *nexusSyncTransport) getReqParam() tools.NexusReqParam {
+	return tools.NexusReqParam{
+		UserName:   models.Config.Sync.NexusUser,
+		Password:   models.Config.Sync.NexusPwd,
+		RepoUrl:    models.Config.Sync.NexusAddress,
+		Repository: models.Config.Sync.NexusRepo,
+		TimeoutSec: 30,
+	}
+}
+
+func (t *nexusSyncTransport) Upload(localFilePath, fileName string) (err error) {
+	nexusParam := t.getReqParam()
+	nexusParam.FileParams = []*tools.NexusFileParam{{SourceFilePath: localFilePath, DestFilePath: models.SyncNexusDir + fileName}}
+	if _, err = tools.UploadFile(&nexusParam); err != nil {
+		err = fmt.Errorf("upload sync file:%s to nexus fail,%s ", localFilePath, err.Error())
+	}
+	return
+}
+
+func (t *nexusSyncTransport) ListFiles() (fileNameList []string, err error) {
+	nexusParam := t.getReqParam()
+	nexusParam.DirPath = models.SyncNexusDir
+	if fileNameList, err = tools.ListFilesInRepo(&nexusParam); err != nil {
+		err = fmt.Errorf("list file from nexus fail,%s ", err.Error())
+	}
+	return
+}
+
+func (t *nexusSyncTransport) Download(fileNameList []string, destDir string) (err error) {
+	nexusParam := t.getReqParam()
+	for _, fileName := range fileNameList {
+		nexusParam.FileParams = append(nexusParam.FileParams, &tools.NexusFileParam{SourceFilePath: nexusParam.RepoUrl + "/repository/" + nexusParam.Repository + models.SyncNexusDir + fileName, DestFilePath: destDir + fileName})
+	}
+	if err = tools.DownloadFile(&nexusParam); err != nil {
+		err = fmt.Errorf("download file from nexus fail,%s ", err.Error())
+	}
+	return
+}
+
+func (t *nexusSyncTransport) RemoteRepo() string {
+	return fmt.Sprintf("%s__%s", models.Config.Sync.NexusAddress, models.Config.Sync.NexusRepo)
+}
+
+// localSyncTransport 主备环境挂载同一个共享目录(如NFS)
+type localSyncTransport struct {
+	Dir string
+}
+
+func (t *localSyncTransport) Upload(localFilePath, fileName string) (err error) {
+	if err = os.MkdirAll(t.Dir, 0755); err != nil {
+		return fmt.Errorf("make sync local dir fail,%s ", err.Error())
+	}
+	destFilePath := filepath.Join(t.Dir, fileName)
+	if err = copySyncFile(localFilePath, destFilePath+".tmp"); err != nil {
+		return
+	}
+	if err = os.Rename(destFilePath+".tmp", destFilePath); err != nil {
+		err = fmt.Errorf("rename sync file:%s fail,%s ", destFilePath, err.Error())
+	}
+	return
+}
+
+func (t *localSyncTransport) ListFiles() (fileNameList []string, err error) {
+	fileInfos, readErr := ioutil.ReadDir(t.Dir)
+	if readErr != nil {
+		err = fmt.Errorf("list file from sync dir:%s fail,%s ", t.Dir, readErr.Error())
+		return
+	}
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() || !syncFileNameRegexp.MatchString(fileInfo.Name()) {
+			continue
+		}
+		fileNameList = append(fileNameList, fileInfo.Name())
+	}
+	return
+}
+
+func (t *localSyncTransport) Download(fileNameList []string, destDir string) (err error) {
+	for _, fileName := range fileNameList {
+		if err = copySyncFile(filepath.Join(t.Dir, fileName), destDir+fileName); err != nil {
+			break
+		}
+	}
+	return
+}
+
+func (t *localSyncTransport) RemoteRepo() string {
+	return fmt.Sprintf("%s__%s", SyncTransportLocal, t.Dir)
+}
+
+// httpSyncTransport 主环境把同步文件推送到备环境的接收接口,备环境从接收目录读取
+type httpSyncTransport struct {
+	localSyncTransport
+	Address string
+	Key     string
+}
+
+func (t *httpSyncTransport) Upload(localFilePath, fileName string) (err error) {
+	fileBytes, readErr := os.ReadFile(localFilePath)
+	if readErr != nil {
+		return fmt.Errorf("read sync file:%s fail,%s ", localFilePath, readErr.Error())
+	}
+	reqUrl := fmt.Sprintf("%s%s/sync/receive/%s", t.Address, models.UrlPrefix, fileName)
+	req, reqErr := http.NewRequest(http.MethodPost, reqUrl, bytes.NewReader(fileBytes))
+	if reqErr != nil {
+		return fmt.Errorf("new sync request fail,%s ", reqErr.Error())
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SyncHeaderTimestamp, timestamp)
+	req.Header.Set(SyncHeaderSignature, SignSyncContent(t.Key, timestamp, fileName, fileBytes))
+	httpClient := http.Client{Timeout: 30 * time.Second}
+	resp, respErr := httpClient.Do(req)
+	if respErr != nil {
+		return fmt.Errorf("push sync file:%s to %s fail,%s ", fileName, reqUrl, respErr.Error())
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("push sync file:%s to %s fail,status:%s body:%s ", fileName, reqUrl, resp.Status, string(respBytes))
+	}
+	var response models.ResponseErrorJson
+	if err = json.Unmarshal(respBytes, &response); err != nil {
+		return fmt.Errorf("json unmarshal sync push response fail,%s ", err.Error())
+	}
+	if response.StatusCode != "OK" {
+		err = fmt.Errorf("push sync file:%s to %s fail,%s ", fileName, reqUrl, response.StatusMessage)
+	}
+	return
+}
+
+func (t *httpSyncTransport) RemoteRepo() string {
+	return fmt.Sprintf("%s__%s", SyncTransportHttp, t.Address)
+}
+
+func copySyncFile(sourcePath, destPath string) (err error) {
+	sourceFile, openErr := os.Open(sourcePath)
+	if openErr != nil {
+		return fmt.Errorf("open sync file:%s fail,%s ", sourcePath, openErr.Error())
+	}
+	defer sourceFile.Close()
+	destFile, createErr := os.Create(destPath)
+	if createErr != nil {
+		return fmt.Errorf("create sync file:%s fail,%s ", destPath, createErr.Error())
+	}
+	defer destFile.Close()
+	if _, err = io.Copy(destFile, sourceFile); err != nil {
+		err = fmt.Errorf("copy sync file:%s to %s fail,%s ", sourcePath, destPath, err.Error())
+	}
+	return
+}
@@ -0,0 +1,497 @@
+package db
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/exterror"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"github.com/go-sql-driver/mysql"
+	"go.uber.org/zap"
+)
+
+const (
+	uniqueIndexSourceAttr = "attr"
+	uniqueIndexSourceKey  = "key"
+	// ci表是utf8,单个索引最多3072字节
+	indexMaxBytes        = 3072
+	uniqueKeyCacheExpire = 30 * time.Second
+)
+
+var (
+	duplicateEntryRegexp = regexp.MustCompile(`Duplicate entry '(.*)' for key '(.+)'`)
+	uniqueKeyNameRegexp  = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+	uniqueKeyCacheMap    = make(map[string]*uniqueKeyCacheObj)
+	uniqueKeyCacheLock   = new(sync.RWMutex)
+)
+
+type uniqueKeyCacheObj struct {
+	keyRows    []*models.SysCiTypeUniqueKeyTable
+	expireTime time.Time
+}
+
+func QueryCiTypeUniqueKey(ciTypeId string) (rowData []*models.SysCiTypeUniqueKeyTable, err error) {
+	rowData = []*models.SysCiTypeUniqueKeyTable{}
+	if err = x.SQL("select * from sys_ci_type_unique_key where ci_type=? order by create_time", ciTypeId).Find(&rowData); err != nil {
+		err = fmt.Errorf("Try to query ci type unique key fail,%s ", err.Error())
+	}
+	return
+}
+
+// QueryCiTypeUniqueIndex 返回ci类型应该存在的唯一索引以及数据库里是否已经建立
+func QueryCiTypeUniqueIndex(ciTypeId string) (indexList []*models.CiTypeUniqueIndexObj, err error) {
+	attrRows, getErr := GetCiAttrByCiType(ciTypeId, true)
+	if getErr != nil {
+		err = fmt.Errorf("Try to get ci attributes fail,%s ", getErr.Error())
+		return
+	}
+	if indexList, err = getCiTypeUniqueIndexList(ciTypeId, attrRows); err != nil {
+		return
+	}
+	existIndexMap, queryErr := getTableUniqueIndexMap(ciTypeId)
+	if queryErr != nil {
+		err = queryErr
+		return
+	}
+	for _, index := range indexList {
+		_, index.Exist = existIndexMap[index.IndexName]
+	}
+	return
+}
+
+func CreateCiTypeUniqueKey(ciTypeId string, param *models.CiTypeUniqueKeyParam, operator string) (rowData *models.SysCiTypeUniqueKeyTable, err error) {
+	if !uniqueKeyNameRegexp.MatchString(param.Name) {
+		err = fmt.Errorf("Unique key name:%s illegal,only letters,numbers and _ are allowed ", param.Name)
+		return
+	}
+	ciTypeData, getErr := GetCiTypeById(ciTypeId)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	rowData = &models.SysCiTypeUniqueKeyTable{Id: ciTypeId + models.SysTableIdConnector + param.Name, CiType: ciTypeId, Name: param.Name, Attrs: strings.Join(param.Attrs, ","),
+		Status: "notCreated", CreateUser: operator, CreateTime: time.Now().Format(models.DateTimeFormat)}
+	existRows, _ := x.QueryString("select id from sys_ci_type_unique_key where id=?", rowData.Id)
+	if len(existRows) > 0 {
+		err = fmt.Errorf("Unique key:%s already exists ", param.Name)
+		return
+	}
+	attrRows, getErr := GetCiAttrByCiType(ciTypeId, false)
+	if getErr != nil {
+		err = fmt.Errorf("Try to get ci attributes fail,%s ", getErr.Error())
+		return
+	}
+	keyAttrs, validateErr := validateIndexAttrs(attrRows, param.Attrs)
+	if validateErr != nil {
+		err = validateErr
+		return
+	}
+	if ciTypeData.Status == "created" {
+		for _, attr := range keyAttrs {
+			if attr.Status != "created" {
+				err = fmt.Errorf("Attr:%s is not created,please apply it first ", attr.Name)
+				return
+			}
+		}
+		indexName := buildCiTableIndexName("ukc", ciTypeId, param.Name)
+		if err = createTableUniqueIndex(ciTypeId, indexName, keyAttrs); err != nil {
+			return
+		}
+		rowData.Status = "created"
+	}
+	defer clearCiTypeUniqueKeyCache(ciTypeId)
+	_, err = x.Exec("insert into sys_ci_type_unique_key(id,ci_type,name,attrs,status,create_user,create_time) values (?,?,?,?,?,?,?)",
+		rowData.Id, rowData.CiType, rowData.Name, rowData.Attrs, rowData.Status, rowData.CreateUser, rowData.CreateTime)
+	if err != nil {
+		err = fmt.Errorf("Try to insert ci type unique key fail,%s ", err.Error())
+	}
+	return
+}
+
+func DeleteCiTypeUniqueKey(ciTypeId, keyId string) (err error) {
+	var keyRows []*models.SysCiTypeUniqueKeyTable
+	if err = x.SQL("select * from sys_ci_type_unique_key where id=? and ci_type=?", keyId, ciTypeId).Find(&keyRows); err != nil {
+		return fmt.Errorf("Try to query ci type unique key fail,%s ", err.Error())
+	}
+	if len(keyRows) == 0 {
+		return fmt.Errorf("Can not find unique key:%s in ciType:%s ", keyId, ciTypeId)
+	}
+	existIndexMap, queryErr := getTableUniqueIndexMap(ciTypeId)
+	if queryErr != nil {
+		return queryErr
+	}
+	indexName := buildCiTableIndexName("ukc", ciTypeId, keyRows[0].Name)
+	if _, b := existIndexMap[indexName]; b {
+		if _, err = x.Exec(fmt.Sprintf("ALTER TABLE `%s` DROP INDEX `%s`", ciTypeId, indexName)); err != nil {
+			return fmt.Errorf("Try to drop unique index %s fail,%s ", indexName, err.Error())
+		}
+	}
+	defer clearCiTypeUniqueKeyCache(ciTypeId)
+	if _, err = x.Exec("delete from sys_ci_type_unique_key where id=?", keyId); err != nil {
+		err = fmt.Errorf("Try to delete ci type unique key fail,%s ", err.Error())
+	}
+	return
+}
+
+// SyncCiTypeUniqueIndex 按属性唯一约束和组合唯一键补建或删除ci表上的唯一索引
+func SyncCiTypeUniqueIndex(ciTypeId string) (err error) {
+	attrRows, getErr := GetCiAttrByCiType(ciTypeId, true)
+	if getErr != nil {
+		return fmt.Errorf("Try to get ci attributes fail,%s ", getErr.Error())
+	}
+	indexList, buildErr := getCiTypeUniqueIndexList(ciTypeId, attrRows)
+	if buildErr != nil {
+		return buildErr
+	}
+	existIndexMap, queryErr := getTableUniqueIndexMap(ciTypeId)
+	if queryErr != nil {
+		return queryErr
+	}
+	attrMap := make(map[string]*models.SysCiTypeAttrTable)
+	for _, attr := range attrRows {
+		attrMap[attr.Name] = attr
+	}
+	expectIndexMap := make(map[string]bool)
+	for _, index := range indexList {
+		expectIndexMap[index.IndexName] = true
+		if _, b := existIndexMap[index.IndexName]; b {
+			continue
+		}
+		var indexAttrs []*models.SysCiTypeAttrTable
+		for _, attrName := range index.Attrs {
+			indexAttrs = append(indexAttrs, attrMap[attrName])
+		}
+		if err = createTableUniqueIndex(ciTypeId, index.IndexName, indexAttrs); err != nil {
+			break
+		}
+		if index.Source == uniqueIndexSourceKey {
+			x.Exec("update sys_ci_type_unique_key set status='created' where id=?", index.KeyId)
+		}
+	}
+	if err != nil {
+		return
+	}
+	for indexName := range existIndexMap {
+		if expectIndexMap[indexName] {
+			continue
+		}
+		if _, err = x.Exec(fmt.Sprintf("ALTER TABLE `%s` DROP INDEX `%s`", ciTypeId, indexName)); err != nil {
+			err = fmt.Errorf("Try to drop unique index %s fail,%s ", indexName, err.Error())
+			break
+		}
+	}
+	return
+}
+
+func getCiTypeUniqueIndexList(ciTypeId string, attrRows []*models.SysCiTypeAttrTable) (indexList []*models.CiTypeUniqueIndexObj, err error) {
+	keyRows, queryErr := QueryCiTypeUniqueKey(ciTypeId)
+	if queryErr != nil {
+		err = queryErr
+		return
+	}
+	indexList = buildCiTypeUniqueIndexList(ciTypeId, attrRows, keyRows)
+	return
+}
+
+// getCachedCiTypeUniqueKey 数据操作时使用的组合唯一键,本实例变更唯一键时清理缓存,其它实例的变更在缓存过期后生效
+func getCachedCiTypeUniqueKey(ciTypeId string) (keyRows []*models.SysCiTypeUniqueKeyTable, err error) {
+	uniqueKeyCacheLock.RLock()
+	cacheObj, b := uniqueKeyCacheMap[ciTypeId]
+	uniqueKeyCacheLock.RUnlock()
+	if b && time.Now().Before(cacheObj.expireTime) {
+		return cacheObj.keyRows, nil
+	}
+	if keyRows, err = QueryCiTypeUniqueKey(ciTypeId); err != nil {
+		return
+	}
+	uniqueKeyCacheLock.Lock()
+	uniqueKeyCacheMap[ciTypeId] = &uniqueKeyCacheObj{keyRows: keyRows, expireTime: time.Now().Add(uniqueKeyCacheExpire)}
+	uniqueKeyCacheLock.Unlock()
+	return
+}
+
+func clearCiTypeUniqueKeyCache(ciTypeId string) {
+	uniqueKeyCacheLock.Lock()
+	delete(uniqueKeyCacheMap, ciTypeId)
+	uniqueKeyCacheLock.Unlock()
+}
+
+// buildCiTypeUniqueIndexList 唯一约束属性需要开启unique_index_enable才建索引,组合唯一键一直建索引
+func buildCiTypeUniqueIndexList(ciTypeId string, attrRows []*models.SysCiTypeAttrTable, keyRows []*models.SysCiTypeUniqueKeyTable) (indexList []*models.CiTypeUniqueIndexObj) {
+	indexList = []*models.CiTypeUniqueIndexObj{}
+	attrMap := make(map[string]*models.SysCiTypeAttrTable)
+	for _, attr := range attrRows {
+		attrMap[attr.Name] = attr
+		if !models.Config.Database.UniqueIndexEnable || attr.UniqueConstraint != "yes" || attr.Name == "guid" {
+			continue
+		}
+		if attr.InputType == models.MultiRefType || attr.DataType == "text" {
+			continue
+		}
+		indexList = append(indexList, &models.CiTypeUniqueIndexObj{IndexName: buildCiTableIndexName("uk", ciTypeId, attr.Name), Source: uniqueIndexSourceAttr, Attrs: []string{attr.Name}})
+	}
+	for _, key := range keyRows {
+		keyAttrList := strings.Split(key.Attrs, ",")
+		// 组合键中的属性被删除时不再建索引
+		attrLegal := true
+		for _, attrName := range keyAttrList {
+			if _, b := attrMap[attrName]; !b {
+				attrLegal = false
+				break
+			}
+		}
+		if !attrLegal {
+			continue
+		}
+		indexList = append(indexList, &models.CiTypeUniqueIndexObj{IndexName: buildCiTableIndexName("ukc", ciTypeId, key.Name), Source: uniqueIndexSourceKey, KeyId: key.Id, Attrs: keyAttrList})
+	}
+	return
+}
+
+func validateIndexAttrs(attrRows []*models.SysCiTypeAttrTable, attrNameList []string) (keyAttrs []*models.SysCiTypeAttrTable, err error) {
+	if len(attrNameList) == 0 {
+		err = fmt.Errorf("Index attrs can not empty ")
+		return
+	}
+	attrMap := make(map[string]*models.SysCiTypeAttrTable)
+	for _, attr := range attrRows {
+		if attr.Status != "deleted" {
+			attrMap[attr.Name] = attr
+		}
+	}
+	indexBytes := 0
+	nameMap := make(map[string]bool)
+	for _, attrName := range attrNameList {
+		attr, b := attrMap[attrName]
+		if !b {
+			err = fmt.Errorf("Can not find attr:%s ", attrName)
+			return
+		}
+		if nameMap[attrName] {
+			err = fmt.Errorf("Attr:%s duplicate in index ", attrName)
+			return
+		}
+		nameMap[attrName] = true
+		if attr.InputType == models.MultiRefType || attr.DataType == "text" {
+			err = fmt.Errorf("Attr:%s with input type %s data type %s can not use in index ", attrName, attr.InputType, attr.DataType)
+			return
+		}
+		if attr.DataType == "varchar" {
+			indexBytes += attr.DataLength * 3
+		} else {
+			indexBytes += 8
+		}
+		keyAttrs = append(keyAttrs, attr)
+	}
+	if indexBytes > indexMaxBytes {
+		err = fmt.Errorf("Index attrs total length too long,max %d bytes ", indexMaxBytes)
+	}
+	return
+}
+
+// createTableUniqueIndex 建索引前先把可空列的空字符串置为NULL,再检查已有数据是否重复
+func createTableUniqueIndex(ciTypeId, indexName string, attrs []*models.SysCiTypeAttrTable) (err error) {
+	var columnList []string
+	for _, attr := range attrs {
+		if attr == nil {
+			return fmt.Errorf("Unique index %s attr illegal ", indexName)
+		}
+		columnList = append(columnList, attr.Name)
+	}
+	if err = checkUniqueIndexDuplicate(ciTypeId, attrs); err != nil {
+		return
+	}
+	if err = resetUniqueIndexEmptyValue(ciTypeId, attrs); err != nil {
+		return
+	}
+	if _, err = x.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD UNIQUE INDEX `%s` (`%s`)", ciTypeId, indexName, strings.Join(columnList, "`,`"))); err != nil {
+		err = fmt.Errorf("Try to create unique index %s fail,%s ", indexName, err.Error())
+	} else {
+		log.Info(nil, log.LOGGER_APP, "Create unique index success", zap.String("ciType", ciTypeId), zap.String("index", indexName))
+	}
+	return
+}
+
+// resetUniqueIndexEmptyValue 可空列的空字符串置为NULL,并为改动的数据写历史记录
+func resetUniqueIndexEmptyValue(ciTypeId string, attrs []*models.SysCiTypeAttrTable) (err error) {
+	var nullableList, filterList []string
+	for _, attr := range attrs {
+		if attr.Nullable != "no" {
+			nullableList = append(nullableList, attr.Name)
+			filterList = append(filterList, fmt.Sprintf("`%s`=''", attr.Name))
+		}
+	}
+	if len(nullableList) == 0 {
+		return
+	}
+	rowList, queryErr := queryRawRows(ciTypeId, fmt.Sprintf("SELECT * FROM `%s` WHERE %s", ciTypeId, strings.Join(filterList, " or ")))
+	if queryErr != nil {
+		return queryErr
+	}
+	if len(rowList) == 0 {
+		return
+	}
+	var actions []*execAction
+	for _, attrName := range nullableList {
+		actions = append(actions, &execAction{Sql: fmt.Sprintf("UPDATE `%s` SET `%s`=NULL WHERE `%s`=''", ciTypeId, attrName, attrName)})
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	for _, row := range rowList {
+		historyData := models.CiDataMapObj{}
+		for k, v := range row {
+			if v == nil {
+				historyData[k] = "reset_null^"
+			} else {
+				historyData[k] = *v
+			}
+		}
+		for _, attrName := range nullableList {
+			if historyData[attrName] == "" {
+				historyData[attrName] = "reset_null^"
+			}
+		}
+		actions = append(actions, getHistoryActionByData(historyData, ciTypeId, nowTime, &models.SysStateTransitionQuery{Action: "update", TargetIsConfirm: "no"}))
+	}
+	if err = transaction(actions); err != nil {
+		err = fmt.Errorf("Try to reset empty value of %s to null fail,%s ", strings.Join(nullableList, ","), err.Error())
+	}
+	return
+}
+
+// checkUniqueIndexDuplicate 可空列的空值建索引时会置为NULL,不参与重复检查
+func checkUniqueIndexDuplicate(ciTypeId string, attrs []*models.SysCiTypeAttrTable) error {
+	var columnList, filterList []string
+	for _, attr := range attrs {
+		columnList = append(columnList, attr.Name)
+		if attr.Nullable != "no" {
+			filterList = append(filterList, fmt.Sprintf("`%s` is not null and `%s`!=''", attr.Name, attr.Name))
+		} else {
+			filterList = append(filterList, fmt.Sprintf("`%s` is not null", attr.Name))
+		}
+	}
+	columnSql := "`" + strings.Join(columnList, "`,`") + "`"
+	queryRows, err := x.QueryString(fmt.Sprintf("SELECT %s,count(1) as num FROM `%s` WHERE %s GROUP BY %s HAVING count(1)>1 LIMIT 10", columnSql, ciTypeId, strings.Join(filterList, " and "), columnSql))
+	if err != nil {
+		return fmt.Errorf("Try to check unique column duplicate data fail,%s ", err.Error())
+	}
+	if len(queryRows) == 0 {
+		return nil
+	}
+	var duplicateList []string
+	for _, row := range queryRows {
+		var valueList []string
+		for _, column := range columnList {
+			valueList = append(valueList, row[column])
+		}
+		duplicateList = append(duplicateList, fmt.Sprintf("%s(%s)", strings.Join(valueList, "-"), row["num"]))
+	}
+	return fmt.Errorf("CiType:%s column:%s have duplicate data:%s ", ciTypeId, strings.Join(columnList, ","), strings.Join(duplicateList, ","))
+}
+
+func getTableUniqueIndexMap(ciTypeId string) (indexMap map[string][]string, err error) {
+	indexMap = make(map[string][]string)
+	queryRows, queryErr := x.QueryString("SELECT INDEX_NAME,COLUMN_NAME FROM information_schema.STATISTICS WHERE TABLE_SCHEMA=? AND TABLE_NAME=? AND NON_UNIQUE=0 AND INDEX_NAME like 'uk%' ORDER BY INDEX_NAME,SEQ_IN_INDEX",
+		models.Config.Database.DataBase, ciTypeId)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query table:%s unique index fail,%s ", ciTypeId, queryErr.Error())
+		return
+	}
+	for _, row := range queryRows {
+		if !strings.HasPrefix(row["INDEX_NAME"], "uk_") && !strings.HasPrefix(row["INDEX_NAME"], "ukc_") {
+			continue
+		}
+		indexMap[row["INDEX_NAME"]] = append(indexMap[row["INDEX_NAME"]], row["COLUMN_NAME"])
+	}
+	return
+}
+
+// getUniqueIndexColumnMap 返回建了唯一索引的可空列,这些列的空值要存成NULL,否则空字符串之间会冲突
+func getUniqueIndexColumnMap(ciTypeId string, attrRows []*models.SysCiTypeAttrTable) map[string]bool {
+	columnMap := make(map[string]bool)
+	keyRows, err := getCachedCiTypeUniqueKey(ciTypeId)
+	if err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to get unique index column fail", zap.String("ciType", ciTypeId), zap.Error(err))
+		return columnMap
+	}
+	indexList := buildCiTypeUniqueIndexList(ciTypeId, attrRows, keyRows)
+	nullableMap := make(map[string]bool)
+	for _, attr := range attrRows {
+		nullableMap[attr.Name] = attr.Nullable != "no"
+	}
+	for _, index := range indexList {
+		for _, attrName := range index.Attrs {
+			if nullableMap[attrName] {
+				columnMap[attrName] = true
+			}
+		}
+	}
+	return columnMap
+}
+
+func checkAttrInUniqueIndex(ciTypeId, attrName string) (indexName string, err error) {
+	existIndexMap, queryErr := getTableUniqueIndexMap(ciTypeId)
+	if queryErr != nil {
+		err = queryErr
+		return
+	}
+	for name, columnList := range existIndexMap {
+		for _, column := range columnList {
+			if column == attrName {
+				indexName = name
+				return
+			}
+		}
+	}
+	return
+}
+
+func buildCiTableIndexName(prefix, ciTypeId, name string) string {
+	indexName := fmt.Sprintf("%s_%s_%s", prefix, ciTypeId, name)
+	// mysql索引名最长64
+	if len(indexName) > 64 {
+		indexName = fmt.Sprintf("%s_%x", prefix, md5.Sum([]byte(ciTypeId+name)))
+	}
+	return indexName
+}
+
+// transUniqueIndexError 把数据库唯一索引冲突的报错转成可读的业务错误
+func transUniqueIndexError(ciTypeList []string, err error) error {
+	var mysqlErr *mysql.MySQLError
+	if err == nil || !errors.As(err, &mysqlErr) || mysqlErr.Number != 1062 {
+		return err
+	}
+	matchList := duplicateEntryRegexp.FindStringSubmatch(mysqlErr.Message)
+	if len(matchList) != 3 {
+		return err
+	}
+	value, indexName := matchList[1], matchList[2]
+	// mysql8的报错里索引名会带上表名
+	if strings.Contains(indexName, ".") {
+		indexName = indexName[strings.LastIndex(indexName, ".")+1:]
+	}
+	ciTypeId, columnList := "", []string{}
+	for _, ciType := range ciTypeList {
+		indexMap, queryErr := getTableUniqueIndexMap(ciType)
+		if queryErr != nil {
+			continue
+		}
+		if tmpColumnList, b := indexMap[indexName]; b {
+			ciTypeId, columnList = ciType, tmpColumnList
+			break
+		}
+	}
+	if ciTypeId == "" {
+		return err
+	}
+	customErr := exterror.New().UniqueConstraintConflictError
+	if customErr.Code == 0 {
+		return fmt.Errorf("Unique constraint conflict,ciType:%s attribute:%s value:%s already exists ", ciTypeId, strings.Join(columnList, ","), value)
+	}
+	return exterror.Catch(customErr.WithParam(ciTypeId, strings.Join(columnList, ","), value), err)
+}
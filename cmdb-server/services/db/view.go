@@ -173,6 +173,36 @@ func GetRootCiDataWithReportId(reportId string, keyName string) (ciDataGuidList
 
 func ViewConfirmAction(param models.ViewData, userToken, operator string, userRoles []string) (result []models.CiDataMapObj, err error) {
 	result = []models.CiDataMapObj{}
+	viewData, editableGuidList, err := getViewEditableGuidList(param)
+	if err != nil {
+		return
+	}
+	log.Info(nil, log.LOGGER_APP, "Confirm view", zap.Int("guidLength", len(editableGuidList)), zap.Strings("guid", editableGuidList))
+	if len(editableGuidList) == 0 {
+		return
+	}
+	// confirm data
+	var confirmParam []models.CiDataMapObj
+	for _, v := range editableGuidList {
+		tmpMap := make(map[string]string)
+		tmpMap["guid"] = v
+		confirmParam = append(confirmParam, tmpMap)
+	}
+	permission := true
+	if operator == "SYSTEM" {
+		permission = false
+	}
+	handleParam := models.HandleCiDataParam{InputData: confirmParam, CiTypeId: viewData.CiType, Operation: "Confirm", Operator: operator, Roles: userRoles, Permission: permission}
+	handleParam.UserToken = userToken
+	result, _, err = HandleCiDataOperation(handleParam)
+	if err != nil {
+		err = fmt.Errorf("Handle ci data confirm fail,%s ", err.Error())
+	}
+	return
+}
+
+// 获取视图确认窗口内可编辑(待确认)的数据guid列表
+func getViewEditableGuidList(param models.ViewData) (viewData *models.ViewQuery, editableGuidList []string, err error) {
 	rootGuidList := strings.Split(param.RootCi, ",")
 	viewData, queryViewErr := QueryViewById(param.ViewId)
 	if queryViewErr != nil {
@@ -186,7 +216,7 @@ func ViewConfirmAction(param models.ViewData, userToken, operator string, userRo
 		err = fmt.Errorf("Query root report fail,%s ", err.Error())
 		return
 	}
-	editableGuidList := []string{}
+	editableGuidList = []string{}
 	existMap := make(map[string]int)
 	for _, roNode := range rootReportObjectsData {
 		rootReportAttr, _, tmpErr := GetReportAttr(roNode.Id)
@@ -206,29 +236,5 @@ func ViewConfirmAction(param models.ViewData, userToken, operator string, userRo
 			}
 		}
 	}
-	if err != nil {
-		return
-	}
-	log.Info(nil, log.LOGGER_APP, "Confirm view", zap.Int("guidLength", len(editableGuidList)), zap.Strings("guid", editableGuidList))
-	if len(editableGuidList) == 0 {
-		return
-	}
-	// confirm data
-	var confirmParam []models.CiDataMapObj
-	for _, v := range editableGuidList {
-		tmpMap := make(map[string]string)
-		tmpMap["guid"] = v
-		confirmParam = append(confirmParam, tmpMap)
-	}
-	permission := true
-	if operator == "SYSTEM" {
-		permission = false
-	}
-	handleParam := models.HandleCiDataParam{InputData: confirmParam, CiTypeId: viewData.CiType, Operation: "Confirm", Operator: operator, Roles: userRoles, Permission: permission}
-	handleParam.UserToken = userToken
-	result, _, err = HandleCiDataOperation(handleParam)
-	if err != nil {
-		err = fmt.Errorf("Handle ci data confirm fail,%s ", err.Error())
-	}
 	return
 }
@@ -0,0 +1,588 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+)
+
+// graphElementNullColumns 图元素中的外键列,为空时需要写入null
+var graphElementNullColumns = map[string]string{"parent_element": "", "edit_ref_attr": ""}
+
+// graphElementChecker 按视图所属报表校验图元素的报表对象、显示表达式与编辑引用属性
+type graphElementChecker struct {
+	report          string
+	reportObjectMap map[string]*models.SysReportObjectTable
+	nameMap         map[string]map[string]bool
+}
+
+func GetViewDetail(viewId string) (result models.UpdateViewParam, err error) {
+	result = models.UpdateViewParam{Id: viewId, MGMT: []string{}, USE: []string{}}
+	var viewTable []*models.SysViewTable
+	err = x.SQL("select * from sys_view where id=?", viewId).Find(&viewTable)
+	if err != nil {
+		err = fmt.Errorf("Try to query view fail,%s ", err.Error())
+		return
+	}
+	if len(viewTable) == 0 {
+		err = fmt.Errorf("Can not find view with id:%s ", viewId)
+		return
+	}
+	result.Name = viewTable[0].Name
+	result.Report = viewTable[0].Report
+	result.Editable = viewTable[0].Editable
+	result.SuportVersion = viewTable[0].SuportVersion
+	result.Multiple = viewTable[0].Multiple
+	result.FilterAttr = viewTable[0].FilterAttr
+	result.FilterValue = viewTable[0].FilterValue
+	var roleViewTable []*models.SysRoleViewTable
+	err = x.SQL("select * from sys_role_view where view=?", viewId).Find(&roleViewTable)
+	if err != nil {
+		err = fmt.Errorf("Try to query view role fail,%s ", err.Error())
+		return
+	}
+	for _, v := range roleViewTable {
+		if v.Permission == "USE" {
+			result.USE = append(result.USE, v.Role)
+		}
+		if v.Permission == "MGMT" {
+			result.MGMT = append(result.MGMT, v.Role)
+		}
+	}
+	return
+}
+
+func CreateView(param *models.UpdateViewParam, user string) (err error) {
+	var existViews []*models.SysViewTable
+	if err = x.SQL("select id from sys_view where id=?", param.Id).Find(&existViews); err != nil {
+		err = fmt.Errorf("Try to query view fail,%s ", err.Error())
+		return
+	}
+	if len(existViews) > 0 {
+		err = fmt.Errorf("View:%s already exist ", param.Id)
+		return
+	}
+	if err = checkViewParam(param); err != nil {
+		return
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	actions := []*execAction{{Sql: "insert into sys_view(id,name,report,editable,suport_version,multiple,create_time,create_user,filter_attr,filter_value) values (?,?,?,?,?,?,?,?,?,?)",
+		Param: []interface{}{param.Id, param.Name, param.Report, param.Editable, param.SuportVersion, param.Multiple, nowTime, user, NewNullString(param.FilterAttr), param.FilterValue}}}
+	actions = append(actions, getRoleViewActions(param.Id, param.USE, param.MGMT)...)
+	err = transaction(actions)
+	if err != nil {
+		err = fmt.Errorf("Try to create view fail,%s ", err.Error())
+	}
+	return
+}
+
+func UpdateView(param *models.UpdateViewParam, user string) (err error) {
+	var viewTable []*models.SysViewTable
+	if err = x.SQL("select id,report from sys_view where id=?", param.Id).Find(&viewTable); err != nil {
+		err = fmt.Errorf("Try to query view fail,%s ", err.Error())
+		return
+	}
+	if len(viewTable) == 0 {
+		err = fmt.Errorf("Can not find view with id:%s ", param.Id)
+		return
+	}
+	if viewTable[0].Report != param.Report {
+		// 图元素都指向原报表的报表对象,有图时不允许更换报表
+		graphRows, queryErr := x.QueryString("select id from sys_graph where view=?", param.Id)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to query view graph fail,%s ", queryErr.Error())
+			return
+		}
+		if len(graphRows) > 0 {
+			err = fmt.Errorf("View:%s already has graphs,can not change report ", param.Id)
+			return
+		}
+	}
+	if err = checkViewParam(param); err != nil {
+		return
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	actions := []*execAction{{Sql: "update sys_view set name=?,report=?,editable=?,suport_version=?,multiple=?,update_time=?,update_user=?,filter_attr=?,filter_value=? where id=?",
+		Param: []interface{}{param.Name, param.Report, param.Editable, param.SuportVersion, param.Multiple, nowTime, user, NewNullString(param.FilterAttr), param.FilterValue, param.Id}}}
+	actions = append(actions, &execAction{Sql: "delete from sys_role_view where view=?", Param: []interface{}{param.Id}})
+	actions = append(actions, getRoleViewActions(param.Id, param.USE, param.MGMT)...)
+	err = transaction(actions)
+	if err != nil {
+		err = fmt.Errorf("Try to update view fail,%s ", err.Error())
+	}
+	return
+}
+
+func DeleteView(viewId string) (err error) {
+	graphRows, queryErr := x.QueryString("select id from sys_graph where view=?", viewId)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query view graph fail,%s ", queryErr.Error())
+		return
+	}
+	actions := []*execAction{}
+	for _, row := range graphRows {
+		actions = append(actions, getGraphElementClearActions(row["id"])...)
+	}
+	actions = append(actions, &execAction{Sql: "delete from sys_graph where view=?", Param: []interface{}{viewId}})
+	actions = append(actions, &execAction{Sql: "delete from sys_role_view where view=?", Param: []interface{}{viewId}})
+	actions = append(actions, &execAction{Sql: "delete from sys_view where id=?", Param: []interface{}{viewId}})
+	err = transaction(actions)
+	if err != nil {
+		err = fmt.Errorf("Try to delete view fail,%s ", err.Error())
+	}
+	return
+}
+
+// CopyView 复制视图,图与图元素生成新的id,父子关系按新id重新关联
+func CopyView(param *models.CopyViewParam, user string) (err error) {
+	sourceView, err := GetViewDetail(param.Id)
+	if err != nil {
+		return
+	}
+	newView := sourceView
+	newView.Id, newView.Name = param.NewId, param.NewName
+	var existViews []*models.SysViewTable
+	if err = x.SQL("select id from sys_view where id=?", newView.Id).Find(&existViews); err != nil {
+		err = fmt.Errorf("Try to query view fail,%s ", err.Error())
+		return
+	}
+	if len(existViews) > 0 {
+		err = fmt.Errorf("View:%s already exist ", newView.Id)
+		return
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	actions := []*execAction{{Sql: "insert into sys_view(id,name,report,editable,suport_version,multiple,create_time,create_user,filter_attr,filter_value) values (?,?,?,?,?,?,?,?,?,?)",
+		Param: []interface{}{newView.Id, newView.Name, newView.Report, newView.Editable, newView.SuportVersion, newView.Multiple, nowTime, user, NewNullString(newView.FilterAttr), newView.FilterValue}}}
+	actions = append(actions, getRoleViewActions(newView.Id, newView.USE, newView.MGMT)...)
+	var graphList []*models.SysGraphTable
+	if err = x.SQL("select * from sys_graph where view=?", param.Id).Find(&graphList); err != nil {
+		err = fmt.Errorf("Try to query view graph fail,%s ", err.Error())
+		return
+	}
+	for _, graph := range graphList {
+		var elementList []*models.SysGraphElementTable
+		if err = x.SQL("select * from sys_graph_element where graph=? order by seq_no", graph.Id).Find(&elementList); err != nil {
+			err = fmt.Errorf("Try to query graph element fail,%s ", err.Error())
+			return
+		}
+		newGraph := *graph
+		newGraph.Id = "graph_" + guid.CreateGuid()
+		newGraph.View = newView.Id
+		graphAction, _ := GetInsertTableExecAction("sys_graph", newGraph, nil)
+		actions = append(actions, graphAction)
+		// 按层级从根节点开始复制,保证父元素先于子元素插入
+		idMap := make(map[string]string)
+		parentList := []string{""}
+		for len(parentList) > 0 {
+			var nextParentList []string
+			for _, parentId := range parentList {
+				for _, element := range elementList {
+					if element.ParentElement != parentId {
+						continue
+					}
+					newElement := *element
+					newElement.Id = "ge_" + guid.CreateGuid()
+					newElement.Graph = newGraph.Id
+					newElement.ParentElement = idMap[parentId]
+					idMap[element.Id] = newElement.Id
+					elementAction, _ := GetInsertTableExecAction("sys_graph_element", newElement, graphElementNullColumns)
+					actions = append(actions, elementAction)
+					nextParentList = append(nextParentList, element.Id)
+				}
+			}
+			parentList = nextParentList
+		}
+	}
+	err = transaction(actions)
+	if err != nil {
+		err = fmt.Errorf("Try to copy view fail,%s ", err.Error())
+	}
+	return
+}
+
+func QueryViewGraph(param *models.QueryRequestParam) (pageInfo models.PageInfo, rowData []*models.SysGraphTable, err error) {
+	rowData = []*models.SysGraphTable{}
+	filterSql, queryColumn, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysGraphTable{}, PrimaryKey: "id"})
+	if err != nil {
+		return
+	}
+	baseSql := fmt.Sprintf("SELECT %s FROM sys_graph WHERE 1=1 %s ", queryColumn, filterSql)
+	if param.Paging {
+		pageInfo.StartIndex = param.Pageable.StartIndex
+		pageInfo.PageSize = param.Pageable.PageSize
+		pageInfo.TotalRows = queryCount(baseSql, queryParam...)
+		pageSql, pageParam := transPageInfoToSQL(*param.Pageable)
+		baseSql += pageSql
+		queryParam = append(queryParam, pageParam...)
+	}
+	err = x.SQL(baseSql, queryParam...).Find(&rowData)
+	return
+}
+
+func QueryViewGraphElement(param *models.QueryRequestParam) (pageInfo models.PageInfo, rowData []*models.SysGraphElementTable, err error) {
+	rowData = []*models.SysGraphElementTable{}
+	filterSql, queryColumn, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysGraphElementTable{}, PrimaryKey: "id"})
+	if err != nil {
+		return
+	}
+	baseSql := fmt.Sprintf("SELECT %s FROM sys_graph_element WHERE 1=1 %s ", queryColumn, filterSql)
+	if param.Paging {
+		pageInfo.StartIndex = param.Pageable.StartIndex
+		pageInfo.PageSize = param.Pageable.PageSize
+		pageInfo.TotalRows = queryCount(baseSql, queryParam...)
+		pageSql, pageParam := transPageInfoToSQL(*param.Pageable)
+		baseSql += pageSql
+		queryParam = append(queryParam, pageParam...)
+	}
+	err = x.SQL(baseSql, queryParam...).Find(&rowData)
+	return
+}
+
+func CreateGraph(param *models.ModifyGraphParam) (graphId string, err error) {
+	if param.Id == "" {
+		param.Id = "graph_" + guid.CreateGuid()
+	} else {
+		if _, queryErr := GetGraphById(param.Id); queryErr == nil {
+			err = fmt.Errorf("Graph:%s already exist ", param.Id)
+			return
+		}
+	}
+	checker, err := newGraphElementChecker(param.View)
+	if err != nil {
+		return
+	}
+	graphAction, _ := GetInsertTableExecAction("sys_graph", buildGraphTable(param), nil)
+	actions := []*execAction{graphAction}
+	if param.RootData != nil {
+		elementActions, buildErr := checker.buildElementTreeActions(param.Id, "", 0, param.RootData)
+		if buildErr != nil {
+			err = buildErr
+			return
+		}
+		actions = append(actions, elementActions...)
+	}
+	err = transaction(actions)
+	if err != nil {
+		err = fmt.Errorf("Try to create graph fail,%s ", err.Error())
+		return
+	}
+	graphId = param.Id
+	return
+}
+
+func UpdateGraph(param *models.ModifyGraphParam) (err error) {
+	if param.Id == "" {
+		err = fmt.Errorf("Param graphId can not empty ")
+		return
+	}
+	graph, err := GetGraphById(param.Id)
+	if err != nil {
+		return
+	}
+	if graph.View != param.View {
+		err = fmt.Errorf("Graph:%s is not belong to view:%s ", param.Id, param.View)
+		return
+	}
+	checker, err := newGraphElementChecker(param.View)
+	if err != nil {
+		return
+	}
+	graphAction, _ := GetUpdateTableExecAction("sys_graph", "id", param.Id, buildGraphTable(param), nil)
+	actions := []*execAction{graphAction}
+	if param.RootData != nil {
+		elementActions, buildErr := checker.buildElementTreeActions(param.Id, "", 0, param.RootData)
+		if buildErr != nil {
+			err = buildErr
+			return
+		}
+		actions = append(actions, getGraphElementClearActions(param.Id)...)
+		actions = append(actions, elementActions...)
+	}
+	err = transaction(actions)
+	if err != nil {
+		err = fmt.Errorf("Try to update graph fail,%s ", err.Error())
+	}
+	return
+}
+
+func DeleteGraph(graphId string) (err error) {
+	actions := getGraphElementClearActions(graphId)
+	actions = append(actions, &execAction{Sql: "delete from sys_graph where id=?", Param: []interface{}{graphId}})
+	err = transaction(actions)
+	if err != nil {
+		err = fmt.Errorf("Try to delete graph fail,%s ", err.Error())
+	}
+	return
+}
+
+func CreateGraphElement(param *models.SysGraphElementTable) (err error) {
+	graph, err := GetGraphById(param.Graph)
+	if err != nil {
+		return
+	}
+	checker, err := newGraphElementChecker(graph.View)
+	if err != nil {
+		return
+	}
+	if param.ParentElement != "" {
+		parentRows, queryErr := x.QueryString("select id from sys_graph_element where id=? and graph=?", param.ParentElement, param.Graph)
+		if queryErr != nil {
+			err = fmt.Errorf("Try to query graph element fail,%s ", queryErr.Error())
+			return
+		}
+		if len(parentRows) == 0 {
+			err = fmt.Errorf("Parent element:%s is not belong to graph:%s ", param.ParentElement, param.Graph)
+			return
+		}
+	}
+	if param.Id == "" {
+		param.Id = "ge_" + guid.CreateGuid()
+	}
+	if param.SeqNo == "" {
+		param.SeqNo = "0"
+	}
+	if err = checker.checkElement(param); err != nil {
+		return
+	}
+	action, _ := GetInsertTableExecAction("sys_graph_element", *param, graphElementNullColumns)
+	err = transaction([]*execAction{action})
+	if err != nil {
+		err = fmt.Errorf("Try to create graph element fail,%s ", err.Error())
+	}
+	return
+}
+
+// UpdateGraphElement 修改图元素配置,元素所属的图与父元素保持不变
+func UpdateGraphElement(param *models.SysGraphElementTable) (err error) {
+	var elementList []*models.SysGraphElementTable
+	if err = x.SQL("select * from sys_graph_element where id=?", param.Id).Find(&elementList); err != nil {
+		err = fmt.Errorf("Try to query graph element fail,%s ", err.Error())
+		return
+	}
+	if len(elementList) == 0 {
+		err = fmt.Errorf("Can not find graph element with id:%s ", param.Id)
+		return
+	}
+	param.Graph, param.ParentElement = elementList[0].Graph, elementList[0].ParentElement
+	if param.SeqNo == "" {
+		param.SeqNo = elementList[0].SeqNo
+	}
+	graph, err := GetGraphById(param.Graph)
+	if err != nil {
+		return
+	}
+	checker, err := newGraphElementChecker(graph.View)
+	if err != nil {
+		return
+	}
+	if err = checker.checkElement(param); err != nil {
+		return
+	}
+	action, _ := GetUpdateTableExecAction("sys_graph_element", "id", param.Id, *param, graphElementNullColumns)
+	err = transaction([]*execAction{action})
+	if err != nil {
+		err = fmt.Errorf("Try to update graph element fail,%s ", err.Error())
+	}
+	return
+}
+
+// DeleteGraphElement 删除图元素及其下所有子元素
+func DeleteGraphElement(elementId string) (err error) {
+	actions := []*execAction{}
+	parentList := []string{elementId}
+	for len(parentList) > 0 {
+		var nextParentList []string
+		for _, parentId := range parentList {
+			actions = append(actions, &execAction{Sql: "delete from sys_graph_element where id=?", Param: []interface{}{parentId}})
+			childRows, queryErr := x.QueryString("select id from sys_graph_element where parent_element=?", parentId)
+			if queryErr != nil {
+				err = fmt.Errorf("Try to query child graph element fail,%s ", queryErr.Error())
+				return
+			}
+			for _, row := range childRows {
+				nextParentList = append(nextParentList, row["id"])
+			}
+		}
+		parentList = nextParentList
+	}
+	// 子元素先删,避免外键约束
+	for i, j := 0, len(actions)-1; i < j; i, j = i+1, j-1 {
+		actions[i], actions[j] = actions[j], actions[i]
+	}
+	err = transaction(actions)
+	if err != nil {
+		err = fmt.Errorf("Try to delete graph element fail,%s ", err.Error())
+	}
+	return
+}
+
+func checkViewParam(param *models.UpdateViewParam) (err error) {
+	var reportList []*models.SysReportTable
+	if err = x.SQL("select id,ci_type from sys_report where id=?", param.Report).Find(&reportList); err != nil {
+		err = fmt.Errorf("Try to query report fail,%s ", err.Error())
+		return
+	}
+	if len(reportList) == 0 {
+		err = fmt.Errorf("Can not find report with id:%s ", param.Report)
+		return
+	}
+	if param.FilterAttr != "" {
+		// 过滤属性可以传属性名,按报表的ci类型补全成属性id
+		if !strings.Contains(param.FilterAttr, "__") {
+			param.FilterAttr = reportList[0].CiType + "__" + param.FilterAttr
+		}
+		attr, getErr := GetCiAttrById(param.FilterAttr)
+		if getErr != nil {
+			err = getErr
+			return
+		}
+		if attr.CiType != reportList[0].CiType {
+			err = fmt.Errorf("Filter attr:%s is not belong to report ciType:%s ", param.FilterAttr, reportList[0].CiType)
+			return
+		}
+	}
+	return
+}
+
+func getRoleViewActions(viewId string, useRoles, mgmtRoles []string) (actions []*execAction) {
+	execSqlCmd := "insert into sys_role_view(id,role,view,permission) values (?,?,?,?)"
+	for _, role := range useRoles {
+		actions = append(actions, &execAction{Sql: execSqlCmd, Param: []interface{}{role + "__" + viewId + "__" + "USE", role, viewId, "USE"}})
+	}
+	for _, role := range mgmtRoles {
+		actions = append(actions, &execAction{Sql: execSqlCmd, Param: []interface{}{role + "__" + viewId + "__" + "MGMT", role, viewId, "MGMT"}})
+	}
+	return
+}
+
+// getGraphElementClearActions 先断开父子关系再删除,不需要按层级逆序删除
+func getGraphElementClearActions(graphId string) (actions []*execAction) {
+	actions = append(actions, &execAction{Sql: "update sys_graph_element set parent_element=null where graph=?", Param: []interface{}{graphId}})
+	actions = append(actions, &execAction{Sql: "delete from sys_graph_element where graph=?", Param: []interface{}{graphId}})
+	return
+}
+
+func buildGraphTable(param *models.ModifyGraphParam) models.SysGraphTable {
+	return models.SysGraphTable{Id: param.Id, Name: param.Name, View: param.View, GraphType: param.GraphType, NodeGroups: param.NodeGroups,
+		GraphDir: param.GraphDir, GraphNodeConfig: param.GraphNodeConfig, GraphEdgeConfig: param.GraphEdgeConfig}
+}
+
+func newGraphElementChecker(viewId string) (checker *graphElementChecker, err error) {
+	var viewTable []*models.SysViewTable
+	if err = x.SQL("select id,report from sys_view where id=?", viewId).Find(&viewTable); err != nil {
+		err = fmt.Errorf("Try to query view fail,%s ", err.Error())
+		return
+	}
+	if len(viewTable) == 0 {
+		err = fmt.Errorf("Can not find view with id:%s ", viewId)
+		return
+	}
+	checker = &graphElementChecker{report: viewTable[0].Report, reportObjectMap: make(map[string]*models.SysReportObjectTable), nameMap: make(map[string]map[string]bool)}
+	var reportObjectList []*models.SysReportObjectTable
+	if err = x.SQL("select * from sys_report_object where report=?", checker.report).Find(&reportObjectList); err != nil {
+		err = fmt.Errorf("Try to query report object fail,%s ", err.Error())
+		return
+	}
+	for _, reportObject := range reportObjectList {
+		checker.reportObjectMap[reportObject.Id] = reportObject
+		checker.nameMap[reportObject.Id] = map[string]bool{"guid": true}
+	}
+	// 显示表达式可以引用报表对象属性的数据名或列名,也可以引用子报表对象的数据名
+	for _, reportObject := range reportObjectList {
+		if parentNameMap, b := checker.nameMap[reportObject.ParentObject]; b {
+			parentNameMap[reportObject.DataName] = true
+		}
+		attrList, _, getErr := GetReportAttr(reportObject.Id)
+		if getErr != nil {
+			err = getErr
+			return
+		}
+		for _, attr := range attrList {
+			checker.nameMap[reportObject.Id][attr.DataName] = true
+			checker.nameMap[reportObject.Id][attr.CiTypeAttr[strings.Index(attr.CiTypeAttr, "__")+2:]] = true
+		}
+	}
+	return
+}
+
+func (c *graphElementChecker) checkElement(element *models.SysGraphElementTable) (err error) {
+	reportObject, b := c.reportObjectMap[element.ReportObject]
+	if !b {
+		err = fmt.Errorf("Graph element:%s report object:%s is not belong to report:%s ", element.Id, element.ReportObject, c.report)
+		return
+	}
+	if element.DisplayExpression == "" {
+		err = fmt.Errorf("Graph element:%s display expression can not empty ", element.Id)
+		return
+	}
+	var parts []string
+	if err = json.Unmarshal([]byte(element.DisplayExpression), &parts); err != nil {
+		err = fmt.Errorf("Graph element:%s display expression must be json string list,%s ", element.Id, err.Error())
+		return
+	}
+	var illegalNames []string
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		// 单引号包裹的是常量文本
+		if part[0] == '\'' {
+			continue
+		}
+		name := strings.Split(part, ".")[0]
+		if !c.nameMap[reportObject.Id][name] {
+			illegalNames = append(illegalNames, part)
+		}
+	}
+	if len(illegalNames) > 0 {
+		err = fmt.Errorf("Graph element:%s display expression reference %s not exist in report object:%s ", element.Id, strings.Join(illegalNames, ","), reportObject.Id)
+		return
+	}
+	if element.EditRefAttr != "" {
+		// 编辑引用属性可以传属性名,按元素的ci类型补全成属性id
+		if !strings.Contains(element.EditRefAttr, "__") {
+			element.EditRefAttr = reportObject.CiType + "__" + element.EditRefAttr
+		}
+		attr, getErr := GetCiAttrById(element.EditRefAttr)
+		if getErr != nil {
+			err = getErr
+			return
+		}
+		if attr.CiType != reportObject.CiType || (attr.InputType != "ref" && attr.InputType != models.MultiRefType) {
+			err = fmt.Errorf("Graph element:%s edit ref attr:%s must be a reference attribute of ciType:%s ", element.Id, element.EditRefAttr, reportObject.CiType)
+			return
+		}
+	}
+	return
+}
+
+// buildElementTreeActions 校验并生成整棵图元素树的插入语句,父元素在子元素之前
+func (c *graphElementChecker) buildElementTreeActions(graphId, parentId string, seqNo int, node *models.GraphElementNode) (actions []*execAction, err error) {
+	element := models.SysGraphElementTable{Id: node.Id, Graph: graphId, ParentElement: parentId, ReportObject: node.ReportObject, ShowTable: node.ShowTable,
+		DisplayExpression: node.DisplayExpression, NodeGroupName: node.NodeGroupName, LineStartData: node.LineStartData, LineEndData: node.LineEndData,
+		LineDisplayPosition: node.LineDisplayPosition, GraphType: node.GraphType, GraphShapeData: node.GraphShapeData, GraphShapes: node.GraphShapes,
+		GraphConfigData: node.GraphConfigData, GraphConfigs: node.GraphConfigs, EditRefAttr: node.EditRefAttrName, GraphFilterData: node.GraphFilterData,
+		GraphFilterValues: node.GraphFilterValues, Editable: node.Editable, SeqNo: strconv.Itoa(seqNo), OrderData: node.OrderData, UpdateOperation: node.UpdateOperation}
+	if element.Id == "" {
+		element.Id = "ge_" + guid.CreateGuid()
+	}
+	if err = c.checkElement(&element); err != nil {
+		return
+	}
+	action, _ := GetInsertTableExecAction("sys_graph_element", element, graphElementNullColumns)
+	actions = append(actions, action)
+	for i, child := range node.Children {
+		childActions, childErr := c.buildElementTreeActions(graphId, element.Id, i, child)
+		if childErr != nil {
+			err = childErr
+			return
+		}
+		actions = append(actions, childActions...)
+	}
+	return
+}
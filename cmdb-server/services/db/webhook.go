@@ -0,0 +1,665 @@
+package db
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/go-common-lib/cipher"
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/expression"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+)
+
+const (
+	webhookDefaultMaxRetry  = 5
+	webhookRetryBaseSeconds = 10
+	webhookRetryMaxSeconds  = 3600
+	webhookBatchSize        = 100
+	webhookResponseMaxLen   = 2000
+	// 投递中事件的租约,需大于单次请求超时时间
+	webhookSendingLease = 5 * time.Minute
+)
+
+var (
+	webhookOutboxChan  = make(chan int, 100)
+	webhookHttpClient  = &http.Client{Timeout: 10 * time.Second}
+	webhookLegalAction = []string{"insert", "update", "confirm", "delete", "execute"}
+)
+
+func QueryWebhook(ciType string) (result []*models.SysWebhookTable, err error) {
+	result = []*models.SysWebhookTable{}
+	if ciType != "" {
+		err = x.SQL("select * from sys_webhook where ci_type=? order by create_time desc", ciType).Find(&result)
+	} else {
+		err = x.SQL("select * from sys_webhook order by create_time desc").Find(&result)
+	}
+	if err != nil {
+		err = fmt.Errorf("Try to query webhook table fail,%s ", err.Error())
+		return
+	}
+	for _, row := range result {
+		if row.Secret != "" {
+			row.Secret = models.PasswordDisplay
+		}
+	}
+	return
+}
+
+func CreateWebhook(param *models.SysWebhookTable, operator string) (err error) {
+	if err = validateWebhookParam(param); err != nil {
+		return
+	}
+	encryptSecret, encodeErr := encryptWebhookSecret(param.Secret)
+	if encodeErr != nil {
+		err = encodeErr
+		return
+	}
+	param.Id = "wh_" + guid.CreateGuid()
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	_, err = x.Exec("insert into sys_webhook(id,name,url,secret,ci_type,actions,filter,enable,max_retry,create_user,create_time,update_user,update_time) values (?,?,?,?,?,?,?,?,?,?,?,?,?)",
+		param.Id, param.Name, param.Url, encryptSecret, param.CiType, param.Actions, param.Filter, param.Enable, param.MaxRetry, operator, nowTime, operator, nowTime)
+	if err != nil {
+		err = fmt.Errorf("Try to insert webhook fail,%s ", err.Error())
+	}
+	return
+}
+
+func UpdateWebhook(param *models.SysWebhookTable, operator string) (err error) {
+	if err = validateWebhookParam(param); err != nil {
+		return
+	}
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	execParams := []interface{}{"update sys_webhook set name=?,url=?,ci_type=?,actions=?,filter=?,enable=?,max_retry=?,update_user=?,update_time=? where id=?",
+		param.Name, param.Url, param.CiType, param.Actions, param.Filter, param.Enable, param.MaxRetry, operator, nowTime, param.Id}
+	// 密钥展示为脱敏值时不更新
+	if param.Secret != models.PasswordDisplay {
+		encryptSecret, encodeErr := encryptWebhookSecret(param.Secret)
+		if encodeErr != nil {
+			err = encodeErr
+			return
+		}
+		execParams = []interface{}{"update sys_webhook set name=?,url=?,secret=?,ci_type=?,actions=?,filter=?,enable=?,max_retry=?,update_user=?,update_time=? where id=?",
+			param.Name, param.Url, encryptSecret, param.CiType, param.Actions, param.Filter, param.Enable, param.MaxRetry, operator, nowTime, param.Id}
+	}
+	execResult, execErr := x.Exec(execParams...)
+	if execErr != nil {
+		err = fmt.Errorf("Try to update webhook fail,%s ", execErr.Error())
+		return
+	}
+	if affectNum, _ := execResult.RowsAffected(); affectNum == 0 {
+		err = fmt.Errorf("Can not find webhook:%s ", param.Id)
+	}
+	return
+}
+
+func DeleteWebhook(webhookId string) (err error) {
+	var actions []*execAction
+	actions = append(actions, &execAction{Sql: "delete from sys_webhook_delivery where webhook=?", Param: []interface{}{webhookId}})
+	actions = append(actions, &execAction{Sql: "delete from sys_webhook_outbox where webhook=?", Param: []interface{}{webhookId}})
+	actions = append(actions, &execAction{Sql: "delete from sys_webhook where id=?", Param: []interface{}{webhookId}})
+	if err = transaction(actions); err != nil {
+		err = fmt.Errorf("Try to delete webhook fail,%s ", err.Error())
+	}
+	return
+}
+
+func QueryWebhookOutbox(param *models.QueryRequestParam) (pageInfo models.PageInfo, rowData []*models.SysWebhookOutboxTable, err error) {
+	rowData = []*models.SysWebhookOutboxTable{}
+	filterSql, queryColumn, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysWebhookOutboxTable{}, PrimaryKey: "id"})
+	if err != nil {
+		return
+	}
+	baseSql := fmt.Sprintf("SELECT %s FROM sys_webhook_outbox WHERE 1=1 %s ", queryColumn, filterSql)
+	if param.Paging {
+		pageInfo.StartIndex = param.Pageable.StartIndex
+		pageInfo.PageSize = param.Pageable.PageSize
+		pageInfo.TotalRows = queryCount(baseSql, queryParam...)
+		pageSql, pageParam := transPageInfoToSQL(*param.Pageable)
+		baseSql += pageSql
+		queryParam = append(queryParam, pageParam...)
+	}
+	err = x.SQL(baseSql, queryParam...).Find(&rowData)
+	if err != nil {
+		err = fmt.Errorf("Try to query webhook outbox fail,%s ", err.Error())
+	}
+	return
+}
+
+func QueryWebhookDelivery(param *models.QueryRequestParam) (pageInfo models.PageInfo, rowData []*models.SysWebhookDeliveryTable, err error) {
+	rowData = []*models.SysWebhookDeliveryTable{}
+	filterSql, queryColumn, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysWebhookDeliveryTable{}, PrimaryKey: "id"})
+	if err != nil {
+		return
+	}
+	baseSql := fmt.Sprintf("SELECT %s FROM sys_webhook_delivery WHERE 1=1 %s ", queryColumn, filterSql)
+	if param.Paging {
+		pageInfo.StartIndex = param.Pageable.StartIndex
+		pageInfo.PageSize = param.Pageable.PageSize
+		pageInfo.TotalRows = queryCount(baseSql, queryParam...)
+		pageSql, pageParam := transPageInfoToSQL(*param.Pageable)
+		baseSql += pageSql
+		queryParam = append(queryParam, pageParam...)
+	}
+	err = x.SQL(baseSql, queryParam...).Find(&rowData)
+	if err != nil {
+		err = fmt.Errorf("Try to query webhook delivery fail,%s ", err.Error())
+	}
+	return
+}
+
+// RetryWebhookOutbox 把投递失败的事件重新放回待投递队列
+func RetryWebhookOutbox(outboxId int) (err error) {
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	execResult, execErr := x.Exec("update sys_webhook_outbox set status='wait',retry_count=0,next_time=?,update_time=? where id=? and status='fail'", nowTime, nowTime, outboxId)
+	if execErr != nil {
+		err = fmt.Errorf("Try to update webhook outbox fail,%s ", execErr.Error())
+		return
+	}
+	if affectNum, _ := execResult.RowsAffected(); affectNum == 0 {
+		err = fmt.Errorf("Can not find fail webhook outbox:%d ", outboxId)
+		return
+	}
+	notifyWebhookOutbox(outboxId)
+	return
+}
+
+func validateWebhookParam(param *models.SysWebhookTable) (err error) {
+	if !strings.HasPrefix(param.Url, "http://") && !strings.HasPrefix(param.Url, "https://") {
+		return fmt.Errorf("Webhook url:%s illegal,should start with http:// or https:// ", param.Url)
+	}
+	if param.Enable == "" {
+		param.Enable = "yes"
+	}
+	if param.Enable != "yes" && param.Enable != "no" {
+		return fmt.Errorf("Webhook enable:%s illegal,should be yes or no ", param.Enable)
+	}
+	if param.MaxRetry <= 0 {
+		param.MaxRetry = webhookDefaultMaxRetry
+	}
+	var actionList []string
+	for _, action := range strings.Split(param.Actions, ",") {
+		action = strings.TrimSpace(action)
+		if action == "" {
+			continue
+		}
+		legalFlag := false
+		for _, legalAction := range webhookLegalAction {
+			if action == legalAction {
+				legalFlag = true
+				break
+			}
+		}
+		if !legalFlag {
+			return fmt.Errorf("Webhook action:%s illegal,should in %s ", action, strings.Join(webhookLegalAction, ","))
+		}
+		actionList = append(actionList, action)
+	}
+	param.Actions = strings.Join(actionList, ",")
+	ciTypeRows, queryErr := x.QueryString("select id from sys_ci_type where id=?", param.CiType)
+	if queryErr != nil {
+		return fmt.Errorf("Try to query ciType fail,%s ", queryErr.Error())
+	}
+	if len(ciTypeRows) == 0 {
+		return fmt.Errorf("Can not find ciType:%s ", param.CiType)
+	}
+	if param.Filter != "" {
+		expr, parseErr := expression.Parse(param.Filter)
+		if parseErr != nil {
+			return fmt.Errorf("Webhook filter:%s illegal,%s ", param.Filter, parseErr.Error())
+		}
+		if expr.FirstSegment().CiType != param.CiType {
+			return fmt.Errorf("Webhook filter:%s should start with ciType:%s ", param.Filter, param.CiType)
+		}
+		if filterErr := validateExpressWithError(param.Filter); filterErr != nil {
+			return filterErr
+		}
+	}
+	return
+}
+
+func encryptWebhookSecret(secret string) (result string, err error) {
+	if secret == "" {
+		return
+	}
+	result, err = cipher.AesEnPassword(models.Config.Wecube.EncryptSeed, secret)
+	if err != nil {
+		err = fmt.Errorf("Try to encrypt webhook secret fail,%s ", err.Error())
+	}
+	return
+}
+
+func buildWebhookRowObj(ciObj *models.MultiCiDataObj, rowGuid, action string, nowData map[string]string) *models.WebhookRowObj {
+	rowObj := models.WebhookRowObj{CiType: ciObj.CiTypeId, Guid: rowGuid, Action: action, NowData: nowData}
+	for _, attr := range ciObj.Attributes {
+		if attr.InputType == "password" || attr.Sensitive == "yes" {
+			rowObj.SensitiveAttrs = append(rowObj.SensitiveAttrs, attr.Name)
+		}
+	}
+	return &rowObj
+}
+
+func getWebhookSubscription(rowList []*models.WebhookRowObj) (webhookMap map[string][]*models.SysWebhookTable, err error) {
+	webhookMap = make(map[string][]*models.SysWebhookTable)
+	if len(rowList) == 0 {
+		return
+	}
+	ciTypeMap := make(map[string]int)
+	var ciTypeList []string
+	for _, row := range rowList {
+		if _, b := ciTypeMap[row.CiType]; !b {
+			ciTypeMap[row.CiType] = 1
+			ciTypeList = append(ciTypeList, row.CiType)
+		}
+	}
+	var webhookRows []*models.SysWebhookTable
+	err = x.SQL("select * from sys_webhook where enable='yes' and ci_type in ('" + strings.Join(ciTypeList, "','") + "')").Find(&webhookRows)
+	if err != nil {
+		err = fmt.Errorf("Try to query webhook subscription fail,%s ", err.Error())
+		return
+	}
+	for _, row := range webhookRows {
+		webhookMap[row.CiType] = append(webhookMap[row.CiType], row)
+	}
+	return
+}
+
+func isWebhookActionMatch(webhook *models.SysWebhookTable, action string) bool {
+	if webhook.Actions == "" {
+		return true
+	}
+	for _, v := range strings.Split(webhook.Actions, ",") {
+		if v == action {
+			return true
+		}
+	}
+	return false
+}
+
+// 根据过滤表达式返回命中的数据guid
+func getWebhookFilterGuidMap(webhook *models.SysWebhookTable, guidList []string) (guidMap map[string]int, err error) {
+	guidMap = make(map[string]int)
+	if webhook.Filter == "" {
+		for _, v := range guidList {
+			guidMap[v] = 1
+		}
+		return
+	}
+	matchList, filterErr := getExpressResultList(webhook.Filter, "", map[string]string{webhook.CiType: strings.Join(guidList, ",")}, true)
+	if filterErr != nil {
+		err = fmt.Errorf("webhook:%s filter fail,%s ", webhook.Id, filterErr.Error())
+		return
+	}
+	for _, v := range matchList {
+		guidMap[v] = 1
+	}
+	return
+}
+
+// buildWebhookOutboxActions 在数据操作事务提交前执行,生成的待投递事件与数据在同一事务中提交
+// 删除的数据在提交后无法再做过滤,所以在这里先匹配并带上删除前的数据; 其它数据写为pending,提交后再按过滤表达式匹配并补充最新数据
+func buildWebhookOutboxActions(rowList []*models.WebhookRowObj, operation, operator, nowTime string) (actions []*execAction, err error) {
+	webhookMap, err := getWebhookSubscription(rowList)
+	if err != nil || len(webhookMap) == 0 {
+		return
+	}
+	for _, webhooks := range webhookMap {
+		for _, webhook := range webhooks {
+			var deleteRowList []*models.WebhookRowObj
+			var deleteGuidList []string
+			for _, row := range rowList {
+				if row.CiType != webhook.CiType || !isWebhookActionMatch(webhook, row.Action) {
+					continue
+				}
+				if row.Action == "delete" {
+					deleteRowList = append(deleteRowList, row)
+					deleteGuidList = append(deleteGuidList, row.Guid)
+					continue
+				}
+				payload := models.WebhookEventPayload{Webhook: webhook.Id, CiType: row.CiType, Guid: row.Guid, Action: row.Action, Operation: operation, Operator: operator, EventTime: nowTime}
+				actions = append(actions, buildWebhookOutboxInsertAction(&payload, "pending", "", nowTime))
+			}
+			if len(deleteGuidList) == 0 {
+				continue
+			}
+			// 过滤失败时事件记为fail,可在待投递事件中查看并手动重试
+			guidMap, filterErr := getWebhookFilterGuidMap(webhook, deleteGuidList)
+			for _, row := range deleteRowList {
+				payload := models.WebhookEventPayload{Webhook: webhook.Id, CiType: row.CiType, Guid: row.Guid, Action: row.Action, Operation: operation, Operator: operator, EventTime: nowTime, Data: buildWebhookRowData(row.NowData, row.SensitiveAttrs)}
+				if filterErr != nil {
+					log.Error(nil, log.LOGGER_APP, "Prepare webhook delete event fail", zap.Error(filterErr))
+					actions = append(actions, buildWebhookOutboxInsertAction(&payload, "fail", filterErr.Error(), nowTime))
+				} else if _, b := guidMap[row.Guid]; b {
+					actions = append(actions, buildWebhookOutboxInsertAction(&payload, "wait", "", nowTime))
+				}
+			}
+		}
+	}
+	return
+}
+
+func buildWebhookOutboxInsertAction(payload *models.WebhookEventPayload, status, errorMsg, nowTime string) *execAction {
+	payloadBytes, _ := json.Marshal(payload)
+	return &execAction{Sql: "insert into sys_webhook_outbox(webhook,ci_type,data_guid,action,operation,payload,status,retry_count,next_time,error_msg,create_time,update_time) values (?,?,?,?,?,?,?,0,?,?,?,?)",
+		Param: []interface{}{payload.Webhook, payload.CiType, payload.Guid, payload.Action, payload.Operation, string(payloadBytes), status, nowTime, errorMsg, nowTime, nowTime}}
+}
+
+// resolveWebhookOutbox 处理数据事务中写入的pending事件,命中过滤表达式的补充最新数据后转为wait,未命中的转为skip
+func resolveWebhookOutbox() {
+	nowTime := time.Now()
+	var outboxRows []*models.SysWebhookOutboxTable
+	err := x.SQL("select * from sys_webhook_outbox where status='pending' and next_time<=? order by id limit ?", nowTime.Format(models.DateTimeFormat), webhookBatchSize).Find(&outboxRows)
+	if err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to query pending webhook outbox fail", zap.Error(err))
+		return
+	}
+	webhookOutboxMap := make(map[string][]*models.SysWebhookOutboxTable)
+	var webhookIdList []string
+	for _, outbox := range outboxRows {
+		if _, b := webhookOutboxMap[outbox.Webhook]; !b {
+			webhookIdList = append(webhookIdList, outbox.Webhook)
+		}
+		webhookOutboxMap[outbox.Webhook] = append(webhookOutboxMap[outbox.Webhook], outbox)
+	}
+	for _, webhookId := range webhookIdList {
+		outboxList := webhookOutboxMap[webhookId]
+		var actions []*execAction
+		var webhookRows []*models.SysWebhookTable
+		x.SQL("select * from sys_webhook where id=?", webhookId).Find(&webhookRows)
+		if len(webhookRows) == 0 {
+			for _, outbox := range outboxList {
+				actions = append(actions, &execAction{Sql: "update sys_webhook_outbox set status='fail',error_msg=?,update_time=? where id=? and status='pending'",
+					Param: []interface{}{fmt.Sprintf("Can not find webhook:%s ", webhookId), nowTime.Format(models.DateTimeFormat), outbox.Id}})
+			}
+			if err = transaction(actions); err != nil {
+				log.Error(nil, log.LOGGER_APP, "Try to update pending webhook outbox fail", zap.String("webhook", webhookId), zap.Error(err))
+			}
+			continue
+		}
+		webhook := webhookRows[0]
+		var guidList []string
+		for _, outbox := range outboxList {
+			guidList = append(guidList, outbox.DataGuid)
+		}
+		guidMap, filterErr := getWebhookFilterGuidMap(webhook, guidList)
+		if filterErr != nil {
+			// 过滤失败时保留pending,稍后再试
+			log.Error(nil, log.LOGGER_APP, "Filter webhook event fail", zap.String("webhook", webhookId), zap.Error(filterErr))
+			for _, outbox := range outboxList {
+				actions = append(actions, &execAction{Sql: "update sys_webhook_outbox set retry_count=retry_count+1,next_time=?,error_msg=?,update_time=? where id=? and status='pending'",
+					Param: []interface{}{nowTime.Add(getWebhookRetryInterval(outbox.RetryCount + 1)).Format(models.DateTimeFormat), filterErr.Error(), nowTime.Format(models.DateTimeFormat), outbox.Id}})
+			}
+			if err = transaction(actions); err != nil {
+				log.Error(nil, log.LOGGER_APP, "Try to update pending webhook outbox fail", zap.String("webhook", webhookId), zap.Error(err))
+			}
+			continue
+		}
+		sensitiveAttrs, attrErr := getCiTypeSensitiveAttrs(webhook.CiType)
+		if attrErr != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to query webhook sensitive attrs fail", zap.String("webhook", webhookId), zap.Error(attrErr))
+			continue
+		}
+		var rowList []*models.WebhookRowObj
+		for _, outbox := range outboxList {
+			if _, b := guidMap[outbox.DataGuid]; b {
+				rowList = append(rowList, &models.WebhookRowObj{CiType: outbox.CiType, Guid: outbox.DataGuid, Action: outbox.Action, SensitiveAttrs: sensitiveAttrs})
+			}
+		}
+		rowDataMap := getWebhookRowData(rowList)
+		for _, outbox := range outboxList {
+			if _, b := guidMap[outbox.DataGuid]; !b {
+				actions = append(actions, &execAction{Sql: "update sys_webhook_outbox set status='skip',update_time=? where id=? and status='pending'", Param: []interface{}{nowTime.Format(models.DateTimeFormat), outbox.Id}})
+				continue
+			}
+			var payload models.WebhookEventPayload
+			if err = json.Unmarshal([]byte(outbox.Payload), &payload); err != nil {
+				actions = append(actions, &execAction{Sql: "update sys_webhook_outbox set status='fail',error_msg=?,update_time=? where id=? and status='pending'",
+					Param: []interface{}{fmt.Sprintf("Json unmarshal webhook payload fail,%s ", err.Error()), nowTime.Format(models.DateTimeFormat), outbox.Id}})
+				continue
+			}
+			payload.Data = rowDataMap[outbox.DataGuid]
+			payloadBytes, _ := json.Marshal(payload)
+			actions = append(actions, &execAction{Sql: "update sys_webhook_outbox set status='wait',payload=?,retry_count=0,next_time=?,error_msg='',update_time=? where id=? and status='pending'",
+				Param: []interface{}{string(payloadBytes), nowTime.Format(models.DateTimeFormat), nowTime.Format(models.DateTimeFormat), outbox.Id}})
+		}
+		if err = transaction(actions); err != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to update pending webhook outbox fail", zap.String("webhook", webhookId), zap.Error(err))
+		}
+	}
+}
+
+func getCiTypeSensitiveAttrs(ciType string) (sensitiveAttrs []string, err error) {
+	queryRows, queryErr := x.QueryString("select name from sys_ci_type_attr where ci_type=? and (input_type=? or sensitive='yes')", ciType, models.PasswordInputType)
+	if queryErr != nil {
+		err = fmt.Errorf("Try to query ci attr fail,%s ", queryErr.Error())
+		return
+	}
+	for _, row := range queryRows {
+		sensitiveAttrs = append(sensitiveAttrs, row["name"])
+	}
+	return
+}
+
+// 获取事件数据内容,删除的数据取删除前的数据,其它取事务提交后的最新数据,密码和敏感字段脱敏
+func getWebhookRowData(rowList []*models.WebhookRowObj) (rowDataMap map[string]map[string]interface{}) {
+	rowDataMap = make(map[string]map[string]interface{})
+	ciGuidMap := make(map[string][]string)
+	for _, row := range rowList {
+		if row.Action == "delete" {
+			rowDataMap[row.Guid] = buildWebhookRowData(row.NowData, row.SensitiveAttrs)
+			continue
+		}
+		ciGuidMap[row.CiType] = append(ciGuidMap[row.CiType], row.Guid)
+	}
+	for ciType, guidList := range ciGuidMap {
+		queryRows, err := x.QueryString(fmt.Sprintf("select * from `%s` where guid in ('%s')", ciType, strings.Join(guidList, "','")))
+		if err != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to query webhook row data fail", zap.String("ciType", ciType), zap.Error(err))
+			continue
+		}
+		var sensitiveAttrs []string
+		for _, row := range rowList {
+			if row.CiType == ciType {
+				sensitiveAttrs = row.SensitiveAttrs
+				break
+			}
+		}
+		for _, queryRow := range queryRows {
+			rowDataMap[queryRow["guid"]] = buildWebhookRowData(queryRow, sensitiveAttrs)
+		}
+	}
+	return
+}
+
+func buildWebhookRowData(input map[string]string, sensitiveAttrs []string) (output map[string]interface{}) {
+	output = make(map[string]interface{})
+	for k, v := range input {
+		output[k] = v
+	}
+	for _, attr := range sensitiveAttrs {
+		if _, b := output[attr]; b {
+			output[attr] = models.PasswordDisplay
+		}
+	}
+	return
+}
+
+func notifyWebhookOutbox(outboxId int) {
+	select {
+	case webhookOutboxChan <- outboxId:
+	default:
+		// 队列已满时由定时扫描兜底
+	}
+}
+
+func StartConsumeWebhookOutbox() {
+	log.Info(nil, log.LOGGER_APP, "start consume webhook outbox job")
+	t := time.NewTicker(30 * time.Second).C
+	for {
+		handleWebhookOutbox()
+		select {
+		case <-webhookOutboxChan:
+		case <-t:
+		}
+	}
+}
+
+// reclaimWebhookOutbox 投递中的事件超过租约时间仍未完成,说明投递的实例已退出,重新放回待投递
+func reclaimWebhookOutbox() {
+	nowTime := time.Now()
+	expireTime := nowTime.Add(-webhookSendingLease).Format(models.DateTimeFormat)
+	if _, err := x.Exec("update sys_webhook_outbox set status='wait',update_time=? where status='sending' and update_time<?", nowTime.Format(models.DateTimeFormat), expireTime); err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to reclaim sending webhook outbox fail", zap.Error(err))
+	}
+}
+
+func handleWebhookOutbox() {
+	reclaimWebhookOutbox()
+	resolveWebhookOutbox()
+	for {
+		var outboxRows []*models.SysWebhookOutboxTable
+		err := x.SQL("select * from sys_webhook_outbox where status='wait' and next_time<=? order by id limit ?", time.Now().Format(models.DateTimeFormat), webhookBatchSize).Find(&outboxRows)
+		if err != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to query webhook outbox fail", zap.Error(err))
+			return
+		}
+		if len(outboxRows) == 0 {
+			return
+		}
+		webhookMap := make(map[string]*models.SysWebhookTable)
+		for _, outbox := range outboxRows {
+			// 抢占事件,防止多实例重复投递
+			execResult, execErr := x.Exec("update sys_webhook_outbox set status='sending',update_time=? where id=? and status='wait'", time.Now().Format(models.DateTimeFormat), outbox.Id)
+			if execErr != nil {
+				log.Error(nil, log.LOGGER_APP, "Try to lock webhook outbox fail", zap.Int("id", outbox.Id), zap.Error(execErr))
+				continue
+			}
+			if affectNum, _ := execResult.RowsAffected(); affectNum == 0 {
+				continue
+			}
+			if _, b := webhookMap[outbox.Webhook]; !b {
+				var webhookRows []*models.SysWebhookTable
+				x.SQL("select * from sys_webhook where id=?", outbox.Webhook).Find(&webhookRows)
+				if len(webhookRows) > 0 {
+					webhookMap[outbox.Webhook] = webhookRows[0]
+				} else {
+					webhookMap[outbox.Webhook] = nil
+				}
+			}
+			deliverWebhookOutbox(outbox, webhookMap[outbox.Webhook])
+		}
+		if len(outboxRows) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+func deliverWebhookOutbox(outbox *models.SysWebhookOutboxTable, webhook *models.SysWebhookTable) {
+	delivery := models.SysWebhookDeliveryTable{Outbox: outbox.Id, Webhook: outbox.Webhook, RequestTime: time.Now().Format(models.DateTimeFormat)}
+	startTime := time.Now()
+	var err error
+	if webhook == nil {
+		err = fmt.Errorf("Can not find webhook:%s ", outbox.Webhook)
+	} else {
+		delivery.ResponseCode, delivery.ResponseBody, err = doWebhookRequest(outbox, webhook)
+	}
+	delivery.CostMs = time.Since(startTime).Milliseconds()
+	nowTime := time.Now()
+	if err != nil {
+		delivery.ErrorMsg = err.Error()
+		outbox.RetryCount = outbox.RetryCount + 1
+		maxRetry := webhookDefaultMaxRetry
+		if webhook != nil {
+			maxRetry = webhook.MaxRetry
+		}
+		if outbox.RetryCount >= maxRetry || webhook == nil {
+			outbox.Status = "fail"
+		} else {
+			outbox.Status = "wait"
+		}
+		outbox.NextTime = nowTime.Add(getWebhookRetryInterval(outbox.RetryCount)).Format(models.DateTimeFormat)
+		log.Warn(nil, log.LOGGER_APP, "Deliver webhook event fail", zap.Int("id", outbox.Id), zap.Int("retry", outbox.RetryCount), zap.Error(err))
+	} else {
+		outbox.Status = "ok"
+	}
+	var actions []*execAction
+	actions = append(actions, &execAction{Sql: "insert into sys_webhook_delivery(outbox,webhook,request_time,response_code,response_body,error_msg,cost_ms) values (?,?,?,?,?,?,?)",
+		Param: []interface{}{delivery.Outbox, delivery.Webhook, delivery.RequestTime, delivery.ResponseCode, delivery.ResponseBody, delivery.ErrorMsg, delivery.CostMs}})
+	actions = append(actions, &execAction{Sql: "update sys_webhook_outbox set status=?,retry_count=?,next_time=?,error_msg=?,update_time=? where id=?",
+		Param: []interface{}{outbox.Status, outbox.RetryCount, outbox.NextTime, delivery.ErrorMsg, nowTime.Format(models.DateTimeFormat), outbox.Id}})
+	if err = transaction(actions); err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to save webhook delivery fail", zap.Int("id", outbox.Id), zap.Error(err))
+	}
+}
+
+// 指数退避,10s,20s,40s...最长1小时
+func getWebhookRetryInterval(retryCount int) time.Duration {
+	seconds := float64(webhookRetryBaseSeconds) * math.Pow(2, float64(retryCount-1))
+	if seconds > webhookRetryMaxSeconds {
+		seconds = webhookRetryMaxSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func doWebhookRequest(outbox *models.SysWebhookOutboxTable, webhook *models.SysWebhookTable) (respCode int, respBody string, err error) {
+	var payload models.WebhookEventPayload
+	if err = json.Unmarshal([]byte(outbox.Payload), &payload); err != nil {
+		err = fmt.Errorf("Json unmarshal webhook payload fail,%s ", err.Error())
+		return
+	}
+	payload.EventId = outbox.Id
+	payloadBytes, _ := json.Marshal(payload)
+	req, reqErr := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(payloadBytes))
+	if reqErr != nil {
+		err = fmt.Errorf("Try to new webhook request fail,%s ", reqErr.Error())
+		return
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Wecmdb-Event", payload.Action)
+	req.Header.Set("X-Wecmdb-Delivery", strconv.Itoa(outbox.Id))
+	req.Header.Set("X-Wecmdb-Timestamp", timestamp)
+	if webhook.Secret != "" {
+		secret, decodeErr := cipher.AesDePassword(models.Config.Wecube.EncryptSeed, webhook.Secret)
+		if decodeErr != nil {
+			err = fmt.Errorf("Try to decrypt webhook secret fail,%s ", decodeErr.Error())
+			return
+		}
+		req.Header.Set("X-Wecmdb-Signature", "sha256="+signWebhookPayload(secret, timestamp, payloadBytes))
+	}
+	resp, respErr := webhookHttpClient.Do(req)
+	if respErr != nil {
+		err = fmt.Errorf("Try to do webhook request fail,%s ", respErr.Error())
+		return
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	respCode = resp.StatusCode
+	respBody = string(respBytes)
+	if len(respBody) > webhookResponseMaxLen {
+		respBody = respBody[:webhookResponseMaxLen]
+	}
+	if respCode < 200 || respCode >= 300 {
+		err = fmt.Errorf("Webhook response status code:%d ", respCode)
+	}
+	return
+}
+
+// 签名内容为 timestamp.body ,接收方用相同密钥计算HMAC-SHA256校验
+func signWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,308 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/common/log"
+	"github.com/WeBankPartners/we-cmdb/cmdb-server/models"
+	"go.uber.org/zap"
+)
+
+const (
+	workQueueDefaultWorkerNum = 4
+	workQueueMaxRetry         = 5
+	workQueueClaimSize        = 10
+	workQueueRunningTimeout   = 10 * time.Minute
+)
+
+var (
+	workQueueNotifyChan = make(chan int, 100)
+	workQueueHandlerMap = map[string]func(payload string) error{
+		models.WorkQueueAutofillChain:  handleAutofillChainWorkItem,
+		models.WorkQueueAutofillGuid:   handleAutofillGuidWorkItem,
+		models.WorkQueueAutofillCiType: handleAutofillCiTypeWorkItem,
+		models.WorkQueueUniquePath:     handleUniquePathWorkItem,
+	}
+)
+
+type workQueueItem struct {
+	Queue    string
+	DedupKey string
+	Payload  interface{}
+}
+
+// enqueueWorkItem 持久化待处理项,同一队列中等待中的相同去重键只保留一条
+func enqueueWorkItem(itemList []*workQueueItem) (err error) {
+	actions, err := buildWorkItemActions(itemList)
+	if err != nil || len(actions) == 0 {
+		return
+	}
+	if err = transaction(actions); err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to save work queue item fail", zap.Int("num", len(actions)), zap.Error(err))
+		err = fmt.Errorf("Try to save work queue item fail,%s ", err.Error())
+		return
+	}
+	notifyWorkQueue(len(actions))
+	return
+}
+
+// buildWorkItemActions 生成待处理项的写入语句,由调用方放进数据修改的事务里,提交后再调用notifyWorkQueue
+func buildWorkItemActions(itemList []*workQueueItem) (actions []*execAction, err error) {
+	nowTime := time.Now().Format(models.DateTimeFormat)
+	for _, item := range itemList {
+		payloadBytes, marshalErr := json.Marshal(item.Payload)
+		if marshalErr != nil {
+			err = fmt.Errorf("Try to json marshal work queue:%s payload fail,%s ", item.Queue, marshalErr.Error())
+			return
+		}
+		actions = append(actions, &execAction{Sql: "insert into sys_work_queue(queue,dedup_key,pending_key,payload,status,retry_count,next_time,create_time,update_time) values (?,?,?,?,'wait',0,?,?,?) on duplicate key update payload=values(payload),update_time=values(update_time)",
+			Param: []interface{}{item.Queue, item.DedupKey, item.Queue + ":" + item.DedupKey, string(payloadBytes), nowTime, nowTime, nowTime}})
+	}
+	return
+}
+
+func notifyWorkQueue(num int) {
+	if num <= 0 {
+		return
+	}
+	select {
+	case workQueueNotifyChan <- num:
+	default:
+	}
+}
+
+// buildAutofillChainWorkItem 按数据行+修改的属性去重
+func buildAutofillChainWorkItem(autofillChainMap map[string][]*models.AutofillChainObj) (itemList []*workQueueItem) {
+	for ciType, rows := range autofillChainMap {
+		for _, row := range rows {
+			for _, column := range row.UpdateColumn {
+				itemList = append(itemList, &workQueueItem{Queue: models.WorkQueueAutofillChain, DedupKey: row.Guid + ":" + column, Payload: models.WorkQueueAutofillPayload{CiType: ciType, Guid: row.Guid, Column: column}})
+			}
+			for _, guidList := range row.MultiColumnDelMap {
+				for _, rowGuid := range guidList {
+					if lastIndex := strings.LastIndex(rowGuid, "_"); lastIndex >= 0 {
+						itemList = append(itemList, buildAutofillGuidWorkItem(rowGuid[:lastIndex], rowGuid))
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+func enqueueAffectCiType(ciType string) error {
+	return enqueueWorkItem([]*workQueueItem{{Queue: models.WorkQueueAutofillCiType, DedupKey: ciType, Payload: models.WorkQueueAutofillPayload{CiType: ciType}}})
+}
+
+func buildUniquePathWorkItem(uniquePathList []*models.AutoActiveHandleParam) (itemList []*workQueueItem) {
+	for _, uniquePathObj := range uniquePathList {
+		for _, rowData := range uniquePathObj.Data {
+			itemList = append(itemList, &workQueueItem{Queue: models.WorkQueueUniquePath, DedupKey: rowData["guid"] + ":" + uniquePathObj.Operation,
+				Payload: models.AutoActiveHandleParam{CiType: uniquePathObj.CiType, Operation: uniquePathObj.Operation, User: uniquePathObj.User, Data: []map[string]string{rowData}}})
+		}
+	}
+	return
+}
+
+func buildAutofillGuidWorkItem(ciType, rowGuid string) *workQueueItem {
+	return &workQueueItem{Queue: models.WorkQueueAutofillGuid, DedupKey: rowGuid, Payload: models.WorkQueueAutofillPayload{CiType: ciType, Guid: rowGuid}}
+}
+
+func StartConsumeWorkQueue() {
+	workerNum := models.Config.Job.QueueWorkerNum
+	if workerNum <= 0 {
+		workerNum = workQueueDefaultWorkerNum
+	}
+	log.Info(nil, log.LOGGER_APP, "start consume work queue job", zap.Int("workerNum", workerNum))
+	for i := 0; i < workerNum; i++ {
+		go startWorkQueueWorker()
+	}
+	t := time.NewTicker(time.Minute).C
+	for {
+		resetTimeoutWorkItem()
+		<-t
+	}
+}
+
+// startWorkQueueWorker 固定数量的worker各自抢占处理,代替原来每次来数据都起一个协程
+func startWorkQueueWorker() {
+	t := time.NewTicker(10 * time.Second).C
+	for {
+		for {
+			item := claimWorkItem()
+			if item == nil {
+				break
+			}
+			handleWorkItem(item)
+		}
+		select {
+		case <-workQueueNotifyChan:
+		case <-t:
+		}
+	}
+}
+
+// claimWorkItem 抢占最早的待处理项,防止多个worker或多实例重复处理
+func claimWorkItem() *models.SysWorkQueueTable {
+	var itemRows []*models.SysWorkQueueTable
+	err := x.SQL("select * from sys_work_queue where status='wait' and next_time<=? order by id limit ?", time.Now().Format(models.DateTimeFormat), workQueueClaimSize).Find(&itemRows)
+	if err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to query work queue fail", zap.Error(err))
+		return nil
+	}
+	for _, item := range itemRows {
+		// 抢占后清空pending_key,处理期间新进来的相同项可以重新排队
+		execResult, execErr := x.Exec("update sys_work_queue set status='running',pending_key=null,update_time=? where id=? and status='wait'", time.Now().Format(models.DateTimeFormat), item.Id)
+		if execErr != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to lock work queue item fail", zap.Int("id", item.Id), zap.Error(execErr))
+			continue
+		}
+		if affectNum, _ := execResult.RowsAffected(); affectNum == 0 {
+			continue
+		}
+		return item
+	}
+	return nil
+}
+
+func handleWorkItem(item *models.SysWorkQueueTable) {
+	var err error
+	handler, b := workQueueHandlerMap[item.Queue]
+	if !b {
+		err = fmt.Errorf("Work queue:%s not support ", item.Queue)
+	} else {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("Work queue handle panic:%v ", r)
+				}
+			}()
+			err = handler(item.Payload)
+		}()
+	}
+	if err == nil {
+		if _, execErr := x.Exec("delete from sys_work_queue where id=?", item.Id); execErr != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to delete done work queue item fail", zap.Int("id", item.Id), zap.Error(execErr))
+		}
+		return
+	}
+	log.Warn(nil, log.LOGGER_APP, "Handle work queue item fail", zap.Int("id", item.Id), zap.String("queue", item.Queue), zap.String("key", item.DedupKey), zap.Int("retry", item.RetryCount), zap.Error(err))
+	item.RetryCount = item.RetryCount + 1
+	nowTime := time.Now()
+	if item.RetryCount >= workQueueMaxRetry {
+		_, err = x.Exec("update sys_work_queue set status='fail',retry_count=?,error_msg=?,update_time=? where id=?", item.RetryCount, err.Error(), nowTime.Format(models.DateTimeFormat), item.Id)
+	} else {
+		nextTime := nowTime.Add(time.Duration(10*math.Pow(2, float64(item.RetryCount-1))) * time.Second)
+		err = requeueWorkItem(item, err.Error(), nextTime.Format(models.DateTimeFormat))
+	}
+	if err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to update work queue item fail", zap.Int("id", item.Id), zap.Error(err))
+	}
+}
+
+// requeueWorkItem 重新排队,如果已经有相同的项在等待,则当前项可以直接删除
+func requeueWorkItem(item *models.SysWorkQueueTable, errorMsg, nextTime string) (err error) {
+	_, err = x.Exec("update sys_work_queue set status='wait',pending_key=?,retry_count=?,next_time=?,error_msg=?,update_time=? where id=?",
+		item.Queue+":"+item.DedupKey, item.RetryCount, nextTime, errorMsg, time.Now().Format(models.DateTimeFormat), item.Id)
+	if err != nil && strings.Contains(err.Error(), "Duplicate entry") {
+		_, err = x.Exec("delete from sys_work_queue where id=?", item.Id)
+	}
+	return
+}
+
+// resetTimeoutWorkItem 执行实例退出时处理中的项会一直停留在running,超时后重新排队
+func resetTimeoutWorkItem() {
+	var itemRows []*models.SysWorkQueueTable
+	timeoutTime := time.Now().Add(-workQueueRunningTimeout).Format(models.DateTimeFormat)
+	if err := x.SQL("select * from sys_work_queue where status='running' and update_time<?", timeoutTime).Find(&itemRows); err != nil {
+		log.Error(nil, log.LOGGER_APP, "Try to query timeout work queue item fail", zap.Error(err))
+		return
+	}
+	for _, item := range itemRows {
+		log.Warn(nil, log.LOGGER_APP, "Reset timeout work queue item", zap.Int("id", item.Id), zap.String("queue", item.Queue), zap.String("key", item.DedupKey))
+		if err := requeueWorkItem(item, item.ErrorMsg, time.Now().Format(models.DateTimeFormat)); err != nil {
+			log.Error(nil, log.LOGGER_APP, "Try to reset timeout work queue item fail", zap.Int("id", item.Id), zap.Error(err))
+		}
+	}
+}
+
+func QueryWorkQueue(param *models.QueryRequestParam) (pageInfo models.PageInfo, rowData []*models.SysWorkQueueTable, err error) {
+	rowData = []*models.SysWorkQueueTable{}
+	filterSql, queryColumn, queryParam, err := transFiltersToSQL(param, &models.TransFiltersParam{IsStruct: true, StructObj: models.SysWorkQueueTable{}, PrimaryKey: "id"})
+	if err != nil {
+		return
+	}
+	baseSql := fmt.Sprintf("SELECT %s FROM sys_work_queue WHERE 1=1 %s ", queryColumn, filterSql)
+	if param.Paging {
+		pageInfo.StartIndex = param.Pageable.StartIndex
+		pageInfo.PageSize = param.Pageable.PageSize
+		pageInfo.TotalRows = queryCount(baseSql, queryParam...)
+		pageSql, pageParam := transPageInfoToSQL(*param.Pageable)
+		baseSql += pageSql
+		queryParam = append(queryParam, pageParam...)
+	}
+	err = x.SQL(baseSql, queryParam...).Find(&rowData)
+	if err != nil {
+		err = fmt.Errorf("Try to query work queue fail,%s ", err.Error())
+	}
+	return
+}
+
+// RetryWorkQueueItem 把失败的项重新放回队列
+func RetryWorkQueueItem(itemId int) (err error) {
+	var itemRows []*models.SysWorkQueueTable
+	if err = x.SQL("select * from sys_work_queue where id=? and status='fail'", itemId).Find(&itemRows); err != nil {
+		err = fmt.Errorf("Try to query work queue item fail,%s ", err.Error())
+		return
+	}
+	if len(itemRows) == 0 {
+		err = fmt.Errorf("Can not find fail work queue item:%d ", itemId)
+		return
+	}
+	itemRows[0].RetryCount = 0
+	if err = requeueWorkItem(itemRows[0], itemRows[0].ErrorMsg, time.Now().Format(models.DateTimeFormat)); err != nil {
+		err = fmt.Errorf("Try to retry work queue item fail,%s ", err.Error())
+		return
+	}
+	select {
+	case workQueueNotifyChan <- 1:
+	default:
+	}
+	return
+}
+
+func handleAutofillChainWorkItem(payload string) (err error) {
+	var param models.WorkQueueAutofillPayload
+	if err = json.Unmarshal([]byte(payload), &param); err != nil {
+		return
+	}
+	return handleAffectGuidMap(map[string][]*models.AutofillChainObj{param.CiType: {{Guid: param.Guid, UpdateColumn: []string{param.Column}}}})
+}
+
+func handleAutofillGuidWorkItem(payload string) (err error) {
+	var param models.WorkQueueAutofillPayload
+	if err = json.Unmarshal([]byte(payload), &param); err != nil {
+		return
+	}
+	return autofillAffectActionFunc(param.CiType, param.Guid, time.Now().Format(models.DateTimeFormat))
+}
+
+func handleAutofillCiTypeWorkItem(payload string) (err error) {
+	var param models.WorkQueueAutofillPayload
+	if err = json.Unmarshal([]byte(payload), &param); err != nil {
+		return
+	}
+	return handleAffectCiType(param.CiType)
+}
+
+func handleUniquePathWorkItem(payload string) (err error) {
+	var param models.AutoActiveHandleParam
+	if err = json.Unmarshal([]byte(payload), &param); err != nil {
+		return
+	}
+	return consumeUniquePathHandle(&param)
+}
@@ -310,12 +310,12 @@ export default {
             if (this.form[i][0] !== '' && this.form[i][1] !== '') {
               filters.push({
                 name: i,
-                operator: 'gt',
+                operator: 'gte',
                 value: moment(this.form[i][0]).format(DATE_FORMAT)
               })
               filters.push({
                 name: i,
-                operator: 'lt',
+                operator: 'lte',
                 value: moment(this.form[i][1]).format(DATE_FORMAT)
               })
             }
@@ -971,4 +971,211 @@ CREATE TABLE `sys_sync_data` (
     `create_time` DATETIME DEFAULT NULL COMMENT '开始时间',
     `update_time` DATETIME DEFAULT NULL COMMENT '结束时间'
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
-#@v2.3.1.7-end@;
\ No newline at end of file
+#@v2.3.1.7-end@;
+
+#@v2.4.0.1-begin@;
+CREATE TABLE `sys_webhook` (
+    `id` VARCHAR(64) PRIMARY KEY NOT NULL COMMENT '主键',
+    `name` VARCHAR(128) NOT NULL COMMENT '名称',
+    `url` VARCHAR(512) NOT NULL COMMENT '推送地址',
+    `secret` VARCHAR(255) DEFAULT NULL COMMENT '签名密钥(加密存储)',
+    `ci_type` VARCHAR(64) NOT NULL COMMENT '订阅ci类型',
+    `actions` VARCHAR(255) DEFAULT NULL COMMENT '订阅行为,逗号分隔,为空表示全部',
+    `filter` TEXT DEFAULT NULL COMMENT '过滤表达式',
+    `enable` VARCHAR(16) DEFAULT 'yes' COMMENT '是否启用: yes/no',
+    `max_retry` INT DEFAULT 5 COMMENT '最大重试次数',
+    `create_user` VARCHAR(64) DEFAULT NULL COMMENT '创建人',
+    `create_time` DATETIME DEFAULT NULL COMMENT '创建时间',
+    `update_user` VARCHAR(64) DEFAULT NULL COMMENT '更新人',
+    `update_time` DATETIME DEFAULT NULL COMMENT '更新时间'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `sys_webhook_outbox` (
+    `id` int unsigned PRIMARY KEY NOT NULL AUTO_INCREMENT COMMENT '自增主键',
+    `webhook` VARCHAR(64) NOT NULL COMMENT '订阅id',
+    `ci_type` VARCHAR(64) DEFAULT NULL COMMENT 'ci类型',
+    `data_guid` VARCHAR(64) DEFAULT NULL COMMENT '数据guid',
+    `action` VARCHAR(32) DEFAULT NULL COMMENT '数据行为',
+    `operation` VARCHAR(64) DEFAULT NULL COMMENT '操作行为',
+    `payload` LONGTEXT DEFAULT NULL COMMENT '推送内容',
+    `status` VARCHAR(32) NOT NULL COMMENT '状态: pending/wait/sending/ok/fail/skip',
+    `retry_count` INT DEFAULT 0 COMMENT '重试次数',
+    `next_time` DATETIME DEFAULT NULL COMMENT '下次投递时间',
+    `error_msg` TEXT DEFAULT NULL COMMENT '错误信息',
+    `create_time` DATETIME DEFAULT NULL COMMENT '创建时间',
+    `update_time` DATETIME DEFAULT NULL COMMENT '更新时间',
+    KEY `idx_webhook_outbox_status` (`status`,`next_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `sys_webhook_delivery` (
+    `id` int unsigned PRIMARY KEY NOT NULL AUTO_INCREMENT COMMENT '自增主键',
+    `outbox` int unsigned NOT NULL COMMENT '待投递事件id',
+    `webhook` VARCHAR(64) NOT NULL COMMENT '订阅id',
+    `request_time` DATETIME DEFAULT NULL COMMENT '请求时间',
+    `response_code` INT DEFAULT 0 COMMENT '响应状态码',
+    `response_body` TEXT DEFAULT NULL COMMENT '响应内容',
+    `error_msg` TEXT DEFAULT NULL COMMENT '错误信息',
+    `cost_ms` BIGINT DEFAULT 0 COMMENT '耗时(毫秒)',
+    KEY `idx_webhook_delivery_outbox` (`outbox`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `sys_schema_migration` (
+    `id` VARCHAR(64) PRIMARY KEY NOT NULL COMMENT '主键',
+    `ci_type` VARCHAR(64) NOT NULL COMMENT 'ci类型',
+    `ci_type_attr` VARCHAR(64) NOT NULL COMMENT 'ci属性',
+    `change_type` VARCHAR(32) NOT NULL COMMENT '变更类型: widen/narrow/typeChange',
+    `old_data_type` VARCHAR(32) DEFAULT NULL COMMENT '原数据类型',
+    `old_data_length` INT DEFAULT 0 COMMENT '原数据长度',
+    `new_data_type` VARCHAR(32) DEFAULT NULL COMMENT '新数据类型',
+    `new_data_length` INT DEFAULT 0 COMMENT '新数据长度',
+    `forward_sql` TEXT DEFAULT NULL COMMENT '变更sql',
+    `rollback_sql` TEXT DEFAULT NULL COMMENT '回滚sql',
+    `status` VARCHAR(16) DEFAULT NULL COMMENT '状态: running/ok/fail/rollback',
+    `error_msg` TEXT DEFAULT NULL COMMENT '错误信息',
+    `create_user` VARCHAR(64) DEFAULT NULL COMMENT '创建人',
+    `create_time` DATETIME DEFAULT NULL COMMENT '创建时间',
+    `update_time` DATETIME DEFAULT NULL COMMENT '更新时间',
+    KEY `idx_schema_migration_ci_type` (`ci_type`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `sys_ci_type_unique_key` (
+    `id` VARCHAR(128) PRIMARY KEY NOT NULL COMMENT '主键',
+    `ci_type` VARCHAR(64) NOT NULL COMMENT 'ci类型',
+    `name` VARCHAR(64) NOT NULL COMMENT '唯一键名称',
+    `attrs` VARCHAR(512) NOT NULL COMMENT '属性名,逗号分隔',
+    `status` VARCHAR(16) DEFAULT 'notCreated' COMMENT '状态: notCreated/created',
+    `create_user` VARCHAR(64) DEFAULT NULL COMMENT '创建人',
+    `create_time` DATETIME DEFAULT NULL COMMENT '创建时间',
+    KEY `idx_ci_type_unique_key_ci_type` (`ci_type`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `sys_ci_type_index` (
+    `id` VARCHAR(128) PRIMARY KEY NOT NULL COMMENT '主键',
+    `ci_type` VARCHAR(64) NOT NULL COMMENT 'ci类型',
+    `name` VARCHAR(64) NOT NULL COMMENT '索引名称',
+    `attrs` VARCHAR(512) NOT NULL COMMENT '属性名,逗号分隔',
+    `target` VARCHAR(16) DEFAULT 'now' COMMENT '建立位置: now/history/all',
+    `status` VARCHAR(16) DEFAULT 'notCreated' COMMENT '状态: notCreated/created',
+    `create_user` VARCHAR(64) DEFAULT NULL COMMENT '创建人',
+    `create_time` DATETIME DEFAULT NULL COMMENT '创建时间',
+    `update_time` DATETIME DEFAULT NULL COMMENT '更新时间',
+    KEY `idx_ci_type_index_ci_type` (`ci_type`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `sys_ci_query_stat` (
+    `id` VARCHAR(64) PRIMARY KEY NOT NULL COMMENT '主键,查询条件的摘要',
+    `ci_type` VARCHAR(64) NOT NULL COMMENT 'ci类型',
+    `query_mode` VARCHAR(16) DEFAULT NULL COMMENT '查询模式',
+    `filter_attrs` VARCHAR(1024) DEFAULT NULL COMMENT '过滤属性与操作符',
+    `sort_attr` VARCHAR(64) DEFAULT NULL COMMENT '排序属性',
+    `query_count` INT DEFAULT 0 COMMENT '查询次数',
+    `slow_count` INT DEFAULT 0 COMMENT '慢查询次数',
+    `total_cost_ms` BIGINT DEFAULT 0 COMMENT '总耗时(毫秒)',
+    `max_cost_ms` BIGINT DEFAULT 0 COMMENT '最大耗时(毫秒)',
+    `last_time` DATETIME DEFAULT NULL COMMENT '最近查询时间',
+    KEY `idx_ci_query_stat_ci_type` (`ci_type`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+alter table sys_state_transition add column `guard_filter` varchar(1024) default null comment '转换条件,过滤表达式语法';
+alter table sys_state_transition add column `required_attrs` varchar(1024) default null comment '转换前必填属性,逗号分隔';
+alter table sys_state_transition add column `ref_state_guard` varchar(1024) default null comment '引用数据状态条件';
+
+CREATE TABLE `sys_ci_data_bulk_job` (
+    `id` VARCHAR(64) PRIMARY KEY NOT NULL COMMENT '主键',
+    `mode` VARCHAR(16) DEFAULT 'chunk-atomic' COMMENT '模式: chunk-atomic(每个分片一个事务,失败后停止)/best-effort',
+    `status` VARCHAR(16) DEFAULT 'running' COMMENT '状态: running/success/partial/fail/interrupted',
+    `job` VARCHAR(64) DEFAULT NULL COMMENT '执行的异步任务id',
+    `chunk_size` INT DEFAULT 0 COMMENT '分片大小',
+    `total_count` INT DEFAULT 0 COMMENT '总行数',
+    `done_count` INT DEFAULT 0 COMMENT '已处理行数',
+    `success_count` INT DEFAULT 0 COMMENT '成功行数',
+    `fail_count` INT DEFAULT 0 COMMENT '失败行数',
+    `error_msg` TEXT DEFAULT NULL COMMENT '错误信息',
+    `create_user` VARCHAR(64) DEFAULT NULL COMMENT '创建人',
+    `create_time` DATETIME DEFAULT NULL COMMENT '创建时间',
+    `update_time` DATETIME DEFAULT NULL COMMENT '更新时间'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `sys_ci_data_bulk_row` (
+    `id` INT PRIMARY KEY AUTO_INCREMENT COMMENT '主键',
+    `job` VARCHAR(64) NOT NULL COMMENT '批量任务',
+    `row_index` INT DEFAULT 0 COMMENT '行序号',
+    `ci_type` VARCHAR(64) DEFAULT NULL COMMENT 'ci类型',
+    `operation` VARCHAR(64) DEFAULT NULL COMMENT '操作',
+    `guid` VARCHAR(64) DEFAULT NULL COMMENT '数据guid',
+    `new_guid` VARCHAR(64) DEFAULT NULL COMMENT '新增数据生成的guid',
+    `status` VARCHAR(16) DEFAULT NULL COMMENT '状态: success/fail/skip',
+    `error_msg` TEXT DEFAULT NULL COMMENT '错误信息',
+    KEY `idx_ci_data_bulk_row_job` (`job`,`row_index`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `sys_job` (
+    `id` VARCHAR(64) PRIMARY KEY NOT NULL COMMENT '主键',
+    `job_type` VARCHAR(64) NOT NULL COMMENT '任务类型',
+    `status` VARCHAR(16) DEFAULT 'wait' COMMENT '状态: wait/running/success/fail/cancel/interrupted',
+    `param` LONGTEXT DEFAULT NULL COMMENT '任务参数',
+    `result` LONGTEXT DEFAULT NULL COMMENT '任务结果',
+    `error_msg` TEXT DEFAULT NULL COMMENT '错误信息',
+    `roles` VARCHAR(1024) DEFAULT NULL COMMENT '提交人角色',
+    `user_token` TEXT DEFAULT NULL COMMENT '加密后的提交人token,任务结束后清空',
+    `instance` VARCHAR(128) DEFAULT NULL COMMENT '执行实例',
+    `retry_count` INT DEFAULT 0 COMMENT '中断后重新执行次数',
+    `create_user` VARCHAR(64) DEFAULT NULL COMMENT '创建人',
+    `create_time` DATETIME DEFAULT NULL COMMENT '创建时间',
+    `start_time` DATETIME DEFAULT NULL COMMENT '开始时间',
+    `end_time` DATETIME DEFAULT NULL COMMENT '结束时间',
+    `update_time` DATETIME DEFAULT NULL COMMENT '更新时间,执行中定时刷新作为心跳',
+    KEY `idx_job_status` (`status`,`create_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `sys_work_queue` (
+    `id` INT PRIMARY KEY AUTO_INCREMENT COMMENT '主键',
+    `queue` VARCHAR(32) NOT NULL COMMENT '队列: autofillChain/autofillGuid/autofillCiType/uniquePath',
+    `dedup_key` VARCHAR(255) NOT NULL COMMENT '去重键',
+    `pending_key` VARCHAR(320) DEFAULT NULL COMMENT '等待中的去重键,非等待状态为空',
+    `payload` LONGTEXT DEFAULT NULL COMMENT '内容',
+    `status` VARCHAR(16) DEFAULT 'wait' COMMENT '状态: wait/running/fail',
+    `retry_count` INT DEFAULT 0 COMMENT '重试次数',
+    `next_time` DATETIME DEFAULT NULL COMMENT '下次执行时间',
+    `error_msg` TEXT DEFAULT NULL COMMENT '错误信息',
+    `create_time` DATETIME DEFAULT NULL COMMENT '创建时间',
+    `update_time` DATETIME DEFAULT NULL COMMENT '更新时间',
+    UNIQUE KEY `uk_work_queue_pending_key` (`pending_key`),
+    KEY `idx_work_queue_status` (`status`,`next_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+alter table sys_log modify column `operator` varchar(64) NOT NULL COMMENT '操作用户';
+alter table sys_log modify column `data_key_name` varchar(255) DEFAULT NULL COMMENT '数据名称';
+alter table sys_log add index `idx_sys_log_data_guid` (`data_guid`);
+alter table sys_log add index `idx_sys_log_operator` (`operator`,`created_date`);
+alter table sys_log add index `idx_sys_log_cat_date` (`log_cat`,`created_date`);
+alter table sys_log add column `chain_seq` bigint DEFAULT NULL COMMENT '哈希链序号,封链前为空';
+alter table sys_log add column `prev_hash` varchar(64) DEFAULT NULL COMMENT '上一条日志哈希';
+alter table sys_log add column `hash` varchar(64) DEFAULT NULL COMMENT '日志哈希';
+alter table sys_log add unique index `uk_sys_log_chain_seq` (`chain_seq`);
+
+CREATE TABLE `sys_log_chain` (
+    `id` VARCHAR(32) PRIMARY KEY COMMENT '主键',
+    `last_seq` BIGINT DEFAULT 0 COMMENT '最后封链序号',
+    `last_hash` VARCHAR(64) DEFAULT '' COMMENT '最后封链哈希',
+    `archive_seq` BIGINT DEFAULT 0 COMMENT '已归档到的序号',
+    `archive_hash` VARCHAR(64) DEFAULT '' COMMENT '已归档最后一条哈希',
+    `head_sign` VARCHAR(64) DEFAULT '' COMMENT '链头签名',
+    `update_time` DATETIME DEFAULT NULL COMMENT '更新时间'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `sys_log_archive` (
+    `id` INT PRIMARY KEY AUTO_INCREMENT COMMENT '主键',
+    `file_name` VARCHAR(255) NOT NULL COMMENT '归档文件名',
+    `start_seq` BIGINT NOT NULL COMMENT '开始序号',
+    `end_seq` BIGINT NOT NULL COMMENT '结束序号',
+    `row_count` INT DEFAULT 0 COMMENT '日志条数',
+    `start_date` DATETIME DEFAULT NULL COMMENT '第一条日志时间',
+    `end_date` DATETIME DEFAULT NULL COMMENT '最后一条日志时间',
+    `first_prev_hash` VARCHAR(64) DEFAULT '' COMMENT '第一条日志的上一条哈希',
+    `last_hash` VARCHAR(64) DEFAULT '' COMMENT '最后一条日志哈希',
+    `file_sha256` VARCHAR(64) DEFAULT '' COMMENT '归档文件摘要',
+    `signature` VARCHAR(64) DEFAULT '' COMMENT '清单签名',
+    `create_time` DATETIME DEFAULT NULL COMMENT '创建时间',
+    KEY `idx_log_archive_seq` (`start_seq`,`end_seq`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+#@v2.4.0.1-end@;
\ No newline at end of file